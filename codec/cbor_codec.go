@@ -0,0 +1,60 @@
+// Copyright 2024 sqos. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package codec
+
+import (
+	"reflect"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// cbor codec name and id
+const (
+	NAME_CBOR = "cbor"
+	ID_CBOR   = 'c'
+)
+
+func init() {
+	Reg(new(CBORCodec))
+}
+
+var cborDecMode, _ = cbor.DecOptions{
+	DefaultMapType: reflect.TypeOf(map[string]interface{}(nil)),
+}.DecMode()
+
+// CBORCodec CBOR codec
+// NOTE: The `cbor` struct tag is preferred, `json` struct tag is used as fallback.
+type CBORCodec struct{}
+
+// Name returns codec name.
+func (CBORCodec) Name() string {
+	return NAME_CBOR
+}
+
+// ID returns codec id.
+func (CBORCodec) ID() byte {
+	return ID_CBOR
+}
+
+// Marshal returns the CBOR encoding of v.
+func (CBORCodec) Marshal(v interface{}) ([]byte, error) {
+	return cbor.Marshal(v)
+}
+
+// Unmarshal parses the CBOR-encoded data and stores the result
+// in the value pointed to by v.
+func (CBORCodec) Unmarshal(data []byte, v interface{}) error {
+	return cborDecMode.Unmarshal(data, v)
+}
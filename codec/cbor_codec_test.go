@@ -0,0 +1,34 @@
+package codec
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCBOR(t *testing.T) {
+	type S struct {
+		A string `cbor:"a"`
+		B int    `json:"b"`
+		C []byte `cbor:"c" json:"cc"`
+	}
+	c := new(CBORCodec)
+	data, err := c.Marshal(&S{A: "a", B: 1, C: []byte("c")})
+	assert.NoError(t, err)
+
+	var v interface{}
+	assert.NoError(t, c.Unmarshal(data, &v))
+	m, ok := v.(map[string]interface{})
+	assert.True(t, ok)
+	assert.Equal(t, "a", m["a"])
+	assert.EqualValues(t, 1, m["b"])
+	assert.Equal(t, []byte("c"), m["c"])
+
+	var s S
+	assert.NoError(t, c.Unmarshal(data, &s))
+	assert.Equal(t, S{A: "a", B: 1, C: []byte("c")}, s)
+
+	got, err := Get(ID_CBOR)
+	assert.NoError(t, err)
+	assert.Equal(t, NAME_CBOR, got.Name())
+}
@@ -18,6 +18,7 @@ package codec
 
 import (
 	"fmt"
+	"sort"
 )
 
 // Codec makes the body's Encoder and Decoder
@@ -81,6 +82,16 @@ func GetByName(codecName string) (Codec, error) {
 	return codec, nil
 }
 
+// IDs returns the sorted ids of all registered codecs.
+func IDs() []byte {
+	ids := make([]byte, 0, len(codecMap.idMap))
+	for id := range codecMap.idMap {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
 // Marshal returns the encoding of v.
 func Marshal(codecID byte, v interface{}) ([]byte, error) {
 	codec, err := Get(codecID)
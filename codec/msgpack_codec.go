@@ -0,0 +1,64 @@
+// Copyright 2024 sqos. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package codec
+
+import (
+	"bytes"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// msgpack codec name and id
+const (
+	NAME_MSGPACK = "msgpack"
+	ID_MSGPACK   = 'm'
+)
+
+func init() {
+	Reg(new(MsgpackCodec))
+}
+
+// MsgpackCodec MessagePack codec
+// NOTE: The `msgpack` struct tag is preferred, `json` struct tag is used as fallback.
+type MsgpackCodec struct{}
+
+// Name returns codec name.
+func (MsgpackCodec) Name() string {
+	return NAME_MSGPACK
+}
+
+// ID returns codec id.
+func (MsgpackCodec) ID() byte {
+	return ID_MSGPACK
+}
+
+// Marshal returns the MessagePack encoding of v.
+func (MsgpackCodec) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag(NAME_JSON)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Unmarshal parses the MessagePack-encoded data and stores the result
+// in the value pointed to by v.
+func (MsgpackCodec) Unmarshal(data []byte, v interface{}) error {
+	dec := msgpack.NewDecoder(bytes.NewReader(data))
+	dec.SetCustomStructTag(NAME_JSON)
+	return dec.Decode(v)
+}
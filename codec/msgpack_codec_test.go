@@ -0,0 +1,32 @@
+package codec
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMsgpack(t *testing.T) {
+	type S struct {
+		A string `msgpack:"a"`
+		B int    `json:"b"`
+		C []byte `msgpack:"c" json:"cc"`
+	}
+	c := new(MsgpackCodec)
+	data, err := c.Marshal(&S{A: "a", B: 1, C: []byte("c")})
+	assert.NoError(t, err)
+
+	var m map[string]interface{}
+	assert.NoError(t, c.Unmarshal(data, &m))
+	assert.Equal(t, "a", m["a"])
+	assert.EqualValues(t, 1, m["b"])
+	assert.Equal(t, []byte("c"), m["c"])
+
+	var s S
+	assert.NoError(t, c.Unmarshal(data, &s))
+	assert.Equal(t, S{A: "a", B: 1, C: []byte("c")}, s)
+
+	got, err := Get(ID_MSGPACK)
+	assert.NoError(t, err)
+	assert.Equal(t, NAME_MSGPACK, got.Name())
+}
@@ -27,13 +27,23 @@ import (
 	"github.com/sqos/yrpc/socket"
 )
 
+// The policies of the session whose ID is already used, see PeerConfig.DuplicateID
+const (
+	// DuplicateKickOld closes the older session.
+	DuplicateKickOld = "kick_old"
+	// DuplicateRejectNew closes the newer session.
+	DuplicateRejectNew = "reject_new"
+	// DuplicateAllowMultiple keeps all sessions of the ID, GetSession returns the latest one.
+	DuplicateAllowMultiple = "allow_multiple"
+)
+
 // PeerConfig peer config
 // NOTE:
 //
 //	yaml tag is used for github.com/sqos/cfgo
 //	ini tag is used for github.com/sqos/ini
 type PeerConfig struct {
-	Network           string        `yaml:"network"              ini:"network"              comment:"Network; tcp, tcp4, tcp6, unix, unixpacket, kcp or quic"`
+	Network           string        `yaml:"network"              ini:"network"              comment:"Network; tcp, tcp4, tcp6, unix, unixpacket, kcp, quic or a network registered by RegNetwork"`
 	LocalIP           string        `yaml:"local_ip"             ini:"local_ip"             comment:"Local IP"`
 	LocalPort         uint16        `yaml:"local_port"           ini:"local_port"           comment:"Local port; for client role"`
 	ListenPort        uint16        `yaml:"listen_port"          ini:"listen_port"          comment:"Listen port; for server role"`
@@ -46,6 +56,9 @@ type PeerConfig struct {
 	SlowCometDuration time.Duration `yaml:"slow_comet_duration"  ini:"slow_comet_duration"  comment:"Slow operation alarm threshold; ns,µs,ms,s ..."`
 	PrintDetail       bool          `yaml:"print_detail"         ini:"print_detail"         comment:"Is print body and metadata or not"`
 	CountTime         bool          `yaml:"count_time"           ini:"count_time"           comment:"Is count cost time or not"`
+	ReadLimit         uint32        `yaml:"read_limit"           ini:"read_limit"           comment:"Maximum size of the received message, the oversized one is discarded and answered with CodePayloadTooLarge; if 0, only the global GetReadLimit() is checked"`
+	BroadcastWorkers  int           `yaml:"broadcast_workers"    ini:"broadcast_workers"    comment:"Maximum number of the goroutines writing a broadcast concurrently, default 32"`
+	DuplicateID       string        `yaml:"duplicate_id"         ini:"duplicate_id"         comment:"Policy of the session whose ID is already used; kick_old (default), reject_new or allow_multiple"`
 
 	localAddr         net.Addr
 	listenAddr        net.Addr
@@ -104,10 +117,23 @@ func (p *PeerConfig) check() (err error) {
 	if p.RedialInterval <= 0 {
 		p.RedialInterval = time.Millisecond * 100
 	}
+	if p.BroadcastWorkers <= 0 {
+		p.BroadcastWorkers = 32
+	}
+	switch p.DuplicateID {
+	case "":
+		p.DuplicateID = DuplicateKickOld
+	case DuplicateKickOld, DuplicateRejectNew, DuplicateAllowMultiple:
+	default:
+		return errors.New("Invalid duplicate_id config, refer to the following: kick_old, reject_new or allow_multiple")
+	}
 	return nil
 }
 
 func (p *PeerConfig) newAddr(port string) (net.Addr, error) {
+	if _, ok := getCustomNetwork(p.Network); ok {
+		return NewFakeAddr(p.Network, p.LocalIP, port), nil
+	}
 	switch p.Network {
 	default:
 		return nil, errors.New("Invalid network config, refer to the following: tcp, tcp4, tcp6, unix, unixpacket, kcp or quic")
@@ -397,6 +397,9 @@ func (c *handlerCtx) bindPush(header Header) interface{} {
 		c.stat = statNotFound
 		return nil
 	}
+	if !c.checkReadLimit(c.handler.readLimit) {
+		return nil
+	}
 
 	// reset plugin container
 	c.pluginContainer = c.handler.pluginContainer
@@ -431,12 +434,15 @@ func (c *handlerCtx) handlePush() {
 		}
 	}()
 	if c.stat.OK() && c.handler != nil {
-		if c.pluginContainer.postReadPushBody(c) == nil {
+		if stat := c.pluginContainer.postReadPushBody(c); stat == nil {
 			if c.handler.isUnknown {
 				c.handler.unknownHandleFunc(c)
 			} else {
 				c.handler.handleFunc(c, c.arg)
 			}
+			c.pluginContainer.postHandlePush(c)
+		} else {
+			c.stat = stat
 		}
 	}
 	if !c.stat.OK() {
@@ -461,6 +467,9 @@ func (c *handlerCtx) bindCall(header Header) interface{} {
 		c.stat = statNotFound
 		return nil
 	}
+	if !c.checkReadLimit(c.handler.readLimit) {
+		return nil
+	}
 
 	// reset plugin container
 	c.pluginContainer = c.handler.pluginContainer
@@ -482,7 +491,7 @@ func (c *handlerCtx) bindCall(header Header) interface{} {
 
 // handleCall handles and replies call.
 func (c *handlerCtx) handleCall() {
-	var writed bool
+	var writed, preWrote bool
 	defer func() {
 		if p := recover(); p != nil {
 			Errorf("panic:%v\n%s", p, goutil.PanicTrace(2))
@@ -490,6 +499,10 @@ func (c *handlerCtx) handleCall() {
 				if c.stat.OK() {
 					c.stat = statInternalServerError.Copy(p)
 				}
+				if !preWrote {
+					// let the plugins release the resources taken for the call
+					c.pluginContainer.preWriteReply(c)
+				}
 				c.writeReply(c.stat)
 			}
 		}
@@ -500,7 +513,7 @@ func (c *handlerCtx) handleCall() {
 	}()
 
 	c.output.SetMtype(TypeReply)
-	c.output.SetSeq(c.input.Seq())
+	socket.SetWideSeq(c.output, socket.WideSeqOf(c.input))
 	c.output.SetServiceMethod(c.input.ServiceMethod())
 	c.output.XferPipe().AppendFrom(c.input.XferPipe())
 
@@ -528,6 +541,7 @@ func (c *handlerCtx) handleCall() {
 
 	// reply call
 	c.setReplyBodyCodec(!c.stat.OK())
+	preWrote = true
 	c.pluginContainer.preWriteReply(c)
 	stat := c.writeReply(c.stat)
 	if !stat.OK() {
@@ -599,6 +613,10 @@ func (c *handlerCtx) bindReply(header Header) interface{} {
 	c.input.Meta().CopyTo(c.callCmd.inputMeta)
 	c.setContext(c.callCmd.output.Context())
 	c.input.SetBody(c.callCmd.result)
+	if !c.checkReadLimit(0) {
+		c.callCmd.stat = c.stat
+		return nil
+	}
 
 	stat := c.pluginContainer.postReadReplyHeader(c)
 	if !stat.OK() {
@@ -128,6 +128,14 @@ const (
 
 // dialOne dials the connection once.
 func (d *Dialer) dialOne(addr string) (net.Conn, error) {
+	if n, ok := getCustomNetwork(d.network); ok {
+		conn, err := n.dial(d.localAddr.String(), addr, d.dialTimeout)
+		if err != nil || d.tlsConfig == nil {
+			return conn, err
+		}
+		return tls.Client(conn, clientTLSConfig(d.tlsConfig, addr)), nil
+	}
+
 	if network := asQUIC(d.network); network != "" {
 		ctx := context.Background()
 		if d.dialTimeout > 0 {
@@ -141,7 +149,11 @@ func (d *Dialer) dialOne(addr string) (net.Conn, error) {
 	}
 
 	if network := asKCP(d.network); network != "" {
-		return kcp.DialAddrContext(network, d.localAddr.(*FakeAddr).udpAddr, addr, d.tlsConfig, dataShards, parityShards)
+		var tlsConf *tls.Config
+		if d.tlsConfig != nil {
+			tlsConf = clientTLSConfig(d.tlsConfig, addr)
+		}
+		return kcp.DialAddrContext(network, d.localAddr.(*FakeAddr).udpAddr, addr, tlsConf, dataShards, parityShards)
 	}
 	dialer := &net.Dialer{
 		LocalAddr: d.localAddr,
@@ -153,6 +165,21 @@ func (d *Dialer) dialOne(addr string) (net.Conn, error) {
 	return dialer.Dial(d.network, addr)
 }
 
+// clientTLSConfig returns the TLS config with the ServerName of the dial address,
+// the same as tls.DialWithDialer does.
+func clientTLSConfig(tlsConfig *tls.Config, addr string) *tls.Config {
+	if tlsConfig.ServerName != "" {
+		return tlsConfig
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil || host == "" {
+		return tlsConfig
+	}
+	c := tlsConfig.Clone()
+	c.ServerName = host
+	return c
+}
+
 // newRedialCounter creates a new redial counter.
 func (d *Dialer) newRedialCounter() *redialCounter {
 	r := redialCounter(d.redialTimes)
@@ -0,0 +1,165 @@
+package yrpc_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/sqos/yrpc"
+	"github.com/sqos/yrpc/yrpctest"
+)
+
+var closeReasons = make(chan *yrpc.Status, 10)
+
+func closeReason(ctx yrpc.PushCtx, stat *yrpc.Status) *yrpc.Status {
+	closeReasons <- stat
+	return nil
+}
+
+// duplicateServer starts a server that sends the accepted sessions to the channel.
+func duplicateServer(t *testing.T, cfg yrpc.PeerConfig, plugin ...yrpc.Plugin) (yrpc.Peer, string, <-chan yrpc.Session) {
+	accepted := make(chan yrpc.Session, 10)
+	plugin = append(plugin, &yrpc.PluginImpl{
+		PluginName: "accepted",
+		OnPostAccept: func(sess yrpc.PreSession) *yrpc.Status {
+			accepted <- sess.(yrpc.Session)
+			return nil
+		},
+	})
+	srv, addr := yrpctest.NewServer(t, cfg, plugin...)
+	return srv, addr, accepted
+}
+
+// dialDuplicate dials from the local port, and returns the server-side session once it is added or rejected.
+// NOTE: The server-side sessions of the same local port have the same ID.
+func dialDuplicate(t *testing.T, srv yrpc.Peer, addr string, port uint16, accepted <-chan yrpc.Session) yrpc.Session {
+	cli := yrpctest.NewClient(t, yrpc.PeerConfig{LocalPort: port})
+	cli.RoutePushFunc(closeReason)
+	yrpctest.Dial(t, cli, addr)
+	var sess yrpc.Session
+	select {
+	case sess = <-accepted:
+	case <-time.After(time.Second):
+		t.Fatal("accept timeout")
+	}
+	assert.Eventually(t, func() bool {
+		for _, s := range srv.GetSessions(sess.ID()) {
+			if s == sess {
+				return true
+			}
+		}
+		return !sess.Health()
+	}, time.Second, time.Millisecond)
+	return sess
+}
+
+func recvCloseReason(t *testing.T) *yrpc.Status {
+	select {
+	case stat := <-closeReasons:
+		return stat
+	case <-time.After(time.Second):
+		t.Fatal("receive timeout")
+		return nil
+	}
+}
+
+func TestDuplicateKickOld(t *testing.T) {
+	evicted := make(chan yrpc.BaseSession, 1)
+	srv, addr, accepted := duplicateServer(t, yrpc.PeerConfig{}, &yrpc.PluginImpl{
+		PluginName: "evict",
+		OnPostEvict: func(sess yrpc.BaseSession, reason *yrpc.Status) *yrpc.Status {
+			evicted <- sess
+			return nil
+		},
+	})
+	a := dialDuplicate(t, srv, addr, 2000, accepted)
+	b := dialDuplicate(t, srv, addr, 2000, accepted)
+
+	assert.Equal(t, yrpc.CodeConflict, recvCloseReason(t).Code())
+	assert.Equal(t, a, <-evicted)
+	assert.Eventually(t, func() bool { return !a.Health() }, time.Second, 10*time.Millisecond)
+	assert.True(t, b.Health())
+	sess, ok := srv.GetSession(b.ID())
+	assert.True(t, ok)
+	assert.Equal(t, b, sess)
+	assert.Equal(t, 1, srv.CountSession())
+}
+
+func TestDuplicateRejectNew(t *testing.T) {
+	srv, addr, accepted := duplicateServer(t, yrpc.PeerConfig{DuplicateID: yrpc.DuplicateRejectNew})
+	a := dialDuplicate(t, srv, addr, 2001, accepted)
+	b := dialDuplicate(t, srv, addr, 2001, accepted)
+	assert.Equal(t, yrpc.CodeConflict, recvCloseReason(t).Code())
+	assert.Eventually(t, func() bool { return !b.Health() }, time.Second, 10*time.Millisecond)
+	assert.True(t, a.Health())
+
+	// SetID to a used id
+	c := dialDuplicate(t, srv, addr, 2002, accepted)
+	oldID := c.ID()
+	c.SetID(a.ID())
+	assert.Equal(t, oldID, c.ID())
+	assert.Equal(t, yrpc.CodeConflict, recvCloseReason(t).Code())
+	assert.Eventually(t, func() bool { return !c.Health() }, time.Second, 10*time.Millisecond)
+	sess, ok := srv.GetSession(a.ID())
+	assert.True(t, ok)
+	assert.Equal(t, a, sess)
+	assert.Equal(t, 1, srv.CountSession())
+}
+
+func TestDuplicateAllowMultiple(t *testing.T) {
+	srv, addr, accepted := duplicateServer(t, yrpc.PeerConfig{DuplicateID: yrpc.DuplicateAllowMultiple})
+	a := dialDuplicate(t, srv, addr, 2003, accepted)
+	b := dialDuplicate(t, srv, addr, 2003, accepted)
+
+	assert.Equal(t, 2, srv.CountSession())
+	assert.Equal(t, []yrpc.Session{b, a}, srv.GetSessions(a.ID()))
+	var n int
+	srv.RangeSession(func(yrpc.Session) bool {
+		n++
+		return true
+	})
+	assert.Equal(t, 2, n)
+
+	b.Close()
+	sess, ok := srv.GetSession(a.ID())
+	assert.True(t, ok)
+	assert.Equal(t, a, sess)
+	assert.Equal(t, 1, srv.CountSession())
+	assert.True(t, a.Health())
+	select {
+	case stat := <-closeReasons:
+		t.Fatalf("unexpected close reason: %v", stat)
+	default:
+	}
+}
+
+func TestDuplicateRejectRedial(t *testing.T) {
+	_, addr1 := yrpctest.NewServer(t, yrpc.PeerConfig{})
+	_, addr2 := yrpctest.NewServer(t, yrpc.PeerConfig{})
+	var other yrpc.Session
+	cli := yrpctest.NewClient(t, yrpc.PeerConfig{
+		DuplicateID:    yrpc.DuplicateRejectNew,
+		RedialTimes:    1,
+		RedialInterval: 10 * time.Millisecond,
+	}, &yrpc.PluginImpl{
+		PluginName: "occupy",
+		// the id is taken by another session while the session is redialing
+		OnPostDial: func(sess yrpc.PreSession, isRedial bool) *yrpc.Status {
+			if isRedial {
+				other.SetID("x")
+			}
+			return nil
+		},
+	})
+	a := yrpctest.Dial(t, cli, addr1)
+	a.SetID("x")
+	other = yrpctest.Dial(t, cli, addr2)
+
+	assert.Equal(t, 1, yrpctest.DropConns(addr1))
+	assert.Eventually(t, func() bool { return !a.Health() }, time.Second, 10*time.Millisecond)
+	sess, ok := cli.GetSession("x")
+	assert.True(t, ok)
+	assert.Equal(t, other, sess)
+	assert.Equal(t, 1, cli.CountSession())
+}
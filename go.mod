@@ -4,14 +4,19 @@ go 1.23
 
 require (
 	github.com/apache/thrift v0.21.0
+	github.com/fxamacker/cbor/v2 v2.9.4
+	github.com/klauspost/compress v1.17.11
 	github.com/montanaflynn/stats v0.7.1
+	github.com/pierrec/lz4/v4 v4.1.22
 	github.com/quic-go/quic-go v0.48.2
 	github.com/sqos/cfgo v0.0.0-20241128161207-0168be10b146
 	github.com/sqos/goutil v1.0.2
 	github.com/stretchr/testify v1.10.0
 	github.com/tidwall/evio v1.0.8
 	github.com/tidwall/gjson v1.18.0
+	github.com/vmihailenco/msgpack/v5 v5.4.1
 	github.com/xtaci/kcp-go/v5 v5.6.18
+	golang.org/x/crypto v0.26.0
 	golang.org/x/sys v0.27.0
 	google.golang.org/protobuf v1.33.0
 )
@@ -32,8 +37,9 @@ require (
 	github.com/tidwall/match v1.1.1 // indirect
 	github.com/tidwall/pretty v1.2.0 // indirect
 	github.com/tjfoc/gmsm v1.4.1 // indirect
+	github.com/vmihailenco/tagparser/v2 v2.0.0 // indirect
+	github.com/x448/float16 v0.8.4 // indirect
 	go.uber.org/mock v0.4.0 // indirect
-	golang.org/x/crypto v0.26.0 // indirect
 	golang.org/x/exp v0.0.0-20240506185415-9bf2ced13842 // indirect
 	golang.org/x/mod v0.17.0 // indirect
 	golang.org/x/net v0.28.0 // indirect
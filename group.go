@@ -0,0 +1,220 @@
+// Copyright 2024 sqos. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package yrpc
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/sqos/yrpc/socket"
+)
+
+// BroadcastResult the delivery result of a broadcast to a session
+type BroadcastResult struct {
+	SessionID string
+	Status    *Status
+}
+
+// sessionGroups the named session groups (rooms, tags and so on)
+type sessionGroups struct {
+	mu     sync.RWMutex
+	groups map[string]map[*session]struct{}
+	joined map[*session]map[string]struct{}
+}
+
+func newSessionGroups() *sessionGroups {
+	return &sessionGroups{
+		groups: make(map[string]map[*session]struct{}),
+		joined: make(map[*session]map[string]struct{}),
+	}
+}
+
+// join adds the session to the group, returns false if the session is closed.
+func (g *sessionGroups) join(group string, sess *session) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	// leaveAll is called after notifyClosed, so the closed session never stays in the groups.
+	select {
+	case <-sess.closeNotifyCh:
+		return false
+	default:
+	}
+	members, ok := g.groups[group]
+	if !ok {
+		members = make(map[*session]struct{})
+		g.groups[group] = members
+	}
+	members[sess] = struct{}{}
+	joined, ok := g.joined[sess]
+	if !ok {
+		joined = make(map[string]struct{})
+		g.joined[sess] = joined
+	}
+	joined[group] = struct{}{}
+	return true
+}
+
+// leave removes the session from the group.
+func (g *sessionGroups) leave(group string, sess *session) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.leaveLocked(group, sess)
+}
+
+func (g *sessionGroups) leaveLocked(group string, sess *session) {
+	if members, ok := g.groups[group]; ok {
+		delete(members, sess)
+		if len(members) == 0 {
+			delete(g.groups, group)
+		}
+	}
+	if joined, ok := g.joined[sess]; ok {
+		delete(joined, group)
+		if len(joined) == 0 {
+			delete(g.joined, sess)
+		}
+	}
+}
+
+// leaveAll removes the session from all groups.
+func (g *sessionGroups) leaveAll(sess *session) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for group := range g.joined[sess] {
+		g.leaveLocked(group, sess)
+	}
+}
+
+func (g *sessionGroups) members(group string) []*session {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	members := g.groups[group]
+	list := make([]*session, 0, len(members))
+	for sess := range members {
+		list = append(list, sess)
+	}
+	return list
+}
+
+func (g *sessionGroups) count(group string) int {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return len(g.groups[group])
+}
+
+// JoinGroup adds the session to the named group.
+// NOTE: It returns false if the session is closed or not of the peer;
+// The session is removed from all groups before PostDisconnect plugins are executed.
+func (p *peer) JoinGroup(group string, sess CtxSession) bool {
+	s, ok := sess.(*session)
+	if !ok || s.peer != p {
+		return false
+	}
+	return p.groups.join(group, s)
+}
+
+// LeaveGroup removes the session from the named group.
+func (p *peer) LeaveGroup(group string, sess CtxSession) {
+	if s, ok := sess.(*session); ok && s.peer == p {
+		p.groups.leave(group, s)
+	}
+}
+
+// RangeGroup ranges the sessions of the named group. If fn returns false, stop traversing.
+func (p *peer) RangeGroup(group string, fn func(sess Session) bool) {
+	for _, s := range p.groups.members(group) {
+		if !fn(s) {
+			return
+		}
+	}
+}
+
+// CountGroup returns the number of the sessions of the named group.
+func (p *peer) CountGroup(group string) int {
+	return p.groups.count(group)
+}
+
+// Broadcast pushes the message to the sessions of the named group, or all sessions if group is empty,
+// and returns the delivery result of each session.
+// NOTE:
+//
+//	The body is encoded once per body codec, and the plugins of PreWritePush get the encoded []byte body;
+//	The sessions are written concurrently by at most PeerConfig.BroadcastWorkers goroutines.
+func (p *peer) Broadcast(group, serviceMethod string, args interface{}, setting ...MessageSetting) []BroadcastResult {
+	var sessions []*session
+	if group == "" {
+		p.sessHub.rangeCallback(func(s *session) bool {
+			sessions = append(sessions, s)
+			return true
+		})
+	} else {
+		sessions = p.groups.members(group)
+	}
+	results := make([]BroadcastResult, len(sessions))
+	cache := new(bodyCache)
+	var (
+		next int64 = -1
+		wg   sync.WaitGroup
+	)
+	for w := min(p.broadcastWorkers, len(sessions)); w > 0; w-- {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				i := int(atomic.AddInt64(&next, 1))
+				if i >= len(sessions) {
+					return
+				}
+				s := sessions[i]
+				results[i] = BroadcastResult{
+					SessionID: s.ID(),
+					Status:    s.push(serviceMethod, args, cache, setting),
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+// bodyCache caches the encoded body of each body codec.
+type bodyCache struct {
+	mu     sync.Mutex
+	bodies map[byte]*encodedBody
+}
+
+type encodedBody struct {
+	once sync.Once
+	body []byte
+	err  error
+}
+
+// encode returns the encoded body of the message, which is encoded only once per body codec.
+func (c *bodyCache) encode(output socket.Message) ([]byte, error) {
+	c.mu.Lock()
+	if c.bodies == nil {
+		c.bodies = make(map[byte]*encodedBody)
+	}
+	e, ok := c.bodies[output.BodyCodec()]
+	if !ok {
+		e = new(encodedBody)
+		c.bodies[output.BodyCodec()] = e
+	}
+	c.mu.Unlock()
+	e.once.Do(func() {
+		e.body, e.err = output.MarshalBody()
+	})
+	return e.body, e.err
+}
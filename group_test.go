@@ -0,0 +1,118 @@
+package yrpc_test
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/sqos/yrpc"
+	"github.com/sqos/yrpc/codec"
+	"github.com/sqos/yrpc/yrpctest"
+)
+
+// countCodec counts the marshalling.
+type countCodec struct {
+	codec.JSONCodec
+	count atomic.Int32
+}
+
+func (c *countCodec) ID() byte     { return 'Z' }
+func (c *countCodec) Name() string { return "count-json" }
+
+func (c *countCodec) Marshal(v interface{}) ([]byte, error) {
+	c.count.Add(1)
+	return c.JSONCodec.Marshal(v)
+}
+
+var groupCodec = func() *countCodec {
+	c := new(countCodec)
+	codec.Reg(c)
+	return c
+}()
+
+type Room struct {
+	yrpc.CallCtx
+}
+
+func (r *Room) Join(name *string) (bool, *yrpc.Status) {
+	return r.Peer().JoinGroup(*name, r.Session()), nil
+}
+
+var groupReceived = make(chan string, 10)
+
+type GroupPush struct {
+	yrpc.PushCtx
+}
+
+func (g *GroupPush) Notice(arg *string) *yrpc.Status {
+	name, _ := g.Session().Swap().Load("name")
+	groupReceived <- name.(string) + ":" + *arg
+	return nil
+}
+
+func TestBroadcast(t *testing.T) {
+	cc := groupCodec
+	cc.count.Store(0)
+	srv, addr := yrpctest.NewServer(t, yrpc.PeerConfig{BroadcastWorkers: 2})
+	srv.RouteCall(new(Room))
+
+	dial := func(name string) yrpc.Session {
+		cli := yrpctest.NewClient(t, yrpc.PeerConfig{})
+		cli.RoutePush(new(GroupPush))
+		sess := yrpctest.Dial(t, cli, addr)
+		sess.Swap().Store("name", name)
+		return sess
+	}
+	join := func(sess yrpc.Session, room string) {
+		var ok bool
+		stat := sess.Call("/room/join", room, &ok).Status()
+		assert.True(t, stat.OK(), stat)
+		assert.True(t, ok)
+	}
+	collect := func(n int) []string {
+		var list []string
+		for i := 0; i < n; i++ {
+			select {
+			case s := <-groupReceived:
+				list = append(list, s)
+			case <-time.After(time.Second):
+				t.Fatal("receive timeout")
+			}
+		}
+		return list
+	}
+
+	a, b, c := dial("a"), dial("b"), dial("c")
+	join(a, "room1")
+	join(b, "room1")
+	join(c, "room2")
+	assert.Equal(t, 2, srv.CountGroup("room1"))
+
+	results := srv.Broadcast("room1", "/group_push/notice", "hi", yrpc.WithBodyCodec(cc.ID()))
+	assert.Len(t, results, 2)
+	for _, r := range results {
+		assert.True(t, r.Status.OK(), r.Status)
+	}
+	assert.Equal(t, int32(1), cc.count.Load())
+	assert.ElementsMatch(t, []string{"a:hi", "b:hi"}, collect(2))
+
+	// all sessions
+	results = srv.Broadcast("", "/group_push/notice", "all")
+	assert.Len(t, results, 3)
+	assert.ElementsMatch(t, []string{"a:all", "b:all", "c:all"}, collect(3))
+
+	// the server-side session id is the local address of the client-side one
+	sess, _ := srv.GetSession(a.LocalAddr().String())
+	srv.LeaveGroup("room1", sess)
+	assert.Equal(t, 1, srv.CountGroup("room1"))
+
+	// removed after disconnection
+	c.Close()
+	assert.Eventually(t, func() bool {
+		return srv.CountGroup("room2") == 0
+	}, time.Second, 10*time.Millisecond)
+	assert.Empty(t, srv.Broadcast("room2", "/group_push/notice", "none"))
+	assert.Empty(t, srv.Broadcast("no-room", "/group_push/notice", "none"))
+}
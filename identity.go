@@ -0,0 +1,135 @@
+// Copyright 2024 sqos. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package yrpc
+
+import (
+	"container/list"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"sync"
+)
+
+// PeerIdentity is the identity of the remote peer from its TLS certificate.
+type PeerIdentity struct {
+	// CommonName is the subject common name of the leaf certificate.
+	CommonName string
+	// DNSNames is the DNS subject alternative names of the leaf certificate.
+	DNSNames []string
+	// URIs is the URI subject alternative names of the leaf certificate, e.g. SPIFFE IDs.
+	URIs []string
+	// Verified reports whether the certificate chain has been verified by the local peer,
+	// including by the VerifyConnection of TLSReloader with InsecureSkipVerify.
+	Verified bool
+	// Certificates is the certificate chain presented by the remote peer, the first one is the leaf.
+	Certificates []*x509.Certificate
+	// VerifiedChains is the verified chains built by the local peer.
+	VerifiedChains [][]*x509.Certificate
+}
+
+func newPeerIdentity(state *tls.ConnectionState) *PeerIdentity {
+	if len(state.PeerCertificates) == 0 {
+		return nil
+	}
+	leaf := state.PeerCertificates[0]
+	chains := state.VerifiedChains
+	if len(chains) == 0 {
+		chains, _ = verifiedChains.get(leaf)
+	}
+	identity := &PeerIdentity{
+		CommonName:     leaf.Subject.CommonName,
+		DNSNames:       leaf.DNSNames,
+		Verified:       len(chains) > 0,
+		Certificates:   state.PeerCertificates,
+		VerifiedChains: chains,
+	}
+	for _, u := range leaf.URIs {
+		identity.URIs = append(identity.URIs, u.String())
+	}
+	return identity
+}
+
+// tlsConnectionState returns the TLS connection state of the conn or the conn it wraps.
+func tlsConnectionState(conn net.Conn) *tls.ConnectionState {
+	for i := 0; conn != nil && i < 8; i++ {
+		if c, ok := conn.(interface {
+			ConnectionState() tls.ConnectionState
+		}); ok {
+			state := c.ConnectionState()
+			return &state
+		}
+		c, ok := conn.(interface{ NetConn() net.Conn })
+		if !ok {
+			return nil
+		}
+		conn = c.NetConn()
+	}
+	return nil
+}
+
+// verifiedChains records the chains built by the custom verification of TLSReloader,
+// since the tls.ConnectionState has no VerifiedChains if InsecureSkipVerify is set.
+var verifiedChains = newChainCache(1024)
+
+// chainCache the LRU of the verified chains by the leaf certificate
+type chainCache struct {
+	size  int
+	mu    sync.Mutex
+	ll    *list.List
+	items map[*x509.Certificate]*list.Element
+}
+
+type chainEntry struct {
+	leaf   *x509.Certificate
+	chains [][]*x509.Certificate
+}
+
+func newChainCache(size int) *chainCache {
+	return &chainCache{
+		size:  size,
+		ll:    list.New(),
+		items: make(map[*x509.Certificate]*list.Element),
+	}
+}
+
+// add records the chains of the leaf certificate, and evicts the least recently used one if full.
+func (c *chainCache) add(leaf *x509.Certificate, chains [][]*x509.Certificate) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.items[leaf]; ok {
+		e.Value.(*chainEntry).chains = chains
+		c.ll.MoveToFront(e)
+		return
+	}
+	c.items[leaf] = c.ll.PushFront(&chainEntry{leaf: leaf, chains: chains})
+	if c.ll.Len() > c.size {
+		e := c.ll.Back()
+		c.ll.Remove(e)
+		delete(c.items, e.Value.(*chainEntry).leaf)
+	}
+}
+
+// get returns the chains of the leaf certificate.
+// NOTE: The certificate is matched by pointer, i.e. the one of the verified connection.
+func (c *chainCache) get(leaf *x509.Certificate) ([][]*x509.Certificate, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.items[leaf]
+	if !ok {
+		return nil, false
+	}
+	c.ll.MoveToFront(e)
+	return e.Value.(*chainEntry).chains, true
+}
@@ -46,7 +46,13 @@ func NewInheritedListener(addr net.Addr, tlsConfig *tls.Config) (lis net.Listene
 		laddr = popParentLaddr(network, host, laddr)
 	}
 
-	if _network := asQUIC(network); _network != "" {
+	if n, ok := getCustomNetwork(network); ok {
+		lis, err = n.listen(laddr)
+		if err == nil && tlsConfig != nil {
+			lis = tls.NewListener(lis, tlsConfig)
+		}
+
+	} else if _network := asQUIC(network); _network != "" {
 		if tlsConfig == nil {
 			tlsConfig = testTLSConfig
 		}
@@ -2,6 +2,7 @@ package yrpc
 
 import (
 	"strconv"
+	"time"
 
 	"github.com/sqos/yrpc/codec"
 	"github.com/sqos/yrpc/socket"
@@ -69,6 +70,8 @@ const (
 	MetaRealIP = "X-Real-IP"
 	// MetaAcceptBodyCodec the key of body codec that the sender wishes to accept
 	MetaAcceptBodyCodec = "X-Accept-Body-Codec"
+	// MetaRetryAfter the key of the delay seconds after which the rejected message may be retried
+	MetaRetryAfter = "Retry-After"
 )
 
 var (
@@ -81,6 +84,16 @@ var (
 	// PutMessage puts a Message to message pool.
 	//  func PutMessage(m Message)
 	PutMessage = socket.PutMessage
+	// CheckReadLimit returns an error if the size of the received message exceeds the read limit of the peer.
+	// SUGGEST: The Proto that reads the whole message into memory calls it after SetSize.
+	//  func CheckReadLimit(m Message) error
+	CheckReadLimit = socket.CheckReadLimit
+	// ReadLimitOf returns the read limit of the received message, 0 means only GetReadLimit() is checked.
+	//  func ReadLimitOf(m Message) uint32
+	ReadLimitOf = socket.ReadLimitOf
+	// WideSeqOf returns the 64-bit message sequence, whose low 32 bits are m.Seq().
+	//  func WideSeqOf(m Message) int64
+	WideSeqOf = socket.WideSeqOf
 )
 
 var (
@@ -141,6 +154,21 @@ func WithAcceptBodyCodec(bodyCodec byte) MessageSetting {
 	return socket.WithAddMeta(MetaAcceptBodyCodec, strconv.FormatUint(uint64(bodyCodec), 10))
 }
 
+// WithRetryAfter sets the delay after which the rejected message may be retried.
+// NOTE: The delay is rounded up to whole seconds, at least 1 second.
+func WithRetryAfter(delay time.Duration) MessageSetting {
+	return socket.WithSetMeta(MetaRetryAfter, FormatRetryAfter(delay))
+}
+
+// FormatRetryAfter formats the delay as the value of MetaRetryAfter.
+func FormatRetryAfter(delay time.Duration) string {
+	sec := int64((delay + time.Second - 1) / time.Second)
+	if sec < 1 {
+		sec = 1
+	}
+	return strconv.FormatInt(sec, 10)
+}
+
 // withMtype sets the message type.
 func withMtype(mtype byte) MessageSetting {
 	return func(m Message) {
@@ -148,6 +176,19 @@ func withMtype(mtype byte) MessageSetting {
 	}
 }
 
+// GetRetryAfter gets the delay after which the rejected message may be retried.
+func GetRetryAfter(meta *utils.Args) (time.Duration, bool) {
+	s := meta.Peek(MetaRetryAfter)
+	if len(s) == 0 {
+		return 0, false
+	}
+	sec, err := strconv.ParseInt(goutil.BytesToString(s), 10, 64)
+	if err != nil || sec < 0 {
+		return 0, false
+	}
+	return time.Duration(sec) * time.Second, true
+}
+
 // GetAcceptBodyCodec gets the body codec that the sender wishes to accept.
 // NOTE: If the specified codec is invalid, the receiver will ignore the mate data.
 func GetAcceptBodyCodec(meta *utils.Args) (byte, bool) {
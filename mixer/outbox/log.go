@@ -0,0 +1,254 @@
+// Copyright 2024 sqos. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outbox
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LogSuffix is the file name suffix of the queue logs.
+const LogSuffix = ".log"
+
+// record the persisted PUSH message
+type record struct {
+	ServiceMethod string `json:"m"`
+	BodyCodec     byte   `json:"c"`
+	Meta          []byte `json:"h,omitempty"`
+	Body          []byte `json:"b,omitempty"`
+	// Expire is the expiration time in unix nanoseconds.
+	Expire int64 `json:"e"`
+}
+
+func (r *record) expired(now int64) bool {
+	return r.Expire <= now
+}
+
+// queue the append-only log of the messages addressed to a session ID
+// NOTE: Each record is framed by its 4-byte big-endian length, a torn tail is truncated on reading.
+type queue struct {
+	mu       sync.Mutex
+	filename string
+	// maxFrame is the max length of a record frame, the longer one is treated as corrupted.
+	maxFrame int64
+	loaded   bool
+	count    int
+	bytes    int64
+	// refs is the number of the holders of the queue, guarded by Outbox.mu.
+	refs int
+}
+
+var errTornTail = errors.New("outbox: torn tail of the log")
+
+// maxFrameSize returns the max length of a record frame of the max body size.
+// NOTE: The body is base64 encoded in JSON, 64KB is left for the other fields.
+func maxFrameSize(maxBytes int64) int64 {
+	return (maxBytes+2)/3*4 + 64<<10
+}
+
+func logFilename(dir, id string) string {
+	return filepath.Join(dir, hex.EncodeToString([]byte(id))+LogSuffix)
+}
+
+// logID returns the session ID of the log file name, false if it is not a queue log.
+func logID(name string) (string, bool) {
+	if !strings.HasSuffix(name, LogSuffix) {
+		return "", false
+	}
+	id, err := hex.DecodeString(strings.TrimSuffix(name, LogSuffix))
+	if err != nil {
+		return "", false
+	}
+	return string(id), true
+}
+
+// load counts the records of the log once.
+func (q *queue) load() error {
+	if q.loaded {
+		return nil
+	}
+	records, err := q.read()
+	if err != nil {
+		return err
+	}
+	q.count, q.bytes = 0, 0
+	for _, r := range records {
+		q.count++
+		q.bytes += int64(len(r.Body))
+	}
+	q.loaded = true
+	return nil
+}
+
+// read returns all records of the log in order.
+// NOTE: The torn tail is truncated, so the records appended later are not lost behind it.
+func (q *queue) read() ([]*record, error) {
+	f, err := os.Open(q.filename)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var (
+		records []*record
+		rd      = bufio.NewReader(f)
+		head    [4]byte
+		valid   int64
+	)
+	for {
+		if _, err = io.ReadFull(rd, head[:]); err != nil {
+			break
+		}
+		n := binary.BigEndian.Uint32(head[:])
+		if int64(n) > q.maxFrame {
+			err = errTornTail
+			break
+		}
+		b := make([]byte, n)
+		if _, err = io.ReadFull(rd, b); err != nil {
+			break
+		}
+		r := new(record)
+		if err = json.Unmarshal(b, r); err != nil {
+			break
+		}
+		records = append(records, r)
+		valid += int64(len(head) + len(b))
+	}
+	f.Close()
+	if err == io.EOF {
+		return records, nil
+	}
+	// the last append is interrupted, or the log is corrupted
+	return records, os.Truncate(q.filename, valid)
+}
+
+// append appends the record to the log.
+func (q *queue) append(r *record, sync bool) error {
+	b, err := encodeRecord(r)
+	if err != nil {
+		return err
+	}
+	if int64(len(b)-4) > q.maxFrame {
+		return fmt.Errorf("outbox: record size %d exceeds %d", len(b)-4, q.maxFrame)
+	}
+	f, err := os.OpenFile(q.filename, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(b); err == nil && sync {
+		err = f.Sync()
+	}
+	if err2 := f.Close(); err == nil {
+		err = err2
+	}
+	if err != nil {
+		return err
+	}
+	q.count++
+	q.bytes += int64(len(r.Body))
+	return nil
+}
+
+// rewrite replaces the log with the records, removes it if there is no record.
+func (q *queue) rewrite(records []*record, sync bool) error {
+	q.loaded = false
+	if len(records) == 0 {
+		if err := os.Remove(q.filename); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		q.count, q.bytes, q.loaded = 0, 0, true
+		return nil
+	}
+	tmp := q.filename + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	var count int
+	var bytes int64
+	for _, r := range records {
+		var b []byte
+		if b, err = encodeRecord(r); err != nil {
+			break
+		}
+		if _, err = w.Write(b); err != nil {
+			break
+		}
+		count++
+		bytes += int64(len(r.Body))
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil && sync {
+		err = f.Sync()
+	}
+	if err2 := f.Close(); err == nil {
+		err = err2
+	}
+	if err == nil {
+		err = os.Rename(tmp, q.filename)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	q.count, q.bytes, q.loaded = count, bytes, true
+	return nil
+}
+
+// compact removes the expired records, and returns the live ones.
+func (q *queue) compact(sync bool) ([]*record, int, error) {
+	records, err := q.read()
+	if err != nil {
+		return nil, 0, err
+	}
+	now := time.Now().UnixNano()
+	live := records[:0]
+	for _, r := range records {
+		if !r.expired(now) {
+			live = append(live, r)
+		}
+	}
+	expired := len(records) - len(live)
+	if expired > 0 {
+		err = q.rewrite(live, sync)
+	}
+	return live, expired, err
+}
+
+func encodeRecord(r *record) ([]byte, error) {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return nil, err
+	}
+	frame := make([]byte, 4, 4+len(b))
+	binary.BigEndian.PutUint32(frame, uint32(len(b)))
+	return append(frame, b...), nil
+}
@@ -0,0 +1,26 @@
+package outbox
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQueueRelease(t *testing.T) {
+	ob, err := New(Config{Dir: t.TempDir()})
+	assert.NoError(t, err)
+
+	// the empty queues are not kept
+	assert.Equal(t, 0, ob.Queued("a"))
+	assert.Empty(t, ob.queues)
+
+	assert.True(t, ob.Push("a", "/notice/send", "x").OK())
+	assert.Len(t, ob.queues, 1)
+
+	// the queue is dropped after its log is removed
+	q := ob.acquire("a")
+	assert.NoError(t, q.rewrite(nil, false))
+	ob.release("a", q)
+	assert.Empty(t, ob.queues)
+	assert.Equal(t, 0, ob.Queued("a"))
+}
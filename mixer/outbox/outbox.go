@@ -0,0 +1,309 @@
+// Package outbox is a store-and-forward queue of the PUSH messages addressed to the offline sessions.
+//
+// Copyright 2024 sqos. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package outbox
+
+import (
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sqos/yrpc"
+	"github.com/sqos/yrpc/codec"
+)
+
+// CodeQuotaExceeded the queue of the session ID is full.
+const CodeQuotaExceeded int32 = 1480
+
+// Config the outbox config
+type Config struct {
+	// Dir is the directory of the queue logs, required.
+	Dir string
+	// TTL is the time to live of the queued messages, default is 24h.
+	TTL time.Duration
+	// MaxMessages is the max number of the queued messages of a session ID, default is 1000.
+	MaxMessages int
+	// MaxBytes is the max total body size of the queued messages of a session ID, default is 4MB.
+	MaxBytes int64
+	// SyncWrite makes every write of the logs synced to the disk.
+	SyncWrite bool
+}
+
+// Outbox queues the PUSH messages addressed to the offline session IDs on the disk,
+// and delivers them in order when a session with the ID is added to the session hub.
+// NOTE:
+//
+//	It is registered on one peer;
+//	Only the service method, the body codec and the metadata of the message settings are persisted;
+//	The session ID is read when the session is added, so the one set by SetID in PostAccept is used;
+//	The session rejected by the duplicate session ID policy is not added, and the messages stay queued.
+type Outbox struct {
+	cfg       Config
+	peer      yrpc.EarlyPeer
+	bodyCodec byte
+	mu        sync.Mutex
+	queues    map[string]*queue
+}
+
+var (
+	_ yrpc.PreNewPeerPlugin  = (*Outbox)(nil)
+	_ yrpc.PostNewPeerPlugin = (*Outbox)(nil)
+	_ yrpc.PostAddPlugin     = (*Outbox)(nil)
+)
+
+// New creates an outbox that saves the queue logs under cfg.Dir.
+func New(cfg Config) (*Outbox, error) {
+	if cfg.TTL <= 0 {
+		cfg.TTL = 24 * time.Hour
+	}
+	if cfg.MaxMessages <= 0 {
+		cfg.MaxMessages = 1000
+	}
+	if cfg.MaxBytes <= 0 {
+		cfg.MaxBytes = 4 << 20
+	}
+	if err := os.MkdirAll(cfg.Dir, 0o700); err != nil {
+		return nil, err
+	}
+	return &Outbox{
+		cfg:       cfg,
+		bodyCodec: yrpc.DefaultBodyCodec().ID(),
+		queues:    make(map[string]*queue),
+	}, nil
+}
+
+// Name returns the plugin name.
+func (o *Outbox) Name() string {
+	return "outbox"
+}
+
+// PreNewPeer gets the default body codec of the peer.
+func (o *Outbox) PreNewPeer(cfg *yrpc.PeerConfig, _ *yrpc.PluginContainer) error {
+	if c, err := codec.GetByName(cfg.DefaultBodyCodec); err == nil {
+		o.bodyCodec = c.ID()
+	}
+	return nil
+}
+
+// PostNewPeer saves the peer.
+func (o *Outbox) PostNewPeer(peer yrpc.EarlyPeer) error {
+	o.peer = peer
+	return nil
+}
+
+// PostAdd delivers the queued messages asynchronously after the session is added to the session hub.
+func (o *Outbox) PostAdd(sess yrpc.Session) *yrpc.Status {
+	go o.Deliver(sess)
+	return nil
+}
+
+// Push sends the PUSH message to the session of the ID, or queues it if the session is offline.
+// NOTE:
+//
+//	It returns nil if the message is sent or queued;
+//	The message is queued after the ones queued before, even if the session is online,
+//	and then the queue is delivered to the online session at once;
+//	It returns CodeQuotaExceeded if the queue of the ID is full.
+func (o *Outbox) Push(sessID, serviceMethod string, args interface{}, setting ...yrpc.MessageSetting) *yrpc.Status {
+	q := o.acquire(sessID)
+	defer o.release(sessID, q)
+	if err := q.load(); err != nil {
+		return yrpc.NewStatus(yrpc.CodeInternalServerError, "outbox read failed", err.Error())
+	}
+	var (
+		sess   yrpc.Session
+		online bool
+	)
+	if o.peer != nil {
+		sess, online = o.peer.GetSession(sessID)
+	}
+	if q.count == 0 && online {
+		stat := sess.Push(serviceMethod, args, setting...)
+		if !isConnError(stat) {
+			return stat
+		}
+		online = false
+	}
+	r, stat := o.newRecord(serviceMethod, args, setting)
+	if !stat.OK() {
+		return stat
+	}
+	if !o.fits(q, r) {
+		// make room by the expired messages
+		if _, _, err := q.compact(o.cfg.SyncWrite); err != nil {
+			return yrpc.NewStatus(yrpc.CodeInternalServerError, "outbox compact failed", err.Error())
+		}
+		if !o.fits(q, r) {
+			return yrpc.NewStatus(CodeQuotaExceeded, "outbox quota exceeded",
+				"queued messages: "+strconv.Itoa(q.count)+", bytes: "+strconv.FormatInt(q.bytes, 10))
+		}
+	}
+	if err := q.append(r, o.cfg.SyncWrite); err != nil {
+		return yrpc.NewStatus(yrpc.CodeInternalServerError, "outbox write failed", err.Error())
+	}
+	if online && sess.Health() {
+		// the queue is left behind by a failed delivery, or not delivered to the session just added yet
+		o.deliver(sess, q)
+	}
+	return nil
+}
+
+// isConnError returns true if the push failed for the connection, so that it can be retried later.
+func isConnError(stat *yrpc.Status) bool {
+	switch stat.Code() {
+	case yrpc.CodeConnClosed, yrpc.CodeWriteFailed:
+		return true
+	}
+	return false
+}
+
+func (o *Outbox) fits(q *queue, r *record) bool {
+	return q.count < o.cfg.MaxMessages && q.bytes+int64(len(r.Body)) <= o.cfg.MaxBytes
+}
+
+// newRecord encodes the message as the settings of the session.Push do.
+func (o *Outbox) newRecord(serviceMethod string, args interface{}, setting []yrpc.MessageSetting) (*record, *yrpc.Status) {
+	m := yrpc.GetMessage()
+	defer yrpc.PutMessage(m)
+	m.SetServiceMethod(serviceMethod)
+	m.SetBody(args)
+	for _, fn := range setting {
+		if fn != nil {
+			fn(m)
+		}
+	}
+	if m.BodyCodec() == codec.NilCodecID {
+		m.SetBodyCodec(o.bodyCodec)
+	}
+	body, err := m.MarshalBody()
+	if err != nil {
+		return nil, yrpc.NewStatus(yrpc.CodeBadMessage, "outbox encode failed", err.Error())
+	}
+	return &record{
+		ServiceMethod: m.ServiceMethod(),
+		BodyCodec:     m.BodyCodec(),
+		Meta:          append([]byte(nil), m.Meta().QueryString()...),
+		Body:          append([]byte(nil), body...),
+		Expire:        time.Now().Add(o.cfg.TTL).UnixNano(),
+	}, nil
+}
+
+// Deliver sends the queued messages of the session ID in order, and returns the number of the sent messages.
+// NOTE:
+//
+//	It is called automatically after the session is added;
+//	Call it after the session ID is changed later, e.g. by a login handler;
+//	The expired messages are dropped, and so are the ones failed not for the connection;
+//	The delivery stops at the first failure of the connection.
+func (o *Outbox) Deliver(sess yrpc.CtxSession) int {
+	sessID := sess.ID()
+	q := o.acquire(sessID)
+	defer o.release(sessID, q)
+	return o.deliver(sess, q)
+}
+
+// deliver sends the queued messages of the locked queue.
+func (o *Outbox) deliver(sess yrpc.CtxSession, q *queue) int {
+	sessID := sess.ID()
+	records, _, err := q.compact(o.cfg.SyncWrite)
+	if err != nil {
+		sess.Errorf("outbox: read %s failed: %v", sessID, err)
+		return 0
+	}
+	var sent, done int
+	for _, r := range records {
+		meta := r.Meta
+		stat := sess.Push(r.ServiceMethod, r.Body, yrpc.WithBodyCodec(r.BodyCodec), func(m yrpc.Message) {
+			m.Meta().ParseBytes(meta)
+		})
+		if isConnError(stat) {
+			sess.Debugf("outbox: deliver to %s failed: %v", sessID, stat)
+			break
+		}
+		if stat.OK() {
+			sent++
+		} else {
+			sess.Warnf("outbox: drop the message %s to %s: %v", r.ServiceMethod, sessID, stat)
+		}
+		done++
+	}
+	if done > 0 {
+		if err = q.rewrite(records[done:], o.cfg.SyncWrite); err != nil {
+			sess.Errorf("outbox: write %s failed: %v", sessID, err)
+		}
+	}
+	return sent
+}
+
+// Queued returns the number of the queued messages of the session ID, including the expired ones not purged.
+func (o *Outbox) Queued(sessID string) int {
+	q := o.acquire(sessID)
+	defer o.release(sessID, q)
+	if err := q.load(); err != nil {
+		return 0
+	}
+	return q.count
+}
+
+// Purge removes the expired messages of all session IDs, and returns the number of them.
+// NOTE: The expired messages are also dropped on delivery, call it periodically to reclaim the disk space.
+func (o *Outbox) Purge() (int, error) {
+	entries, err := os.ReadDir(o.cfg.Dir)
+	if err != nil {
+		return 0, err
+	}
+	var total int
+	for _, e := range entries {
+		id, ok := logID(e.Name())
+		if !ok || e.IsDir() {
+			continue
+		}
+		q := o.acquire(id)
+		_, n, err := q.compact(o.cfg.SyncWrite)
+		o.release(id, q)
+		if err != nil {
+			return total, err
+		}
+		total += n
+	}
+	return total, nil
+}
+
+// acquire returns the locked queue of the session ID.
+func (o *Outbox) acquire(sessID string) *queue {
+	o.mu.Lock()
+	q, ok := o.queues[sessID]
+	if !ok {
+		q = &queue{filename: logFilename(o.cfg.Dir, sessID), maxFrame: maxFrameSize(o.cfg.MaxBytes)}
+		o.queues[sessID] = q
+	}
+	q.refs++
+	o.mu.Unlock()
+	q.mu.Lock()
+	return q
+}
+
+// release unlocks the queue, and drops it if it is empty and no one else holds it,
+// so the queues of all the IDs ever seen are not kept in memory.
+func (o *Outbox) release(sessID string, q *queue) {
+	q.mu.Unlock()
+	o.mu.Lock()
+	q.refs--
+	if q.refs == 0 && q.count == 0 {
+		delete(o.queues, sessID)
+	}
+	o.mu.Unlock()
+}
@@ -0,0 +1,250 @@
+package outbox_test
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/sqos/yrpc"
+	"github.com/sqos/yrpc/mixer/outbox"
+	"github.com/sqos/yrpc/yrpctest"
+)
+
+type notice struct {
+	yrpc.PushCtx
+}
+
+// pushed is a received message and its sequence.
+type pushed struct {
+	seq int32
+	s   string
+}
+
+var received = make(chan pushed, 10)
+
+func (n *notice) Send(arg *string) *yrpc.Status {
+	received <- pushed{seq: n.Seq(), s: *arg + string(n.PeekMeta("lang"))}
+	return nil
+}
+
+// connect dials the server, and returns the client-side session.
+func connect(t *testing.T, addr string) yrpc.Session {
+	cli := yrpctest.NewClient(t, yrpc.PeerConfig{})
+	cli.RoutePush(new(notice))
+	return yrpctest.Dial(t, cli, addr)
+}
+
+// recv receives n messages, and returns them in the order of sending,
+// since the PUSH handlers run concurrently.
+func recv(t *testing.T, n int) []string {
+	msgs := make([]pushed, n)
+	for i := range msgs {
+		msgs[i] = yrpctest.Receive(t, received)
+	}
+	sort.Slice(msgs, func(i, j int) bool { return msgs[i].seq < msgs[j].seq })
+	r := make([]string, n)
+	for i, m := range msgs {
+		r[i] = m.s
+	}
+	return r
+}
+
+// newServer starts a server that sets the session ID to id, and returns the address.
+func newServer(t *testing.T, ob *outbox.Outbox, id string, plugins ...yrpc.Plugin) string {
+	plugins = append([]yrpc.Plugin{&yrpc.PluginImpl{
+		PluginName: "login",
+		OnPostAccept: func(sess yrpc.PreSession) *yrpc.Status {
+			sess.SetID(id)
+			return nil
+		},
+	}}, append(plugins, ob)...)
+	_, addr := yrpctest.NewServer(t, yrpc.PeerConfig{}, plugins...)
+	return addr
+}
+
+func TestStoreAndForward(t *testing.T) {
+	dir := t.TempDir()
+	ob, err := outbox.New(outbox.Config{Dir: dir})
+	assert.NoError(t, err)
+	addr := newServer(t, ob, "user-1")
+
+	// offline
+	for _, s := range []string{"a", "b"} {
+		stat := ob.Push("user-1", "/notice/send", s)
+		assert.True(t, stat.OK(), stat)
+	}
+	stat := ob.Push("user-1", "/notice/send", "c", yrpc.WithSetMeta("lang", "-en"))
+	assert.True(t, stat.OK(), stat)
+	assert.Equal(t, 3, ob.Queued("user-1"))
+
+	// durable
+	ob2, err := outbox.New(outbox.Config{Dir: dir})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, ob2.Queued("user-1"))
+
+	connect(t, addr)
+	assert.Equal(t, []string{"a", "b", "c-en"}, recv(t, 3))
+	assert.Eventually(t, func() bool {
+		return ob.Queued("user-1") == 0
+	}, time.Second, 10*time.Millisecond)
+
+	// online
+	stat = ob.Push("user-1", "/notice/send", "d")
+	assert.True(t, stat.OK(), stat)
+	assert.Equal(t, []string{"d"}, recv(t, 1))
+	assert.Equal(t, 0, ob.Queued("user-1"))
+	yrpctest.AssertNoReceive(t, received, 50*time.Millisecond)
+}
+
+func TestDeliverFailure(t *testing.T) {
+	ob, err := outbox.New(outbox.Config{Dir: t.TempDir()})
+	assert.NoError(t, err)
+	var writeFailed atomic.Bool
+	addr := newServer(t, ob, "user-4", &yrpc.PluginImpl{
+		PluginName: "reject",
+		OnPreWritePush: func(ctx yrpc.WriteCtx) *yrpc.Status {
+			if writeFailed.Load() {
+				return yrpc.NewStatus(yrpc.CodeWriteFailed, "write failed", "")
+			}
+			if ctx.Output().ServiceMethod() == "/notice/reject" {
+				return yrpc.NewStatus(yrpc.CodeBadMessage, "rejected", "")
+			}
+			return nil
+		},
+	})
+
+	// the rejected message is dropped instead of blocking the queue
+	for _, serviceMethod := range []string{"/notice/send", "/notice/reject", "/notice/send"} {
+		stat := ob.Push("user-4", serviceMethod, "a")
+		assert.True(t, stat.OK(), stat)
+	}
+	connect(t, addr)
+	assert.Equal(t, []string{"a", "a"}, recv(t, 2))
+	assert.Eventually(t, func() bool {
+		return ob.Queued("user-4") == 0
+	}, time.Second, 10*time.Millisecond)
+
+	// the message failed for the connection is queued, and delivered with the next one
+	writeFailed.Store(true)
+	stat := ob.Push("user-4", "/notice/send", "b")
+	assert.True(t, stat.OK(), stat)
+	assert.Equal(t, 1, ob.Queued("user-4"))
+	writeFailed.Store(false)
+	stat = ob.Push("user-4", "/notice/send", "c")
+	assert.True(t, stat.OK(), stat)
+	assert.Equal(t, []string{"b", "c"}, recv(t, 2))
+	assert.Equal(t, 0, ob.Queued("user-4"))
+	yrpctest.AssertNoReceive(t, received, 50*time.Millisecond)
+}
+
+func TestDuplicateRejectNew(t *testing.T) {
+	ob, err := outbox.New(outbox.Config{Dir: t.TempDir()})
+	assert.NoError(t, err)
+	var (
+		writeFailed atomic.Bool
+		failures    atomic.Int32
+	)
+	_, addr := yrpctest.NewServer(t, yrpc.PeerConfig{DuplicateID: yrpc.DuplicateRejectNew}, &yrpc.PluginImpl{
+		PluginName: "login",
+		OnPostAccept: func(sess yrpc.PreSession) *yrpc.Status {
+			sess.SetID("user-5")
+			return nil
+		},
+		OnPreWritePush: func(ctx yrpc.WriteCtx) *yrpc.Status {
+			if writeFailed.Load() {
+				failures.Add(1)
+				return yrpc.NewStatus(yrpc.CodeWriteFailed, "write failed", "")
+			}
+			return nil
+		},
+	}, ob)
+
+	// the delivery to the added session fails, the message stays queued
+	writeFailed.Store(true)
+	stat := ob.Push("user-5", "/notice/send", "a")
+	assert.True(t, stat.OK(), stat)
+	connect(t, addr)
+	assert.Eventually(t, func() bool {
+		return failures.Load() == 1
+	}, time.Second, 10*time.Millisecond)
+	writeFailed.Store(false)
+
+	// the rejected session does not take the queued messages
+	connect(t, addr)
+	yrpctest.AssertNoReceive(t, received, 50*time.Millisecond)
+	assert.Equal(t, 1, ob.Queued("user-5"))
+
+	stat = ob.Push("user-5", "/notice/send", "b")
+	assert.True(t, stat.OK(), stat)
+	assert.Equal(t, []string{"a", "b"}, recv(t, 2))
+	assert.Equal(t, 0, ob.Queued("user-5"))
+}
+
+func TestQuotaAndExpiry(t *testing.T) {
+	ob, err := outbox.New(outbox.Config{Dir: t.TempDir(), MaxMessages: 2, TTL: 50 * time.Millisecond})
+	assert.NoError(t, err)
+	newServer(t, ob, "user-2")
+
+	for i := 0; i < 2; i++ {
+		stat := ob.Push("user-2", "/notice/send", "x")
+		assert.True(t, stat.OK(), stat)
+	}
+	stat := ob.Push("user-2", "/notice/send", "x")
+	assert.Equal(t, outbox.CodeQuotaExceeded, stat.Code())
+
+	time.Sleep(60 * time.Millisecond)
+	// the expired messages make room
+	stat = ob.Push("user-2", "/notice/send", "y")
+	assert.True(t, stat.OK(), stat)
+	assert.Equal(t, 1, ob.Queued("user-2"))
+
+	time.Sleep(60 * time.Millisecond)
+	n, err := ob.Purge()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, 0, ob.Queued("user-2"))
+}
+
+func TestTornTail(t *testing.T) {
+	for _, tail := range [][]byte{
+		// the interrupted append
+		{0, 0, 0, 100, '{', '"', 'm'},
+		// the corrupted length
+		{0xff, 0xff, 0xff, 0xff, 0},
+	} {
+		dir := t.TempDir()
+		ob, err := outbox.New(outbox.Config{Dir: dir})
+		assert.NoError(t, err)
+		stat := ob.Push("user-3", "/notice/send", "a")
+		assert.True(t, stat.OK(), stat)
+
+		logs, _ := filepath.Glob(filepath.Join(dir, "*"+outbox.LogSuffix))
+		if !assert.Len(t, logs, 1) {
+			return
+		}
+		f, err := os.OpenFile(logs[0], os.O_WRONLY|os.O_APPEND, 0)
+		assert.NoError(t, err)
+		_, err = f.Write(tail)
+		assert.NoError(t, err)
+		f.Close()
+
+		// the torn tail is truncated before appending
+		ob, err = outbox.New(outbox.Config{Dir: dir})
+		assert.NoError(t, err)
+		assert.Equal(t, 1, ob.Queued("user-3"))
+		stat = ob.Push("user-3", "/notice/send", "b")
+		assert.True(t, stat.OK(), stat)
+
+		ob, err = outbox.New(outbox.Config{Dir: dir})
+		assert.NoError(t, err)
+		assert.Equal(t, 2, ob.Queued("user-3"))
+		sess := connect(t, newServer(t, ob, "user-3"))
+		assert.Equal(t, []string{"a", "b"}, recv(t, 2))
+		sess.Close()
+	}
+}
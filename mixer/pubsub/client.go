@@ -0,0 +1,99 @@
+// Copyright 2024 sqos. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pubsub
+
+import (
+	"github.com/sqos/yrpc"
+)
+
+// Subscribe subscribes the session to the topic patterns of the remote broker,
+// and returns the current patterns of the session.
+func Subscribe(sess yrpc.CtxSession, patterns ...string) ([]string, *yrpc.Status) {
+	var list []string
+	stat := sess.Call(SubscribeServiceMethod, patterns, &list).Status()
+	return list, stat
+}
+
+// Unsubscribe unsubscribes the session from the topic patterns of the remote broker, or from all if patterns is empty,
+// and returns the current patterns of the session.
+func Unsubscribe(sess yrpc.CtxSession, patterns ...string) ([]string, *yrpc.Status) {
+	var list []string
+	if patterns == nil {
+		patterns = []string{}
+	}
+	stat := sess.Call(UnsubscribeServiceMethod, patterns, &list).Status()
+	return list, stat
+}
+
+// Publish publishes the message by the remote broker,
+// and returns the number of the subscribers that it is queued for.
+func Publish(sess yrpc.CtxSession, topic string, data []byte) (int, *yrpc.Status) {
+	var n int
+	stat := sess.Call(PublishServiceMethod, &PublishArgs{Topic: topic, Data: data}, &n).Status()
+	return n, stat
+}
+
+// NewHandler creates a subscriber plugin, which registers MessageServiceMethod to receive the messages.
+// NOTE: The fn is called in the PUSH handler goroutine.
+func NewHandler(fn func(sess yrpc.CtxSession, msg *Message)) yrpc.Plugin {
+	return &handlerPlugin{fn: fn}
+}
+
+type handlerPlugin struct {
+	fn func(sess yrpc.CtxSession, msg *Message)
+}
+
+var (
+	_ yrpc.PostNewPeerPlugin = (*handlerPlugin)(nil)
+)
+
+func (h *handlerPlugin) Name() string {
+	return "pubsub-handler"
+}
+
+func (h *handlerPlugin) PostNewPeer(peer yrpc.EarlyPeer) error {
+	peer.SubRoute(ServicePrefix, &handlerRoutePlugin{h}).RoutePushFunc((*messagePush).message)
+	return nil
+}
+
+const handlerSwapKey = "pubsub-handler"
+
+// handlerRoutePlugin passes the handler to the PUSH handler.
+type handlerRoutePlugin struct {
+	h *handlerPlugin
+}
+
+var (
+	_ yrpc.PreReadPushBodyPlugin = (*handlerRoutePlugin)(nil)
+)
+
+func (r *handlerRoutePlugin) Name() string {
+	return "pubsub-handler-route"
+}
+
+func (r *handlerRoutePlugin) PreReadPushBody(ctx yrpc.ReadCtx) *yrpc.Status {
+	ctx.Swap().Store(handlerSwapKey, r.h)
+	return nil
+}
+
+type messagePush struct {
+	yrpc.PushCtx
+}
+
+func (m *messagePush) message(msg *Message) *yrpc.Status {
+	h, _ := m.Swap().Load(handlerSwapKey)
+	h.(*handlerPlugin).fn(m.Session(), msg)
+	return nil
+}
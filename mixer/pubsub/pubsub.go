@@ -0,0 +1,385 @@
+// Package pubsub is a topic-based publish/subscribe broker that delivers messages by PUSH.
+//
+// Copyright 2024 sqos. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package pubsub
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/sqos/yrpc"
+)
+
+// The service methods of the broker and the subscriber
+const (
+	ServicePrefix            = "/pubsub"
+	SubscribeServiceMethod   = ServicePrefix + "/subscribe"
+	UnsubscribeServiceMethod = ServicePrefix + "/unsubscribe"
+	PublishServiceMethod     = ServicePrefix + "/publish"
+	// MessageServiceMethod is the PUSH service method of the delivered messages.
+	MessageServiceMethod = ServicePrefix + "/message"
+)
+
+// SlowPolicy is the policy of the subscriber that receives slower than the messages are published.
+type SlowPolicy int
+
+// The slow subscriber policies
+const (
+	// PolicyDrop drops the message if the subscriber is still sending the previous one.
+	PolicyDrop SlowPolicy = iota
+	// PolicyBuffer buffers up to Config.BufferSize messages, and drops the newer ones if the buffer is full.
+	PolicyBuffer
+	// PolicyDisconnect buffers up to Config.BufferSize messages, and closes the session if the buffer is full.
+	PolicyDisconnect
+)
+
+// DefaultBufferSize the default buffer size of a subscriber
+const DefaultBufferSize = 1024
+
+// ErrSessionClosed the error of subscribing the session that has been closed
+var ErrSessionClosed = errors.New("pubsub: session closed")
+
+type (
+	// Message the published message
+	Message struct {
+		Topic string
+		Data  []byte
+	}
+	// PublishArgs the publish arg of the call
+	PublishArgs = Message
+	// Config the broker config
+	Config struct {
+		// Policy is the slow subscriber policy.
+		Policy SlowPolicy
+		// BufferSize is the max number of the buffered messages of a subscriber, default is DefaultBufferSize.
+		// It is ignored by PolicyDrop.
+		BufferSize int
+		// OnDrop is called when a message to the session is dropped, optional.
+		// It is called by the publisher after the broker lock is released, so it may call the broker.
+		OnDrop func(sessID string, msg *Message)
+	}
+	// Stats the broker counters
+	Stats struct {
+		Subscribers int
+		Published   uint64
+		Delivered   uint64
+		Dropped     uint64
+		// Disconnected is the number of the sessions closed by PolicyDisconnect.
+		Disconnected uint64
+	}
+)
+
+// Broker is the pub/sub broker plugin, which registers the service methods under ServicePrefix.
+// NOTE:
+//
+//	The topic is dot-separated tokens, e.g. "order.created.eu";
+//	In the subscription pattern, "*" matches one token, and the trailing ">" matches one or more tokens;
+//	The messages are pushed to each subscriber by its own goroutine in the published order;
+//	The subscriptions of a session are removed after it is disconnected.
+type Broker struct {
+	cfg Config
+	mu  sync.RWMutex
+	// subscribers is keyed by the session, the CtxSession and BaseSession of it are the same key.
+	subscribers map[interface{}]*subscriber
+	stats       struct {
+		published, delivered, dropped, disconnected atomic.Uint64
+	}
+}
+
+var (
+	_ yrpc.PostNewPeerPlugin    = (*Broker)(nil)
+	_ yrpc.PostDisconnectPlugin = (*Broker)(nil)
+)
+
+// NewBroker creates a pub/sub broker plugin.
+func NewBroker(cfg Config) *Broker {
+	if cfg.BufferSize <= 0 {
+		cfg.BufferSize = DefaultBufferSize
+	}
+	return &Broker{
+		cfg:         cfg,
+		subscribers: make(map[interface{}]*subscriber),
+	}
+}
+
+// Name returns the plugin name.
+func (b *Broker) Name() string {
+	return "pubsub"
+}
+
+// PostNewPeer registers the service methods.
+func (b *Broker) PostNewPeer(peer yrpc.EarlyPeer) error {
+	group := peer.SubRoute(ServicePrefix, &brokerRoutePlugin{b})
+	group.RouteCallFunc((*brokerCall).subscribe)
+	group.RouteCallFunc((*brokerCall).unsubscribe)
+	group.RouteCallFunc((*brokerCall).publish)
+	return nil
+}
+
+// PostDisconnect removes the subscriptions of the session.
+func (b *Broker) PostDisconnect(sess yrpc.BaseSession) *yrpc.Status {
+	b.remove(sess)
+	return nil
+}
+
+// Subscribe subscribes the session to the topic patterns, and returns its current patterns.
+// NOTE: It returns ErrSessionClosed if the session has been closed.
+func (b *Broker) Subscribe(sess yrpc.CtxSession, patterns ...string) ([]string, error) {
+	for _, p := range patterns {
+		if err := validPattern(p); err != nil {
+			return nil, err
+		}
+	}
+	b.mu.Lock()
+	// PostDisconnect may have removed the session before it is subscribed
+	if isClosed(sess) {
+		b.mu.Unlock()
+		return nil, ErrSessionClosed
+	}
+	s, ok := b.subscribers[sess]
+	if !ok {
+		s = b.newSubscriber(sess)
+		b.subscribers[sess] = s
+	}
+	for _, p := range patterns {
+		s.patterns[p] = struct{}{}
+	}
+	list := s.patternList()
+	b.mu.Unlock()
+	if isClosed(sess) {
+		b.remove(sess)
+		return nil, ErrSessionClosed
+	}
+	return list, nil
+}
+
+// isClosed returns true if the session has gone away, which is notified before PostDisconnect.
+func isClosed(sess yrpc.CtxSession) bool {
+	select {
+	case <-sess.CloseNotify():
+		return true
+	default:
+		return false
+	}
+}
+
+// Unsubscribe unsubscribes the session from the topic patterns, or from all if patterns is empty,
+// and returns its current patterns.
+func (b *Broker) Unsubscribe(sess yrpc.CtxSession, patterns ...string) []string {
+	if len(patterns) == 0 {
+		b.remove(sess)
+		return []string{}
+	}
+	b.mu.Lock()
+	s, ok := b.subscribers[sess]
+	if !ok {
+		b.mu.Unlock()
+		return []string{}
+	}
+	for _, p := range patterns {
+		delete(s.patterns, p)
+	}
+	list := s.patternList()
+	if len(list) == 0 {
+		delete(b.subscribers, sess)
+		s.stop()
+	}
+	b.mu.Unlock()
+	return list
+}
+
+// Publish publishes the message to the subscribers of the topic,
+// and returns the number of the subscribers that it is queued for.
+func (b *Broker) Publish(topic string, data []byte) (int, error) {
+	if err := validTopic(topic); err != nil {
+		return 0, err
+	}
+	b.stats.published.Add(1)
+	msg := &Message{Topic: topic, Data: data}
+	var (
+		n       int
+		dropped []string
+	)
+	b.mu.RLock()
+	for _, s := range b.subscribers {
+		if !s.match(topic) {
+			continue
+		}
+		if queued, drop := b.enqueue(s, msg); queued {
+			n++
+		} else if drop {
+			dropped = append(dropped, s.sess.ID())
+		}
+	}
+	b.mu.RUnlock()
+	if b.cfg.OnDrop != nil {
+		for _, sessID := range dropped {
+			b.cfg.OnDrop(sessID, msg)
+		}
+	}
+	return n, nil
+}
+
+// Stats returns the counters.
+func (b *Broker) Stats() Stats {
+	b.mu.RLock()
+	n := len(b.subscribers)
+	b.mu.RUnlock()
+	return Stats{
+		Subscribers:  n,
+		Published:    b.stats.published.Load(),
+		Delivered:    b.stats.delivered.Load(),
+		Dropped:      b.stats.dropped.Load(),
+		Disconnected: b.stats.disconnected.Load(),
+	}
+}
+
+func (b *Broker) remove(sess interface{}) {
+	b.mu.Lock()
+	s, ok := b.subscribers[sess]
+	delete(b.subscribers, sess)
+	b.mu.Unlock()
+	if ok {
+		s.stop()
+	}
+}
+
+// enqueue queues the message to the subscriber, and handles the full queue by the policy,
+// dropped is true if the message is dropped by the full queue.
+func (b *Broker) enqueue(s *subscriber, msg *Message) (queued, dropped bool) {
+	select {
+	case s.queue <- msg:
+		return true, false
+	case <-s.done:
+		return false, false
+	default:
+	}
+	b.stats.dropped.Add(1)
+	if b.cfg.Policy == PolicyDisconnect && s.closing.CompareAndSwap(false, true) {
+		b.stats.disconnected.Add(1)
+		if c, ok := s.sess.(interface{ Close() error }); ok {
+			go c.Close()
+		}
+	}
+	return false, true
+}
+
+type subscriber struct {
+	sess     yrpc.CtxSession
+	patterns map[string]struct{} // guarded by Broker.mu
+	queue    chan *Message
+	done     chan struct{}
+	stopOnce sync.Once
+	closing  atomic.Bool
+}
+
+func (b *Broker) newSubscriber(sess yrpc.CtxSession) *subscriber {
+	s := &subscriber{
+		sess:     sess,
+		patterns: make(map[string]struct{}),
+		done:     make(chan struct{}),
+	}
+	if b.cfg.Policy == PolicyDrop {
+		s.queue = make(chan *Message)
+	} else {
+		s.queue = make(chan *Message, b.cfg.BufferSize)
+	}
+	go func() {
+		for {
+			select {
+			case msg := <-s.queue:
+				if stat := sess.Push(MessageServiceMethod, msg); stat.OK() {
+					b.stats.delivered.Add(1)
+				}
+			case <-s.done:
+				return
+			}
+		}
+	}()
+	return s
+}
+
+func (s *subscriber) stop() {
+	s.stopOnce.Do(func() { close(s.done) })
+}
+
+func (s *subscriber) match(topic string) bool {
+	for p := range s.patterns {
+		if matchTopic(p, topic) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *subscriber) patternList() []string {
+	list := make([]string, 0, len(s.patterns))
+	for p := range s.patterns {
+		list = append(list, p)
+	}
+	sort.Strings(list)
+	return list
+}
+
+const brokerSwapKey = "pubsub-broker"
+
+// brokerRoutePlugin passes the broker to the handlers.
+type brokerRoutePlugin struct {
+	b *Broker
+}
+
+var (
+	_ yrpc.PreReadCallBodyPlugin = (*brokerRoutePlugin)(nil)
+)
+
+func (r *brokerRoutePlugin) Name() string {
+	return "pubsub-route"
+}
+
+func (r *brokerRoutePlugin) PreReadCallBody(ctx yrpc.ReadCtx) *yrpc.Status {
+	ctx.Swap().Store(brokerSwapKey, r.b)
+	return nil
+}
+
+type brokerCall struct {
+	yrpc.CallCtx
+}
+
+func (c *brokerCall) broker() *Broker {
+	b, _ := c.Swap().Load(brokerSwapKey)
+	return b.(*Broker)
+}
+
+func (c *brokerCall) subscribe(patterns *[]string) ([]string, *yrpc.Status) {
+	list, err := c.broker().Subscribe(c.Session(), *patterns...)
+	if err != nil {
+		return nil, yrpc.NewStatus(yrpc.CodeBadMessage, yrpc.CodeText(yrpc.CodeBadMessage), err)
+	}
+	return list, nil
+}
+
+func (c *brokerCall) unsubscribe(patterns *[]string) ([]string, *yrpc.Status) {
+	return c.broker().Unsubscribe(c.Session(), *patterns...), nil
+}
+
+func (c *brokerCall) publish(arg *PublishArgs) (int, *yrpc.Status) {
+	n, err := c.broker().Publish(arg.Topic, arg.Data)
+	if err != nil {
+		return 0, yrpc.NewStatus(yrpc.CodeBadMessage, yrpc.CodeText(yrpc.CodeBadMessage), err)
+	}
+	return n, nil
+}
@@ -0,0 +1,211 @@
+package pubsub_test
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/sqos/yrpc"
+	"github.com/sqos/yrpc/mixer/pubsub"
+	"github.com/sqos/yrpc/yrpctest"
+)
+
+func TestMatchTopic(t *testing.T) {
+	cases := []struct {
+		pattern, topic string
+		match          bool
+	}{
+		{"a.b", "a.b", true},
+		{"a.b", "a.c", false},
+		{"a.b", "a.b.c", false},
+		{"a.*", "a.b", true},
+		{"a.*", "a", false},
+		{"a.*", "a.b.c", false},
+		{"*.b.*", "a.b.c", true},
+		{"a.>", "a.b", true},
+		{"a.>", "a.b.c", true},
+		{"a.>", "a", false},
+		{">", "a.b", true},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.match, pubsub.MatchTopic(c.pattern, c.topic), "%s %s", c.pattern, c.topic)
+	}
+}
+
+func dial(t *testing.T, addr string, msgCh chan<- *pubsub.Message) yrpc.Session {
+	cli := yrpctest.NewClient(t, yrpc.PeerConfig{}, pubsub.NewHandler(func(sess yrpc.CtxSession, msg *pubsub.Message) {
+		msgCh <- msg
+	}))
+	return yrpctest.Dial(t, cli, addr)
+}
+
+func TestBroker(t *testing.T) {
+	broker := pubsub.NewBroker(pubsub.Config{Policy: pubsub.PolicyBuffer})
+	_, addr := yrpctest.NewServer(t, yrpc.PeerConfig{}, broker)
+	chA, chB := make(chan *pubsub.Message, 10), make(chan *pubsub.Message, 10)
+	sessA, sessB := dial(t, addr, chA), dial(t, addr, chB)
+
+	list, stat := pubsub.Subscribe(sessA, "order.*", "user.login")
+	assert.True(t, stat.OK(), stat)
+	assert.Equal(t, []string{"order.*", "user.login"}, list)
+	_, stat = pubsub.Subscribe(sessB, "order.>")
+	assert.True(t, stat.OK(), stat)
+	_, stat = pubsub.Subscribe(sessB, "order..x")
+	assert.Equal(t, yrpc.CodeBadMessage, stat.Code())
+
+	// server-side
+	n, err := broker.Publish("order.created", []byte("1"))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, &pubsub.Message{Topic: "order.created", Data: []byte("1")}, yrpctest.Receive(t, chA))
+	assert.Equal(t, "order.created", yrpctest.Receive(t, chB).Topic)
+
+	// via call
+	n, stat = pubsub.Publish(sessA, "order.created.eu", []byte("2"))
+	assert.True(t, stat.OK(), stat)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, "order.created.eu", yrpctest.Receive(t, chB).Topic)
+	yrpctest.AssertNoReceive(t, chA, 50*time.Millisecond)
+	_, stat = pubsub.Publish(sessA, "order.*", nil)
+	assert.Equal(t, yrpc.CodeBadMessage, stat.Code())
+
+	list, stat = pubsub.Unsubscribe(sessA, "order.*")
+	assert.True(t, stat.OK(), stat)
+	assert.Equal(t, []string{"user.login"}, list)
+	n, _ = broker.Publish("order.paid", nil)
+	assert.Equal(t, 1, n)
+	yrpctest.AssertNoReceive(t, chA, 50*time.Millisecond)
+
+	// removed after disconnection
+	sessB.Close()
+	assert.Eventually(t, func() bool {
+		return broker.Stats().Subscribers == 1
+	}, time.Second, 10*time.Millisecond)
+	n, _ = broker.Publish("order.paid", nil)
+	assert.Equal(t, 0, n)
+}
+
+func TestSubscribeClosed(t *testing.T) {
+	broker := pubsub.NewBroker(pubsub.Config{})
+	p := yrpctest.NewPair(t, yrpctest.PairConfig{ServerPlugins: []yrpc.Plugin{broker}})
+	sess := p.Dial()
+	srvSess := p.ServerSession(sess)
+	srvSess.Close()
+
+	// the subscription after PostDisconnect is rejected, instead of leaking
+	_, err := broker.Subscribe(srvSess, "order.*")
+	assert.ErrorIs(t, err, pubsub.ErrSessionClosed)
+	assert.Equal(t, 0, broker.Stats().Subscribers)
+}
+
+// slowSession blocks the PUSH until unblocked.
+type slowSession struct {
+	yrpc.CtxSession
+	unblock chan struct{}
+	pushed  atomic.Int32
+	closed  atomic.Bool
+}
+
+func (s *slowSession) ID() string { return "slow" }
+
+func (s *slowSession) Push(string, interface{}, ...yrpc.MessageSetting) *yrpc.Status {
+	<-s.unblock
+	s.pushed.Add(1)
+	return nil
+}
+
+func (s *slowSession) Close() error {
+	s.closed.Store(true)
+	return nil
+}
+
+func (s *slowSession) CloseNotify() <-chan struct{} { return nil }
+
+func TestSlowPolicy(t *testing.T) {
+	for _, policy := range []pubsub.SlowPolicy{pubsub.PolicyDrop, pubsub.PolicyBuffer, pubsub.PolicyDisconnect} {
+		var dropped int
+		broker := pubsub.NewBroker(pubsub.Config{
+			Policy:     policy,
+			BufferSize: 2,
+			OnDrop: func(sessID string, msg *pubsub.Message) {
+				assert.Equal(t, "slow", sessID)
+				dropped++
+			},
+		})
+		sess := &slowSession{unblock: make(chan struct{})}
+		_, err := broker.Subscribe(sess, "a")
+		assert.NoError(t, err)
+
+		// the first message is being pushed
+		assert.Eventually(t, func() bool {
+			n, _ := broker.Publish("a", nil)
+			return n == 1
+		}, time.Second, time.Millisecond)
+		// the drops before the subscriber goroutine starts are not counted
+		base := dropped
+		time.Sleep(10 * time.Millisecond)
+		for i := 0; i < 3; i++ {
+			broker.Publish("a", nil)
+		}
+		close(sess.unblock)
+
+		assert.Equal(t, uint64(dropped), broker.Stats().Dropped)
+		dropped -= base
+		switch policy {
+		case pubsub.PolicyDrop:
+			assert.Equal(t, 3, dropped)
+			assert.False(t, sess.closed.Load())
+		case pubsub.PolicyBuffer:
+			assert.Equal(t, 1, dropped)
+			assert.False(t, sess.closed.Load())
+		case pubsub.PolicyDisconnect:
+			assert.Equal(t, 1, dropped)
+			assert.Eventually(t, sess.closed.Load, time.Second, time.Millisecond)
+			assert.Equal(t, uint64(1), broker.Stats().Disconnected)
+		}
+		assert.Eventually(t, func() bool {
+			return int(sess.pushed.Load()) == 4-dropped
+		}, time.Second, time.Millisecond)
+	}
+}
+
+// TestDropUnsubscribe checks that OnDrop may call the broker.
+func TestDropUnsubscribe(t *testing.T) {
+	var (
+		broker  *pubsub.Broker
+		pushing atomic.Bool
+	)
+	sess := &slowSession{unblock: make(chan struct{})}
+	defer close(sess.unblock)
+	broker = pubsub.NewBroker(pubsub.Config{
+		Policy: pubsub.PolicyDrop,
+		OnDrop: func(sessID string, msg *pubsub.Message) {
+			// the drops before the subscriber goroutine starts are ignored
+			if pushing.Load() {
+				broker.Unsubscribe(sess)
+			}
+		},
+	})
+	_, err := broker.Subscribe(sess, "a")
+	assert.NoError(t, err)
+
+	// the first message is being pushed
+	assert.Eventually(t, func() bool {
+		n, _ := broker.Publish("a", nil)
+		return n == 1
+	}, time.Second, time.Millisecond)
+	pushing.Store(true)
+	done := make(chan struct{})
+	go func() {
+		broker.Publish("a", nil)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("publish is blocked by OnDrop")
+	}
+	assert.Equal(t, 0, broker.Stats().Subscribers)
+}
@@ -0,0 +1,69 @@
+// Copyright 2024 sqos. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pubsub
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	wildcardOne  = "*"
+	wildcardTail = ">"
+)
+
+// MatchTopic reports whether the topic matches the subscription pattern.
+func MatchTopic(pattern, topic string) bool {
+	return matchTopic(pattern, topic)
+}
+
+func matchTopic(pattern, topic string) bool {
+	for {
+		var p, t string
+		p, pattern, _ = strings.Cut(pattern, ".")
+		if p == wildcardTail {
+			return topic != ""
+		}
+		if topic == "" {
+			return false
+		}
+		t, topic, _ = strings.Cut(topic, ".")
+		if p != wildcardOne && p != t {
+			return false
+		}
+		if pattern == "" {
+			return topic == ""
+		}
+	}
+}
+
+func validTopic(topic string) error {
+	for _, t := range strings.Split(topic, ".") {
+		if t == "" || t == wildcardOne || t == wildcardTail {
+			return fmt.Errorf("pubsub: invalid topic %q", topic)
+		}
+	}
+	return nil
+}
+
+func validPattern(pattern string) error {
+	tokens := strings.Split(pattern, ".")
+	for i, t := range tokens {
+		if t == "" || (t == wildcardTail && i != len(tokens)-1) {
+			return fmt.Errorf("pubsub: invalid pattern %q", pattern)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,375 @@
+// Copyright 2024 sqos. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transfer
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"time"
+
+	"github.com/sqos/yrpc"
+)
+
+// DefaultChunkSize the default chunk size of the client
+const DefaultChunkSize = 256 << 10
+
+// ClientConfig the transfer client config
+type ClientConfig struct {
+	// ChunkSize is the size of a chunk, default is DefaultChunkSize.
+	ChunkSize int
+	// Concurrency is the max number of the concurrent transfers, 0 means no limit.
+	// The excess transfers wait for their turn.
+	Concurrency int
+	// BytesPerSecond is the bandwidth limit shared by all transfers, 0 means no limit.
+	BytesPerSecond int64
+	// Retries is the max number of the consecutive retries without progress, default is 3, negative means no retry.
+	Retries int
+	// RetryInterval is the interval between the retries, default is 1s.
+	// NOTE: It should be longer than the redial time of the session.
+	RetryInterval time.Duration
+	// OnProgress is called after each acknowledged chunk, optional.
+	OnProgress func(name string, done, total int64)
+	// Settings are applied to every message, e.g. yrpc.WithXferPipe('m') of xfer/md5.
+	Settings []yrpc.MessageSetting
+}
+
+// Client is the transfer client.
+// NOTE:
+//
+//	The client resumes the transfer from the acknowledged offset after a retryable error,
+//	such as the disconnection of a session with PeerConfig.RedialTimes;
+//	An interrupted upload can also be resumed by uploading the same content to the same name again later;
+//	The transfer restarts from the beginning if the content of the upload or the remote object of the download is changed.
+type Client struct {
+	sess          yrpc.CtxSession
+	chunkSize     int
+	retries       int
+	retryInterval time.Duration
+	onProgress    func(name string, done, total int64)
+	settings      []yrpc.MessageSetting
+	sem           chan struct{}
+	limiter       *limiter
+}
+
+// NewClient creates a transfer client of the session.
+func NewClient(sess yrpc.CtxSession, cfg ClientConfig) *Client {
+	if cfg.ChunkSize <= 0 {
+		cfg.ChunkSize = DefaultChunkSize
+	}
+	if cfg.Retries == 0 {
+		cfg.Retries = 3
+	} else if cfg.Retries < 0 {
+		cfg.Retries = 0
+	}
+	if cfg.RetryInterval <= 0 {
+		cfg.RetryInterval = time.Second
+	}
+	c := &Client{
+		sess:          sess,
+		chunkSize:     cfg.ChunkSize,
+		retries:       cfg.Retries,
+		retryInterval: cfg.RetryInterval,
+		onProgress:    cfg.OnProgress,
+		settings:      cfg.Settings,
+		limiter:       newLimiter(cfg.BytesPerSecond),
+	}
+	if cfg.Concurrency > 0 {
+		c.sem = make(chan struct{}, cfg.Concurrency)
+	}
+	return c
+}
+
+// Stat returns the information of the remote object.
+func (c *Client) Stat(name string) (*Info, *yrpc.Status) {
+	info := new(Info)
+	stat := c.call(StatServiceMethod, name, info)
+	if !stat.OK() {
+		return nil, stat
+	}
+	return info, nil
+}
+
+// Upload uploads the data of the size as the remote object.
+// NOTE: The data is read twice, the first time computes the upload ID.
+func (c *Client) Upload(name string, r io.ReaderAt, size int64) *yrpc.Status {
+	id, err := uploadID(r, size)
+	if err != nil {
+		return localStatus(err)
+	}
+	c.acquire()
+	defer c.release()
+	t := &task{name: name, id: id, size: size, acked: -1}
+	buf := make([]byte, c.chunkSize)
+	return c.do(t, func() *yrpc.Status {
+		return c.upload(t, r, buf)
+	})
+}
+
+// UploadFile uploads the local file as the remote object.
+func (c *Client) UploadFile(name, filename string) *yrpc.Status {
+	f, err := os.Open(filename)
+	if err != nil {
+		return localStatus(err)
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return localStatus(err)
+	}
+	return c.Upload(name, f, fi.Size())
+}
+
+// Download downloads the remote object from the offset, and returns the size written in total.
+// NOTE: The offset is trusted, the download restarts from 0 only if the object is changed during the call.
+func (c *Client) Download(name string, w io.WriterAt, offset int64) (int64, *yrpc.Status) {
+	t := &task{name: name, acked: offset}
+	stat := c.downloadTask(t, w)
+	return t.acked, stat
+}
+
+func (c *Client) downloadTask(t *task, w io.WriterAt) *yrpc.Status {
+	c.acquire()
+	defer c.release()
+	return c.do(t, func() *yrpc.Status {
+		return c.download(t, w)
+	})
+}
+
+// DownloadFile downloads the remote object to the local file.
+// NOTE:
+//
+//	The data is written to filename+PartialSuffix first, which is resumed by the next call if failed;
+//	The version of the remote object is saved to filename+PartialIDSuffix,
+//	the partial file is not resumed if the object is changed.
+func (c *Client) DownloadFile(name, filename string) *yrpc.Status {
+	f, err := os.OpenFile(filename+PartialSuffix, os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return localStatus(err)
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return localStatus(err)
+	}
+	version, err := os.ReadFile(filename + PartialIDSuffix)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		f.Close()
+		return localStatus(err)
+	}
+	t := &task{name: name, version: string(version), acked: fi.Size()}
+	if t.version == "" {
+		t.acked = 0
+	}
+	t.pin = func(version string) error {
+		// the data of the other version is never resumed with this version
+		if err := f.Truncate(0); err != nil {
+			return err
+		}
+		return os.WriteFile(filename+PartialIDSuffix, []byte(version), 0o644)
+	}
+	stat := c.downloadTask(t, f)
+	if !stat.OK() {
+		f.Close()
+		return stat
+	}
+	size := t.acked
+	// the object may be smaller than the stale partial file
+	err = f.Truncate(size)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(filename+PartialSuffix, filename)
+	}
+	if err == nil {
+		if err = os.Remove(filename + PartialIDSuffix); errors.Is(err, fs.ErrNotExist) {
+			err = nil
+		}
+	}
+	return localStatus(err)
+}
+
+type task struct {
+	name string
+	// id is the upload ID.
+	id string
+	// version is the version of the downloading object.
+	version string
+	// pin is called before downloading the version from 0, optional.
+	pin        func(version string) error
+	size       int64
+	acked      int64
+	committing bool
+}
+
+// uploadID returns the ID of the upload content, which is made of the size and the SHA-256 of the data.
+func uploadID(r io.ReaderAt, size int64) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, io.NewSectionReader(r, 0, size)); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x-%x", size, h.Sum(nil)), nil
+}
+
+// do runs the transfer, and retries it if the error is retryable.
+func (c *Client) do(t *task, fn func() *yrpc.Status) *yrpc.Status {
+	var retries int
+	for {
+		acked := t.acked
+		stat := fn()
+		if stat.OK() || !isRetryable(stat.Code()) {
+			return stat
+		}
+		if t.acked > acked {
+			retries = 0
+		}
+		if retries >= c.retries {
+			return stat
+		}
+		retries++
+		time.Sleep(c.retryInterval)
+	}
+}
+
+func (c *Client) upload(t *task, r io.ReaderAt, buf []byte) *yrpc.Status {
+	info, stat := c.Stat(t.name)
+	if stat.Code() == yrpc.CodeNotFound {
+		info, stat = &Info{Size: -1, Partial: -1}, nil
+	}
+	if !stat.OK() {
+		return stat
+	}
+	if t.committing && info.Partial < 0 && info.Size == t.size {
+		// the reply of the last commit is lost
+		return nil
+	}
+	offset := info.Partial
+	if offset < 0 || offset > t.size || info.UploadID != t.id {
+		offset = 0
+	}
+	c.progress(t, offset)
+	synced := offset == info.Partial && info.UploadID == t.id
+	for !synced || offset < t.size {
+		n := int(min(int64(len(buf)), t.size-offset))
+		data := buf[:n]
+		if _, err := r.ReadAt(data, offset); err != nil && !errors.Is(err, io.EOF) {
+			return localStatus(err)
+		}
+		c.limiter.wait(n)
+		reply := new(WriteReply)
+		stat = c.call(WriteServiceMethod, &WriteArgs{
+			Name:     t.name,
+			UploadID: t.id,
+			Offset:   offset,
+			Data:     data,
+			Checksum: Checksum(data),
+		}, reply)
+		if !stat.OK() {
+			return stat
+		}
+		offset, synced = reply.Offset, true
+		c.progress(t, offset)
+	}
+	t.committing = true
+	return c.call(CommitServiceMethod, &CommitArgs{Name: t.name, UploadID: t.id, Size: t.size}, new(Info))
+}
+
+func (c *Client) download(t *task, w io.WriterAt) *yrpc.Status {
+	info, stat := c.Stat(t.name)
+	if !stat.OK() {
+		return stat
+	}
+	if info.Size < 0 {
+		return yrpc.NewStatus(yrpc.CodeNotFound, yrpc.CodeText(yrpc.CodeNotFound), fmt.Sprintf("object %q is not complete", t.name))
+	}
+	t.size = info.Size
+	if t.version != info.Version {
+		// the data written from the other version is overwritten
+		if t.version != "" {
+			t.acked = 0
+		}
+		if t.pin != nil {
+			if err := t.pin(info.Version); err != nil {
+				return localStatus(err)
+			}
+		}
+		t.version = info.Version
+	}
+	if t.acked < 0 || t.acked > t.size {
+		t.acked = 0
+	}
+	c.progress(t, t.acked)
+	for {
+		reply := new(ReadReply)
+		stat = c.call(ReadServiceMethod, &ReadArgs{Name: t.name, Version: t.version, Offset: t.acked, Length: c.chunkSize}, reply)
+		if !stat.OK() {
+			return stat
+		}
+		if Checksum(reply.Data) != reply.Checksum {
+			return yrpc.NewStatus(CodeChecksumMismatch, "checksum mismatch", fmt.Sprintf("chunk at offset %d", t.acked))
+		}
+		if _, err := w.WriteAt(reply.Data, t.acked); err != nil {
+			return localStatus(err)
+		}
+		c.limiter.wait(len(reply.Data))
+		c.progress(t, t.acked+int64(len(reply.Data)))
+		if reply.EOF {
+			return nil
+		}
+	}
+}
+
+func (c *Client) call(serviceMethod string, arg, result interface{}) *yrpc.Status {
+	return c.sess.Call(serviceMethod, arg, result, c.settings...).Status()
+}
+
+func (c *Client) progress(t *task, acked int64) {
+	t.acked = acked
+	if c.onProgress != nil {
+		c.onProgress(t.name, acked, t.size)
+	}
+}
+
+func (c *Client) acquire() {
+	if c.sem != nil {
+		c.sem <- struct{}{}
+	}
+}
+
+func (c *Client) release() {
+	if c.sem != nil {
+		<-c.sem
+	}
+}
+
+func isRetryable(code int32) bool {
+	switch code {
+	case yrpc.CodeWrongConn, yrpc.CodeConnClosed, yrpc.CodeWriteFailed, yrpc.CodeDialFailed,
+		yrpc.CodeHandleTimeout, yrpc.CodeServiceUnavailable, CodeChecksumMismatch, CodeOffsetMismatch:
+		return true
+	}
+	return false
+}
+
+func localStatus(err error) *yrpc.Status {
+	if err == nil {
+		return nil
+	}
+	return yrpc.NewStatus(yrpc.CodeUnknownError, "local I/O error", err)
+}
@@ -0,0 +1,184 @@
+// Copyright 2024 sqos. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transfer
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// PartialSuffix is the file name suffix of the partial objects of LocalStorage.
+	PartialSuffix = ".part"
+	// PartialIDSuffix is the file name suffix of the identity of the partial objects,
+	// which is the upload ID for LocalStorage and the object version for Client.DownloadFile.
+	PartialIDSuffix = PartialSuffix + ".id"
+)
+
+// ErrInvalidName the error of the invalid object name
+var ErrInvalidName = errors.New("transfer: invalid object name")
+
+type (
+	// Storage is the object storage of the transfer server.
+	// NOTE:
+	//
+	//	An upload is appended to the partial object, which becomes the complete one after Commit;
+	//	The methods of the same name are not called concurrently by the server.
+	Storage interface {
+		// Stat returns the information of the complete and the partial object,
+		// returns an error satisfying errors.Is(err, fs.ErrNotExist) if neither exists.
+		Stat(name string) (Info, error)
+		// Append truncates the partial object to the offset, then appends the data to it,
+		// and returns the new size of the partial object.
+		// The upload ID is saved with the partial object if the offset is 0, which restarts the upload.
+		Append(name, uploadID string, offset int64, data []byte) (int64, error)
+		// Commit replaces the complete object with the partial one.
+		Commit(name string) error
+		// ReadAt reads the complete object at the offset, the same as io.ReaderAt.
+		ReadAt(name string, p []byte, offset int64) (int, error)
+	}
+	// Info the object information
+	Info struct {
+		// Size is the size of the complete object, -1 if it does not exist.
+		Size int64
+		// Version identifies the content of the complete object, which changes if the object is replaced.
+		Version string
+		// Partial is the acknowledged size of the partial object, -1 if it does not exist.
+		Partial int64
+		// UploadID is the upload ID of the partial object, the partial object of another upload is not resumed.
+		UploadID string
+	}
+)
+
+// LocalStorage is the Storage of the local file system.
+type LocalStorage struct {
+	dir string
+}
+
+var _ Storage = (*LocalStorage)(nil)
+
+// NewLocalStorage creates a Storage that saves the objects under the directory.
+// NOTE:
+//
+//	The object name is a slash-separated path relative to the directory;
+//	The version of the complete object is made of its size and modification time.
+func NewLocalStorage(dir string) *LocalStorage {
+	return &LocalStorage{dir: dir}
+}
+
+// Stat returns the information of the object.
+func (l *LocalStorage) Stat(name string) (Info, error) {
+	filename, err := l.filename(name)
+	if err != nil {
+		return Info{}, err
+	}
+	info := Info{Size: -1, Partial: -1}
+	if fi, err := os.Stat(filename); err == nil {
+		info.Size = fi.Size()
+		info.Version = fmt.Sprintf("%x-%x", fi.Size(), fi.ModTime().UnixNano())
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return Info{}, err
+	}
+	if fi, err := os.Stat(filename + PartialSuffix); err == nil {
+		info.Partial = fi.Size()
+		// the partial object without the upload ID is never resumed
+		id, err := os.ReadFile(filename + PartialIDSuffix)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return Info{}, err
+		}
+		info.UploadID = string(id)
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return Info{}, err
+	}
+	if info.Size < 0 && info.Partial < 0 {
+		return Info{}, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+	}
+	return info, nil
+}
+
+// Append appends the data to the partial object at the offset.
+func (l *LocalStorage) Append(name, uploadID string, offset int64, data []byte) (int64, error) {
+	filename, err := l.filename(name)
+	if err != nil {
+		return 0, err
+	}
+	if err = os.MkdirAll(filepath.Dir(filename), 0o755); err != nil {
+		return 0, err
+	}
+	f, err := os.OpenFile(filename+PartialSuffix, os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	if err = f.Truncate(offset); err != nil {
+		return 0, err
+	}
+	// the old partial data is truncated before the new upload ID is saved
+	if offset == 0 {
+		if err = os.WriteFile(filename+PartialIDSuffix, []byte(uploadID), 0o644); err != nil {
+			return 0, err
+		}
+	}
+	if _, err = f.WriteAt(data, offset); err != nil {
+		return 0, err
+	}
+	if err = f.Sync(); err != nil {
+		return 0, err
+	}
+	return offset + int64(len(data)), nil
+}
+
+// Commit renames the partial object to the complete one.
+func (l *LocalStorage) Commit(name string) error {
+	filename, err := l.filename(name)
+	if err != nil {
+		return err
+	}
+	if err = os.Rename(filename+PartialSuffix, filename); err != nil {
+		return err
+	}
+	if err = os.Remove(filename + PartialIDSuffix); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// ReadAt reads the complete object at the offset.
+func (l *LocalStorage) ReadAt(name string, p []byte, offset int64) (int, error) {
+	filename, err := l.filename(name)
+	if err != nil {
+		return 0, err
+	}
+	f, err := os.Open(filename)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	return f.ReadAt(p, offset)
+}
+
+// filename returns the local file name of the object, which never escapes the directory.
+func (l *LocalStorage) filename(name string) (string, error) {
+	clean := strings.TrimPrefix(path.Clean("/"+name), "/")
+	if clean == "" || strings.HasSuffix(clean, PartialSuffix) || strings.HasSuffix(clean, PartialIDSuffix) {
+		return "", fmt.Errorf("%w %q", ErrInvalidName, name)
+	}
+	return filepath.Join(l.dir, filepath.FromSlash(clean)), nil
+}
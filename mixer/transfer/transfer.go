@@ -0,0 +1,341 @@
+// Package transfer is a resumable file and blob transfer service over yrpc sessions.
+//
+// Copyright 2024 sqos. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package transfer
+
+import (
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"hash/fnv"
+	"io"
+	"io/fs"
+	"sync"
+
+	"github.com/sqos/yrpc"
+)
+
+// The service methods of the transfer server
+const (
+	ServicePrefix       = "/transfer"
+	StatServiceMethod   = ServicePrefix + "/stat"
+	WriteServiceMethod  = ServicePrefix + "/write"
+	CommitServiceMethod = ServicePrefix + "/commit"
+	ReadServiceMethod   = ServicePrefix + "/read"
+)
+
+// The status codes of the transfer service
+const (
+	// CodeChecksumMismatch the chunk is corrupted, it is safe to send it again.
+	CodeChecksumMismatch int32 = 1460
+	// CodeOffsetMismatch the chunk offset is not the acknowledged one, or the object is changed,
+	// the client should stat and resume.
+	CodeOffsetMismatch int32 = 1461
+)
+
+// DefaultMaxChunkSize the default max size of a chunk
+const DefaultMaxChunkSize = 4 << 20
+
+type (
+	// WriteArgs the chunk of an upload
+	WriteArgs struct {
+		Name string
+		// UploadID identifies the content of the upload, see Info.UploadID.
+		UploadID string
+		Offset   int64
+		Data     []byte
+		// Checksum is the CRC-32C of Data.
+		Checksum uint32
+	}
+	// WriteReply the reply of an upload chunk
+	WriteReply struct {
+		// Offset is the acknowledged size of the partial object.
+		Offset int64
+	}
+	// CommitArgs the end of an upload
+	CommitArgs struct {
+		Name     string
+		UploadID string
+		// Size is the expected size of the object.
+		Size int64
+	}
+	// ReadArgs the chunk request of a download
+	ReadArgs struct {
+		Name string
+		// Version is the version of the object when the download starts, not checked if empty.
+		Version string
+		Offset  int64
+		Length  int
+	}
+	// ReadReply the chunk of a download
+	ReadReply struct {
+		Data []byte
+		// Checksum is the CRC-32C of Data.
+		Checksum uint32
+		// EOF reports whether the chunk reaches the end of the object.
+		EOF bool
+	}
+)
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// Checksum returns the CRC-32C checksum of the chunk data.
+func Checksum(data []byte) uint32 {
+	return crc32.Checksum(data, crc32cTable)
+}
+
+// ServerConfig the transfer server config
+type ServerConfig struct {
+	// Storage is the object storage, required.
+	Storage Storage
+	// MaxConcurrent is the max number of the chunks handled concurrently, 0 means no limit.
+	// The excess chunks are rejected with yrpc.CodeServiceUnavailable, and the client retries later.
+	MaxConcurrent int
+	// BytesPerSecond is the bandwidth limit shared by all transfers, 0 means no limit.
+	BytesPerSecond int64
+	// MaxChunkSize is the max size of a chunk, default is DefaultMaxChunkSize.
+	MaxChunkSize int
+}
+
+// Server is the transfer server plugin, which registers the service methods under ServicePrefix.
+// NOTE:
+//
+//	The chunks of a transfer are sent in order, and the server acknowledges the offset of each one,
+//	so the client resumes from the acknowledged offset after redialing;
+//	PeerConfig.ReadLimit and socket.MessageSizeLimit must be larger than the chunk size.
+type Server struct {
+	storage      Storage
+	maxChunkSize int
+	sem          chan struct{}
+	limiter      *limiter
+	locks        [64]sync.Mutex
+}
+
+var (
+	_ yrpc.PostNewPeerPlugin = (*Server)(nil)
+)
+
+// NewServer creates a transfer server plugin.
+func NewServer(cfg ServerConfig) *Server {
+	if cfg.Storage == nil {
+		panic("transfer: ServerConfig.Storage is nil")
+	}
+	if cfg.MaxChunkSize <= 0 {
+		cfg.MaxChunkSize = DefaultMaxChunkSize
+	}
+	s := &Server{
+		storage:      cfg.Storage,
+		maxChunkSize: cfg.MaxChunkSize,
+		limiter:      newLimiter(cfg.BytesPerSecond),
+	}
+	if cfg.MaxConcurrent > 0 {
+		s.sem = make(chan struct{}, cfg.MaxConcurrent)
+	}
+	return s
+}
+
+// Name returns the plugin name.
+func (s *Server) Name() string {
+	return "transfer"
+}
+
+// PostNewPeer registers the service methods.
+func (s *Server) PostNewPeer(peer yrpc.EarlyPeer) error {
+	group := peer.SubRoute(ServicePrefix, &routePlugin{s})
+	group.RouteCallFunc((*transferCall).stat)
+	group.RouteCallFunc((*transferCall).write)
+	group.RouteCallFunc((*transferCall).commit)
+	group.RouteCallFunc((*transferCall).read)
+	return nil
+}
+
+func (s *Server) acquire() *yrpc.Status {
+	if s.sem == nil {
+		return nil
+	}
+	select {
+	case s.sem <- struct{}{}:
+		return nil
+	default:
+		return yrpc.NewStatus(yrpc.CodeServiceUnavailable, yrpc.CodeText(yrpc.CodeServiceUnavailable), "too many concurrent transfers")
+	}
+}
+
+func (s *Server) release() {
+	if s.sem != nil {
+		<-s.sem
+	}
+}
+
+// lock locks the object name, so the chunks of the same name are not handled concurrently.
+func (s *Server) lock(name string) func() {
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	mu := &s.locks[h.Sum32()%uint32(len(s.locks))]
+	mu.Lock()
+	return mu.Unlock
+}
+
+const serverSwapKey = "transfer-server"
+
+// routePlugin passes the server to the handlers.
+type routePlugin struct {
+	s *Server
+}
+
+var (
+	_ yrpc.PreReadCallBodyPlugin = (*routePlugin)(nil)
+)
+
+func (r *routePlugin) Name() string {
+	return "transfer-route"
+}
+
+func (r *routePlugin) PreReadCallBody(ctx yrpc.ReadCtx) *yrpc.Status {
+	ctx.Swap().Store(serverSwapKey, r.s)
+	return nil
+}
+
+type transferCall struct {
+	yrpc.CallCtx
+}
+
+func (c *transferCall) server() *Server {
+	s, _ := c.Swap().Load(serverSwapKey)
+	return s.(*Server)
+}
+
+func (c *transferCall) stat(name *string) (*Info, *yrpc.Status) {
+	s := c.server()
+	defer s.lock(*name)()
+	info, err := s.storage.Stat(*name)
+	if err != nil {
+		return nil, storageStatus(err)
+	}
+	return &info, nil
+}
+
+func (c *transferCall) write(arg *WriteArgs) (*WriteReply, *yrpc.Status) {
+	s := c.server()
+	if len(arg.Data) > s.maxChunkSize {
+		return nil, yrpc.NewStatus(yrpc.CodeBadMessage, yrpc.CodeText(yrpc.CodeBadMessage),
+			fmt.Sprintf("chunk size %d exceeds %d", len(arg.Data), s.maxChunkSize))
+	}
+	if Checksum(arg.Data) != arg.Checksum {
+		return nil, yrpc.NewStatus(CodeChecksumMismatch, "checksum mismatch", fmt.Sprintf("chunk at offset %d", arg.Offset))
+	}
+	if stat := s.acquire(); !stat.OK() {
+		return nil, stat
+	}
+	defer s.release()
+	s.limiter.wait(len(arg.Data))
+	defer s.lock(arg.Name)()
+	// offset 0 restarts the upload
+	if arg.Offset != 0 {
+		info, err := s.storage.Stat(arg.Name)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, storageStatus(err)
+		}
+		if err != nil || info.Partial != arg.Offset {
+			return nil, yrpc.NewStatus(CodeOffsetMismatch, "offset mismatch",
+				fmt.Sprintf("chunk offset %d, acknowledged offset %d", arg.Offset, info.Partial))
+		}
+		if info.UploadID != arg.UploadID {
+			return nil, yrpc.NewStatus(CodeOffsetMismatch, "offset mismatch",
+				fmt.Sprintf("the partial object belongs to the upload %q", info.UploadID))
+		}
+	}
+	offset, err := s.storage.Append(arg.Name, arg.UploadID, arg.Offset, arg.Data)
+	if err != nil {
+		return nil, storageStatus(err)
+	}
+	return &WriteReply{Offset: offset}, nil
+}
+
+func (c *transferCall) commit(arg *CommitArgs) (*Info, *yrpc.Status) {
+	s := c.server()
+	defer s.lock(arg.Name)()
+	info, err := s.storage.Stat(arg.Name)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, storageStatus(err)
+	}
+	if err != nil || info.Partial != arg.Size {
+		return nil, yrpc.NewStatus(CodeOffsetMismatch, "offset mismatch",
+			fmt.Sprintf("object size %d, acknowledged offset %d", arg.Size, info.Partial))
+	}
+	if info.UploadID != arg.UploadID {
+		return nil, yrpc.NewStatus(CodeOffsetMismatch, "offset mismatch",
+			fmt.Sprintf("the partial object belongs to the upload %q", info.UploadID))
+	}
+	if err = s.storage.Commit(arg.Name); err != nil {
+		return nil, storageStatus(err)
+	}
+	if info, err = s.storage.Stat(arg.Name); err != nil {
+		return nil, storageStatus(err)
+	}
+	return &info, nil
+}
+
+func (c *transferCall) read(arg *ReadArgs) (*ReadReply, *yrpc.Status) {
+	s := c.server()
+	if arg.Length <= 0 || arg.Length > s.maxChunkSize {
+		return nil, yrpc.NewStatus(yrpc.CodeBadMessage, yrpc.CodeText(yrpc.CodeBadMessage),
+			fmt.Sprintf("chunk size %d is out of range (0, %d]", arg.Length, s.maxChunkSize))
+	}
+	if stat := s.acquire(); !stat.OK() {
+		return nil, stat
+	}
+	defer s.release()
+	data, eof, stat := s.readChunk(arg)
+	if !stat.OK() {
+		return nil, stat
+	}
+	// throttle after the name lock is released, as write does before taking it
+	s.limiter.wait(len(data))
+	return &ReadReply{Data: data, Checksum: Checksum(data), EOF: eof}, nil
+}
+
+// readChunk reads the chunk under the name lock, so the object is not replaced while reading.
+func (s *Server) readChunk(arg *ReadArgs) ([]byte, bool, *yrpc.Status) {
+	defer s.lock(arg.Name)()
+	if arg.Version != "" {
+		info, err := s.storage.Stat(arg.Name)
+		if err != nil {
+			return nil, false, storageStatus(err)
+		}
+		if info.Version != arg.Version {
+			return nil, false, yrpc.NewStatus(CodeOffsetMismatch, "version mismatch",
+				fmt.Sprintf("object version %q, download version %q", info.Version, arg.Version))
+		}
+	}
+	data := make([]byte, arg.Length)
+	n, err := s.storage.ReadAt(arg.Name, data, arg.Offset)
+	eof := errors.Is(err, io.EOF)
+	if err != nil && !eof {
+		return nil, false, storageStatus(err)
+	}
+	return data[:n], eof, nil
+}
+
+func storageStatus(err error) *yrpc.Status {
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return yrpc.NewStatus(yrpc.CodeNotFound, yrpc.CodeText(yrpc.CodeNotFound), err)
+	case errors.Is(err, ErrInvalidName):
+		return yrpc.NewStatus(yrpc.CodeBadMessage, yrpc.CodeText(yrpc.CodeBadMessage), err)
+	default:
+		return yrpc.NewStatus(yrpc.CodeInternalServerError, yrpc.CodeText(yrpc.CodeInternalServerError), err)
+	}
+}
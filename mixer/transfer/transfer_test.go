@@ -0,0 +1,253 @@
+package transfer_test
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/sqos/yrpc"
+	"github.com/sqos/yrpc/mixer/transfer"
+	"github.com/sqos/yrpc/yrpctest"
+)
+
+// countStorage counts the appended bytes.
+type countStorage struct {
+	transfer.Storage
+	appended atomic.Int64
+}
+
+func (s *countStorage) Append(name, uploadID string, offset int64, data []byte) (int64, error) {
+	s.appended.Add(int64(len(data)))
+	return s.Storage.Append(name, uploadID, offset, data)
+}
+
+// failReader fails at the offset from the second pass, the first pass computes the upload ID.
+type failReader struct {
+	*bytes.Reader
+	failAt int64
+	passed bool
+}
+
+func (r *failReader) ReadAt(p []byte, off int64) (int, error) {
+	if off >= r.failAt {
+		if r.passed {
+			return 0, errors.New("read failed")
+		}
+		r.passed = off+int64(len(p)) >= r.Size()
+	}
+	return r.Reader.ReadAt(p, off)
+}
+
+func newSession(t *testing.T, storage transfer.Storage) yrpc.Session {
+	return yrpctest.NewPair(t, yrpctest.PairConfig{
+		ServerPlugins: []yrpc.Plugin{transfer.NewServer(transfer.ServerConfig{
+			Storage:      storage,
+			MaxChunkSize: 64 << 10,
+		})},
+	}).Dial()
+}
+
+func randBytes(n int) []byte {
+	b := make([]byte, n)
+	rand.Read(b)
+	return b
+}
+
+func TestUpload(t *testing.T) {
+	dir := t.TempDir()
+	storage := &countStorage{Storage: transfer.NewLocalStorage(dir)}
+	sess := newSession(t, storage)
+	data := randBytes(300 << 10)
+
+	var progress []int64
+	cli := transfer.NewClient(sess, transfer.ClientConfig{
+		ChunkSize: 64 << 10,
+		Retries:   -1,
+		OnProgress: func(name string, done, total int64) {
+			if name == "a/b.bin" {
+				assert.Equal(t, int64(len(data)), total)
+				progress = append(progress, done)
+			}
+		},
+	})
+
+	// interrupted
+	stat := cli.Upload("a/b.bin", &failReader{Reader: bytes.NewReader(data), failAt: 128 << 10}, int64(len(data)))
+	assert.False(t, stat.OK())
+	info, stat := cli.Stat("a/b.bin")
+	if assert.True(t, stat.OK(), stat) {
+		assert.Equal(t, int64(-1), info.Size)
+		assert.Equal(t, int64(128<<10), info.Partial)
+		assert.NotEmpty(t, info.UploadID)
+	}
+
+	// resumed from the acknowledged offset
+	progress = nil
+	stat = cli.Upload("a/b.bin", bytes.NewReader(data), int64(len(data)))
+	assert.True(t, stat.OK(), stat)
+	assert.Equal(t, []int64{128 << 10, 192 << 10, 256 << 10, 300 << 10}, progress)
+	assert.Equal(t, int64(len(data)), storage.appended.Load())
+	b, err := os.ReadFile(filepath.Join(dir, "a", "b.bin"))
+	assert.NoError(t, err)
+	assert.Equal(t, data, b)
+
+	// the partial object of the other content is not resumed
+	stat = cli.Upload("a/b.bin", &failReader{Reader: bytes.NewReader(data), failAt: 128 << 10}, int64(len(data)))
+	assert.False(t, stat.OK())
+	other := randBytes(len(data))
+	progress = nil
+	storage.appended.Store(0)
+	stat = cli.Upload("a/b.bin", bytes.NewReader(other), int64(len(other)))
+	assert.True(t, stat.OK(), stat)
+	assert.Equal(t, int64(0), progress[0])
+	assert.Equal(t, int64(len(other)), storage.appended.Load())
+	b, err = os.ReadFile(filepath.Join(dir, "a", "b.bin"))
+	assert.NoError(t, err)
+	assert.Equal(t, other, b)
+
+	// the empty object
+	assert.True(t, cli.Upload("empty", bytes.NewReader(nil), 0).OK())
+	info, stat = cli.Stat("empty")
+	if assert.True(t, stat.OK(), stat) {
+		assert.Equal(t, int64(0), info.Size)
+		assert.Equal(t, int64(-1), info.Partial)
+	}
+
+	_, stat = cli.Stat("none")
+	assert.Equal(t, yrpc.CodeNotFound, stat.Code())
+}
+
+func TestChunkCheck(t *testing.T) {
+	sess := newSession(t, transfer.NewLocalStorage(t.TempDir()))
+	data := []byte("hello")
+	stat := sess.Call(transfer.WriteServiceMethod, &transfer.WriteArgs{Name: "x", Data: data, Checksum: 1}, new(transfer.WriteReply)).Status()
+	assert.Equal(t, transfer.CodeChecksumMismatch, stat.Code())
+
+	reply := new(transfer.WriteReply)
+	stat = sess.Call(transfer.WriteServiceMethod, &transfer.WriteArgs{Name: "x", Data: data, Checksum: transfer.Checksum(data)}, reply).Status()
+	assert.True(t, stat.OK(), stat)
+	assert.Equal(t, int64(5), reply.Offset)
+
+	stat = sess.Call(transfer.WriteServiceMethod, &transfer.WriteArgs{Name: "x", Offset: 3, Data: data, Checksum: transfer.Checksum(data)}, reply).Status()
+	assert.Equal(t, transfer.CodeOffsetMismatch, stat.Code())
+
+	stat = sess.Call(transfer.CommitServiceMethod, &transfer.CommitArgs{Name: "x", Size: 6}, new(transfer.Info)).Status()
+	assert.Equal(t, transfer.CodeOffsetMismatch, stat.Code())
+
+	stat = sess.Call(transfer.WriteServiceMethod, &transfer.WriteArgs{Name: "x", Data: make([]byte, 65<<10)}, reply).Status()
+	assert.Equal(t, yrpc.CodeBadMessage, stat.Code())
+}
+
+func TestDownload(t *testing.T) {
+	dir := t.TempDir()
+	data := randBytes(200 << 10)
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "blob"), data, 0o644))
+	sess := newSession(t, transfer.NewLocalStorage(dir))
+
+	var first int64 = -1
+	cli := transfer.NewClient(sess, transfer.ClientConfig{
+		ChunkSize: 64 << 10,
+		OnProgress: func(name string, done, total int64) {
+			if first < 0 {
+				first = done
+			}
+		},
+	})
+	local := filepath.Join(t.TempDir(), "blob")
+
+	// the partial file without the object version is not resumed
+	assert.NoError(t, os.WriteFile(local+transfer.PartialSuffix, randBytes(300<<10), 0o644))
+	stat := cli.DownloadFile("blob", local)
+	assert.True(t, stat.OK(), stat)
+	assert.Equal(t, int64(0), first)
+	b, err := os.ReadFile(local)
+	assert.NoError(t, err)
+	assert.Equal(t, data, b)
+	assert.NoFileExists(t, local+transfer.PartialIDSuffix)
+
+	stat = cli.DownloadFile("none", local)
+	assert.Equal(t, yrpc.CodeNotFound, stat.Code())
+}
+
+func TestResumeAfterRedial(t *testing.T) {
+	dir := t.TempDir()
+	storage := &countStorage{Storage: transfer.NewLocalStorage(dir)}
+	p := yrpctest.NewPair(t, yrpctest.PairConfig{
+		Client: yrpc.PeerConfig{RedialTimes: 3, RedialInterval: 10 * time.Millisecond},
+		ServerPlugins: []yrpc.Plugin{transfer.NewServer(transfer.ServerConfig{
+			Storage:      storage,
+			MaxChunkSize: 64 << 10,
+		})},
+	})
+	sess := p.Dial()
+	// newClient creates a client that disconnects the session once after acknowledging 128KB.
+	newClient := func(retries int, progress *[]int64) *transfer.Client {
+		var dropped bool
+		return transfer.NewClient(sess, transfer.ClientConfig{
+			ChunkSize:     64 << 10,
+			Retries:       retries,
+			RetryInterval: 100 * time.Millisecond,
+			OnProgress: func(name string, done, total int64) {
+				*progress = append(*progress, done)
+				if done == 128<<10 && !dropped {
+					dropped = true
+					assert.Equal(t, 1, yrpctest.DropConns(p.Addr))
+				}
+			},
+		})
+	}
+
+	// the upload is resumed after redialing
+	data := randBytes(300 << 10)
+	var progress []int64
+	stat := newClient(0, &progress).Upload("blob", bytes.NewReader(data), int64(len(data)))
+	assert.True(t, stat.OK(), stat)
+	assert.Equal(t, []int64{0, 64 << 10, 128 << 10, 128 << 10, 192 << 10, 256 << 10, 300 << 10}, progress)
+	assert.Equal(t, int64(len(data)), storage.appended.Load())
+
+	// the interrupted download is resumed by the next call
+	local := filepath.Join(t.TempDir(), "blob")
+	progress = nil
+	stat = newClient(-1, &progress).DownloadFile("blob", local)
+	assert.False(t, stat.OK())
+	progress = nil
+	stat = newClient(0, &progress).DownloadFile("blob", local)
+	assert.True(t, stat.OK(), stat)
+	assert.Equal(t, int64(128<<10), progress[0])
+	b, err := os.ReadFile(local)
+	assert.NoError(t, err)
+	assert.Equal(t, data, b)
+
+	// the interrupted download is restarted if the object is changed
+	progress = nil
+	stat = newClient(-1, &progress).DownloadFile("blob", local)
+	assert.False(t, stat.OK())
+	other := randBytes(len(data))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "blob"), other, 0o644))
+	progress = nil
+	stat = newClient(0, &progress).DownloadFile("blob", local)
+	assert.True(t, stat.OK(), stat)
+	assert.Equal(t, int64(0), progress[0])
+	b, err = os.ReadFile(local)
+	assert.NoError(t, err)
+	assert.Equal(t, other, b)
+}
+
+func TestBandwidth(t *testing.T) {
+	sess := newSession(t, transfer.NewLocalStorage(t.TempDir()))
+	cli := transfer.NewClient(sess, transfer.ClientConfig{
+		ChunkSize:      25 << 10,
+		BytesPerSecond: 200 << 10,
+	})
+	start := time.Now()
+	stat := cli.Upload("x", bytes.NewReader(randBytes(100<<10)), 100<<10)
+	assert.True(t, stat.OK(), stat)
+	assert.GreaterOrEqual(t, time.Since(start), 300*time.Millisecond)
+}
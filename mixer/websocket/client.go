@@ -26,6 +26,7 @@ import (
 	"github.com/sqos/yrpc/mixer/websocket/jsonSubProto"
 	"github.com/sqos/yrpc/mixer/websocket/pbSubProto"
 	ws "github.com/sqos/yrpc/mixer/websocket/websocket"
+	"github.com/sqos/yrpc/proto/jsonrpc2"
 )
 
 // Client a websocket client
@@ -52,6 +53,11 @@ func (c *Client) DialProtobuf(addr string) (yrpc.Session, *yrpc.Status) {
 	return c.Dial(addr, pbSubProto.NewPbSubProtoFunc())
 }
 
+// DialJSONRPC2 connects with the JSON-RPC 2.0 protocol.
+func (c *Client) DialJSONRPC2(addr string) (yrpc.Session, *yrpc.Status) {
+	return c.Dial(addr, jsonrpc2.NewJSONRPC2SubProtoFunc())
+}
+
 // Dial connects with the peer of the destination address.
 func (c *Client) Dial(addr string, protoFunc ...yrpc.ProtoFunc) (yrpc.Session, *yrpc.Status) {
 	if len(protoFunc) == 0 {
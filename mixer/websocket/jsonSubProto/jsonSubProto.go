@@ -3,12 +3,14 @@ package jsonSubProto
 
 import (
 	"bytes"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"sync"
 
 	"github.com/sqos/yrpc"
+	"github.com/sqos/yrpc/codec"
 	"github.com/sqos/goutil"
 	"github.com/tidwall/gjson"
 )
@@ -51,6 +53,9 @@ func (j *jsonSubProto) Pack(m yrpc.Message) error {
 	if err != nil {
 		return err
 	}
+	if isBinaryCodec(m.BodyCodec()) {
+		bodyBytes = goutil.StringToBytes(base64.StdEncoding.EncodeToString(bodyBytes))
+	}
 	// marshal transfer pipe ids
 	var xferPipeIDs = make([]int, m.XferPipe().Len())
 	for i, id := range m.XferPipe().IDs() {
@@ -103,7 +108,14 @@ func (j *jsonSubProto) Unpack(m yrpc.Message) error {
 	// read body
 	m.SetBodyCodec(byte(gjson.Get(s, "bodyCodec").Int()))
 	body := gjson.Get(s, "body").String()
-	bodyBytes, err := m.XferPipe().OnUnpack(goutil.StringToBytes(body))
+	bodyBytes := goutil.StringToBytes(body)
+	if isBinaryCodec(m.BodyCodec()) {
+		bodyBytes, err = base64.StdEncoding.DecodeString(body)
+		if err != nil {
+			return err
+		}
+	}
+	bodyBytes, err = m.XferPipe().OnUnpack(bodyBytes)
 	if err != nil {
 		return err
 	}
@@ -119,3 +131,12 @@ func (j *jsonSubProto) Unpack(m yrpc.Message) error {
 	err = m.UnmarshalBody(bodyBytes)
 	return err
 }
+
+// isBinaryCodec returns whether the body is binary and needs base64 encoding in JSON.
+func isBinaryCodec(codecID byte) bool {
+	switch codecID {
+	case codec.ID_MSGPACK, codec.ID_CBOR:
+		return true
+	}
+	return false
+}
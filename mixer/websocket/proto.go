@@ -77,12 +77,20 @@ func (w *wsProto) Pack(m yrpc.Message) error {
 	if err != nil {
 		return err
 	}
+	// the sub-protocol may defer the writing, e.g. JSON-RPC 2.0 batch reply
+	if w.subConn.w.Len() == 0 {
+		return nil
+	}
 	return ws.Message.Send(w.conn, w.subConn.w.Bytes())
 }
 
 // Unpack reads bytes from the connection to the Message.
 // NOTE: Concurrent unsafe!
 func (w *wsProto) Unpack(m yrpc.Message) error {
+	// the sub-protocol may unpack multiple messages from one frame, e.g. JSON-RPC 2.0 batch
+	if p, ok := w.subProto.(pendingProto); ok && p.Pending() {
+		return w.subProto.Unpack(m)
+	}
 	err := ws.Message.Receive(w.conn, w.subConn.rBytes)
 	if err != nil {
 		return err
@@ -91,6 +99,11 @@ func (w *wsProto) Unpack(m yrpc.Message) error {
 	return w.subProto.Unpack(m)
 }
 
+// pendingProto is the sub-protocol that has the unpacked messages of the last frame.
+type pendingProto interface {
+	Pending() bool
+}
+
 func newVirtualConn() *virtualConn {
 	buf := new([]byte)
 	return &virtualConn{
@@ -28,6 +28,7 @@ import (
 	"github.com/sqos/yrpc/mixer/websocket/jsonSubProto"
 	"github.com/sqos/yrpc/mixer/websocket/pbSubProto"
 	ws "github.com/sqos/yrpc/mixer/websocket/websocket"
+	"github.com/sqos/yrpc/proto/jsonrpc2"
 )
 
 // Server a websocket server
@@ -76,6 +77,11 @@ func (srv *Server) ListenAndServeProtobuf() error {
 	return srv.ListenAndServe(pbSubProto.NewPbSubProtoFunc())
 }
 
+// ListenAndServeJSONRPC2 listen and serve with the JSON-RPC 2.0 protocol.
+func (srv *Server) ListenAndServeJSONRPC2() error {
+	return srv.ListenAndServe(jsonrpc2.NewJSONRPC2SubProtoFunc())
+}
+
 // ListenAndServe listens on the TCP network address addr and then calls
 // Serve with handler to handle requests on incoming connections.
 // Accepted connections are configured to enable TCP keep-alives.
@@ -143,6 +149,11 @@ func NewPbServeHandler(peer yrpc.Peer, handshake func(*ws.Config, *http.Request)
 	return NewServeHandler(peer, handshake, pbSubProto.NewPbSubProtoFunc())
 }
 
+// NewJSONRPC2ServeHandler creates a websocket JSON-RPC 2.0 handler.
+func NewJSONRPC2ServeHandler(peer yrpc.Peer, handshake func(*ws.Config, *http.Request) error) http.Handler {
+	return NewServeHandler(peer, handshake, jsonrpc2.NewJSONRPC2SubProtoFunc())
+}
+
 // NewServeHandler creates a websocket handler.
 func NewServeHandler(peer yrpc.Peer, handshake func(*ws.Config, *http.Request) error, protoFunc ...yrpc.ProtoFunc) http.Handler {
 	w := &serverHandler{
@@ -1,8 +1,12 @@
 package websocket_test
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
 
@@ -10,6 +14,7 @@ import (
 	ws "github.com/sqos/yrpc/mixer/websocket"
 	"github.com/sqos/yrpc/mixer/websocket/jsonSubProto"
 	"github.com/sqos/yrpc/mixer/websocket/pbSubProto"
+	"github.com/sqos/yrpc/mixer/websocket/websocket"
 	"github.com/sqos/yrpc/plugin/auth"
 	"github.com/sqos/goutil"
 )
@@ -246,3 +251,54 @@ var handshakePlugin = ws.NewHandshakeAuthPlugin(
 		return nil
 	},
 )
+
+func TestJSONRPC2WebsocketBatch(t *testing.T) {
+	srv := yrpc.NewPeer(yrpc.PeerConfig{})
+	defer srv.Close()
+	srv.RouteCall(new(P))
+	httpSrv := httptest.NewServer(ws.NewJSONRPC2ServeHandler(srv, nil))
+	defer httpSrv.Close()
+
+	conn, err := websocket.Dial("ws"+strings.TrimPrefix(httpSrv.URL, "http"), "", httpSrv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// the batch and the next request are in two frames
+	err = websocket.Message.Send(conn, `[`+
+		`{"jsonrpc":"2.0","method":"/p/divide","params":{"A":10,"B":2},"id":1},`+
+		`{"jsonrpc":"2.0","method":"/p/divide","params":{"A":10,"B":5},"id":2}]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = websocket.Message.Send(conn, `{"jsonrpc":"2.0","method":"/p/divide","params":{"A":9,"B":3},"id":3}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	results := map[float64]float64{}
+	for len(results) < 3 {
+		var b []byte
+		if err = websocket.Message.Receive(conn, &b); err != nil {
+			t.Fatalf("results %v: %v", results, err)
+		}
+		var replies []map[string]interface{}
+		if b[0] == '[' {
+			err = json.Unmarshal(b, &replies)
+		} else {
+			replies = append(replies, nil)
+			err = json.Unmarshal(b, &replies[0])
+		}
+		if err != nil {
+			t.Fatalf("%v: %s", err, b)
+		}
+		for _, reply := range replies {
+			results[reply["id"].(float64)], _ = reply["result"].(float64)
+		}
+	}
+	want := map[float64]float64{1: 5, 2: 2, 3: 3}
+	if !reflect.DeepEqual(want, results) {
+		t.Fatalf("want %v, got %v", want, results)
+	}
+}
@@ -0,0 +1,64 @@
+// Copyright 2024 sqos. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package yrpc
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+type (
+	// ListenFunc listens on the local address of a custom network.
+	ListenFunc func(laddr string) (net.Listener, error)
+	// DialFunc dials the address of a custom network.
+	DialFunc func(laddr, raddr string, timeout time.Duration) (net.Conn, error)
+)
+
+type customNetwork struct {
+	listen ListenFunc
+	dial   DialFunc
+}
+
+var (
+	customNetworks   = make(map[string]*customNetwork)
+	customNetworksMu sync.RWMutex
+)
+
+// RegNetwork registers a custom network, which can be used as PeerConfig.Network.
+// NOTE:
+//
+//	It panics if the network is built-in or already registered;
+//	The TLS config of the peer is applied to the custom network connections.
+func RegNetwork(network string, listen ListenFunc, dial DialFunc) {
+	switch network {
+	case "tcp", "tcp4", "tcp6", "unix", "unixpacket", "kcp", "udp", "udp4", "udp6", "quic":
+		panic(fmt.Sprintf("built-in network: %s", network))
+	}
+	customNetworksMu.Lock()
+	defer customNetworksMu.Unlock()
+	if _, ok := customNetworks[network]; ok {
+		panic(fmt.Sprintf("multi-register network: %s", network))
+	}
+	customNetworks[network] = &customNetwork{listen: listen, dial: dial}
+}
+
+func getCustomNetwork(network string) (*customNetwork, bool) {
+	customNetworksMu.RLock()
+	defer customNetworksMu.RUnlock()
+	n, ok := customNetworks[network]
+	return n, ok
+}
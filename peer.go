@@ -16,6 +16,7 @@
 package yrpc
 
 import (
+	"context"
 	"crypto/tls"
 	"fmt"
 	"net"
@@ -25,6 +26,7 @@ import (
 	"github.com/sqos/yrpc/codec"
 	"github.com/sqos/yrpc/kcp"
 	"github.com/sqos/yrpc/quic"
+	"github.com/sqos/yrpc/socket"
 	"github.com/sqos/goutil"
 	"github.com/sqos/goutil/coarsetime"
 	"github.com/sqos/goutil/errors"
@@ -39,8 +41,21 @@ type (
 		CountSession() int
 		// GetSession gets the session by id.
 		GetSession(sessionID string) (Session, bool)
+		// GetSessions gets all sessions of the id, the latest first.
+		GetSessions(sessionID string) []Session
 		// RangeSession ranges all sessions. If fn returns false, stop traversing.
 		RangeSession(fn func(sess Session) bool)
+		// JoinGroup adds the session to the named group, returns false if the session is closed.
+		JoinGroup(group string, sess CtxSession) bool
+		// LeaveGroup removes the session from the named group.
+		LeaveGroup(group string, sess CtxSession)
+		// RangeGroup ranges the sessions of the named group. If fn returns false, stop traversing.
+		RangeGroup(group string, fn func(sess Session) bool)
+		// CountGroup returns the number of the sessions of the named group.
+		CountGroup(group string) int
+		// Broadcast pushes the message to the sessions of the named group, or all sessions if group is empty,
+		// and returns the delivery result of each session.
+		Broadcast(group, serviceMethod string, args interface{}, setting ...MessageSetting) []BroadcastResult
 		// SetTLSConfig sets the TLS config.
 		SetTLSConfig(tlsConfig *tls.Config)
 		// SetTLSConfigFromFile sets the TLS config from file.
@@ -83,6 +98,14 @@ type (
 		//  Not check TLS;
 		//  Execute the PostAcceptPlugin plugins.
 		ServeConn(conn net.Conn, protoFunc ...ProtoFunc) (Session, *Status)
+		// ServeMessage reads one message from the connection, handles it synchronously
+		// and writes the reply (if any) back to the connection.
+		// NOTE:
+		//  The session is temporary and is not added to the session hub;
+		//  Not execute the PostAcceptPlugin and PostDisconnectPlugin plugins;
+		//  Not close the connection;
+		//  Returns the handling status of the PUSH message, e.g. CodeNotFound.
+		ServeMessage(conn net.Conn, protoFunc ...ProtoFunc) *Status
 	}
 )
 
@@ -96,6 +119,7 @@ type peer struct {
 	router            *Router
 	pluginContainer   *PluginContainer
 	sessHub           *SessionHub
+	groups            *sessionGroups
 	closeCh           chan struct{}
 	defaultSessionAge time.Duration // Default session max age, if less than or equal to 0, no time limit
 	defaultContextAge time.Duration // Default CALL or PUSH context max age, if less than or equal to 0, no time limit
@@ -107,6 +131,8 @@ type peer struct {
 	defaultBodyCodec  byte
 	printDetail       bool
 	countTime         bool
+	readLimit         uint32
+	broadcastWorkers  int
 
 	// only for server role
 	listenAddr net.Addr
@@ -129,7 +155,8 @@ func NewPeer(cfg PeerConfig, globalLeftPlugin ...Plugin) Peer {
 	var p = &peer{
 		router:            newRouter(pluginContainer),
 		pluginContainer:   pluginContainer,
-		sessHub:           newSessionHub(),
+		sessHub:           newSessionHub(cfg.DuplicateID),
+		groups:            newSessionGroups(),
 		defaultSessionAge: cfg.DefaultSessionAge,
 		defaultContextAge: cfg.DefaultContextAge,
 		closeCh:           make(chan struct{}),
@@ -138,6 +165,8 @@ func NewPeer(cfg PeerConfig, globalLeftPlugin ...Plugin) Peer {
 		listenAddr:        cfg.listenAddr,
 		printDetail:       cfg.PrintDetail,
 		countTime:         cfg.CountTime,
+		readLimit:         cfg.ReadLimit,
+		broadcastWorkers:  cfg.BroadcastWorkers,
 		listeners:         make(map[net.Listener]struct{}),
 		dialer: &Dialer{
 			network:        cfg.Network,
@@ -180,6 +209,7 @@ func (p *peer) SetTLSConfig(tlsConfig *tls.Config) {
 }
 
 // SetTLSConfigFromFile sets the TLS config from file.
+// NOTE: The certificate is loaded once, use NewTLSReloader to rotate it without restarting.
 func (p *peer) SetTLSConfigFromFile(tlsCertFile, tlsKeyFile string, insecureSkipVerifyForClient ...bool) error {
 	tlsConfig, err := NewTLSConfigFromFile(tlsCertFile, tlsKeyFile, insecureSkipVerifyForClient...)
 	if err == nil {
@@ -193,11 +223,49 @@ func (p *peer) GetSession(sessionID string) (Session, bool) {
 	return p.sessHub.get(sessionID)
 }
 
+// GetSessions gets all sessions of the id, the latest first.
+// NOTE: There is more than one only if PeerConfig.DuplicateID is DuplicateAllowMultiple.
+func (p *peer) GetSessions(sessionID string) []Session {
+	all := p.sessHub.getAll(sessionID)
+	list := make([]Session, len(all))
+	for i, s := range all {
+		list[i] = s
+	}
+	return list
+}
+
 // RangeSession ranges all sessions.
 // If fn returns false, stop traversing.
 func (p *peer) RangeSession(fn func(sess Session) bool) {
-	p.sessHub.sessions.Range(func(key, value interface{}) bool {
-		return fn(value.(*session))
+	p.sessHub.rangeCallback(func(s *session) bool {
+		return fn(s)
+	})
+}
+
+// addSession adds the session to the session hub by the duplicate session ID policy,
+// executes the PostAddPlugin plugins, returns the close reason if the session itself is rejected.
+func (p *peer) addSession(sess *session) *Status {
+	evicted, reason := p.sessHub.set(sess)
+	if evicted != nil {
+		p.evictSession(evicted, reason)
+		if evicted == sess {
+			return reason
+		}
+	}
+	p.pluginContainer.postAdd(sess)
+	return nil
+}
+
+// evictSession sends the close reason to the session evicted for the duplicate session ID,
+// executes the PostEvictPlugin plugins, and closes it asynchronously.
+func (p *peer) evictSession(sess *session, reason *Status) {
+	Infof("evict session (addr:%s, id:%s): %s", sess.RemoteAddr().String(), sess.ID(), reason.String())
+	AnywayGo(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		sess.Push(CloseReasonServiceMethod, reason, socket.WithContext(ctx))
+		p.pluginContainer.postEvict(sess, reason)
+		sess.Close()
 	})
 }
 
@@ -236,6 +304,7 @@ func (p *peer) Dial(addr string, protoFunc ...ProtoFunc) (Session, *Status) {
 			if stat := p.pluginContainer.preDial(p.dialer.localAddr, addr); stat.OK() {
 				_, err = p.dialer.dialWithRetry(addr, oldID, func(conn net.Conn) error {
 					sess.socket.Reset(conn, protoFunc...)
+					sess.peerIdentity.Store(nil)
 					if oldIP == oldID {
 						sess.socket.SetID(sess.LocalAddr().String())
 					} else {
@@ -263,17 +332,24 @@ func (p *peer) Dial(addr string, protoFunc ...ProtoFunc) (Session, *Status) {
 				oldConn.Close()
 			}
 			sess.changeStatus(statusOk)
-			AnywayGo(sess.startReadAndHandle)
-			p.sessHub.set(sess)
+			if stat := p.addSession(sess); !stat.OK() {
+				// rejected by the duplicate session ID policy, close it before reading
+				sess.closeLocked()
+				Errorf("redial fail (network:%s, addr:%s, id:%s): %s", p.network, addr, sess.ID(), stat.String())
+				return false
+			}
 			Infof("redial ok (network:%s, addr:%s, id:%s)", p.network, addr, sess.ID())
+			AnywayGo(sess.startReadAndHandle)
 			return true
 		}
 	}
 
-	Infof("dial ok (network:%s, addr:%s, id:%s)", p.network, addr, sess.ID())
 	sess.changeStatus(statusOk)
+	if stat := p.addSession(sess); !stat.OK() {
+		return nil, stat
+	}
+	Infof("dial ok (network:%s, addr:%s, id:%s)", p.network, addr, sess.ID())
 	AnywayGo(sess.startReadAndHandle)
-	p.sessHub.set(sess)
 	return sess, nil
 }
 
@@ -301,13 +377,49 @@ func (p *peer) ServeConn(conn net.Conn, protoFunc ...ProtoFunc) (Session, *Statu
 		sess.Close()
 		return nil, stat
 	}
-	Infof("serve ok (network:%s, addr:%s, id:%s)", network, sess.RemoteAddr().String(), sess.ID())
 	sess.changeStatus(statusOk)
+	if stat := p.addSession(sess); !stat.OK() {
+		return nil, stat
+	}
+	Infof("serve ok (network:%s, addr:%s, id:%s)", network, sess.RemoteAddr().String(), sess.ID())
 	AnywayGo(sess.startReadAndHandle)
-	p.sessHub.set(sess)
 	return sess, nil
 }
 
+// ServeMessage reads one message from the connection, handles it synchronously
+// and writes the reply (if any) back to the connection.
+// NOTE:
+//
+//	The session is temporary and is not added to the session hub;
+//	Not execute the PostAcceptPlugin and PostDisconnectPlugin plugins;
+//	Not close the connection;
+//	Returns the handling status of the PUSH message, e.g. CodeNotFound.
+func (p *peer) ServeMessage(conn net.Conn, protoFunc ...ProtoFunc) *Status {
+	var sess = newSession(p, conn, protoFunc)
+	sess.changeStatus(statusOk)
+	defer func() {
+		sess.changeStatus(statusActiveClosed)
+		sess.notifyClosed()
+	}()
+	var ctx = p.getContext(sess, false)
+	defer p.putContext(ctx, false)
+	socket.WithReadLimit(p.frameReadLimit())(ctx.input)
+	if err := p.pluginContainer.preReadHeader(ctx); err != nil {
+		return statBadMessage.Copy(err)
+	}
+	if err := sess.socket.ReadMessage(ctx.input); err != nil {
+		if ctx.GetBodyCodec() == codec.NilCodecID {
+			return statBadMessage.Copy(err)
+		}
+		ctx.stat = statBadMessage.Copy(err)
+	}
+	ctx.handle()
+	if ctx.input.Mtype() == TypePush {
+		return ctx.stat
+	}
+	return nil
+}
+
 // ErrListenClosed listener is closed error.
 var ErrListenClosed = errors.New("listener is closed")
 
@@ -378,9 +490,11 @@ func (p *peer) serveListener(lis net.Listener, protoFunc ...ProtoFunc) error {
 				sess.Close()
 				return
 			}
-			Infof("accept ok (network:%s, addr:%s, id:%s)", network, sess.RemoteAddr().String(), sess.ID())
-			p.sessHub.set(sess)
 			sess.changeStatus(statusOk)
+			if stat := p.addSession(sess); !stat.OK() {
+				return
+			}
+			Infof("accept ok (network:%s, addr:%s, id:%s)", network, sess.RemoteAddr().String(), sess.ID())
 			sess.startReadAndHandle()
 		})
 	}
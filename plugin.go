@@ -130,6 +130,11 @@ type (
 		Plugin
 		PostReadPushBody(ReadCtx) *Status
 	}
+	// PostHandlePushPlugin is executed after the PUSH handler finishes, ReadCtx.Status() is the handle status.
+	PostHandlePushPlugin interface {
+		Plugin
+		PostHandlePush(ReadCtx) *Status
+	}
 	// PostReadReplyHeaderPlugin is executed after reading REPLY message header.
 	PostReadReplyHeaderPlugin interface {
 		Plugin
@@ -150,6 +155,16 @@ type (
 		Plugin
 		PostDisconnect(BaseSession) *Status
 	}
+	// PostAddPlugin is executed after the session is added to the session hub, and before it starts reading.
+	PostAddPlugin interface {
+		Plugin
+		PostAdd(Session) *Status
+	}
+	// PostEvictPlugin is executed after the session is evicted for the duplicate session ID, and before it is closed.
+	PostEvictPlugin interface {
+		Plugin
+		PostEvict(sess BaseSession, reason *Status) *Status
+	}
 )
 
 // PluginContainer a plugin container
@@ -627,6 +642,20 @@ func (p *pluginSingleContainer) postReadPushBody(ctx ReadCtx) *Status {
 	return nil
 }
 
+// PostHandlePush executes the defined plugins after the PUSH handler finishes.
+func (p *pluginSingleContainer) postHandlePush(ctx ReadCtx) *Status {
+	var stat *Status
+	for _, plugin := range p.plugins {
+		if _plugin, ok := plugin.(PostHandlePushPlugin); ok {
+			if stat = _plugin.PostHandlePush(ctx); !stat.OK() {
+				Errorf("[PostHandlePushPlugin:%s] %s", plugin.Name(), stat.String())
+				return stat
+			}
+		}
+	}
+	return nil
+}
+
 // PostReadReplyHeader executes the defined plugins after reading REPLY message header.
 func (p *pluginSingleContainer) postReadReplyHeader(ctx ReadCtx) *Status {
 	var stat *Status
@@ -683,6 +712,34 @@ func (p *pluginSingleContainer) postDisconnect(sess BaseSession) *Status {
 	return nil
 }
 
+// PostAdd executes the defined plugins after the session is added to the session hub.
+func (p *pluginSingleContainer) postAdd(sess Session) *Status {
+	var stat *Status
+	for _, plugin := range p.plugins {
+		if _plugin, ok := plugin.(PostAddPlugin); ok {
+			if stat = _plugin.PostAdd(sess); !stat.OK() {
+				Errorf("[PostAddPlugin:%s] %s", plugin.Name(), stat.String())
+				return stat
+			}
+		}
+	}
+	return nil
+}
+
+// PostEvict executes the defined plugins after the session is evicted for the duplicate session ID.
+func (p *pluginSingleContainer) postEvict(sess BaseSession, reason *Status) *Status {
+	var stat *Status
+	for _, plugin := range p.plugins {
+		if _plugin, ok := plugin.(PostEvictPlugin); ok {
+			if stat = _plugin.PostEvict(sess, reason); !stat.OK() {
+				Errorf("[PostEvictPlugin:%s] %s", plugin.Name(), stat.String())
+				return stat
+			}
+		}
+	}
+	return nil
+}
+
 func warnInvalidHandlerHooks(plugin []Plugin) {
 	for _, p := range plugin {
 		switch p.(type) {
@@ -702,6 +759,14 @@ func warnInvalidHandlerHooks(plugin []Plugin) {
 			LazyDebugf(func() string {
 				return fmt.Sprintf("invalid PostAcceptPlugin in router: %s", p.Name())
 			})
+		case PostAddPlugin:
+			LazyDebugf(func() string {
+				return fmt.Sprintf("invalid PostAddPlugin in router: %s", p.Name())
+			})
+		case PostEvictPlugin:
+			LazyDebugf(func() string {
+				return fmt.Sprintf("invalid PostEvictPlugin in router: %s", p.Name())
+			})
 		case PreWriteCallPlugin:
 			LazyDebugf(func() string {
 				return fmt.Sprintf("invalid PreWriteCallPlugin in router: %s", p.Name())
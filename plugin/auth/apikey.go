@@ -0,0 +1,140 @@
+// Copyright 2024 sqos. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// APIKeyVerifier verifies the static API keys.
+type APIKeyVerifier struct {
+	mu   sync.RWMutex
+	keys map[[sha256.Size]byte]*Claims
+}
+
+var _ Verifier = (*APIKeyVerifier)(nil)
+
+// NewAPIKeyVerifier creates a static API key verifier, keys maps the API key to its claims.
+func NewAPIKeyVerifier(keys map[string]*Claims) *APIKeyVerifier {
+	v := new(APIKeyVerifier)
+	v.Set(keys)
+	return v
+}
+
+// Set replaces all the API keys.
+func (v *APIKeyVerifier) Set(keys map[string]*Claims) {
+	m := make(map[[sha256.Size]byte]*Claims, len(keys))
+	for key, claims := range keys {
+		// index by the digest, so that the lookup time does not depend on the key prefix
+		m[sha256.Sum256([]byte(key))] = claims
+	}
+	v.mu.Lock()
+	v.keys = m
+	v.mu.Unlock()
+}
+
+// Verify returns a copy of the claims of the API key.
+func (v *APIKeyVerifier) Verify(token string) (*Claims, error) {
+	v.mu.RLock()
+	claims, ok := v.keys[sha256.Sum256([]byte(token))]
+	v.mu.RUnlock()
+	if !ok {
+		return nil, errors.New("apikey: invalid API key")
+	}
+	c := *claims
+	if c.Expired(time.Now()) {
+		return nil, errors.New("apikey: API key is expired")
+	}
+	return &c, nil
+}
+
+// HMACVerifier verifies the HMAC-signed timestamp tokens created by SignHMAC.
+// NOTE: The token is "<key id>.<unix seconds>.<base64url HMAC-SHA256 signature>".
+type HMACVerifier struct {
+	secrets map[string][]byte
+	window  time.Duration
+}
+
+var _ Verifier = (*HMACVerifier)(nil)
+
+// NewHMACVerifier creates a HMAC-signed timestamp verifier.
+// NOTE:
+//
+//	secrets maps the key id to its secret, the key id is used as the claims subject;
+//	The token is valid only if its timestamp is within the window around now, the default window is 5 minutes.
+func NewHMACVerifier(secrets map[string][]byte, window time.Duration) *HMACVerifier {
+	if window <= 0 {
+		window = 5 * time.Minute
+	}
+	m := make(map[string][]byte, len(secrets))
+	for id, secret := range secrets {
+		m[id] = secret
+	}
+	return &HMACVerifier{secrets: m, window: window}
+}
+
+// SignHMAC creates a HMAC-signed timestamp token.
+func SignHMAC(keyID string, secret []byte, t time.Time) string {
+	payload := keyID + "." + strconv.FormatInt(t.Unix(), 10)
+	return payload + "." + base64.RawURLEncoding.EncodeToString(hmacSum(secret, payload))
+}
+
+func hmacSum(secret []byte, payload string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
+
+// Verify verifies the signature and the timestamp of the token.
+func (v *HMACVerifier) Verify(token string) (*Claims, error) {
+	i := strings.LastIndexByte(token, '.')
+	if i < 0 {
+		return nil, errors.New("hmac: malformed token")
+	}
+	payload := token[:i]
+	j := strings.LastIndexByte(payload, '.')
+	if j < 0 {
+		return nil, errors.New("hmac: malformed token")
+	}
+	keyID := payload[:j]
+	ts, err := strconv.ParseInt(payload[j+1:], 10, 64)
+	if err != nil {
+		return nil, errors.New("hmac: malformed timestamp")
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(token[i+1:])
+	if err != nil {
+		return nil, errors.New("hmac: malformed signature")
+	}
+	secret, ok := v.secrets[keyID]
+	if !ok || !hmac.Equal(hmacSum(secret, payload), sig) {
+		return nil, errors.New("hmac: invalid signature")
+	}
+	issuedAt := time.Unix(ts, 0)
+	if d := time.Since(issuedAt); d > v.window || d < -v.window {
+		return nil, errors.New("hmac: timestamp is out of the window")
+	}
+	return &Claims{
+		Subject:   keyID,
+		IssuedAt:  issuedAt,
+		ExpiresAt: issuedAt.Add(v.window),
+	}, nil
+}
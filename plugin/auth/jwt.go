@@ -0,0 +1,417 @@
+// Copyright 2024 sqos. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// JWT signing algorithms.
+const (
+	ALG_HS256 = "HS256"
+	ALG_RS256 = "RS256"
+	ALG_EdDSA = "EdDSA"
+)
+
+type (
+	// JWK is a JSON web key.
+	// NOTE:
+	//
+	//	Key is []byte for HS256, *rsa.PublicKey or *rsa.PrivateKey for RS256,
+	//	ed25519.PublicKey or ed25519.PrivateKey for EdDSA;
+	//	The private keys are only used to sign.
+	JWK struct {
+		KeyID     string
+		Algorithm string
+		Key       interface{}
+	}
+	// JWKS is a JSON web key set.
+	JWKS struct {
+		Keys []*JWK
+	}
+	// JWTConfig is the config of the JWT verifier.
+	JWTConfig struct {
+		// JWKSFile is the local JWKS file, it is used if Keys is nil.
+		JWKSFile string
+		// Keys is the verification keys.
+		Keys *JWKS
+		// Audience is the expected audience, it is not checked if empty.
+		Audience string
+		// Issuer is the expected issuer, it is not checked if empty.
+		Issuer string
+		// RequireExpiry rejects the token without the exp claim.
+		RequireExpiry bool
+		// Leeway is the allowed clock skew when checking exp, nbf and iat.
+		Leeway time.Duration
+	}
+	// JWTVerifier verifies the signed JWT.
+	JWTVerifier struct {
+		cfg  JWTConfig
+		keys atomic.Pointer[JWKS]
+	}
+)
+
+var _ Verifier = (*JWTVerifier)(nil)
+
+type jwkJSON struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	K   string `json:"k,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+// ParseJWKS parses the JSON web key set, the "oct", "RSA" and "OKP"(Ed25519) keys are supported.
+func ParseJWKS(data []byte) (*JWKS, error) {
+	var set struct {
+		Keys []jwkJSON `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+	jwks := new(JWKS)
+	for i, k := range set.Keys {
+		key, err := k.parse()
+		if err != nil {
+			return nil, fmt.Errorf("jwks: key %d(%s): %w", i, k.Kid, err)
+		}
+		jwks.Keys = append(jwks.Keys, key)
+	}
+	return jwks, nil
+}
+
+// LoadJWKSFile loads the JSON web key set from the local file.
+func LoadJWKSFile(filename string) (*JWKS, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return ParseJWKS(data)
+}
+
+func (k *jwkJSON) parse() (*JWK, error) {
+	b64 := base64.RawURLEncoding
+	key := &JWK{KeyID: k.Kid}
+	switch k.Kty {
+	case "oct":
+		secret, err := b64.DecodeString(k.K)
+		if err != nil {
+			return nil, err
+		}
+		key.Algorithm, key.Key = ALG_HS256, secret
+	case "RSA":
+		n, err := b64.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := b64.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		eInt := new(big.Int).SetBytes(e)
+		if !eInt.IsInt64() || eInt.Int64() > 1<<31-1 {
+			return nil, errors.New("invalid RSA exponent")
+		}
+		key.Algorithm, key.Key = ALG_RS256, &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(eInt.Int64())}
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := b64.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 public key size")
+		}
+		key.Algorithm, key.Key = ALG_EdDSA, ed25519.PublicKey(x)
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+	if k.Alg != "" && k.Alg != key.Algorithm {
+		return nil, fmt.Errorf("unsupported algorithm %q for key type %q", k.Alg, k.Kty)
+	}
+	return key, nil
+}
+
+// NewJWTVerifier creates a JWT verifier.
+func NewJWTVerifier(cfg JWTConfig) (*JWTVerifier, error) {
+	v := &JWTVerifier{cfg: cfg}
+	if cfg.Keys != nil {
+		v.keys.Store(cfg.Keys)
+		return v, nil
+	}
+	if err := v.Reload(); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// Reload reloads the local JWKS file, e.g. after the keys are rotated.
+func (v *JWTVerifier) Reload() error {
+	if v.cfg.JWKSFile == "" {
+		return errors.New("jwt: no JWKS file")
+	}
+	keys, err := LoadJWKSFile(v.cfg.JWKSFile)
+	if err != nil {
+		return err
+	}
+	v.keys.Store(keys)
+	return nil
+}
+
+// SetKeys replaces the verification keys.
+func (v *JWTVerifier) SetKeys(keys *JWKS) {
+	v.keys.Store(keys)
+}
+
+func (s *JWKS) lookup(kid, alg string) *JWK {
+	for _, k := range s.Keys {
+		// the algorithm is bound to the key to prevent algorithm confusion
+		if k.Algorithm == alg && (kid == "" || k.KeyID == kid) {
+			return k
+		}
+	}
+	return nil
+}
+
+// Verify verifies the signature and the registered claims of the JWT.
+func (v *JWTVerifier) Verify(token string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("jwt: malformed token")
+	}
+	b64 := base64.RawURLEncoding
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	headerBytes, err := b64.DecodeString(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("jwt: malformed header: %w", err)
+	}
+	if err = json.Unmarshal(headerBytes, &header); err != nil {
+		return nil, fmt.Errorf("jwt: malformed header: %w", err)
+	}
+	key := v.keys.Load().lookup(header.Kid, header.Alg)
+	if key == nil {
+		return nil, fmt.Errorf("jwt: no key for kid %q and alg %q", header.Kid, header.Alg)
+	}
+	sig, err := b64.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("jwt: malformed signature: %w", err)
+	}
+	if err = verifyJWS(key, parts[0]+"."+parts[1], sig); err != nil {
+		return nil, err
+	}
+	payload, err := b64.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("jwt: malformed payload: %w", err)
+	}
+	return v.validate(payload)
+}
+
+func verifyJWS(key *JWK, signingInput string, sig []byte) error {
+	switch k := key.Key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, k)
+		mac.Write([]byte(signingInput))
+		if hmac.Equal(mac.Sum(nil), sig) {
+			return nil
+		}
+	case *rsa.PublicKey:
+		digest := sha256.Sum256([]byte(signingInput))
+		if rsa.VerifyPKCS1v15(k, crypto.SHA256, digest[:], sig) == nil {
+			return nil
+		}
+	case *rsa.PrivateKey:
+		return verifyJWS(&JWK{Key: &k.PublicKey}, signingInput, sig)
+	case ed25519.PublicKey:
+		if ed25519.Verify(k, []byte(signingInput), sig) {
+			return nil
+		}
+	case ed25519.PrivateKey:
+		return verifyJWS(&JWK{Key: k.Public()}, signingInput, sig)
+	default:
+		return fmt.Errorf("jwt: unsupported key type %T", key.Key)
+	}
+	return errors.New("jwt: invalid signature")
+}
+
+func (v *JWTVerifier) validate(payload []byte) (*Claims, error) {
+	var raw map[string]interface{}
+	dec := json.NewDecoder(strings.NewReader(string(payload)))
+	dec.UseNumber()
+	if err := dec.Decode(&raw); err != nil {
+		return nil, fmt.Errorf("jwt: malformed payload: %w", err)
+	}
+	claims := &Claims{Extra: make(map[string]interface{})}
+	var nbf time.Time
+	for k, val := range raw {
+		var err error
+		switch k {
+		case "sub":
+			claims.Subject, err = claimString(k, val)
+		case "iss":
+			claims.Issuer, err = claimString(k, val)
+		case "aud":
+			claims.Audience, err = claimAudience(val)
+		case "exp":
+			claims.ExpiresAt, err = claimTime(k, val)
+		case "iat":
+			claims.IssuedAt, err = claimTime(k, val)
+		case "nbf":
+			nbf, err = claimTime(k, val)
+		default:
+			claims.Extra[k] = val
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	now := time.Now()
+	leeway := v.cfg.Leeway
+	if claims.ExpiresAt.IsZero() {
+		if v.cfg.RequireExpiry {
+			return nil, errors.New("jwt: exp claim is required")
+		}
+	} else if !now.Before(claims.ExpiresAt.Add(leeway)) {
+		return nil, errors.New("jwt: token is expired")
+	}
+	if !nbf.IsZero() && now.Add(leeway).Before(nbf) {
+		return nil, errors.New("jwt: token is not valid yet")
+	}
+	if !claims.IssuedAt.IsZero() && now.Add(leeway).Before(claims.IssuedAt) {
+		return nil, errors.New("jwt: token is issued in the future")
+	}
+	if v.cfg.Issuer != "" && claims.Issuer != v.cfg.Issuer {
+		return nil, fmt.Errorf("jwt: unexpected issuer %q", claims.Issuer)
+	}
+	if v.cfg.Audience != "" && !containsString(claims.Audience, v.cfg.Audience) {
+		return nil, fmt.Errorf("jwt: token is not intended for audience %q", v.cfg.Audience)
+	}
+	return claims, nil
+}
+
+func claimString(name string, val interface{}) (string, error) {
+	s, ok := val.(string)
+	if !ok {
+		return "", fmt.Errorf("jwt: %s claim must be a string", name)
+	}
+	return s, nil
+}
+
+func claimAudience(val interface{}) ([]string, error) {
+	switch a := val.(type) {
+	case string:
+		return []string{a}, nil
+	case []interface{}:
+		aud := make([]string, 0, len(a))
+		for _, v := range a {
+			s, ok := v.(string)
+			if !ok {
+				return nil, errors.New("jwt: aud claim must be a string or an array of strings")
+			}
+			aud = append(aud, s)
+		}
+		return aud, nil
+	}
+	return nil, errors.New("jwt: aud claim must be a string or an array of strings")
+}
+
+func claimTime(name string, val interface{}) (time.Time, error) {
+	n, ok := val.(json.Number)
+	if !ok {
+		return time.Time{}, fmt.Errorf("jwt: %s claim must be a number", name)
+	}
+	f, err := n.Float64()
+	if err != nil {
+		return time.Time{}, fmt.Errorf("jwt: %s claim must be a number", name)
+	}
+	sec := int64(f)
+	return time.Unix(sec, int64((f-float64(sec))*1e9)), nil
+}
+
+func containsString(a []string, s string) bool {
+	for _, v := range a {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// SignJWT signs the claims into a compact JWT by the key.
+// NOTE: The time claims(exp, iat, nbf) should be the unix seconds.
+func SignJWT(key *JWK, claims map[string]interface{}) (string, error) {
+	header := map[string]string{"alg": key.Algorithm, "typ": "JWT"}
+	if key.KeyID != "" {
+		header["kid"] = key.KeyID
+	}
+	headerBytes, err := json.Marshal(header)
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	b64 := base64.RawURLEncoding
+	signingInput := b64.EncodeToString(headerBytes) + "." + b64.EncodeToString(payload)
+	var sig []byte
+	switch k := key.Key.(type) {
+	case []byte:
+		if key.Algorithm != ALG_HS256 {
+			return "", fmt.Errorf("jwt: algorithm %q does not match the key type", key.Algorithm)
+		}
+		mac := hmac.New(sha256.New, k)
+		mac.Write([]byte(signingInput))
+		sig = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		if key.Algorithm != ALG_RS256 {
+			return "", fmt.Errorf("jwt: algorithm %q does not match the key type", key.Algorithm)
+		}
+		digest := sha256.Sum256([]byte(signingInput))
+		sig, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:])
+		if err != nil {
+			return "", err
+		}
+	case ed25519.PrivateKey:
+		if key.Algorithm != ALG_EdDSA {
+			return "", fmt.Errorf("jwt: algorithm %q does not match the key type", key.Algorithm)
+		}
+		sig = ed25519.Sign(k, []byte(signingInput))
+	default:
+		return "", fmt.Errorf("jwt: unsupported signing key type %T", key.Key)
+	}
+	return signingInput + "." + b64.EncodeToString(sig), nil
+}
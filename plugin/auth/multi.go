@@ -0,0 +1,180 @@
+// Copyright 2024 sqos. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auth
+
+import (
+	"fmt"
+
+	"github.com/sqos/yrpc"
+)
+
+type (
+	// MultiBearer initiates a multi-round authorization exchange.
+	MultiBearer func(sess Session, fn Exchange) *yrpc.Status
+	// Exchange sends an authorization request and receives its reply, it can be called multiple times.
+	Exchange func(info, retRecv interface{}) *yrpc.Status
+
+	// MultiChecker checks a multi-round authorization exchange.
+	MultiChecker func(sess Session, conv Conversation) *yrpc.Status
+	// Conversation is the server side of a multi-round authorization exchange.
+	// NOTE: Each Recv must be followed by one Reply.
+	Conversation interface {
+		// Recv receives the next authorization request.
+		Recv(infoRecv interface{}) *yrpc.Status
+		// Reply replies the last received request, a non-OK stat ends the exchange.
+		Reply(ret interface{}, stat *yrpc.Status) *yrpc.Status
+	}
+)
+
+// NewMultiBearerPlugin creates a multi-round auth bearer plugin for client.
+func NewMultiBearerPlugin(fn MultiBearer, infoSetting ...yrpc.MessageSetting) yrpc.Plugin {
+	return &multiBearerPlugin{
+		bearerFunc: fn,
+		msgSetting: infoSetting,
+	}
+}
+
+// NewMultiCheckerPlugin creates a multi-round auth checker plugin for server.
+// NOTE: If the last request is not replied when fn returns, it is replied with the returned status.
+func NewMultiCheckerPlugin(fn MultiChecker, retSetting ...yrpc.MessageSetting) yrpc.Plugin {
+	return &multiCheckerPlugin{
+		checkerFunc: fn,
+		msgSetting:  retSetting,
+	}
+}
+
+type multiBearerPlugin struct {
+	bearerFunc MultiBearer
+	msgSetting []yrpc.MessageSetting
+}
+
+type multiCheckerPlugin struct {
+	checkerFunc MultiChecker
+	msgSetting  []yrpc.MessageSetting
+}
+
+var (
+	_ yrpc.PostDialPlugin   = new(multiBearerPlugin)
+	_ yrpc.PostAcceptPlugin = new(multiCheckerPlugin)
+)
+
+func (a *multiBearerPlugin) Name() string {
+	return "auth-multi-bearer"
+}
+
+func (a *multiCheckerPlugin) Name() string {
+	return "auth-multi-checker"
+}
+
+func (a *multiBearerPlugin) PostDial(sess yrpc.PreSession, _ bool) *yrpc.Status {
+	if a.bearerFunc == nil {
+		return nil
+	}
+	var round int
+	return a.bearerFunc(sess, func(info, retRecv interface{}) *yrpc.Status {
+		round++
+		stat := sess.PreSend(yrpc.TypeAuthCall, "", info, nil, a.msgSetting...)
+		if !stat.OK() {
+			return stat
+		}
+		retMsg := sess.PreReceive(func(header yrpc.Header) interface{} {
+			if header.Mtype() != yrpc.TypeAuthReply {
+				return nil
+			}
+			return retRecv
+		})
+		if !retMsg.StatusOK() {
+			return retMsg.Status()
+		}
+		if retMsg.Mtype() != yrpc.TypeAuthReply {
+			return yrpc.NewStatus(
+				yrpc.CodeUnauthorized,
+				yrpc.CodeText(yrpc.CodeUnauthorized),
+				fmt.Sprintf("auth message(%d) expect: AUTH_REPLY, but received: %s",
+					round, yrpc.TypeText(retMsg.Mtype())),
+			)
+		}
+		return nil
+	})
+}
+
+// MultiReplyErr the error of calling Reply without a pending request
+var MultiReplyErr = yrpc.NewStatus(
+	yrpc.CodeInternalServerError,
+	"auth-multi-checker plugin usage is incorrect",
+	"Reply must follow Recv",
+)
+
+// MultiRecvPendingErr the error of calling Recv before replying the last request
+var MultiRecvPendingErr = yrpc.NewStatus(
+	yrpc.CodeInternalServerError,
+	"auth-multi-checker plugin usage is incorrect",
+	"Recv must follow Reply",
+)
+
+func (a *multiCheckerPlugin) PostAccept(sess yrpc.PreSession) *yrpc.Status {
+	if a.checkerFunc == nil {
+		return nil
+	}
+	conv := &conversation{sess: sess, msgSetting: a.msgSetting}
+	stat := a.checkerFunc(sess, conv)
+	if conv.pending {
+		if stat2 := conv.Reply(nil, stat); !stat2.OK() && stat.OK() {
+			return stat2
+		}
+	}
+	return stat
+}
+
+type conversation struct {
+	sess       yrpc.PreSession
+	msgSetting []yrpc.MessageSetting
+	round      int
+	pending    bool
+}
+
+func (c *conversation) Recv(infoRecv interface{}) *yrpc.Status {
+	if c.pending {
+		return MultiRecvPendingErr
+	}
+	c.round++
+	infoMsg := c.sess.PreReceive(func(header yrpc.Header) interface{} {
+		if header.Mtype() != yrpc.TypeAuthCall {
+			return nil
+		}
+		return infoRecv
+	})
+	if !infoMsg.StatusOK() {
+		return infoMsg.Status()
+	}
+	if infoMsg.Mtype() != yrpc.TypeAuthCall {
+		return yrpc.NewStatus(
+			yrpc.CodeUnauthorized,
+			yrpc.CodeText(yrpc.CodeUnauthorized),
+			fmt.Sprintf("auth message(%d) expect: AUTH_CALL, but received: %s",
+				c.round, yrpc.TypeText(infoMsg.Mtype())),
+		)
+	}
+	c.pending = true
+	return nil
+}
+
+func (c *conversation) Reply(ret interface{}, stat *yrpc.Status) *yrpc.Status {
+	if !c.pending {
+		return MultiReplyErr
+	}
+	c.pending = false
+	return c.sess.PreSend(yrpc.TypeAuthReply, "", ret, stat, c.msgSetting...)
+}
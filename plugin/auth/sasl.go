@@ -0,0 +1,187 @@
+// Copyright 2024 sqos. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auth
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/sqos/yrpc"
+	"github.com/sqos/yrpc/codec"
+)
+
+// SASL mechanism names.
+const (
+	MECH_PLAIN         = "PLAIN"
+	MECH_SCRAM_SHA_256 = "SCRAM-SHA-256"
+)
+
+// maxSASLRounds limits the rounds of a SASL exchange.
+const maxSASLRounds = 10
+
+type (
+	// SASLClient is the client side of a SASL mechanism.
+	SASLClient interface {
+		// Mechanism returns the mechanism name.
+		Mechanism() string
+		// Start returns the initial response.
+		Start() ([]byte, error)
+		// Next handles the server challenge and returns the next response,
+		// if done is true, it verifies the final server data and the response is not sent.
+		Next(challenge []byte, done bool) ([]byte, error)
+	}
+	// SASLServer is the server side of a SASL mechanism.
+	SASLServer interface {
+		// Next handles the client response and returns the next challenge,
+		// done is true if the authentication has succeeded.
+		Next(response []byte) (challenge []byte, done bool, err error)
+		// Subject returns the authenticated identity after done.
+		Subject() string
+	}
+	// SASLServerFactory creates a server side conversation of a mechanism.
+	SASLServerFactory func() SASLServer
+)
+
+// saslMessage is the body of the SASL authorization messages.
+type saslMessage struct {
+	Mechanism string `json:"mechanism,omitempty"`
+	Data      []byte `json:"data,omitempty"`
+	Done      bool   `json:"done,omitempty"`
+}
+
+// NewSASLBearerPlugin creates a SASL auth bearer plugin for client.
+// NOTE: newClient is called for each dial, since the mechanism conversations are stateful.
+func NewSASLBearerPlugin(newClient func() SASLClient) yrpc.Plugin {
+	return NewMultiBearerPlugin(func(sess Session, fn Exchange) *yrpc.Status {
+		client := newClient()
+		data, err := client.Start()
+		if err != nil {
+			return saslUnauthorized(err)
+		}
+		req := &saslMessage{Mechanism: client.Mechanism(), Data: data}
+		for round := 0; round < maxSASLRounds; round++ {
+			reply := new(saslMessage)
+			if stat := fn(req, reply); !stat.OK() {
+				return stat
+			}
+			data, err = client.Next(reply.Data, reply.Done)
+			if err != nil {
+				return saslUnauthorized(err)
+			}
+			if reply.Done {
+				return nil
+			}
+			req = &saslMessage{Data: data}
+		}
+		return saslUnauthorized(errors.New("sasl: too many rounds"))
+	}, yrpc.WithBodyCodec(codec.ID_JSON))
+}
+
+// NewSASLCheckerPlugin creates a SASL auth checker plugin for server.
+// NOTE:
+//
+//	mechanisms maps the mechanism name to its server factory;
+//	The authenticated identity is stored in the session swap as the claims subject, get it by GetClaims.
+func NewSASLCheckerPlugin(mechanisms map[string]SASLServerFactory) yrpc.Plugin {
+	return NewMultiCheckerPlugin(func(sess Session, conv Conversation) *yrpc.Status {
+		req := new(saslMessage)
+		if stat := conv.Recv(req); !stat.OK() {
+			return stat
+		}
+		factory, ok := mechanisms[req.Mechanism]
+		if !ok {
+			return saslUnauthorized(fmt.Errorf("sasl: unsupported mechanism %q", req.Mechanism))
+		}
+		server := factory()
+		for round := 0; round < maxSASLRounds; round++ {
+			challenge, done, err := server.Next(req.Data)
+			if err != nil {
+				return saslUnauthorized(err)
+			}
+			if done {
+				sess.Swap().Store(ClaimsSwapKey, &Claims{Subject: server.Subject()})
+				return conv.Reply(&saslMessage{Data: challenge, Done: true}, nil)
+			}
+			if stat := conv.Reply(&saslMessage{Data: challenge}, nil); !stat.OK() {
+				return stat
+			}
+			req = new(saslMessage)
+			if stat := conv.Recv(req); !stat.OK() {
+				return stat
+			}
+		}
+		return saslUnauthorized(errors.New("sasl: too many rounds"))
+	}, yrpc.WithBodyCodec(codec.ID_JSON))
+}
+
+func saslUnauthorized(err error) *yrpc.Status {
+	return yrpc.NewStatus(yrpc.CodeUnauthorized, yrpc.CodeText(yrpc.CodeUnauthorized), err.Error())
+}
+
+// NewPLAINClient creates a PLAIN mechanism client(RFC 4616).
+// NOTE: The password is sent in cleartext, so it should only be used over TLS.
+func NewPLAINClient(authzid, username, password string) SASLClient {
+	return &plainClient{authzid: authzid, username: username, password: password}
+}
+
+type plainClient struct {
+	authzid, username, password string
+}
+
+func (c *plainClient) Mechanism() string {
+	return MECH_PLAIN
+}
+
+func (c *plainClient) Start() ([]byte, error) {
+	return []byte(c.authzid + "\x00" + c.username + "\x00" + c.password), nil
+}
+
+func (c *plainClient) Next(_ []byte, done bool) ([]byte, error) {
+	if !done {
+		return nil, errors.New("sasl: unexpected PLAIN challenge")
+	}
+	return nil, nil
+}
+
+// NewPLAINServer creates a PLAIN mechanism server factory,
+// verify checks the credentials and returns the authenticated identity.
+func NewPLAINServer(verify func(authzid, username, password string) (subject string, err error)) SASLServerFactory {
+	return func() SASLServer {
+		return &plainServer{verify: verify}
+	}
+}
+
+type plainServer struct {
+	verify  func(authzid, username, password string) (string, error)
+	subject string
+}
+
+func (s *plainServer) Next(response []byte) ([]byte, bool, error) {
+	parts := bytes.Split(response, []byte{0})
+	if len(parts) != 3 || len(parts[1]) == 0 {
+		return nil, false, errors.New("sasl: malformed PLAIN response")
+	}
+	subject, err := s.verify(string(parts[0]), string(parts[1]), string(parts[2]))
+	if err != nil {
+		return nil, false, err
+	}
+	s.subject = subject
+	return nil, true, nil
+}
+
+func (s *plainServer) Subject() string {
+	return s.subject
+}
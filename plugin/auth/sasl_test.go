@@ -0,0 +1,171 @@
+package auth_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/sqos/yrpc"
+	"github.com/sqos/yrpc/plugin/auth"
+	"github.com/sqos/yrpc/yrpctest"
+)
+
+func TestSASL(t *testing.T) {
+	aliceCreds, err := auth.NewSCRAMCredentials("pencil", nil, 4096)
+	if !assert.NoError(t, err) {
+		return
+	}
+	checker := auth.NewSASLCheckerPlugin(map[string]auth.SASLServerFactory{
+		auth.MECH_SCRAM_SHA_256: auth.NewSCRAMServer(func(username string) (*auth.SCRAMCredentials, error) {
+			if username == "alice,admin" {
+				return aliceCreds, nil
+			}
+			return nil, errors.New("unknown user")
+		}),
+		auth.MECH_PLAIN: auth.NewPLAINServer(func(_, username, password string) (string, error) {
+			if username == "bob" && password == "secret" {
+				return "bob", nil
+			}
+			return "", errors.New("invalid password")
+		}),
+	})
+
+	srv, addr := yrpctest.NewServer(t, yrpc.PeerConfig{}, checker)
+	srv.RouteCall(new(Whoami))
+	newSession := func(client auth.SASLClient) (yrpc.Session, *yrpc.Status) {
+		bearer := auth.NewSASLBearerPlugin(func() auth.SASLClient { return client })
+		return dial(t, addr, bearer)
+	}
+	whoami := func(sess yrpc.Session) string {
+		var subject string
+		stat := sess.Call("/whoami/get", nil, &subject).Status()
+		assert.True(t, stat.OK(), stat)
+		return subject
+	}
+
+	sess, stat := newSession(auth.NewSCRAMClient("alice,admin", "pencil"))
+	if assert.True(t, stat.OK(), stat) {
+		assert.Equal(t, "alice,admin", whoami(sess))
+	}
+	_, stat = newSession(auth.NewSCRAMClient("alice,admin", "wrong"))
+	assert.Equal(t, yrpc.CodeUnauthorized, stat.Code())
+	_, stat = newSession(auth.NewSCRAMClient("carol", "pencil"))
+	assert.Equal(t, yrpc.CodeUnauthorized, stat.Code())
+
+	sess, stat = newSession(auth.NewPLAINClient("", "bob", "secret"))
+	if assert.True(t, stat.OK(), stat) {
+		assert.Equal(t, "bob", whoami(sess))
+	}
+	_, stat = newSession(auth.NewPLAINClient("", "bob", "wrong"))
+	assert.Equal(t, yrpc.CodeUnauthorized, stat.Code())
+}
+
+// TestSCRAMMutual checks that the client rejects the server that does not know the server key.
+func TestSCRAMMutual(t *testing.T) {
+	realCreds, _ := auth.NewSCRAMCredentials("pencil", []byte("salt"), 4096)
+	fakeCreds, _ := auth.NewSCRAMCredentials("guess", []byte("salt"), 4096)
+	fake := auth.NewSCRAMServer(func(string) (*auth.SCRAMCredentials, error) {
+		return &auth.SCRAMCredentials{
+			Salt:       realCreds.Salt,
+			Iterations: realCreds.Iterations,
+			StoredKey:  realCreds.StoredKey,
+			ServerKey:  fakeCreds.ServerKey,
+		}, nil
+	})()
+	client := auth.NewSCRAMClient("alice", "pencil")
+	data, err := client.Start()
+	assert.NoError(t, err)
+	challenge, done, err := fake.Next(data)
+	assert.NoError(t, err)
+	assert.False(t, done)
+	data, err = client.Next(challenge, false)
+	assert.NoError(t, err)
+	final, done, err := fake.Next(data)
+	assert.NoError(t, err)
+	assert.True(t, done)
+	_, err = client.Next(final, true)
+	assert.EqualError(t, err, "sasl: invalid SCRAM server signature")
+}
+
+// TestSCRAMUnknownUser checks that the unknown user fails at the client proof, not at the first round.
+func TestSCRAMUnknownUser(t *testing.T) {
+	factory := auth.NewSCRAMServer(func(string) (*auth.SCRAMCredentials, error) {
+		return nil, errors.New("unknown user")
+	})
+	var first []string
+	for i := 0; i < 2; i++ {
+		client := auth.NewSCRAMClient("carol", "pencil")
+		server := factory()
+		data, err := client.Start()
+		assert.NoError(t, err)
+		challenge, done, err := server.Next(data)
+		assert.NoError(t, err)
+		assert.False(t, done)
+		// the salt and iteration count, after the nonce
+		first = append(first, string(challenge[strings.Index(string(challenge), ",s="):]))
+		data, err = client.Next(challenge, false)
+		assert.NoError(t, err)
+		_, _, err = server.Next(data)
+		assert.EqualError(t, err, "sasl: SCRAM authentication failed")
+	}
+	assert.Equal(t, first[0], first[1])
+}
+
+// TestSCRAMIterations checks that the client rejects the iteration count out of range.
+func TestSCRAMIterations(t *testing.T) {
+	creds, _ := auth.NewSCRAMCredentials("pencil", []byte("salt"), 1<<30)
+	assert.Equal(t, 1<<20, creds.Iterations)
+	server := auth.NewSCRAMServer(func(string) (*auth.SCRAMCredentials, error) {
+		c := *creds
+		c.Iterations = 1<<20 + 1
+		return &c, nil
+	})()
+	client := auth.NewSCRAMClient("alice", "pencil")
+	data, err := client.Start()
+	assert.NoError(t, err)
+	challenge, _, err := server.Next(data)
+	assert.NoError(t, err)
+	_, err = client.Next(challenge, false)
+	assert.EqualError(t, err, "sasl: invalid SCRAM iteration count")
+}
+
+func TestMultiRound(t *testing.T) {
+	checker := auth.NewMultiCheckerPlugin(func(sess auth.Session, conv auth.Conversation) *yrpc.Status {
+		var sum int
+		for {
+			var n int
+			if stat := conv.Recv(&n); !stat.OK() {
+				return stat
+			}
+			if n == 0 {
+				break
+			}
+			sum += n
+			if stat := conv.Reply(sum, nil); !stat.OK() {
+				return stat
+			}
+		}
+		if sum != 6 {
+			return yrpc.NewStatus(yrpc.CodeUnauthorized, "bad sum", sum)
+		}
+		return conv.Reply("ok", nil)
+	})
+	bearer := auth.NewMultiBearerPlugin(func(sess auth.Session, fn auth.Exchange) *yrpc.Status {
+		var sum int
+		for _, n := range []int{1, 2, 3} {
+			if stat := fn(n, &sum); !stat.OK() {
+				return stat
+			}
+		}
+		assert.Equal(t, 6, sum)
+		var ret string
+		stat := fn(0, &ret)
+		assert.Equal(t, "ok", ret)
+		return stat
+	})
+	_, addr := yrpctest.NewServer(t, yrpc.PeerConfig{}, checker)
+	_, stat := dial(t, addr, bearer)
+	assert.True(t, stat.OK(), stat)
+}
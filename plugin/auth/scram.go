@@ -0,0 +1,321 @@
+// Copyright 2024 sqos. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/pbkdf2"
+
+	"github.com/sqos/yrpc"
+)
+
+const (
+	// scramGS2Header is the GS2 header without channel binding.
+	scramGS2Header = "n,,"
+	// scramMinIterations is the minimum accepted iteration count.
+	scramMinIterations = 4096
+	// scramMaxIterations is the maximum accepted iteration count,
+	// which bounds the PBKDF2 work a server can demand of the client.
+	scramMaxIterations = 1 << 20
+	scramNonceLen      = 18
+)
+
+// SCRAMCredentials is the stored credentials of a SCRAM-SHA-256 user(RFC 5802, RFC 7677).
+// NOTE: The password itself is not stored.
+type SCRAMCredentials struct {
+	Salt       []byte
+	Iterations int
+	StoredKey  []byte
+	ServerKey  []byte
+}
+
+// NewSCRAMCredentials derives the stored credentials from the password,
+// if salt is empty, a random one is generated, if iterations < 4096, 4096 is used,
+// and if iterations > 1<<20, 1<<20 is used.
+func NewSCRAMCredentials(password string, salt []byte, iterations int) (*SCRAMCredentials, error) {
+	if len(salt) == 0 {
+		salt = make([]byte, 16)
+		if _, err := rand.Read(salt); err != nil {
+			return nil, err
+		}
+	}
+	if iterations < scramMinIterations {
+		iterations = scramMinIterations
+	} else if iterations > scramMaxIterations {
+		iterations = scramMaxIterations
+	}
+	saltedPassword := scramSaltedPassword(password, salt, iterations)
+	clientKey := scramHMAC(saltedPassword, "Client Key")
+	storedKey := sha256.Sum256(clientKey)
+	return &SCRAMCredentials{
+		Salt:       salt,
+		Iterations: iterations,
+		StoredKey:  storedKey[:],
+		ServerKey:  scramHMAC(saltedPassword, "Server Key"),
+	}, nil
+}
+
+func scramSaltedPassword(password string, salt []byte, iterations int) []byte {
+	return pbkdf2.Key([]byte(password), salt, iterations, sha256.Size, sha256.New)
+}
+
+func scramHMAC(key []byte, msg string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(msg))
+	return mac.Sum(nil)
+}
+
+func scramNonce() (string, error) {
+	b := make([]byte, scramNonceLen)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawStdEncoding.EncodeToString(b), nil
+}
+
+// scramEscape escapes the username as saslname.
+func scramEscape(s string) string {
+	return strings.NewReplacer("=", "=3D", ",", "=2C").Replace(s)
+}
+
+func scramUnescape(s string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '=' {
+			b.WriteByte(s[i])
+			continue
+		}
+		switch {
+		case strings.HasPrefix(s[i:], "=3D"):
+			b.WriteByte('=')
+		case strings.HasPrefix(s[i:], "=2C"):
+			b.WriteByte(',')
+		default:
+			return "", errors.New("sasl: malformed SCRAM username")
+		}
+		i += 2
+	}
+	return b.String(), nil
+}
+
+// scramAttrs parses the comma separated "k=v" attributes.
+func scramAttrs(msg string) (map[byte]string, error) {
+	attrs := make(map[byte]string)
+	for _, kv := range strings.Split(msg, ",") {
+		if len(kv) < 2 || kv[1] != '=' {
+			return nil, errors.New("sasl: malformed SCRAM message")
+		}
+		attrs[kv[0]] = kv[2:]
+	}
+	return attrs, nil
+}
+
+// NewSCRAMClient creates a SCRAM-SHA-256 mechanism client.
+// NOTE: The password never crosses the wire, and the server is verified by its final signature(mutual authentication).
+func NewSCRAMClient(username, password string) SASLClient {
+	return &scramClient{username: username, password: password}
+}
+
+type scramClient struct {
+	username, password string
+	step               int
+	clientFirstBare    string
+	nonce              string
+	serverSignature    []byte
+}
+
+func (c *scramClient) Mechanism() string {
+	return MECH_SCRAM_SHA_256
+}
+
+func (c *scramClient) Start() ([]byte, error) {
+	nonce, err := scramNonce()
+	if err != nil {
+		return nil, err
+	}
+	c.nonce = nonce
+	c.clientFirstBare = "n=" + scramEscape(c.username) + ",r=" + nonce
+	return []byte(scramGS2Header + c.clientFirstBare), nil
+}
+
+func (c *scramClient) Next(challenge []byte, done bool) ([]byte, error) {
+	c.step++
+	switch {
+	case c.step == 1 && !done:
+		return c.clientFinal(string(challenge))
+	case c.step == 2 && done:
+		attrs, err := scramAttrs(string(challenge))
+		if err != nil {
+			return nil, err
+		}
+		sig, err := base64.StdEncoding.DecodeString(attrs['v'])
+		if err != nil || !hmac.Equal(sig, c.serverSignature) {
+			return nil, errors.New("sasl: invalid SCRAM server signature")
+		}
+		return nil, nil
+	}
+	return nil, errors.New("sasl: unexpected SCRAM message")
+}
+
+func (c *scramClient) clientFinal(serverFirst string) ([]byte, error) {
+	attrs, err := scramAttrs(serverFirst)
+	if err != nil {
+		return nil, err
+	}
+	nonce := attrs['r']
+	if !strings.HasPrefix(nonce, c.nonce) || len(nonce) == len(c.nonce) {
+		return nil, errors.New("sasl: invalid SCRAM server nonce")
+	}
+	salt, err := base64.StdEncoding.DecodeString(attrs['s'])
+	if err != nil || len(salt) == 0 {
+		return nil, errors.New("sasl: invalid SCRAM salt")
+	}
+	iterations, err := strconv.Atoi(attrs['i'])
+	if err != nil || iterations < scramMinIterations || iterations > scramMaxIterations {
+		return nil, errors.New("sasl: invalid SCRAM iteration count")
+	}
+	clientFinalWithoutProof := "c=" + base64.StdEncoding.EncodeToString([]byte(scramGS2Header)) + ",r=" + nonce
+	authMessage := c.clientFirstBare + "," + serverFirst + "," + clientFinalWithoutProof
+	saltedPassword := scramSaltedPassword(c.password, salt, iterations)
+	clientKey := scramHMAC(saltedPassword, "Client Key")
+	storedKey := sha256.Sum256(clientKey)
+	proof := scramHMAC(storedKey[:], authMessage)
+	for i := range proof {
+		proof[i] ^= clientKey[i]
+	}
+	c.serverSignature = scramHMAC(scramHMAC(saltedPassword, "Server Key"), authMessage)
+	return []byte(clientFinalWithoutProof + ",p=" + base64.StdEncoding.EncodeToString(proof)), nil
+}
+
+// NewSCRAMServer creates a SCRAM-SHA-256 mechanism server factory,
+// lookup returns the stored credentials of the username.
+// NOTE: If lookup fails, the server goes on with the fake credentials of a deterministic salt,
+// and fails at the client proof, so that the usernames cannot be enumerated(RFC 5802 §5.1).
+func NewSCRAMServer(lookup func(username string) (*SCRAMCredentials, error)) SASLServerFactory {
+	fakeKey := make([]byte, sha256.Size)
+	if _, err := rand.Read(fakeKey); err != nil {
+		yrpc.Fatalf("sasl: %v", err)
+	}
+	return func() SASLServer {
+		return &scramServer{lookup: lookup, fakeKey: fakeKey}
+	}
+}
+
+type scramServer struct {
+	lookup          func(username string) (*SCRAMCredentials, error)
+	fakeKey         []byte
+	step            int
+	username        string
+	creds           *SCRAMCredentials
+	nonce           string
+	clientFirstBare string
+	serverFirst     string
+}
+
+func (s *scramServer) Next(response []byte) ([]byte, bool, error) {
+	s.step++
+	switch s.step {
+	case 1:
+		challenge, err := s.serverFirstMessage(string(response))
+		return challenge, false, err
+	case 2:
+		final, err := s.serverFinalMessage(string(response))
+		return final, err == nil, err
+	}
+	return nil, false, errors.New("sasl: unexpected SCRAM message")
+}
+
+func (s *scramServer) serverFirstMessage(clientFirst string) ([]byte, error) {
+	if !strings.HasPrefix(clientFirst, scramGS2Header) {
+		return nil, errors.New("sasl: SCRAM channel binding and authzid are not supported")
+	}
+	s.clientFirstBare = clientFirst[len(scramGS2Header):]
+	attrs, err := scramAttrs(s.clientFirstBare)
+	if err != nil {
+		return nil, err
+	}
+	if attrs['r'] == "" {
+		return nil, errors.New("sasl: missing SCRAM client nonce")
+	}
+	s.username, err = scramUnescape(attrs['n'])
+	if err != nil {
+		return nil, err
+	}
+	s.creds, err = s.lookup(s.username)
+	if err != nil {
+		s.creds = s.fakeCredentials()
+	}
+	serverNonce, err := scramNonce()
+	if err != nil {
+		return nil, err
+	}
+	s.nonce = attrs['r'] + serverNonce
+	s.serverFirst = "r=" + s.nonce +
+		",s=" + base64.StdEncoding.EncodeToString(s.creds.Salt) +
+		",i=" + strconv.Itoa(s.creds.Iterations)
+	return []byte(s.serverFirst), nil
+}
+
+// fakeCredentials returns the credentials of the unknown user,
+// whose salt is the same for the same username, and whose stored key matches no proof.
+func (s *scramServer) fakeCredentials() *SCRAMCredentials {
+	return &SCRAMCredentials{
+		Salt:       scramHMAC(s.fakeKey, s.username)[:16],
+		Iterations: scramMinIterations,
+	}
+}
+
+func (s *scramServer) serverFinalMessage(clientFinal string) ([]byte, error) {
+	i := strings.LastIndex(clientFinal, ",p=")
+	if i < 0 {
+		return nil, errors.New("sasl: missing SCRAM client proof")
+	}
+	clientFinalWithoutProof := clientFinal[:i]
+	attrs, err := scramAttrs(clientFinalWithoutProof)
+	if err != nil {
+		return nil, err
+	}
+	if attrs['c'] != base64.StdEncoding.EncodeToString([]byte(scramGS2Header)) {
+		return nil, errors.New("sasl: invalid SCRAM channel binding")
+	}
+	if attrs['r'] != s.nonce {
+		return nil, errors.New("sasl: invalid SCRAM nonce")
+	}
+	proof, err := base64.StdEncoding.DecodeString(clientFinal[i+3:])
+	if err != nil || len(proof) != sha256.Size {
+		return nil, errors.New("sasl: malformed SCRAM client proof")
+	}
+	authMessage := s.clientFirstBare + "," + s.serverFirst + "," + clientFinalWithoutProof
+	clientKey := scramHMAC(s.creds.StoredKey, authMessage)
+	for i := range clientKey {
+		clientKey[i] ^= proof[i]
+	}
+	storedKey := sha256.Sum256(clientKey)
+	if !hmac.Equal(storedKey[:], s.creds.StoredKey) {
+		return nil, errors.New("sasl: SCRAM authentication failed")
+	}
+	return []byte("v=" + base64.StdEncoding.EncodeToString(scramHMAC(s.creds.ServerKey, authMessage))), nil
+}
+
+func (s *scramServer) Subject() string {
+	return s.username
+}
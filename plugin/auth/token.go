@@ -0,0 +1,206 @@
+// Copyright 2024 sqos. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auth
+
+import (
+	"errors"
+	"time"
+
+	"github.com/sqos/goutil"
+
+	"github.com/sqos/yrpc"
+)
+
+const (
+	// ClaimsSwapKey is the session swap key of the verified token claims, the value type is *Claims.
+	ClaimsSwapKey = "auth_claims"
+	// RefreshServiceMethod is the service method to refresh the token mid-session.
+	RefreshServiceMethod = "/auth/refresh"
+	verifierSwapKey      = "auth_verifier_"
+)
+
+type (
+	// Claims is the verified claims of a token.
+	Claims struct {
+		// Subject is the principal of the token.
+		Subject string `json:"sub,omitempty"`
+		// Issuer is the issuer of the token.
+		Issuer string `json:"iss,omitempty"`
+		// Audience is the recipients that the token is intended for.
+		Audience []string `json:"aud,omitempty"`
+		// ExpiresAt is the expiration time, zero means never expires.
+		ExpiresAt time.Time `json:"exp,omitempty"`
+		// IssuedAt is the time at which the token was issued.
+		IssuedAt time.Time `json:"iat,omitempty"`
+		// Extra is the other claims.
+		Extra map[string]interface{} `json:"extra,omitempty"`
+	}
+	// Verifier verifies the token and returns its claims.
+	Verifier interface {
+		Verify(token string) (*Claims, error)
+	}
+	// VerifierFunc is an adapter to use a function as a Verifier.
+	VerifierFunc func(token string) (*Claims, error)
+	// TokenSource returns the token sent by the client.
+	TokenSource func() (string, error)
+)
+
+// Verify calls f(token).
+func (f VerifierFunc) Verify(token string) (*Claims, error) {
+	return f(token)
+}
+
+// Expired reports whether the claims are expired at the time.
+func (c *Claims) Expired(now time.Time) bool {
+	return !c.ExpiresAt.IsZero() && !now.Before(c.ExpiresAt)
+}
+
+// GetClaims returns the verified token claims from the session swap.
+func GetClaims(swap goutil.Map) (*Claims, bool) {
+	v, ok := swap.Load(ClaimsSwapKey)
+	if !ok {
+		return nil, false
+	}
+	c, ok := v.(*Claims)
+	return c, ok
+}
+
+var errEmptyToken = errors.New("token is empty")
+
+// NewTokenBearerPlugin creates a auth bearer plugin for client, which sends the token from the source.
+// NOTE: The reply is the claims verified by the server.
+func NewTokenBearerPlugin(source TokenSource, infoSetting ...yrpc.MessageSetting) yrpc.Plugin {
+	return NewBearerPlugin(func(sess Session, fn SendOnce) *yrpc.Status {
+		token, err := source()
+		if err != nil {
+			return yrpc.NewStatus(yrpc.CodeUnauthorized, yrpc.CodeText(yrpc.CodeUnauthorized), err.Error())
+		}
+		claims := new(Claims)
+		stat := fn(token, claims)
+		if stat.OK() {
+			sess.Swap().Store(ClaimsSwapKey, claims)
+		}
+		return stat
+	}, infoSetting...)
+}
+
+// NewTokenCheckerPlugin creates a auth checker plugin for server, which verifies the token by the verifier.
+// NOTE:
+//
+//	The verified claims are stored in the session swap, get them by GetClaims;
+//	After the claims expire, the CALL and PUSH are rejected until the token is refreshed by Refresh.
+func NewTokenCheckerPlugin(verifier Verifier, retSetting ...yrpc.MessageSetting) yrpc.Plugin {
+	return &tokenCheckerPlugin{
+		authCheckerPlugin: authCheckerPlugin{
+			checkerFunc: func(sess Session, fn RecvOnce) (interface{}, *yrpc.Status) {
+				var token string
+				if stat := fn(&token); !stat.OK() {
+					return nil, stat
+				}
+				claims, stat := verifyToken(verifier, token)
+				if !stat.OK() {
+					return nil, stat
+				}
+				sess.Swap().Store(ClaimsSwapKey, claims)
+				sess.Swap().Store(verifierSwapKey, verifier)
+				return claims, nil
+			},
+			msgSetting: retSetting,
+		},
+	}
+}
+
+type tokenCheckerPlugin struct {
+	authCheckerPlugin
+}
+
+var (
+	_ yrpc.PostNewPeerPlugin        = new(tokenCheckerPlugin)
+	_ yrpc.PostAcceptPlugin         = new(tokenCheckerPlugin)
+	_ yrpc.PostReadCallHeaderPlugin = new(tokenCheckerPlugin)
+	_ yrpc.PostReadPushHeaderPlugin = new(tokenCheckerPlugin)
+)
+
+func (t *tokenCheckerPlugin) Name() string {
+	return "auth-token-checker"
+}
+
+func (t *tokenCheckerPlugin) PostNewPeer(peer yrpc.EarlyPeer) error {
+	peer.SubRoute("/auth").RouteCallFunc((*refreshCall).refresh)
+	return nil
+}
+
+func (t *tokenCheckerPlugin) PostReadCallHeader(ctx yrpc.ReadCtx) *yrpc.Status {
+	if ctx.ServiceMethod() == RefreshServiceMethod {
+		return nil
+	}
+	return checkExpired(ctx)
+}
+
+func (t *tokenCheckerPlugin) PostReadPushHeader(ctx yrpc.ReadCtx) *yrpc.Status {
+	return checkExpired(ctx)
+}
+
+func checkExpired(ctx yrpc.ReadCtx) *yrpc.Status {
+	claims, ok := GetClaims(ctx.Session().Swap())
+	if ok && claims.Expired(time.Now()) {
+		return yrpc.NewStatus(yrpc.CodeUnauthorized, yrpc.CodeText(yrpc.CodeUnauthorized), "token is expired")
+	}
+	return nil
+}
+
+func verifyToken(verifier Verifier, token string) (*Claims, *yrpc.Status) {
+	if token == "" {
+		return nil, yrpc.NewStatus(yrpc.CodeUnauthorized, yrpc.CodeText(yrpc.CodeUnauthorized), errEmptyToken.Error())
+	}
+	claims, err := verifier.Verify(token)
+	if err != nil {
+		return nil, yrpc.NewStatus(yrpc.CodeUnauthorized, yrpc.CodeText(yrpc.CodeUnauthorized), err.Error())
+	}
+	return claims, nil
+}
+
+type refreshCall struct {
+	yrpc.CallCtx
+}
+
+func (r *refreshCall) refresh(token *string) (*Claims, *yrpc.Status) {
+	swap := r.Session().Swap()
+	v, ok := swap.Load(verifierSwapKey)
+	if !ok {
+		return nil, yrpc.NewStatus(yrpc.CodeUnauthorized, yrpc.CodeText(yrpc.CodeUnauthorized), "session is not authenticated by token")
+	}
+	claims, stat := verifyToken(v.(Verifier), *token)
+	if !stat.OK() {
+		return nil, stat
+	}
+	if old, ok := GetClaims(swap); ok && old.Subject != claims.Subject {
+		return nil, yrpc.NewStatus(yrpc.CodeForbidden, yrpc.CodeText(yrpc.CodeForbidden), "token subject can not be changed")
+	}
+	swap.Store(ClaimsSwapKey, claims)
+	return claims, nil
+}
+
+// Refresh sends a new token to refresh the claims of the session mid-session.
+// NOTE: The subject of the new token must be the same as the current one.
+func Refresh(sess yrpc.Session, token string) (*Claims, *yrpc.Status) {
+	claims := new(Claims)
+	stat := sess.Call(RefreshServiceMethod, token, claims).Status()
+	if !stat.OK() {
+		return nil, stat
+	}
+	sess.Swap().Store(ClaimsSwapKey, claims)
+	return claims, nil
+}
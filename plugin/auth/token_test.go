@@ -0,0 +1,209 @@
+package auth_test
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/sqos/yrpc"
+	"github.com/sqos/yrpc/plugin/auth"
+	"github.com/sqos/yrpc/yrpctest"
+)
+
+func TestJWTVerifier(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	edPub, edPriv, _ := ed25519.GenerateKey(rand.Reader)
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	b64 := base64.RawURLEncoding
+	jwksFile := filepath.Join(t.TempDir(), "jwks.json")
+	os.WriteFile(jwksFile, []byte(`{"keys":[`+
+		`{"kty":"oct","kid":"hs","k":"`+b64.EncodeToString(secret)+`"},`+
+		`{"kty":"RSA","kid":"rs","alg":"RS256","n":"`+b64.EncodeToString(rsaKey.N.Bytes())+`","e":"`+b64.EncodeToString(big.NewInt(int64(rsaKey.E)).Bytes())+`"},`+
+		`{"kty":"OKP","kid":"ed","crv":"Ed25519","x":"`+b64.EncodeToString(edPub)+`"}`+
+		`]}`), 0600)
+
+	v, err := auth.NewJWTVerifier(auth.JWTConfig{
+		JWKSFile:      jwksFile,
+		Audience:      "yrpc",
+		Issuer:        "issuer",
+		RequireExpiry: true,
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	now := time.Now().Unix()
+	claims := map[string]interface{}{
+		"sub":  "alice",
+		"iss":  "issuer",
+		"aud":  []string{"other", "yrpc"},
+		"exp":  now + 60,
+		"iat":  now,
+		"role": "admin",
+	}
+	sign := func(key *auth.JWK, modify func(map[string]interface{})) string {
+		c := make(map[string]interface{}, len(claims))
+		for k, v := range claims {
+			c[k] = v
+		}
+		modify(c)
+		token, _ := auth.SignJWT(key, c)
+		return token
+	}
+	for _, key := range []*auth.JWK{
+		{KeyID: "hs", Algorithm: auth.ALG_HS256, Key: secret},
+		{KeyID: "rs", Algorithm: auth.ALG_RS256, Key: rsaKey},
+		{KeyID: "ed", Algorithm: auth.ALG_EdDSA, Key: edPriv},
+	} {
+		token, err := auth.SignJWT(key, claims)
+		if !assert.NoError(t, err) {
+			continue
+		}
+		c, err := v.Verify(token)
+		if assert.NoError(t, err, key.Algorithm) {
+			assert.Equal(t, "alice", c.Subject)
+			assert.Equal(t, []string{"other", "yrpc"}, c.Audience)
+			assert.Equal(t, now+60, c.ExpiresAt.Unix())
+			assert.Equal(t, "admin", c.Extra["role"])
+		}
+		// tampered payload
+		tampered := sign(key, func(c map[string]interface{}) { c["sub"] = "mallory" })
+		i, j := strings.IndexByte(token, '.'), strings.LastIndexByte(token, '.')
+		_, err = v.Verify(token[:i] + tampered[i:j] + token[j:])
+		assert.Error(t, err, key.Algorithm)
+	}
+
+	hs := &auth.JWK{KeyID: "hs", Algorithm: auth.ALG_HS256, Key: secret}
+	_, err = v.Verify(sign(hs, func(c map[string]interface{}) { c["exp"] = now - 1 }))
+	assert.EqualError(t, err, "jwt: token is expired")
+	_, err = v.Verify(sign(hs, func(c map[string]interface{}) { delete(c, "exp") }))
+	assert.EqualError(t, err, "jwt: exp claim is required")
+	_, err = v.Verify(sign(hs, func(c map[string]interface{}) { c["aud"] = "other" }))
+	assert.EqualError(t, err, `jwt: token is not intended for audience "yrpc"`)
+	_, err = v.Verify(sign(hs, func(c map[string]interface{}) { c["iss"] = "evil" }))
+	assert.EqualError(t, err, `jwt: unexpected issuer "evil"`)
+	_, err = v.Verify(sign(hs, func(c map[string]interface{}) { c["nbf"] = now + 60 }))
+	assert.EqualError(t, err, "jwt: token is not valid yet")
+
+	// the algorithm is bound to the key: an HS256 token signed with the RSA public key is rejected
+	_, err = v.Verify(sign(&auth.JWK{KeyID: "rs", Algorithm: auth.ALG_HS256, Key: rsaKey.N.Bytes()}, func(map[string]interface{}) {}))
+	assert.Error(t, err)
+	// unknown key
+	_, err = v.Verify(sign(&auth.JWK{KeyID: "other", Algorithm: auth.ALG_HS256, Key: secret}, func(map[string]interface{}) {}))
+	assert.Error(t, err)
+}
+
+func TestAPIKeyVerifier(t *testing.T) {
+	v := auth.NewAPIKeyVerifier(map[string]*auth.Claims{
+		"key-1": {Subject: "svc-a"},
+		"key-2": {Subject: "svc-b", ExpiresAt: time.Now().Add(-time.Second)},
+	})
+	c, err := v.Verify("key-1")
+	assert.NoError(t, err)
+	assert.Equal(t, "svc-a", c.Subject)
+	_, err = v.Verify("key-2")
+	assert.EqualError(t, err, "apikey: API key is expired")
+	_, err = v.Verify("key-3")
+	assert.EqualError(t, err, "apikey: invalid API key")
+}
+
+func TestHMACVerifier(t *testing.T) {
+	secret := []byte("secret")
+	v := auth.NewHMACVerifier(map[string][]byte{"svc.a": secret}, time.Minute)
+	now := time.Now()
+	c, err := v.Verify(auth.SignHMAC("svc.a", secret, now))
+	assert.NoError(t, err)
+	assert.Equal(t, "svc.a", c.Subject)
+	assert.Equal(t, now.Unix()+60, c.ExpiresAt.Unix())
+	_, err = v.Verify(auth.SignHMAC("svc.a", secret, now.Add(-2*time.Minute)))
+	assert.EqualError(t, err, "hmac: timestamp is out of the window")
+	_, err = v.Verify(auth.SignHMAC("svc.a", []byte("wrong"), now))
+	assert.EqualError(t, err, "hmac: invalid signature")
+	_, err = v.Verify(auth.SignHMAC("svc.b", secret, now))
+	assert.EqualError(t, err, "hmac: invalid signature")
+	_, err = v.Verify("bad")
+	assert.Error(t, err)
+}
+
+type Whoami struct {
+	yrpc.CallCtx
+}
+
+func (w *Whoami) Get(*struct{}) (string, *yrpc.Status) {
+	claims, _ := auth.GetClaims(w.Session().Swap())
+	return claims.Subject, nil
+}
+
+// dial dials the address with the bearer plugin, and returns the status of the bearer if it rejects the session,
+// since the peer reports a failed PostDial as CodeDialFailed.
+func dial(t *testing.T, addr string, bearer yrpc.Plugin) (yrpc.Session, *yrpc.Status) {
+	b := &bearerStatus{PostDialPlugin: bearer.(yrpc.PostDialPlugin)}
+	sess, stat := yrpctest.NewClient(t, yrpc.PeerConfig{}, b).Dial(addr)
+	if !stat.OK() && !b.stat.OK() {
+		return nil, b.stat
+	}
+	return sess, stat
+}
+
+// bearerStatus records the status returned by the PostDial plugin.
+type bearerStatus struct {
+	yrpc.PostDialPlugin
+	stat *yrpc.Status
+}
+
+func (b *bearerStatus) PostDial(sess yrpc.PreSession, isRedial bool) *yrpc.Status {
+	b.stat = b.PostDialPlugin.PostDial(sess, isRedial)
+	return b.stat
+}
+
+func TestTokenPlugin(t *testing.T) {
+	secret := []byte("secret")
+	verifier := auth.NewHMACVerifier(map[string][]byte{"alice": secret, "bob": secret}, 2*time.Second)
+
+	srv, addr := yrpctest.NewServer(t, yrpc.PeerConfig{}, auth.NewTokenCheckerPlugin(verifier))
+	srv.RouteCall(new(Whoami))
+	newSession := func(token string) (yrpc.Session, *yrpc.Status) {
+		bearer := auth.NewTokenBearerPlugin(func() (string, error) { return token, nil })
+		return dial(t, addr, bearer)
+	}
+
+	_, stat := newSession(auth.SignHMAC("alice", []byte("wrong"), time.Now()))
+	assert.Equal(t, yrpc.CodeUnauthorized, stat.Code())
+
+	// issued one second ago, expires after one second
+	sess, stat := newSession(auth.SignHMAC("alice", secret, time.Now().Add(-time.Second)))
+	if !assert.True(t, stat.OK(), stat) {
+		return
+	}
+	claims, ok := auth.GetClaims(sess.Swap())
+	assert.True(t, ok)
+	assert.Equal(t, "alice", claims.Subject)
+
+	var subject string
+	stat = sess.Call("/whoami/get", nil, &subject).Status()
+	assert.True(t, stat.OK(), stat)
+	assert.Equal(t, "alice", subject)
+
+	time.Sleep(time.Until(claims.ExpiresAt) + 100*time.Millisecond)
+	stat = sess.Call("/whoami/get", nil, &subject).Status()
+	assert.Equal(t, yrpc.CodeUnauthorized, stat.Code())
+
+	// the subject can not be changed by refreshing
+	_, stat = auth.Refresh(sess, auth.SignHMAC("bob", secret, time.Now()))
+	assert.Equal(t, yrpc.CodeForbidden, stat.Code())
+
+	claims, stat = auth.Refresh(sess, auth.SignHMAC("alice", secret, time.Now()))
+	if assert.True(t, stat.OK(), stat) {
+		assert.Equal(t, "alice", claims.Subject)
+	}
+	stat = sess.Call("/whoami/get", nil, &subject).Status()
+	assert.True(t, stat.OK(), stat)
+}
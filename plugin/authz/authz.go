@@ -0,0 +1,295 @@
+// Package authz is a plugin that authorizes service methods by the subject and roles of the session.
+//
+// Copyright 2024 sqos. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package authz
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync/atomic"
+
+	"github.com/sqos/goutil"
+
+	"github.com/sqos/yrpc"
+	"github.com/sqos/yrpc/utils"
+)
+
+const (
+	// SubjectSwapKey is the session swap key of the subject, the value type is string.
+	SubjectSwapKey = "authz_subject"
+	// RolesSwapKey is the session swap key of the roles, the value type is []string.
+	RolesSwapKey = "authz_roles"
+)
+
+// SetSubject stores the subject and roles into the session swap,
+// it is usually called by the auth checker after authentication.
+func SetSubject(swap goutil.Map, subject string, roles ...string) {
+	swap.Store(SubjectSwapKey, subject)
+	swap.Store(RolesSwapKey, roles)
+}
+
+// GetSubject returns the subject and roles from the session swap.
+func GetSubject(swap goutil.Map) (subject string, roles []string, ok bool) {
+	v, ok := swap.Load(SubjectSwapKey)
+	if !ok {
+		return "", nil, false
+	}
+	subject, ok = v.(string)
+	if !ok {
+		return "", nil, false
+	}
+	if v, _ := swap.Load(RolesSwapKey); v != nil {
+		roles, _ = v.([]string)
+	}
+	return subject, roles, true
+}
+
+// Effect is the effect of a rule.
+type Effect int8
+
+const (
+	// Allow allows the matched access.
+	Allow Effect = iota
+	// Deny denies the matched access, which overrides any allow.
+	Deny
+)
+
+// String returns the effect text.
+func (e Effect) String() string {
+	if e == Deny {
+		return "deny"
+	}
+	return "allow"
+}
+
+type (
+	// Policy is the authorization policy.
+	// NOTE:
+	//
+	//	A deny rule overrides all the allow rules, the first matched deny rule decides;
+	//	Otherwise the first matched allow rule decides;
+	//	Otherwise DefaultAllow decides.
+	Policy struct {
+		// Prefix is the SubRoute prefix that the service method patterns are relative to,
+		// e.g. "/admin" if the plugin is used by router.SubRoute("/admin", plugin).
+		Prefix string
+		// Rules is the allow and deny rules.
+		Rules []Rule
+		// DefaultAllow allows the access that matches no rule.
+		DefaultAllow bool
+	}
+	// Rule matches the access by service method, message type, subject and roles.
+	// NOTE:
+	//
+	//	The patterns support '*' wildcard, which matches any sequence of characters;
+	//	If both Subjects and Roles are empty, the rule matches anyone, even if no subject is authenticated;
+	//	Otherwise the rule matches if the subject or any of the roles matches.
+	Rule struct {
+		// Effect is Allow or Deny.
+		Effect Effect
+		// ServiceMethod is the service method pattern relative to Policy.Prefix, e.g. "/user/*".
+		ServiceMethod string
+		// Mtypes is the message types, yrpc.TypeCall and/or yrpc.TypePush, empty means all.
+		Mtypes []byte
+		// Subjects is the subject patterns.
+		Subjects []string
+		// Roles is the role patterns.
+		Roles []string
+	}
+	// Decision is the audit record of an authorization decision.
+	Decision struct {
+		// Allowed is the result of the decision.
+		Allowed bool
+		// Subject is the authenticated subject, empty if not authenticated.
+		Subject string
+		// Roles is the roles of the subject.
+		Roles []string
+		// ServiceMethod is the requested service method.
+		ServiceMethod string
+		// Mtype is the message type.
+		Mtype byte
+		// RemoteAddr is the remote address of the session.
+		RemoteAddr net.Addr
+		// RuleIndex is the index of the rule that decides, -1 if decided by DefaultAllow.
+		RuleIndex int
+		// Reason is the text explanation of the decision.
+		Reason string
+	}
+	// Auditor receives every authorization decision.
+	Auditor func(*Decision)
+)
+
+// Authz is a role-based authorization plugin.
+// NOTE: It checks before reading the message body, so it can be used globally or by router.SubRoute.
+type Authz struct {
+	policy  atomic.Pointer[compiledPolicy]
+	auditor Auditor
+}
+
+// clone returns the deep copy of the policy, so the rules are not shared with the caller.
+func (p Policy) clone() Policy {
+	rules := make([]Rule, len(p.Rules))
+	for i, rule := range p.Rules {
+		rule.Mtypes = append([]byte(nil), rule.Mtypes...)
+		rule.Subjects = append([]string(nil), rule.Subjects...)
+		rule.Roles = append([]string(nil), rule.Roles...)
+		rules[i] = rule
+	}
+	p.Rules = rules
+	return p
+}
+
+type compiledPolicy struct {
+	Policy
+	patterns []string
+}
+
+var (
+	_ yrpc.PreReadCallBodyPlugin = (*Authz)(nil)
+	_ yrpc.PreReadPushBodyPlugin = (*Authz)(nil)
+)
+
+// New creates a role-based authorization plugin.
+// NOTE: If auditor is not nil, it is called synchronously for every decision.
+func New(policy Policy, auditor ...Auditor) *Authz {
+	a := new(Authz)
+	if len(auditor) > 0 {
+		a.auditor = auditor[0]
+	}
+	a.Update(policy)
+	return a
+}
+
+// Name returns the plugin name.
+func (a *Authz) Name() string {
+	return "authz"
+}
+
+// Policy returns the copy of the current policy, modifying it does not affect the plugin.
+func (a *Authz) Policy() Policy {
+	return a.policy.Load().Policy.clone()
+}
+
+// Update replaces the policy at runtime, the next messages are checked by the new one.
+func (a *Authz) Update(policy Policy) {
+	prefix := strings.TrimSuffix(policy.Prefix, "/")
+	policy = policy.clone()
+	p := &compiledPolicy{
+		Policy:   policy,
+		patterns: make([]string, len(policy.Rules)),
+	}
+	for i, rule := range policy.Rules {
+		p.patterns[i] = prefix + rule.ServiceMethod
+	}
+	a.policy.Store(p)
+}
+
+// PreReadCallBody authorizes the CALL message.
+func (a *Authz) PreReadCallBody(ctx yrpc.ReadCtx) *yrpc.Status {
+	return a.check(ctx)
+}
+
+// PreReadPushBody authorizes the PUSH message.
+func (a *Authz) PreReadPushBody(ctx yrpc.ReadCtx) *yrpc.Status {
+	return a.check(ctx)
+}
+
+func (a *Authz) check(ctx yrpc.ReadCtx) *yrpc.Status {
+	subject, roles, authenticated := GetSubject(ctx.Session().Swap())
+	d := a.Decide(subject, roles, authenticated, ctx.ServiceMethod(), ctx.Input().Mtype())
+	if a.auditor != nil {
+		d.RemoteAddr = ctx.Session().RemoteAddr()
+		a.auditor(d)
+	}
+	if d.Allowed {
+		return nil
+	}
+	if !authenticated {
+		return yrpc.NewStatus(yrpc.CodeUnauthorized, yrpc.CodeText(yrpc.CodeUnauthorized), d.Reason)
+	}
+	return yrpc.NewStatus(yrpc.CodeForbidden, yrpc.CodeText(yrpc.CodeForbidden), d.Reason)
+}
+
+// Decide evaluates the current policy without side effects.
+func (a *Authz) Decide(subject string, roles []string, authenticated bool, serviceMethod string, mtype byte) *Decision {
+	d := &Decision{
+		Subject:       subject,
+		Roles:         roles,
+		ServiceMethod: serviceMethod,
+		Mtype:         mtype,
+		RuleIndex:     -1,
+	}
+	p := a.policy.Load()
+	allowIndex := -1
+	for i := range p.Rules {
+		rule := &p.Rules[i]
+		if !utils.MatchWildcard(p.patterns[i], serviceMethod) || !rule.matchMtype(mtype) || !rule.matchSubject(subject, roles, authenticated) {
+			continue
+		}
+		if rule.Effect == Deny {
+			d.RuleIndex = i
+			d.Reason = fmt.Sprintf("%q is denied to access %q by rule %d", subject, serviceMethod, i)
+			return d
+		}
+		if allowIndex < 0 {
+			allowIndex = i
+		}
+	}
+	switch {
+	case allowIndex >= 0:
+		d.Allowed = true
+		d.RuleIndex = allowIndex
+		d.Reason = fmt.Sprintf("%q is allowed to access %q by rule %d", subject, serviceMethod, allowIndex)
+	case p.DefaultAllow:
+		d.Allowed = true
+		d.Reason = fmt.Sprintf("%q is allowed to access %q by default", subject, serviceMethod)
+	case !authenticated:
+		d.Reason = fmt.Sprintf("authentication is required to access %q", serviceMethod)
+	default:
+		d.Reason = fmt.Sprintf("%q is not allowed to access %q", subject, serviceMethod)
+	}
+	return d
+}
+
+func (r *Rule) matchMtype(mtype byte) bool {
+	if len(r.Mtypes) == 0 {
+		return true
+	}
+	for _, t := range r.Mtypes {
+		if t == mtype {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Rule) matchSubject(subject string, roles []string, authenticated bool) bool {
+	if len(r.Subjects) == 0 && len(r.Roles) == 0 {
+		return true
+	}
+	if !authenticated {
+		return false
+	}
+	if utils.MatchAnyWildcard(r.Subjects, subject) {
+		return true
+	}
+	for _, role := range roles {
+		if utils.MatchAnyWildcard(r.Roles, role) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,158 @@
+package authz_test
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/sqos/yrpc"
+	"github.com/sqos/yrpc/plugin/authz"
+	"github.com/sqos/yrpc/yrpctest"
+)
+
+type User struct {
+	yrpc.CallCtx
+}
+
+func (u *User) Get(*struct{}) (string, *yrpc.Status) {
+	return "user", nil
+}
+
+func (u *User) Delete(*struct{}) (string, *yrpc.Status) {
+	return "deleted", nil
+}
+
+type Public struct {
+	yrpc.CallCtx
+}
+
+func (p *Public) Ping(*struct{}) (string, *yrpc.Status) {
+	return "pong", nil
+}
+
+// subjectPlugin simulates the auth checker that stores the subject after authentication.
+type subjectPlugin struct {
+	subject string
+	roles   []string
+}
+
+func (p *subjectPlugin) Name() string { return "subject" }
+
+func (p *subjectPlugin) PostAccept(sess yrpc.PreSession) *yrpc.Status {
+	if p.subject != "" {
+		authz.SetSubject(sess.Swap(), p.subject, p.roles...)
+	}
+	return nil
+}
+
+func TestAuthz(t *testing.T) {
+	var (
+		mu        sync.Mutex
+		decisions []*authz.Decision
+	)
+	az := authz.New(authz.Policy{
+		Prefix: "/admin",
+		Rules: []authz.Rule{
+			{Effect: authz.Deny, ServiceMethod: "/user/delete", Subjects: []string{"mallory"}},
+			{Effect: authz.Allow, ServiceMethod: "/user/*", Roles: []string{"admin"}},
+			{Effect: authz.Allow, ServiceMethod: "/user/get", Roles: []string{"viewer"}},
+		},
+	}, func(d *authz.Decision) {
+		mu.Lock()
+		decisions = append(decisions, d)
+		mu.Unlock()
+	})
+
+	newSession := func(subject string, roles ...string) yrpc.Session {
+		srv, addr := yrpctest.NewServer(t, yrpc.PeerConfig{}, &subjectPlugin{subject: subject, roles: roles})
+		group := srv.SubRoute("/admin", az)
+		group.RouteCall(new(User))
+		srv.RouteCall(new(Public))
+		return yrpctest.Dial(t, yrpctest.NewClient(t, yrpc.PeerConfig{}), addr)
+	}
+	call := func(sess yrpc.Session, serviceMethod string) *yrpc.Status {
+		var result string
+		return sess.Call(serviceMethod, nil, &result).Status()
+	}
+
+	admin := newSession("alice", "admin")
+	assert.True(t, call(admin, "/admin/user/get").OK())
+	assert.True(t, call(admin, "/admin/user/delete").OK())
+
+	viewer := newSession("bob", "viewer")
+	assert.True(t, call(viewer, "/admin/user/get").OK())
+	assert.Equal(t, yrpc.CodeForbidden, call(viewer, "/admin/user/delete").Code())
+	// the routes out of the SubRoute are not checked
+	assert.True(t, call(viewer, "/public/ping").OK())
+
+	mallory := newSession("mallory", "admin")
+	assert.True(t, call(mallory, "/admin/user/get").OK())
+	assert.Equal(t, yrpc.CodeForbidden, call(mallory, "/admin/user/delete").Code())
+
+	anonymous := newSession("")
+	assert.Equal(t, yrpc.CodeUnauthorized, call(anonymous, "/admin/user/get").Code())
+
+	mu.Lock()
+	assert.Len(t, decisions, 7)
+	last := decisions[len(decisions)-1]
+	mu.Unlock()
+	assert.False(t, last.Allowed)
+	assert.Equal(t, "/admin/user/get", last.ServiceMethod)
+	assert.Equal(t, -1, last.RuleIndex)
+	assert.NotNil(t, last.RemoteAddr)
+
+	// reload the policy at runtime
+	az.Update(authz.Policy{
+		Prefix: "/admin",
+		Rules: []authz.Rule{
+			{Effect: authz.Allow, ServiceMethod: "/user/*", Roles: []string{"viewer"}},
+		},
+	})
+	assert.True(t, call(viewer, "/admin/user/delete").OK())
+	assert.Equal(t, yrpc.CodeForbidden, call(admin, "/admin/user/delete").Code())
+}
+
+func TestDecide(t *testing.T) {
+	az := authz.New(authz.Policy{
+		Rules: []authz.Rule{
+			{Effect: authz.Allow, ServiceMethod: "/public/*"},
+			{Effect: authz.Allow, ServiceMethod: "/notice/*", Mtypes: []byte{yrpc.TypePush}, Roles: []string{"*"}},
+			{Effect: authz.Deny, ServiceMethod: "/*", Roles: []string{"banned"}},
+		},
+		DefaultAllow: true,
+	})
+	d := az.Decide("", nil, false, "/public/ping", yrpc.TypeCall)
+	assert.True(t, d.Allowed)
+	assert.Equal(t, 0, d.RuleIndex)
+
+	d = az.Decide("bob", []string{"user"}, true, "/notice/send", yrpc.TypePush)
+	assert.True(t, d.Allowed)
+	assert.Equal(t, 1, d.RuleIndex)
+
+	d = az.Decide("bob", []string{"user"}, true, "/notice/send", yrpc.TypeCall)
+	assert.True(t, d.Allowed)
+	assert.Equal(t, -1, d.RuleIndex)
+
+	d = az.Decide("eve", []string{"user", "banned"}, true, "/public/ping", yrpc.TypeCall)
+	assert.False(t, d.Allowed)
+	assert.Equal(t, 2, d.RuleIndex)
+}
+
+func TestPolicyCopy(t *testing.T) {
+	rules := []authz.Rule{
+		{Effect: authz.Deny, ServiceMethod: "/*", Roles: []string{"banned"}},
+	}
+	az := authz.New(authz.Policy{Rules: rules, DefaultAllow: true})
+	// neither the input nor the returned policy is shared with the plugin
+	rules[0].Roles[0] = "user"
+	policy := az.Policy()
+	assert.Equal(t, "banned", policy.Rules[0].Roles[0])
+	policy.Rules[0].Effect = authz.Allow
+	policy.Rules[0].Roles[0] = "user"
+
+	d := az.Decide("eve", []string{"banned"}, true, "/user/get", yrpc.TypeCall)
+	assert.False(t, d.Allowed)
+	assert.Equal(t, 0, d.RuleIndex)
+	assert.Equal(t, authz.Deny, az.Policy().Rules[0].Effect)
+}
@@ -0,0 +1,129 @@
+// Package certauth is a plugin that authorizes service methods by the identity of the peer TLS certificate.
+//
+// Copyright 2024 sqos. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package certauth
+
+import (
+	"fmt"
+
+	"github.com/sqos/yrpc"
+	"github.com/sqos/yrpc/utils"
+)
+
+type (
+	// Config the certificate authorization config
+	Config struct {
+		// Rules is checked in order, the first rule matching the service method decides.
+		Rules []Rule
+		// DefaultAllow allows the service methods that match no rule.
+		DefaultAllow bool
+		// AllowUnverified allows the certificate that is not verified by the local peer,
+		// e.g. tls.RequestClientCert is used.
+		AllowUnverified bool
+	}
+	// Rule authorizes the service methods to the certificate identities.
+	// NOTE:
+	//
+	//	The patterns support '*' wildcard, which matches any sequence of characters;
+	//	The identity is allowed if any of its subject CN, SAN DNS names or SAN URIs matches.
+	Rule struct {
+		// ServiceMethod is the service method pattern, e.g. "/admin/*".
+		ServiceMethod string
+		// CommonNames is the subject common name patterns.
+		CommonNames []string
+		// DNSNames is the SAN DNS name patterns.
+		DNSNames []string
+		// URIs is the SAN URI patterns, e.g. "spiffe://example.org/ns/prod/*".
+		URIs []string
+	}
+)
+
+// NewPlugin creates a certificate authorization plugin.
+// NOTE: It should be used on the peer that verifies the remote certificates, e.g. tls.RequireAndVerifyClientCert.
+func NewPlugin(cfg Config) yrpc.Plugin {
+	return &certauthPlugin{cfg: cfg}
+}
+
+type certauthPlugin struct {
+	cfg Config
+}
+
+var (
+	_ yrpc.PostReadCallHeaderPlugin = new(certauthPlugin)
+	_ yrpc.PostReadPushHeaderPlugin = new(certauthPlugin)
+)
+
+func (c *certauthPlugin) Name() string {
+	return "certauth"
+}
+
+// PostReadCallHeader authorizes the CALL service method.
+func (c *certauthPlugin) PostReadCallHeader(ctx yrpc.ReadCtx) *yrpc.Status {
+	return c.authorize(ctx.ServiceMethod(), ctx.Session().PeerIdentity())
+}
+
+// PostReadPushHeader authorizes the PUSH service method.
+func (c *certauthPlugin) PostReadPushHeader(ctx yrpc.ReadCtx) *yrpc.Status {
+	return c.authorize(ctx.ServiceMethod(), ctx.Session().PeerIdentity())
+}
+
+// authorize checks whether the identity is allowed to access the service method.
+func (c *certauthPlugin) authorize(serviceMethod string, identity *yrpc.PeerIdentity) *yrpc.Status {
+	for _, rule := range c.cfg.Rules {
+		if !utils.MatchWildcard(rule.ServiceMethod, serviceMethod) {
+			continue
+		}
+		if identity == nil {
+			return yrpc.NewStatus(yrpc.CodeUnauthorized, yrpc.CodeText(yrpc.CodeUnauthorized), "peer certificate is required")
+		}
+		if !identity.Verified && !c.cfg.AllowUnverified {
+			return yrpc.NewStatus(yrpc.CodeUnauthorized, yrpc.CodeText(yrpc.CodeUnauthorized), "peer certificate is not verified")
+		}
+		if rule.allow(identity) {
+			return nil
+		}
+		return forbidden(serviceMethod, identity)
+	}
+	if c.cfg.DefaultAllow {
+		return nil
+	}
+	return forbidden(serviceMethod, identity)
+}
+
+func forbidden(serviceMethod string, identity *yrpc.PeerIdentity) *yrpc.Status {
+	var cn string
+	if identity != nil {
+		cn = identity.CommonName
+	}
+	return yrpc.NewStatus(yrpc.CodeForbidden, yrpc.CodeText(yrpc.CodeForbidden),
+		fmt.Sprintf("%q is not allowed to access %q", cn, serviceMethod))
+}
+
+func (r *Rule) allow(identity *yrpc.PeerIdentity) bool {
+	if utils.MatchAnyWildcard(r.CommonNames, identity.CommonName) {
+		return true
+	}
+	for _, s := range identity.DNSNames {
+		if utils.MatchAnyWildcard(r.DNSNames, s) {
+			return true
+		}
+	}
+	for _, s := range identity.URIs {
+		if utils.MatchAnyWildcard(r.URIs, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,169 @@
+package certauth_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/sqos/yrpc"
+	"github.com/sqos/yrpc/plugin/certauth"
+	"github.com/sqos/yrpc/yrpctest"
+)
+
+type Admin struct {
+	yrpc.CallCtx
+}
+
+func (a *Admin) Whoami(*struct{}) (map[string]interface{}, *yrpc.Status) {
+	identity := a.Session().PeerIdentity()
+	return map[string]interface{}{
+		"cn":       identity.CommonName,
+		"uris":     identity.URIs,
+		"verified": identity.Verified,
+		"tls":      a.Session().TLS() != nil,
+	}, nil
+}
+
+type Public struct {
+	yrpc.CallCtx
+}
+
+func (p *Public) Ping(*struct{}) (string, *yrpc.Status) {
+	return "pong", nil
+}
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pool *x509.CertPool
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return &testCA{cert: cert, key: key, pool: pool}
+}
+
+func (ca *testCA) issue(t *testing.T, cn string, uri string, dnsNames ...string) tls.Certificate {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		DNSNames:     dnsNames,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+	}
+	if uri != "" {
+		u, _ := url.Parse(uri)
+		tmpl.URIs = []*url.URL{u}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// withTLS returns the plugin that sets the TLS config of the peer.
+func withTLS(c *tls.Config) yrpc.Plugin {
+	return &yrpc.PluginImpl{
+		PluginName: "tls",
+		OnPostNewPeer: func(peer yrpc.EarlyPeer) error {
+			peer.SetTLSConfig(c)
+			return nil
+		},
+	}
+}
+
+func TestCertAuth(t *testing.T) {
+	ca := newTestCA(t)
+	srvCert := ca.issue(t, "server", "", "server.local")
+	srv, addr := yrpctest.NewServer(t, yrpc.PeerConfig{},
+		withTLS(&tls.Config{
+			Certificates: []tls.Certificate{srvCert},
+			ClientAuth:   tls.VerifyClientCertIfGiven,
+			ClientCAs:    ca.pool,
+		}),
+		certauth.NewPlugin(certauth.Config{
+			Rules: []certauth.Rule{
+				{ServiceMethod: "/admin/*", URIs: []string{"spiffe://example.org/ns/prod/sa/admin-*"}, CommonNames: []string{"root"}},
+				{ServiceMethod: "/public/*", CommonNames: []string{"*"}},
+			},
+		}),
+	)
+	srv.RouteCall(new(Admin))
+	srv.RouteCall(new(Public))
+	_, port, _ := net.SplitHostPort(addr)
+
+	dial := func(clientCert *tls.Certificate) yrpc.Session {
+		cliCfg := &tls.Config{RootCAs: ca.pool}
+		if clientCert != nil {
+			cliCfg.Certificates = []tls.Certificate{*clientCert}
+		}
+		// the ServerName is set from the host of the address
+		cli := yrpctest.NewClient(t, yrpc.PeerConfig{}, withTLS(cliCfg))
+		return yrpctest.Dial(t, cli, net.JoinHostPort("server.local", port))
+	}
+
+	// allowed by SAN URI
+	adminCert := ca.issue(t, "alice", "spiffe://example.org/ns/prod/sa/admin-ops")
+	sess := dial(&adminCert)
+	var result map[string]interface{}
+	stat := sess.Call("/admin/whoami", nil, &result).Status()
+	assert.True(t, stat.OK(), stat)
+	assert.Equal(t, "alice", result["cn"])
+	assert.Equal(t, []interface{}{"spiffe://example.org/ns/prod/sa/admin-ops"}, result["uris"])
+	assert.Equal(t, true, result["verified"])
+	assert.Equal(t, true, result["tls"])
+	identity := sess.PeerIdentity()
+	if assert.NotNil(t, identity) {
+		assert.Equal(t, "server", identity.CommonName)
+		assert.Equal(t, []string{"server.local"}, identity.DNSNames)
+	}
+
+	// forbidden
+	userCert := ca.issue(t, "bob", "spiffe://example.org/ns/prod/sa/web")
+	sess = dial(&userCert)
+	stat = sess.Call("/admin/whoami", nil, &result).Status()
+	assert.Equal(t, yrpc.CodeForbidden, stat.Code())
+	var pong string
+	stat = sess.Call("/public/ping", nil, &pong).Status()
+	assert.True(t, stat.OK(), stat)
+	assert.Equal(t, "pong", pong)
+
+	// no rule matches
+	stat = sess.Call("/other/ping", nil, &pong).Status()
+	assert.Equal(t, yrpc.CodeForbidden, stat.Code())
+
+	// no client certificate
+	sess = dial(nil)
+	stat = sess.Call("/public/ping", nil, &pong).Status()
+	assert.Equal(t, yrpc.CodeUnauthorized, stat.Code())
+}
@@ -0,0 +1,146 @@
+// Copyright 2024 sqos. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ecdhe
+
+import (
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+/*
+# encrypted frame format(Big Endian):
+
+{4 bytes sealed length}
+{sealed data} # ChaCha20-Poly1305, the nonce is the frame counter of the direction
+*/
+
+const maxFramePlaintext = 64 * 1024
+
+var (
+	errBadFrame     = errors.New("ecdhe: bad frame")
+	errNonceExhaust = errors.New("ecdhe: nonce exhausted")
+)
+
+// secureConn encrypts and decrypts the frames of the connection.
+type secureConn struct {
+	net.Conn
+
+	rMu    sync.Mutex
+	rAEAD  cipher.AEAD
+	rSeq   uint64
+	rPlain []byte
+	rBuf   []byte
+	rErr   error
+
+	wMu   sync.Mutex
+	wAEAD cipher.AEAD
+	wSeq  uint64
+	wBuf  []byte
+}
+
+func newSecureConn(conn net.Conn, writeKey, readKey []byte) *secureConn {
+	wAEAD, _ := chacha20poly1305.New(writeKey)
+	rAEAD, _ := chacha20poly1305.New(readKey)
+	return &secureConn{
+		Conn:  conn,
+		rAEAD: rAEAD,
+		wAEAD: wAEAD,
+	}
+}
+
+// NetConn returns the underlying connection.
+func (c *secureConn) NetConn() net.Conn {
+	return c.Conn
+}
+
+func nonce(seq uint64) []byte {
+	var b [chacha20poly1305.NonceSize]byte
+	binary.BigEndian.PutUint64(b[4:], seq)
+	return b[:]
+}
+
+// Read reads and decrypts data from the connection.
+func (c *secureConn) Read(b []byte) (int, error) {
+	c.rMu.Lock()
+	defer c.rMu.Unlock()
+	for len(c.rPlain) == 0 {
+		if c.rErr != nil {
+			return 0, c.rErr
+		}
+		c.rErr = c.readFrame()
+	}
+	n := copy(b, c.rPlain)
+	c.rPlain = c.rPlain[n:]
+	return n, nil
+}
+
+func (c *secureConn) readFrame() error {
+	var head [4]byte
+	if _, err := io.ReadFull(c.Conn, head[:]); err != nil {
+		return err
+	}
+	size := int(binary.BigEndian.Uint32(head[:]))
+	if size > maxFramePlaintext+c.rAEAD.Overhead() || size < c.rAEAD.Overhead() {
+		return errBadFrame
+	}
+	if cap(c.rBuf) < size {
+		c.rBuf = make([]byte, size)
+	}
+	c.rBuf = c.rBuf[:size]
+	if _, err := io.ReadFull(c.Conn, c.rBuf); err != nil {
+		return err
+	}
+	if c.rSeq == ^uint64(0) {
+		return errNonceExhaust
+	}
+	plain, err := c.rAEAD.Open(c.rBuf[:0], nonce(c.rSeq), c.rBuf, nil)
+	if err != nil {
+		return err
+	}
+	c.rSeq++
+	c.rPlain = plain
+	return nil
+}
+
+// Write encrypts and writes data to the connection.
+func (c *secureConn) Write(b []byte) (int, error) {
+	c.wMu.Lock()
+	defer c.wMu.Unlock()
+	var n int
+	for len(b) > 0 {
+		chunk := b
+		if len(chunk) > maxFramePlaintext {
+			chunk = chunk[:maxFramePlaintext]
+		}
+		if c.wSeq == ^uint64(0) {
+			return n, errNonceExhaust
+		}
+		c.wBuf = binary.BigEndian.AppendUint32(c.wBuf[:0], uint32(len(chunk)+c.wAEAD.Overhead()))
+		c.wBuf = c.wAEAD.Seal(c.wBuf, nonce(c.wSeq), chunk, nil)
+		c.wSeq++
+		if _, err := c.Conn.Write(c.wBuf); err != nil {
+			return n, err
+		}
+		n += len(chunk)
+		b = b[len(chunk):]
+	}
+	return n, nil
+}
@@ -0,0 +1,51 @@
+package ecdhe
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSecureConn(t *testing.T) {
+	k1 := bytes.Repeat([]byte{1}, 32)
+	k2 := bytes.Repeat([]byte{2}, 32)
+	a, b := net.Pipe()
+	ca := newSecureConn(a, k1, k2)
+	cb := newSecureConn(b, k2, k1)
+
+	large := bytes.Repeat([]byte("0123456789"), maxFramePlaintext/5)
+	go func() {
+		ca.Write([]byte("hello"))
+		ca.Write(large)
+	}()
+	buf := make([]byte, 5)
+	_, err := io.ReadFull(cb, buf)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(buf))
+	buf = make([]byte, len(large))
+	_, err = io.ReadFull(cb, buf)
+	assert.NoError(t, err)
+	assert.Equal(t, large, buf)
+
+	// the ciphertext does not contain the plaintext
+	raw, peer := net.Pipe()
+	cw := newSecureConn(peer, k1, k2)
+	go cw.Write([]byte("hello"))
+	frame := make([]byte, 4+5+16)
+	_, err = io.ReadFull(raw, frame)
+	assert.NoError(t, err)
+	assert.False(t, bytes.Contains(frame, []byte("hello")))
+
+	// tampered frame
+	frame[len(frame)-1] ^= 1
+	x, y := net.Pipe()
+	cy := newSecureConn(y, k2, k1)
+	go x.Write(frame)
+	_, err = cy.Read(buf)
+	assert.Error(t, err)
+	_, err = cy.Read(buf)
+	assert.Error(t, err)
+}
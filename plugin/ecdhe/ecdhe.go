@@ -0,0 +1,321 @@
+// Package ecdhe is a plugin that encrypts the session by an X25519 key agreement handshake, without TLS.
+//
+// Copyright 2024 sqos. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package ecdhe
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+
+	"github.com/sqos/goutil"
+	"golang.org/x/crypto/hkdf"
+
+	"github.com/sqos/yrpc"
+	"github.com/sqos/yrpc/codec"
+)
+
+const (
+	// HandshakeServiceMethod the service method of the handshake call
+	HandshakeServiceMethod = "/ecdhe/handshake"
+	// ConfirmServiceMethod the service method of the handshake confirmation push
+	ConfirmServiceMethod = "/ecdhe/confirm"
+	// Version is the version of the handshake.
+	Version = 1
+	swapKey = "ecdhe_remote_static_"
+	label   = "yrpc ecdhe v1"
+)
+
+// Config the handshake config
+type Config struct {
+	// StaticKey is the local static X25519 private key used to authenticate the local peer, optional.
+	StaticKey *ecdh.PrivateKey
+	// PinnedKeys is the trusted static X25519 public keys of the remote peers.
+	// If it is not empty, the remote peer must authenticate with one of them.
+	PinnedKeys []*ecdh.PublicKey
+}
+
+// NewPlugin creates a handshake plugin, which must be used on both sides.
+// NOTE:
+//
+//	The client sends its ephemeral key in PostDial, and the server replies its one in PostAccept;
+//	The static keys are mixed into the key derivation, and are proved by the confirmation MACs;
+//	After the handshake, all the frames are encrypted by ChaCha20-Poly1305 with the per-session keys.
+func NewPlugin(cfg Config) yrpc.Plugin {
+	if cfg.StaticKey != nil && cfg.StaticKey.Curve() != ecdh.X25519() {
+		yrpc.Fatalf("ecdhe: the static key is not a X25519 key")
+	}
+	for _, k := range cfg.PinnedKeys {
+		if k.Curve() != ecdh.X25519() {
+			yrpc.Fatalf("ecdhe: the pinned key is not a X25519 key")
+		}
+	}
+	return &ecdhePlugin{cfg: cfg}
+}
+
+// GenerateKey generates a X25519 private key.
+func GenerateKey() (*ecdh.PrivateKey, error) {
+	return ecdh.X25519().GenerateKey(rand.Reader)
+}
+
+// GetRemoteStaticKey returns the authenticated static public key of the remote peer from the session swap.
+func GetRemoteStaticKey(swap goutil.Map) (*ecdh.PublicKey, bool) {
+	v, ok := swap.Load(swapKey)
+	if !ok {
+		return nil, false
+	}
+	k, ok := v.(*ecdh.PublicKey)
+	return k, ok
+}
+
+type ecdhePlugin struct {
+	cfg Config
+}
+
+var (
+	_ yrpc.PostDialPlugin   = new(ecdhePlugin)
+	_ yrpc.PostAcceptPlugin = new(ecdhePlugin)
+)
+
+func (e *ecdhePlugin) Name() string {
+	return "ecdhe"
+}
+
+// handshakeMsg is the body of the handshake messages.
+type handshakeMsg struct {
+	Version   int    `json:"version"`
+	Ephemeral []byte `json:"ephemeral,omitempty"`
+	Static    []byte `json:"static,omitempty"`
+	Confirm   []byte `json:"confirm,omitempty"`
+}
+
+// PostDial performs the handshake as the client.
+func (e *ecdhePlugin) PostDial(sess yrpc.PreSession, _ bool) *yrpc.Status {
+	eph, err := GenerateKey()
+	if err != nil {
+		return yrpc.NewStatus(yrpc.CodeDialFailed, "ecdhe handshake failed", err.Error())
+	}
+	req := &handshakeMsg{Version: Version, Ephemeral: eph.PublicKey().Bytes()}
+	if e.cfg.StaticKey != nil {
+		req.Static = e.cfg.StaticKey.PublicKey().Bytes()
+	}
+	rep := new(handshakeMsg)
+	stat := sess.PreCall(HandshakeServiceMethod, req, rep, yrpc.WithBodyCodec(codec.ID_JSON))
+	if !stat.OK() {
+		return stat
+	}
+	remoteEph, remoteStatic, stat := e.parseRemote(rep)
+	if !stat.OK() {
+		return stat
+	}
+	// DH(e,e), DH(e,S_server), DH(S_client,e)
+	dh, err := agree(dhPair{eph, remoteEph}, dhPair{eph, remoteStatic}, dhPair{e.cfg.StaticKey, remoteEph})
+	if err != nil {
+		return badMessage(err.Error())
+	}
+	keys := deriveKeys(dh, req, rep)
+	if !hmac.Equal(rep.Confirm, keys.serverConfirm) {
+		return unauthorized("the server failed to confirm the handshake")
+	}
+	stat = sess.PreSend(yrpc.TypePush, ConfirmServiceMethod,
+		&handshakeMsg{Version: Version, Confirm: keys.clientConfirm}, nil,
+		yrpc.WithBodyCodec(codec.ID_JSON),
+	)
+	if !stat.OK() {
+		return stat
+	}
+	sess.ModifySocket(func(conn net.Conn) (net.Conn, yrpc.ProtoFunc) {
+		return newSecureConn(conn, keys.clientToServer, keys.serverToClient), nil
+	})
+	storeRemoteStatic(sess.Swap(), remoteStatic)
+	return nil
+}
+
+// PostAccept performs the handshake as the server.
+func (e *ecdhePlugin) PostAccept(sess yrpc.PreSession) *yrpc.Status {
+	req := new(handshakeMsg)
+	input := sess.PreReceive(func(header yrpc.Header) interface{} {
+		if header.Mtype() == yrpc.TypeCall && header.ServiceMethod() == HandshakeServiceMethod {
+			return req
+		}
+		return nil
+	})
+	defer yrpc.PutMessage(input)
+	if !input.StatusOK() {
+		return input.Status()
+	}
+	if input.Mtype() != yrpc.TypeCall || input.ServiceMethod() != HandshakeServiceMethod {
+		stat := badMessage(fmt.Sprintf("handshake message expect: CALL %s, but received: %s %s",
+			HandshakeServiceMethod, yrpc.TypeText(input.Mtype()), input.ServiceMethod()))
+		sess.PreReply(input, nil, stat)
+		return stat
+	}
+	remoteEph, remoteStatic, stat := e.parseRemote(req)
+	if !stat.OK() {
+		sess.PreReply(input, nil, stat)
+		return stat
+	}
+	eph, err := GenerateKey()
+	if err != nil {
+		stat = yrpc.NewStatus(yrpc.CodeInternalServerError, "ecdhe handshake failed", err.Error())
+		sess.PreReply(input, nil, stat)
+		return stat
+	}
+	rep := &handshakeMsg{Version: Version, Ephemeral: eph.PublicKey().Bytes()}
+	if e.cfg.StaticKey != nil {
+		rep.Static = e.cfg.StaticKey.PublicKey().Bytes()
+	}
+	// DH(e,e), DH(e,S_server), DH(S_client,e)
+	dh, err := agree(dhPair{eph, remoteEph}, dhPair{e.cfg.StaticKey, remoteEph}, dhPair{eph, remoteStatic})
+	if err != nil {
+		stat = badMessage(err.Error())
+		sess.PreReply(input, nil, stat)
+		return stat
+	}
+	keys := deriveKeys(dh, req, rep)
+	rep.Confirm = keys.serverConfirm
+	stat = sess.PreReply(input, rep, nil, yrpc.WithBodyCodec(codec.ID_JSON))
+	if !stat.OK() {
+		return stat
+	}
+
+	confirm := new(handshakeMsg)
+	input2 := sess.PreReceive(func(header yrpc.Header) interface{} {
+		if header.Mtype() == yrpc.TypePush && header.ServiceMethod() == ConfirmServiceMethod {
+			return confirm
+		}
+		return nil
+	})
+	defer yrpc.PutMessage(input2)
+	if !input2.StatusOK() {
+		return input2.Status()
+	}
+	if input2.Mtype() != yrpc.TypePush || input2.ServiceMethod() != ConfirmServiceMethod {
+		return badMessage(fmt.Sprintf("handshake message expect: PUSH %s, but received: %s %s",
+			ConfirmServiceMethod, yrpc.TypeText(input2.Mtype()), input2.ServiceMethod()))
+	}
+	if !hmac.Equal(confirm.Confirm, keys.clientConfirm) {
+		return unauthorized("the client failed to confirm the handshake")
+	}
+	sess.ModifySocket(func(conn net.Conn) (net.Conn, yrpc.ProtoFunc) {
+		return newSecureConn(conn, keys.serverToClient, keys.clientToServer), nil
+	})
+	storeRemoteStatic(sess.Swap(), remoteStatic)
+	return nil
+}
+
+func (e *ecdhePlugin) parseRemote(m *handshakeMsg) (eph, static *ecdh.PublicKey, stat *yrpc.Status) {
+	if m.Version != Version {
+		return nil, nil, badMessage(fmt.Sprintf("unsupported handshake version: %d", m.Version))
+	}
+	eph, err := ecdh.X25519().NewPublicKey(m.Ephemeral)
+	if err != nil {
+		return nil, nil, badMessage("invalid ephemeral key: " + err.Error())
+	}
+	if len(m.Static) > 0 {
+		static, err = ecdh.X25519().NewPublicKey(m.Static)
+		if err != nil {
+			return nil, nil, badMessage("invalid static key: " + err.Error())
+		}
+	}
+	if len(e.cfg.PinnedKeys) == 0 {
+		return eph, static, nil
+	}
+	if static == nil {
+		return nil, nil, unauthorized("the remote static key is required")
+	}
+	for _, k := range e.cfg.PinnedKeys {
+		if k.Equal(static) {
+			return eph, static, nil
+		}
+	}
+	return nil, nil, unauthorized("the remote static key is not pinned")
+}
+
+func badMessage(cause string) *yrpc.Status {
+	return yrpc.NewStatus(yrpc.CodeBadMessage, yrpc.CodeText(yrpc.CodeBadMessage), cause)
+}
+
+func unauthorized(cause string) *yrpc.Status {
+	return yrpc.NewStatus(yrpc.CodeUnauthorized, yrpc.CodeText(yrpc.CodeUnauthorized), cause)
+}
+
+func storeRemoteStatic(swap goutil.Map, remoteStatic *ecdh.PublicKey) {
+	if remoteStatic != nil {
+		swap.Store(swapKey, remoteStatic)
+	}
+}
+
+type dhPair struct {
+	priv *ecdh.PrivateKey
+	pub  *ecdh.PublicKey
+}
+
+// agree performs the X25519 function on each pair, the pair with nil key is skipped.
+func agree(pairs ...dhPair) ([][]byte, error) {
+	var dh [][]byte
+	for _, p := range pairs {
+		if p.priv == nil || p.pub == nil {
+			continue
+		}
+		b, err := p.priv.ECDH(p.pub)
+		if err != nil {
+			return nil, err
+		}
+		dh = append(dh, b)
+	}
+	return dh, nil
+}
+
+type sessionKeys struct {
+	clientToServer []byte
+	serverToClient []byte
+	serverConfirm  []byte
+	clientConfirm  []byte
+}
+
+// deriveKeys derives the per-session keys from the shared secrets and the handshake transcript.
+func deriveKeys(dh [][]byte, req, rep *handshakeMsg) *sessionKeys {
+	h := sha256.New()
+	h.Write([]byte(label))
+	for _, b := range [][]byte{req.Ephemeral, req.Static, rep.Ephemeral, rep.Static} {
+		h.Write(binary.BigEndian.AppendUint32(nil, uint32(len(b))))
+		h.Write(b)
+	}
+	transcript := h.Sum(nil)
+	r := hkdf.New(sha256.New, bytes.Join(dh, nil), transcript, []byte(label))
+	var k [4][32]byte
+	for i := range k {
+		io.ReadFull(r, k[i][:])
+	}
+	return &sessionKeys{
+		clientToServer: k[0][:],
+		serverToClient: k[1][:],
+		serverConfirm:  mac(k[2][:], transcript),
+		clientConfirm:  mac(k[3][:], transcript),
+	}
+}
+
+func mac(key, data []byte) []byte {
+	m := hmac.New(sha256.New, key)
+	m.Write(data)
+	return m.Sum(nil)
+}
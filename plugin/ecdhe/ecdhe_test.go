@@ -0,0 +1,95 @@
+package ecdhe_test
+
+import (
+	"crypto/ecdh"
+	"encoding/hex"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/sqos/goutil"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/sqos/yrpc"
+	"github.com/sqos/yrpc/plugin/ecdhe"
+)
+
+type Home struct {
+	yrpc.CallCtx
+}
+
+func (h *Home) Test(arg *map[string]string) (map[string]string, *yrpc.Status) {
+	r := map[string]string{"author": (*arg)["author"]}
+	if k, ok := ecdhe.GetRemoteStaticKey(h.Session().Swap()); ok {
+		r["client_key"] = hex.EncodeToString(k.Bytes())
+	}
+	return r, nil
+}
+
+func mustGenerateKey(t *testing.T) *ecdh.PrivateKey {
+	k, err := ecdhe.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+func dial(t *testing.T, port int, srvCfg, cliCfg ecdhe.Config) (yrpc.Session, *yrpc.Status) {
+	srv := yrpc.NewPeer(yrpc.PeerConfig{ListenPort: uint16(port)}, ecdhe.NewPlugin(srvCfg))
+	srv.RouteCall(new(Home))
+	go srv.ListenAndServe()
+	time.Sleep(time.Second)
+	cli := yrpc.NewPeer(yrpc.PeerConfig{}, ecdhe.NewPlugin(cliCfg))
+	return cli.Dial(":" + strconv.Itoa(port))
+}
+
+//go:generate go test -v -c -o "${GOPACKAGE}" $GOFILE
+
+func TestECDHE(t *testing.T) {
+	if goutil.IsGoTest() {
+		t.Log("skip test in go test")
+		return
+	}
+	srvKey, cliKey := mustGenerateKey(t), mustGenerateKey(t)
+
+	// anonymous
+	sess, stat := dial(t, 9090, ecdhe.Config{}, ecdhe.Config{})
+	if !stat.OK() {
+		t.Fatal(stat)
+	}
+	var result map[string]string
+	for i := 0; i < 10; i++ {
+		stat = sess.Call("/home/test", map[string]string{"author": "andeya"}, &result).Status()
+		assert.True(t, stat.OK(), stat)
+		assert.Equal(t, "andeya", result["author"])
+	}
+
+	// mutual authentication with pinned keys
+	sess, stat = dial(t, 9091,
+		ecdhe.Config{StaticKey: srvKey, PinnedKeys: []*ecdh.PublicKey{cliKey.PublicKey()}},
+		ecdhe.Config{StaticKey: cliKey, PinnedKeys: []*ecdh.PublicKey{srvKey.PublicKey()}},
+	)
+	if !stat.OK() {
+		t.Fatal(stat)
+	}
+	k, ok := ecdhe.GetRemoteStaticKey(sess.Swap())
+	assert.True(t, ok)
+	assert.True(t, k.Equal(srvKey.PublicKey()))
+	stat = sess.Call("/home/test", map[string]string{"author": "andeya"}, &result).Status()
+	assert.True(t, stat.OK(), stat)
+	assert.Equal(t, hex.EncodeToString(cliKey.PublicKey().Bytes()), result["client_key"])
+
+	// the server key is not pinned
+	_, stat = dial(t, 9092,
+		ecdhe.Config{StaticKey: mustGenerateKey(t)},
+		ecdhe.Config{PinnedKeys: []*ecdh.PublicKey{srvKey.PublicKey()}},
+	)
+	assert.False(t, stat.OK())
+
+	// the client key is required
+	_, stat = dial(t, 9093,
+		ecdhe.Config{PinnedKeys: []*ecdh.PublicKey{cliKey.PublicKey()}},
+		ecdhe.Config{},
+	)
+	assert.False(t, stat.OK())
+}
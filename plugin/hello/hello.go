@@ -0,0 +1,277 @@
+// Package hello is a plugin that exchanges identity and capabilities when the session is built.
+//
+// Copyright 2024 sqos. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package hello
+
+import (
+	"fmt"
+
+	"github.com/sqos/goutil"
+
+	"github.com/sqos/yrpc"
+	"github.com/sqos/yrpc/codec"
+	"github.com/sqos/yrpc/socket"
+	"github.com/sqos/yrpc/xfer"
+)
+
+const (
+	// HelloServiceMethod hello service method
+	HelloServiceMethod = "/hello"
+	// Version is the version of the hello exchange.
+	Version = 1
+	swapKey = "hello_remote_"
+)
+
+type (
+	// Info is the identity and capabilities exchanged by the hello.
+	Info struct {
+		// Version is the version of the hello exchange.
+		Version int `json:"version"`
+		// AppName is the application name.
+		AppName string `json:"app_name"`
+		// AppVersion is the application build version.
+		AppVersion string `json:"app_version"`
+		// ProtoID is the socket protocol id, which is set by the plugin.
+		ProtoID byte `json:"proto_id"`
+		// ProtoName is the socket protocol name, which is set by the plugin.
+		ProtoName string `json:"proto_name"`
+		// Codecs is the supported body codec ids.
+		Codecs []byte `json:"codecs"`
+		// XferFilters is the supported transfer filter ids.
+		XferFilters []byte `json:"xfer_filters"`
+		// Extra is the custom information.
+		Extra map[string]string `json:"extra,omitempty"`
+	}
+	// Remote is the hello information of the remote peer.
+	Remote struct {
+		// Info is the identity and capabilities declared by the remote peer.
+		Info *Info
+		// Codecs is the intersection of the local and remote codec ids.
+		Codecs []byte
+		// XferFilters is the intersection of the local and remote transfer filter ids.
+		XferFilters []byte
+	}
+)
+
+// NewPlugin creates a hello plugin, which must be used on both sides.
+// NOTE:
+//
+//	If local.Codecs or local.XferFilters is nil, all registered ones are used;
+//	The client sends the hello in PostDial, and the server replies it in PostAccept;
+//	The peer whose socket protocol differs from the local one is rejected in the hello;
+//	The call or push that uses an unsupported codec or transfer filter fails fast.
+func NewPlugin(local Info) yrpc.Plugin {
+	return &helloPlugin{local: local}
+}
+
+type helloPlugin struct {
+	local Info
+}
+
+var (
+	_ yrpc.PostDialPlugin      = new(helloPlugin)
+	_ yrpc.PostAcceptPlugin    = new(helloPlugin)
+	_ yrpc.PreWriteCallPlugin  = new(helloPlugin)
+	_ yrpc.PreWritePushPlugin  = new(helloPlugin)
+	_ yrpc.PreWriteReplyPlugin = new(helloPlugin)
+)
+
+func (h *helloPlugin) Name() string {
+	return "hello"
+}
+
+func (h *helloPlugin) localInfo(sess yrpc.PreSession) *Info {
+	info := h.local
+	info.Version = Version
+	if v, ok := sess.(socket.ProtoVersioner); ok {
+		info.ProtoID, info.ProtoName = v.ProtoVersion()
+	}
+	if info.Codecs == nil {
+		info.Codecs = codec.IDs()
+	}
+	if info.XferFilters == nil {
+		info.XferFilters = xfer.IDs()
+	}
+	return &info
+}
+
+// PostDial sends the hello and receives the reply.
+func (h *helloPlugin) PostDial(sess yrpc.PreSession, _ bool) *yrpc.Status {
+	local := h.localInfo(sess)
+	remote := new(Info)
+	stat := sess.PreCall(HelloServiceMethod, local, remote, yrpc.WithBodyCodec(codec.ID_JSON))
+	if !stat.OK() {
+		return stat
+	}
+	if stat = checkProto(local, remote); !stat.OK() {
+		return stat
+	}
+	storeRemote(sess.Swap(), local, remote)
+	return nil
+}
+
+// PostAccept receives the hello and replies it.
+func (h *helloPlugin) PostAccept(sess yrpc.PreSession) *yrpc.Status {
+	remote := new(Info)
+	input := sess.PreReceive(func(header yrpc.Header) interface{} {
+		if header.Mtype() == yrpc.TypeCall && header.ServiceMethod() == HelloServiceMethod {
+			return remote
+		}
+		return nil
+	})
+	defer yrpc.PutMessage(input)
+	if !input.StatusOK() {
+		return input.Status()
+	}
+	if input.Mtype() != yrpc.TypeCall || input.ServiceMethod() != HelloServiceMethod {
+		stat := yrpc.NewStatus(
+			yrpc.CodeBadMessage,
+			yrpc.CodeText(yrpc.CodeBadMessage),
+			fmt.Sprintf("hello message expect: CALL %s, but received: %s %s",
+				HelloServiceMethod, yrpc.TypeText(input.Mtype()), input.ServiceMethod()),
+		)
+		sess.PreReply(input, nil, stat)
+		return stat
+	}
+	local := h.localInfo(sess)
+	if stat := checkProto(local, remote); !stat.OK() {
+		sess.PreReply(input, nil, stat)
+		return stat
+	}
+	stat := sess.PreReply(input, local, nil, yrpc.WithBodyCodec(codec.ID_JSON))
+	if !stat.OK() {
+		return stat
+	}
+	storeRemote(sess.Swap(), local, remote)
+	return nil
+}
+
+// PreWriteCall checks the codec and transfer filters of the call.
+func (h *helloPlugin) PreWriteCall(ctx yrpc.WriteCtx) *yrpc.Status {
+	return h.check(ctx)
+}
+
+// PreWritePush checks the codec and transfer filters of the push.
+func (h *helloPlugin) PreWritePush(ctx yrpc.WriteCtx) *yrpc.Status {
+	return h.check(ctx)
+}
+
+// PreWriteReply checks the codec and transfer filters of the reply,
+// and replaces the unsupported reply with the error status.
+func (h *helloPlugin) PreWriteReply(ctx yrpc.WriteCtx) *yrpc.Status {
+	stat := h.check(ctx)
+	if !stat.OK() {
+		// NOTE: The status returned by PreWriteReply does not stop the reply.
+		output := ctx.Output()
+		output.SetStatus(stat)
+		output.SetBody(nil)
+		output.SetBodyCodec(codec.NilCodecID)
+		output.XferPipe().Reset()
+	}
+	return stat
+}
+
+func (h *helloPlugin) check(ctx yrpc.WriteCtx) *yrpc.Status {
+	remote, ok := GetRemote(ctx.Session().Swap())
+	if !ok {
+		return nil
+	}
+	output := ctx.Output()
+	if id := output.BodyCodec(); id != codec.NilCodecID && !remote.SupportCodec(id) {
+		return yrpc.NewStatus(
+			yrpc.CodeUnsupportedCodecType,
+			yrpc.CodeText(yrpc.CodeUnsupportedCodecType),
+			fmt.Sprintf("body codec %q is not supported by the remote peer", id),
+		)
+	}
+	if id, ok := yrpc.GetAcceptBodyCodec(output.Meta()); ok && !remote.SupportCodec(id) {
+		return yrpc.NewStatus(
+			yrpc.CodeUnsupportedCodecType,
+			yrpc.CodeText(yrpc.CodeUnsupportedCodecType),
+			fmt.Sprintf("accept body codec %q is not supported by the remote peer", id),
+		)
+	}
+	for _, id := range output.XferPipe().IDs() {
+		if !remote.SupportXferFilter(id) {
+			return yrpc.NewStatus(
+				yrpc.CodeUnsupportedTx,
+				yrpc.CodeText(yrpc.CodeUnsupportedTx),
+				fmt.Sprintf("transfer filter %q is not supported by the remote peer", id),
+			)
+		}
+	}
+	return nil
+}
+
+// checkProto checks that the remote socket protocol is the same as the local one,
+// the remote that does not declare it is not checked.
+func checkProto(local, remote *Info) *yrpc.Status {
+	if remote.ProtoName == "" || (remote.ProtoID == local.ProtoID && remote.ProtoName == local.ProtoName) {
+		return nil
+	}
+	return yrpc.NewStatus(
+		yrpc.CodeBadMessage,
+		yrpc.CodeText(yrpc.CodeBadMessage),
+		fmt.Sprintf("socket protocol %q(%q) of the remote peer is incompatible with the local %q(%q)",
+			remote.ProtoName, remote.ProtoID, local.ProtoName, local.ProtoID),
+	)
+}
+
+func storeRemote(swap goutil.Map, local, remote *Info) {
+	swap.Store(swapKey, &Remote{
+		Info:        remote,
+		Codecs:      intersect(local.Codecs, remote.Codecs),
+		XferFilters: intersect(local.XferFilters, remote.XferFilters),
+	})
+}
+
+// GetRemote returns the hello information of the remote peer from the session swap.
+func GetRemote(swap goutil.Map) (*Remote, bool) {
+	v, ok := swap.Load(swapKey)
+	if !ok {
+		return nil, false
+	}
+	r, ok := v.(*Remote)
+	return r, ok
+}
+
+// SupportCodec returns whether the codec is supported by both sides.
+func (r *Remote) SupportCodec(id byte) bool {
+	return contains(r.Codecs, id)
+}
+
+// SupportXferFilter returns whether the transfer filter is supported by both sides.
+func (r *Remote) SupportXferFilter(id byte) bool {
+	return contains(r.XferFilters, id)
+}
+
+func intersect(a, b []byte) []byte {
+	r := make([]byte, 0, len(a))
+	for _, id := range a {
+		if contains(b, id) {
+			r = append(r, id)
+		}
+	}
+	return r
+}
+
+func contains(a []byte, id byte) bool {
+	for _, v := range a {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
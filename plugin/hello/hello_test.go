@@ -0,0 +1,132 @@
+package hello_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sqos/goutil"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/sqos/yrpc"
+	"github.com/sqos/yrpc/codec"
+	"github.com/sqos/yrpc/plugin/hello"
+	"github.com/sqos/yrpc/socket"
+	"github.com/sqos/yrpc/xfer/gzip"
+	"github.com/sqos/yrpc/yrpctest"
+)
+
+type Home struct {
+	yrpc.CallCtx
+}
+
+func (h *Home) Test(arg *map[string]string) (map[string]string, *yrpc.Status) {
+	remote, _ := hello.GetRemote(h.Session().Swap())
+	return map[string]string{"client": remote.Info.AppName}, nil
+}
+
+//go:generate go test -v -c -o "${GOPACKAGE}" $GOFILE
+
+func TestHello(t *testing.T) {
+	if goutil.IsGoTest() {
+		t.Log("skip test in go test")
+		return
+	}
+	gzip.Reg('g', "gzip", 5)
+
+	srv := yrpc.NewPeer(
+		yrpc.PeerConfig{ListenPort: 9090},
+		hello.NewPlugin(hello.Info{
+			AppName:     "server",
+			AppVersion:  "v1.0.0",
+			Codecs:      []byte{codec.ID_JSON, codec.ID_PROTOBUF},
+			XferFilters: []byte{},
+		}),
+	)
+	srv.RouteCall(new(Home))
+	go srv.ListenAndServe()
+	time.Sleep(time.Second)
+
+	cli := yrpc.NewPeer(
+		yrpc.PeerConfig{},
+		hello.NewPlugin(hello.Info{AppName: "client", AppVersion: "v0.1.0"}),
+	)
+	sess, stat := cli.Dial(":9090")
+	if !stat.OK() {
+		t.Fatal(stat)
+	}
+	remote, ok := hello.GetRemote(sess.Swap())
+	if !assert.True(t, ok) {
+		return
+	}
+	assert.Equal(t, "server", remote.Info.AppName)
+	assert.Equal(t, "v1.0.0", remote.Info.AppVersion)
+	assert.Equal(t, []byte{codec.ID_JSON, codec.ID_PROTOBUF}, remote.Codecs)
+	assert.Empty(t, remote.XferFilters)
+
+	var result map[string]string
+	stat = sess.Call("/home/test", map[string]string{}, &result).Status()
+	assert.True(t, stat.OK(), stat)
+	assert.Equal(t, "client", result["client"])
+
+	stat = sess.Call("/home/test", map[string]string{}, &result, yrpc.WithXferPipe('g')).Status()
+	assert.Equal(t, yrpc.CodeUnsupportedTx, stat.Code())
+
+	stat = sess.Call("/home/test", map[string]string{}, &result, yrpc.WithBodyCodec(codec.ID_XML)).Status()
+	assert.Equal(t, yrpc.CodeUnsupportedCodecType, stat.Code())
+
+	stat = sess.Push("/home/push", nil, yrpc.WithBodyCodec(codec.ID_MSGPACK))
+	assert.Equal(t, yrpc.CodeUnsupportedCodecType, stat.Code())
+}
+
+type Reply struct {
+	yrpc.CallCtx
+}
+
+func (r *Reply) Xml(arg *map[string]string) (map[string]string, *yrpc.Status) {
+	r.SetBodyCodec(codec.ID_XML)
+	return *arg, nil
+}
+
+func TestHelloReply(t *testing.T) {
+	p := yrpctest.NewPair(t, yrpctest.PairConfig{
+		ServerPlugins: []yrpc.Plugin{hello.NewPlugin(hello.Info{AppName: "server"})},
+		ClientPlugins: []yrpc.Plugin{hello.NewPlugin(hello.Info{
+			AppName: "client",
+			Codecs:  []byte{codec.ID_JSON},
+		})},
+	})
+	p.Server.RouteCall(new(Reply))
+	sess := p.Dial()
+
+	var result map[string]string
+	stat := sess.Call("/reply/xml", map[string]string{}, &result).Status()
+	assert.Equal(t, yrpc.CodeUnsupportedCodecType, stat.Code())
+}
+
+// nextProto is the raw protocol that declares another version.
+type nextProto struct {
+	socket.Proto
+}
+
+func (nextProto) Version() (byte, string) {
+	return 7, "raw"
+}
+
+func TestHelloProto(t *testing.T) {
+	srv, addr := yrpctest.NewServer(t, yrpc.PeerConfig{}, hello.NewPlugin(hello.Info{AppName: "server"}))
+	srv.RouteCall(new(Home))
+	cli := yrpctest.NewClient(t, yrpc.PeerConfig{}, hello.NewPlugin(hello.Info{AppName: "client"}))
+
+	sess := yrpctest.Dial(t, cli, addr)
+	remote, ok := hello.GetRemote(sess.Swap())
+	if assert.True(t, ok) {
+		assert.Equal(t, byte(6), remote.Info.ProtoID)
+		assert.Equal(t, "raw", remote.Info.ProtoName)
+	}
+
+	_, stat := cli.Dial(addr, func(rw yrpc.IOWithReadBuffer) yrpc.Proto {
+		return nextProto{socket.RawProtoFunc(rw)}
+	})
+	assert.Equal(t, yrpc.CodeDialFailed, stat.Code())
+	assert.Contains(t, stat.Cause().Error(), "incompatible")
+}
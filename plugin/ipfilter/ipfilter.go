@@ -0,0 +1,357 @@
+// Package ipfilter is a plugin that allows or denies the remote IPs by CIDR rules.
+//
+// Copyright 2024 sqos. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package ipfilter
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/sqos/yrpc"
+	"github.com/sqos/yrpc/utils"
+)
+
+type (
+	// Rules the IP filter rules.
+	// NOTE:
+	//
+	//	The entries are CIDRs or single IPs, e.g. "10.0.0.0/8", "192.168.1.1", "fd00::/8";
+	//	A matched Deny entry overrides Allow;
+	//	If Allow is not empty, the IP that matches no Allow entry is denied.
+	Rules struct {
+		// Allow is the allowed CIDRs, empty means all.
+		Allow []string
+		// Deny is the denied CIDRs.
+		Deny []string
+		// TrustedProxies is the CIDRs of the proxies whose X-Real-IP metadata is trusted.
+		// The connection from a trusted proxy is accepted, and each message is checked by its X-Real-IP.
+		TrustedProxies []string
+		// Listeners is the local listen addresses that the rules apply to, empty means all,
+		// e.g. ":9090" matches any local IP with port 9090.
+		Listeners []string
+	}
+	// Stats the IP filter counters
+	Stats struct {
+		AllowedConns    uint64
+		BlockedConns    uint64
+		AllowedMessages uint64
+		BlockedMessages uint64
+	}
+	// Block is the report of a blocked attempt.
+	Block struct {
+		// IP is the blocked IP, it is the X-Real-IP if the remote is a trusted proxy.
+		IP string
+		// RemoteAddr is the remote address of the connection.
+		RemoteAddr net.Addr
+		// ServiceMethod is the service method of the message, empty for the connection.
+		ServiceMethod string
+		// Reason is the text explanation.
+		Reason string
+	}
+)
+
+// Filter is an IP allow/deny list plugin.
+// NOTE:
+//
+//	Registered on the peer, it checks the accepted connections and every message;
+//	Use RoutePlugin for router.SubRoute, which only checks the messages.
+type Filter struct {
+	rules   atomic.Pointer[compiledRules]
+	onBlock func(*Block)
+	stats   struct {
+		allowedConns, blockedConns, allowedMessages, blockedMessages atomic.Uint64
+	}
+}
+
+var (
+	_ yrpc.PostAcceptPlugin      = (*Filter)(nil)
+	_ yrpc.PreReadCallBodyPlugin = (*Filter)(nil)
+	_ yrpc.PreReadPushBodyPlugin = (*Filter)(nil)
+)
+
+// New creates an IP filter plugin, returns error if the rules are invalid.
+// NOTE: If onBlock is not nil, it is called synchronously for every blocked attempt.
+func New(rules Rules, onBlock ...func(*Block)) (*Filter, error) {
+	f := new(Filter)
+	if len(onBlock) > 0 {
+		f.onBlock = onBlock[0]
+	}
+	if err := f.Update(rules); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Name returns the plugin name.
+func (f *Filter) Name() string {
+	return "ipfilter"
+}
+
+// Rules returns the current rules.
+func (f *Filter) Rules() Rules {
+	return f.rules.Load().Rules
+}
+
+// Update replaces the rules at runtime, the next connections and messages are checked by the new ones.
+// NOTE: If the rules are invalid, the current rules are kept.
+func (f *Filter) Update(rules Rules) error {
+	c := &compiledRules{Rules: rules}
+	var err error
+	if c.allow, err = parsePrefixes(rules.Allow); err != nil {
+		return err
+	}
+	if c.deny, err = parsePrefixes(rules.Deny); err != nil {
+		return err
+	}
+	if c.trustedProxies, err = parsePrefixes(rules.TrustedProxies); err != nil {
+		return err
+	}
+	for _, addr := range rules.Listeners {
+		ap, err := parseListener(addr)
+		if err != nil {
+			return err
+		}
+		c.listeners = append(c.listeners, ap)
+	}
+	f.rules.Store(c)
+	return nil
+}
+
+// Stats returns the counters.
+func (f *Filter) Stats() Stats {
+	return Stats{
+		AllowedConns:    f.stats.allowedConns.Load(),
+		BlockedConns:    f.stats.blockedConns.Load(),
+		AllowedMessages: f.stats.allowedMessages.Load(),
+		BlockedMessages: f.stats.blockedMessages.Load(),
+	}
+}
+
+// Allowed reports whether the IP is allowed by the current rules.
+func (f *Filter) Allowed(ip string) bool {
+	_, ok := f.rules.Load().check(ip)
+	return ok
+}
+
+// PostAccept checks the remote IP of the accepted connection.
+func (f *Filter) PostAccept(sess yrpc.PreSession) *yrpc.Status {
+	c := f.rules.Load()
+	if !c.matchListener(sess.LocalAddr()) {
+		return nil
+	}
+	remoteIP := utils.HostOf(sess.RemoteAddr().String())
+	if c.isTrustedProxy(remoteIP) {
+		f.stats.allowedConns.Add(1)
+		return nil
+	}
+	reason, ok := c.check(remoteIP)
+	if ok {
+		f.stats.allowedConns.Add(1)
+		return nil
+	}
+	f.stats.blockedConns.Add(1)
+	return f.block(&Block{IP: remoteIP, RemoteAddr: sess.RemoteAddr(), Reason: reason})
+}
+
+// PreReadCallBody checks the remote IP of the CALL message.
+func (f *Filter) PreReadCallBody(ctx yrpc.ReadCtx) *yrpc.Status {
+	return f.checkMessage(ctx)
+}
+
+// PreReadPushBody checks the remote IP of the PUSH message.
+func (f *Filter) PreReadPushBody(ctx yrpc.ReadCtx) *yrpc.Status {
+	return f.checkMessage(ctx)
+}
+
+// RoutePlugin returns the plugin for router.SubRoute, which shares the rules and counters with f.
+func (f *Filter) RoutePlugin() yrpc.Plugin {
+	return &routePlugin{f}
+}
+
+func (f *Filter) checkMessage(ctx yrpc.ReadCtx) *yrpc.Status {
+	c := f.rules.Load()
+	sess := ctx.Session()
+	if !c.matchListener(sess.LocalAddr()) {
+		return nil
+	}
+	ip := utils.HostOf(sess.RemoteAddr().String())
+	if c.isTrustedProxy(ip) {
+		if realIP := ctx.PeekMeta(yrpc.MetaRealIP); len(realIP) > 0 {
+			ip = utils.HostOf(string(realIP))
+		}
+	}
+	reason, ok := c.check(ip)
+	if ok {
+		f.stats.allowedMessages.Add(1)
+		return nil
+	}
+	f.stats.blockedMessages.Add(1)
+	return f.block(&Block{
+		IP:            ip,
+		RemoteAddr:    sess.RemoteAddr(),
+		ServiceMethod: ctx.ServiceMethod(),
+		Reason:        reason,
+	})
+}
+
+func (f *Filter) block(b *Block) *yrpc.Status {
+	if f.onBlock != nil {
+		f.onBlock(b)
+	}
+	return yrpc.NewStatus(yrpc.CodeForbidden, yrpc.CodeText(yrpc.CodeForbidden), b.Reason)
+}
+
+type routePlugin struct {
+	f *Filter
+}
+
+var (
+	_ yrpc.PreReadCallBodyPlugin = (*routePlugin)(nil)
+	_ yrpc.PreReadPushBodyPlugin = (*routePlugin)(nil)
+)
+
+func (r *routePlugin) Name() string {
+	return "ipfilter"
+}
+
+func (r *routePlugin) PreReadCallBody(ctx yrpc.ReadCtx) *yrpc.Status {
+	return r.f.checkMessage(ctx)
+}
+
+func (r *routePlugin) PreReadPushBody(ctx yrpc.ReadCtx) *yrpc.Status {
+	return r.f.checkMessage(ctx)
+}
+
+type compiledRules struct {
+	Rules
+	allow, deny, trustedProxies []netip.Prefix
+	listeners                   []netip.AddrPort
+}
+
+// check returns whether the IP is allowed, and the reason if not.
+func (c *compiledRules) check(ip string) (string, bool) {
+	addr, err := parseIP(ip)
+	if err != nil {
+		return fmt.Sprintf("invalid IP %q", ip), false
+	}
+	if p, ok := matchPrefixes(c.deny, addr); ok {
+		return fmt.Sprintf("%s is denied by %s", ip, p), false
+	}
+	if len(c.allow) == 0 {
+		return "", true
+	}
+	if _, ok := matchPrefixes(c.allow, addr); ok {
+		return "", true
+	}
+	return fmt.Sprintf("%s is not in the allow list", ip), false
+}
+
+func (c *compiledRules) isTrustedProxy(ip string) bool {
+	addr, err := parseIP(ip)
+	if err != nil {
+		return false
+	}
+	_, ok := matchPrefixes(c.trustedProxies, addr)
+	return ok
+}
+
+func (c *compiledRules) matchListener(localAddr net.Addr) bool {
+	if len(c.listeners) == 0 {
+		return true
+	}
+	ap, err := netip.ParseAddrPort(localAddr.String())
+	if err != nil {
+		return false
+	}
+	for _, l := range c.listeners {
+		if l.Port() == ap.Port() && (!l.Addr().IsValid() || l.Addr() == ap.Addr().Unmap()) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchPrefixes(prefixes []netip.Prefix, addr netip.Addr) (netip.Prefix, bool) {
+	for _, p := range prefixes {
+		if p.Contains(addr) {
+			return p, true
+		}
+	}
+	return netip.Prefix{}, false
+}
+
+func parsePrefixes(entries []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(entries))
+	for _, s := range entries {
+		s = strings.TrimSpace(s)
+		if strings.Contains(s, "/") {
+			p, err := netip.ParsePrefix(s)
+			if err != nil {
+				return nil, fmt.Errorf("ipfilter: invalid CIDR %q: %v", s, err)
+			}
+			if p.Addr().Is4In6() {
+				if p.Bits() < 96 {
+					return nil, fmt.Errorf("ipfilter: invalid CIDR %q: IPv4-mapped prefix shorter than /96", s)
+				}
+				p = netip.PrefixFrom(p.Addr().Unmap(), p.Bits()-96)
+			}
+			prefixes = append(prefixes, p.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(s)
+		if err != nil {
+			return nil, fmt.Errorf("ipfilter: invalid IP %q: %v", s, err)
+		}
+		addr = addr.Unmap()
+		prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return prefixes, nil
+}
+
+func parseListener(addr string) (netip.AddrPort, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return netip.AddrPort{}, fmt.Errorf("ipfilter: invalid listener %q: %v", addr, err)
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return netip.AddrPort{}, fmt.Errorf("ipfilter: invalid listener %q: %v", addr, err)
+	}
+	var ip netip.Addr
+	if host != "" {
+		if ip, err = netip.ParseAddr(host); err != nil {
+			return netip.AddrPort{}, fmt.Errorf("ipfilter: invalid listener %q: %v", addr, err)
+		}
+		if ip.IsUnspecified() {
+			// any local IP
+			ip = netip.Addr{}
+		} else {
+			ip = ip.Unmap()
+		}
+	}
+	return netip.AddrPortFrom(ip, uint16(port)), nil
+}
+
+func parseIP(ip string) (netip.Addr, error) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return addr, err
+	}
+	return addr.Unmap().WithZone(""), nil
+}
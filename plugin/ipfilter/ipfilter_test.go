@@ -0,0 +1,142 @@
+package ipfilter_test
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/sqos/yrpc"
+	"github.com/sqos/yrpc/plugin/ipfilter"
+	"github.com/sqos/yrpc/yrpctest"
+)
+
+type Home struct {
+	yrpc.CallCtx
+}
+
+func (h *Home) Ping(*struct{}) (string, *yrpc.Status) {
+	return "pong", nil
+}
+
+// dial dials the server from the local address, which is the remote address of the server-side session.
+func dial(t *testing.T, addr, ip string, port uint16) yrpc.Session {
+	cli := yrpctest.NewClient(t, yrpc.PeerConfig{LocalIP: ip, LocalPort: port})
+	return yrpctest.Dial(t, cli, addr)
+}
+
+// assertClosed asserts that the session is closed by the server.
+func assertClosed(t *testing.T, sess yrpc.Session) {
+	assert.Eventually(t, func() bool { return !sess.Health() }, time.Second, 10*time.Millisecond)
+}
+
+func TestRules(t *testing.T) {
+	f, err := ipfilter.New(ipfilter.Rules{
+		Allow: []string{"10.0.0.0/8", "::ffff:192.168.1.1", "fd00::/8"},
+		Deny:  []string{"10.1.0.0/16"},
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.True(t, f.Allowed("10.0.0.1"))
+	assert.False(t, f.Allowed("10.1.2.3"))
+	assert.True(t, f.Allowed("192.168.1.1"))
+	assert.True(t, f.Allowed("::ffff:10.0.0.1"))
+	assert.True(t, f.Allowed("fd00::1"))
+	assert.False(t, f.Allowed("192.168.1.2"))
+	assert.False(t, f.Allowed("bad"))
+
+	// invalid rules are not applied
+	assert.Error(t, f.Update(ipfilter.Rules{Deny: []string{"10.0.0.0/33"}}))
+	assert.Error(t, f.Update(ipfilter.Rules{Deny: []string{"::ffff:0:0/90"}}))
+	assert.Error(t, f.Update(ipfilter.Rules{Listeners: []string{"9090"}}))
+	assert.True(t, f.Allowed("10.0.0.1"))
+}
+
+func TestFilter(t *testing.T) {
+	var (
+		blocks   []*ipfilter.Block
+		blocksMu sync.Mutex
+	)
+	f, err := ipfilter.New(ipfilter.Rules{}, func(b *ipfilter.Block) {
+		blocksMu.Lock()
+		blocks = append(blocks, b)
+		blocksMu.Unlock()
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	srv, addr := yrpctest.NewServer(t, yrpc.PeerConfig{}, f)
+	srv.RouteCall(new(Home))
+	other, otherAddr := yrpctest.NewServer(t, yrpc.PeerConfig{}, f)
+	other.RouteCall(new(Home))
+	_, port, _ := net.SplitHostPort(addr)
+	assert.NoError(t, f.Update(ipfilter.Rules{
+		Deny:           []string{"1.1.1.0/24"},
+		TrustedProxies: []string{"10.0.0.1"},
+		Listeners:      []string{":" + port},
+	}))
+
+	// denied connection
+	assertClosed(t, dial(t, addr, "1.1.1.1", 1000))
+
+	// other listeners are not filtered
+	var result string
+	sess := dial(t, otherAddr, "1.1.1.1", 1000)
+	assert.True(t, sess.Call("/home/ping", nil, &result).Status().OK())
+
+	// the X-Real-IP from the trusted proxy
+	sess = dial(t, addr, "10.0.0.1", 1000)
+	assert.True(t, sess.Call("/home/ping", nil, &result).Status().OK())
+	assert.True(t, sess.Call("/home/ping", nil, &result, yrpc.WithSetMeta(yrpc.MetaRealIP, "2.2.2.2")).Status().OK())
+	stat := sess.Call("/home/ping", nil, &result, yrpc.WithSetMeta(yrpc.MetaRealIP, "1.1.1.2")).Status()
+	assert.Equal(t, yrpc.CodeForbidden, stat.Code())
+
+	// the X-Real-IP from the untrusted client is ignored
+	sess = dial(t, addr, "2.2.2.2", 1000)
+	assert.True(t, sess.Call("/home/ping", nil, &result, yrpc.WithSetMeta(yrpc.MetaRealIP, "1.1.1.2")).Status().OK())
+
+	// hot-swap the rules, the established session is checked by the new ones
+	assert.NoError(t, f.Update(ipfilter.Rules{Deny: []string{"2.2.2.0/24"}}))
+	stat = sess.Call("/home/ping", nil, &result).Status()
+	assert.Equal(t, yrpc.CodeForbidden, stat.Code())
+
+	assert.Equal(t, ipfilter.Stats{
+		AllowedConns:    2,
+		BlockedConns:    1,
+		AllowedMessages: 3,
+		BlockedMessages: 2,
+	}, f.Stats())
+	blocksMu.Lock()
+	defer blocksMu.Unlock()
+	if assert.Len(t, blocks, 3) {
+		assert.Equal(t, "1.1.1.1", blocks[0].IP)
+		assert.Equal(t, "", blocks[0].ServiceMethod)
+		assert.Equal(t, "1.1.1.2", blocks[1].IP)
+		assert.Equal(t, "10.0.0.1:1000", blocks[1].RemoteAddr.String())
+		assert.Equal(t, "/home/ping", blocks[2].ServiceMethod)
+	}
+}
+
+func TestRoutePlugin(t *testing.T) {
+	f, err := ipfilter.New(ipfilter.Rules{Allow: []string{"10.0.0.0/8"}})
+	if !assert.NoError(t, err) {
+		return
+	}
+	srv, addr := yrpctest.NewServer(t, yrpc.PeerConfig{})
+	srv.RouteCall(new(Home))
+	srv.SubRoute("/internal", f.RoutePlugin()).RouteCall(new(Home))
+
+	sess := dial(t, addr, "1.1.1.1", 1000)
+	var result string
+	assert.True(t, sess.Call("/home/ping", nil, &result).Status().OK())
+	stat := sess.Call("/internal/home/ping", nil, &result).Status()
+	assert.Equal(t, yrpc.CodeForbidden, stat.Code())
+
+	sess = dial(t, addr, "10.0.0.2", 1000)
+	assert.True(t, sess.Call("/internal/home/ping", nil, &result).Status().OK())
+	assert.Equal(t, uint64(0), f.Stats().AllowedConns)
+	assert.Equal(t, uint64(1), f.Stats().BlockedMessages)
+}
@@ -0,0 +1,261 @@
+package overloader
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sqos/yrpc"
+)
+
+// AdaptiveAlgorithm the algorithm that adjusts the concurrency limit
+type AdaptiveAlgorithm int8
+
+// The adaptive concurrency limit algorithms
+const (
+	// AIMD increases the limit by 1 for each successful sample, and multiplies it by BackoffRatio for each drop.
+	AIMD AdaptiveAlgorithm = iota
+	// Gradient adjusts the limit by the ratio of the long-term average latency to the current latency.
+	Gradient
+	// Vegas estimates the queue size by the minimum latency, and keeps it between alpha and beta.
+	Vegas
+)
+
+type (
+	// AdaptiveConfig adaptive concurrency limitation condition
+	AdaptiveConfig struct {
+		// Algorithm is the algorithm to adjust the limit, default AIMD.
+		Algorithm AdaptiveAlgorithm
+		// InitialLimit is the initial in-flight calls limit, default 20.
+		InitialLimit int
+		// MinLimit is the minimum limit, default 1.
+		MinLimit int
+		// MaxLimit is the maximum limit, default 1000.
+		MaxLimit int
+		// Timeout is the latency above which the call is treated as a drop, zero means never.
+		// NOTE: The call replied with yrpc.CodeHandleTimeout is always treated as a drop.
+		Timeout time.Duration
+		// BackoffRatio is the AIMD decrease ratio for a drop, default 0.9.
+		BackoffRatio float64
+		// Smoothing is the Gradient smoothing factor in (0,1], default 0.2.
+		Smoothing float64
+		// OnStats is called when the limit changes and when a call is rejected.
+		OnStats func(AdaptiveStats)
+	}
+	// AdaptiveStats the statistics of the adaptive limiter
+	AdaptiveStats struct {
+		// Limit is the current in-flight calls limit.
+		Limit int
+		// InFlight is the current in-flight calls.
+		InFlight int
+		// Rejected is the total rejected calls.
+		Rejected uint64
+		// RTT is the latency of the last sample.
+		RTT time.Duration
+	}
+	// AdaptiveLimiter plug-in to limit the in-flight calls adaptively by the handler latency
+	AdaptiveLimiter struct {
+		cfg      AdaptiveConfig
+		inFlight int64
+		rejected uint64
+		mu       sync.Mutex
+		limit    float64
+		lastRTT  time.Duration
+		// Gradient: the long-term average latency
+		longRTT float64
+		// Vegas: the minimum latency
+		minRTT time.Duration
+	}
+)
+
+var (
+	_ yrpc.PostReadCallHeaderPlugin = (*AdaptiveLimiter)(nil)
+	_ yrpc.PostReadCallBodyPlugin   = (*AdaptiveLimiter)(nil)
+	_ yrpc.PreWriteReplyPlugin      = (*AdaptiveLimiter)(nil)
+)
+
+// adaptiveStartKey is the context swap key of the call start time.
+const adaptiveStartKey = "overloader_adaptive_start_"
+
+// NewAdaptiveLimiter creates a plug-in to limit the in-flight calls adaptively.
+// NOTE: It releases the call in PreWriteReply, so it should be registered before other PreWriteReply plugins.
+func NewAdaptiveLimiter(cfg AdaptiveConfig) *AdaptiveLimiter {
+	if cfg.MinLimit <= 0 {
+		cfg.MinLimit = 1
+	}
+	if cfg.MaxLimit <= 0 {
+		cfg.MaxLimit = 1000
+	}
+	if cfg.MaxLimit < cfg.MinLimit {
+		cfg.MaxLimit = cfg.MinLimit
+	}
+	if cfg.InitialLimit <= 0 {
+		cfg.InitialLimit = 20
+	}
+	if cfg.BackoffRatio <= 0 || cfg.BackoffRatio >= 1 {
+		cfg.BackoffRatio = 0.9
+	}
+	if cfg.Smoothing <= 0 || cfg.Smoothing > 1 {
+		cfg.Smoothing = 0.2
+	}
+	a := &AdaptiveLimiter{cfg: cfg}
+	a.limit = a.clamp(float64(cfg.InitialLimit))
+	return a
+}
+
+// Name returns the plugin name.
+func (a *AdaptiveLimiter) Name() string {
+	return "adaptive-limiter"
+}
+
+// Stats returns the current statistics.
+func (a *AdaptiveLimiter) Stats() AdaptiveStats {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.stats()
+}
+
+func (a *AdaptiveLimiter) stats() AdaptiveStats {
+	return AdaptiveStats{
+		Limit:    int(a.limit),
+		InFlight: int(atomic.LoadInt64(&a.inFlight)),
+		Rejected: atomic.LoadUint64(&a.rejected),
+		RTT:      a.lastRTT,
+	}
+}
+
+// PostReadCallHeader rejects the call early if the in-flight calls reach the limit.
+func (a *AdaptiveLimiter) PostReadCallHeader(_ yrpc.ReadCtx) *yrpc.Status {
+	limit := a.currentLimit()
+	if atomic.LoadInt64(&a.inFlight) >= limit {
+		return a.reject(limit)
+	}
+	return nil
+}
+
+// PostReadCallBody takes an in-flight slot of the call.
+// NOTE: The slot is taken after the whole message is read, so that it is always released by PreWriteReply.
+func (a *AdaptiveLimiter) PostReadCallBody(ctx yrpc.ReadCtx) *yrpc.Status {
+	limit := a.currentLimit()
+	if atomic.AddInt64(&a.inFlight, 1) > limit {
+		atomic.AddInt64(&a.inFlight, -1)
+		return a.reject(limit)
+	}
+	ctx.Swap().Store(adaptiveStartKey, time.Now())
+	return nil
+}
+
+func (a *AdaptiveLimiter) currentLimit() int64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return int64(a.limit)
+}
+
+func (a *AdaptiveLimiter) reject(limit int64) *yrpc.Status {
+	atomic.AddUint64(&a.rejected, 1)
+	if a.cfg.OnStats != nil {
+		a.cfg.OnStats(a.Stats())
+	}
+	return yrpc.NewStatus(yrpc.CodeServiceUnavailable, fmt.Sprintf("concurrency overload, limit=%d", limit), nil)
+}
+
+// PreWriteReply releases the call and samples its latency.
+func (a *AdaptiveLimiter) PreWriteReply(ctx yrpc.WriteCtx) *yrpc.Status {
+	v, ok := ctx.Swap().Load(adaptiveStartKey)
+	if !ok {
+		return nil
+	}
+	ctx.Swap().Delete(adaptiveStartKey)
+	rtt := time.Since(v.(time.Time))
+	inFlight := atomic.AddInt64(&a.inFlight, -1) + 1
+	dropped := ctx.Status().Code() == yrpc.CodeHandleTimeout ||
+		(a.cfg.Timeout > 0 && rtt > a.cfg.Timeout)
+	a.sample(rtt, int(inFlight), dropped)
+	return nil
+}
+
+func (a *AdaptiveLimiter) sample(rtt time.Duration, inFlight int, dropped bool) {
+	a.mu.Lock()
+	old := int(a.limit)
+	a.lastRTT = rtt
+	switch a.cfg.Algorithm {
+	case Gradient:
+		a.limit = a.clamp(a.gradient(rtt, inFlight, dropped))
+	case Vegas:
+		a.limit = a.clamp(a.vegas(rtt, inFlight, dropped))
+	default:
+		a.limit = a.clamp(a.aimd(inFlight, dropped))
+	}
+	changed := int(a.limit) != old
+	var stats AdaptiveStats
+	if changed {
+		stats = a.stats()
+	}
+	a.mu.Unlock()
+	if changed && a.cfg.OnStats != nil {
+		a.cfg.OnStats(stats)
+	}
+}
+
+func (a *AdaptiveLimiter) aimd(inFlight int, dropped bool) float64 {
+	if dropped {
+		return a.limit * a.cfg.BackoffRatio
+	}
+	// only increase when the limit is actually used
+	if float64(inFlight)*2 >= a.limit {
+		return a.limit + 1
+	}
+	return a.limit
+}
+
+func (a *AdaptiveLimiter) gradient(rtt time.Duration, inFlight int, dropped bool) float64 {
+	short := float64(rtt)
+	if a.longRTT == 0 {
+		a.longRTT = short
+	} else {
+		// the long-term exponential moving average over about 100 samples
+		a.longRTT = a.longRTT*0.99 + short*0.01
+	}
+	if dropped {
+		return a.limit * a.cfg.BackoffRatio
+	}
+	const tolerance = 1.5
+	gradient := 1.0
+	if short > 0 {
+		gradient = math.Max(0.5, math.Min(1, tolerance*a.longRTT/short))
+	}
+	var queueSize float64
+	if float64(inFlight)*2 >= a.limit {
+		queueSize = math.Sqrt(a.limit)
+	}
+	newLimit := a.limit*gradient + queueSize
+	return a.limit*(1-a.cfg.Smoothing) + newLimit*a.cfg.Smoothing
+}
+
+func (a *AdaptiveLimiter) vegas(rtt time.Duration, inFlight int, dropped bool) float64 {
+	if a.minRTT == 0 || rtt < a.minRTT {
+		a.minRTT = rtt
+	}
+	step := math.Max(1, math.Log10(a.limit))
+	if dropped {
+		return a.limit - step
+	}
+	if rtt <= 0 || float64(inFlight)*2 < a.limit {
+		return a.limit
+	}
+	queue := a.limit * (1 - float64(a.minRTT)/float64(rtt))
+	alpha, beta := 3*step, 6*step
+	switch {
+	case queue < alpha:
+		return a.limit + step
+	case queue > beta:
+		return a.limit - step
+	}
+	return a.limit
+}
+
+func (a *AdaptiveLimiter) clamp(limit float64) float64 {
+	return math.Max(float64(a.cfg.MinLimit), math.Min(float64(a.cfg.MaxLimit), limit))
+}
@@ -0,0 +1,112 @@
+package overloader
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/sqos/yrpc"
+	"github.com/sqos/yrpc/yrpctest"
+)
+
+func TestAdaptiveAIMD(t *testing.T) {
+	var stats []AdaptiveStats
+	a := NewAdaptiveLimiter(AdaptiveConfig{
+		InitialLimit: 10,
+		MaxLimit:     12,
+		Timeout:      100 * time.Millisecond,
+		OnStats:      func(s AdaptiveStats) { stats = append(stats, s) },
+	})
+	// not increased if the limit is not used
+	a.sample(time.Millisecond, 1, false)
+	assert.Equal(t, 10, a.Stats().Limit)
+	a.sample(time.Millisecond, 5, false)
+	a.sample(time.Millisecond, 6, false)
+	a.sample(time.Millisecond, 6, false)
+	assert.Equal(t, 12, a.Stats().Limit)
+	a.sample(time.Millisecond, 12, true)
+	assert.Equal(t, 10, a.Stats().Limit)
+	if assert.Len(t, stats, 3) {
+		assert.Equal(t, 10, stats[2].Limit)
+		assert.Equal(t, time.Millisecond, stats[2].RTT)
+	}
+	for i := 0; i < 100; i++ {
+		a.sample(time.Second, 10, true)
+	}
+	assert.Equal(t, 1, a.Stats().Limit)
+}
+
+func TestAdaptiveGradient(t *testing.T) {
+	a := NewAdaptiveLimiter(AdaptiveConfig{
+		Algorithm:    Gradient,
+		InitialLimit: 20,
+		MaxLimit:     100,
+	})
+	// stable latency: grows
+	for i := 0; i < 20; i++ {
+		a.sample(10*time.Millisecond, a.Stats().Limit, false)
+	}
+	grown := a.Stats().Limit
+	assert.True(t, grown > 20, grown)
+	// latency increases: shrinks
+	for i := 0; i < 20; i++ {
+		a.sample(100*time.Millisecond, a.Stats().Limit, false)
+	}
+	assert.True(t, a.Stats().Limit < grown, a.Stats().Limit)
+}
+
+func TestAdaptiveVegas(t *testing.T) {
+	a := NewAdaptiveLimiter(AdaptiveConfig{
+		Algorithm:    Vegas,
+		InitialLimit: 6,
+	})
+	// no queueing: grows
+	a.sample(10*time.Millisecond, 6, false)
+	a.sample(10*time.Millisecond, 7, false)
+	assert.Equal(t, 8, a.Stats().Limit)
+	// queue = 8 * (1 - 10/100) = 7.2 > beta: shrinks
+	a.sample(100*time.Millisecond, 8, false)
+	assert.Equal(t, 7, a.Stats().Limit)
+	// queue = 7 * (1 - 10/20) = 3.5 between alpha and beta: kept
+	a.sample(20*time.Millisecond, 7, false)
+	assert.Equal(t, 7, a.Stats().Limit)
+	a.sample(20*time.Millisecond, 7, true)
+	assert.Equal(t, 6, a.Stats().Limit)
+}
+
+type AdaptiveHome struct {
+	yrpc.CallCtx
+}
+
+var adaptiveRelease = make(chan struct{})
+
+func (h *AdaptiveHome) Block(*struct{}) (string, *yrpc.Status) {
+	<-adaptiveRelease
+	return "ok", nil
+}
+
+func TestAdaptiveLimiter(t *testing.T) {
+	a := NewAdaptiveLimiter(AdaptiveConfig{InitialLimit: 1, MaxLimit: 1})
+	p := yrpctest.NewPair(t, yrpctest.PairConfig{ServerPlugins: []yrpc.Plugin{a}})
+	p.Server.RouteCall(new(AdaptiveHome))
+	sess := p.Dial()
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		var result string
+		assert.True(t, sess.Call("/adaptive_home/block", nil, &result).Status().OK())
+	}()
+	for a.Stats().InFlight == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	stat := sess.Call("/adaptive_home/block", nil, nil).Status()
+	assert.Equal(t, yrpc.CodeServiceUnavailable, stat.Code())
+	assert.Equal(t, uint64(1), a.Stats().Rejected)
+
+	adaptiveRelease <- struct{}{}
+	wg.Wait()
+	assert.Equal(t, 0, a.Stats().InFlight)
+}
@@ -0,0 +1,146 @@
+package overloader
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sqos/yrpc"
+)
+
+// CodeShed the status code of the call shed by Codel,
+// the handler is not executed, so it is safe to retry.
+const CodeShed int32 = 1503
+
+const (
+	// MetaPriority the metadata key of the call priority
+	MetaPriority = "X-Priority"
+	// PriorityCritical the priority of the call that is never shed
+	PriorityCritical = "critical"
+)
+
+type (
+	// CodelConfig queueing delay based load shedding condition
+	CodelConfig struct {
+		// Target is the acceptable minimum queueing delay, default 5ms.
+		Target time.Duration
+		// Interval is the sliding window to observe the minimum queueing delay, default 100ms.
+		Interval time.Duration
+		// CriticalMethods are the service methods that are never shed.
+		CriticalMethods []string
+		// OnShed is called when a call is shed.
+		OnShed func(serviceMethod string, delay time.Duration)
+	}
+	// Codel plug-in to shed the calls that wait too long before handling(CoDel, Controlled Delay).
+	// NOTE:
+	//
+	//	The queueing delay is the time from reading the call to starting its handler in the goroutine pool;
+	//	If the minimum delay of the last interval exceeds Target, the server is overloaded,
+	//	and the calls that have waited more than 2*Target are rejected with CodeShed before handling,
+	//	so the oldest calls are dropped first while the fresh ones still have a chance to succeed;
+	//	The call with CriticalMethods or the X-Priority=critical metadata is never shed.
+	Codel struct {
+		cfg         CodelConfig
+		critical    map[string]bool
+		mu          sync.Mutex
+		intervalEnd time.Time
+		minDelay    time.Duration
+		resetMin    bool
+		overloaded  bool
+		shed        uint64
+	}
+)
+
+var (
+	_ yrpc.PostReadCallHeaderPlugin = (*Codel)(nil)
+	_ yrpc.PostReadCallBodyPlugin   = (*Codel)(nil)
+)
+
+// codelArrivalKey is the context swap key of the call arrival time.
+const codelArrivalKey = "overloader_codel_arrival_"
+
+// NewCodel creates a plug-in to shed calls by the queueing delay.
+// NOTE: The X-Priority metadata is set by the client, only trust it from internal callers.
+func NewCodel(cfg CodelConfig) *Codel {
+	if cfg.Target <= 0 {
+		cfg.Target = 5 * time.Millisecond
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = 100 * time.Millisecond
+	}
+	critical := make(map[string]bool, len(cfg.CriticalMethods))
+	for _, m := range cfg.CriticalMethods {
+		critical[m] = true
+	}
+	return &Codel{
+		cfg:      cfg,
+		critical: critical,
+		resetMin: true,
+	}
+}
+
+// Name returns the plugin name.
+func (c *Codel) Name() string {
+	return "codel"
+}
+
+// Overloaded returns whether the queueing delay exceeds the target in the last interval.
+func (c *Codel) Overloaded() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.overloaded
+}
+
+// Shed returns the total shed calls.
+func (c *Codel) Shed() uint64 {
+	return atomic.LoadUint64(&c.shed)
+}
+
+// PostReadCallHeader records the arrival time of the call.
+func (c *Codel) PostReadCallHeader(ctx yrpc.ReadCtx) *yrpc.Status {
+	ctx.Swap().Store(codelArrivalKey, time.Now())
+	return nil
+}
+
+// PostReadCallBody sheds the call if it has waited too long when the handler starts.
+func (c *Codel) PostReadCallBody(ctx yrpc.ReadCtx) *yrpc.Status {
+	v, ok := ctx.Swap().Load(codelArrivalKey)
+	if !ok {
+		return nil
+	}
+	ctx.Swap().Delete(codelArrivalKey)
+	now := time.Now()
+	delay := now.Sub(v.(time.Time))
+	if !c.observe(delay, now) || c.isCritical(ctx) {
+		return nil
+	}
+	atomic.AddUint64(&c.shed, 1)
+	if c.cfg.OnShed != nil {
+		c.cfg.OnShed(ctx.ServiceMethod(), delay)
+	}
+	return yrpc.NewStatus(CodeShed, "load shedding", "queueing delay "+delay.String())
+}
+
+func (c *Codel) isCritical(ctx yrpc.ReadCtx) bool {
+	return c.critical[ctx.ServiceMethod()] ||
+		string(ctx.PeekMeta(MetaPriority)) == PriorityCritical
+}
+
+// observe records the queueing delay, and returns whether the call should be shed.
+func (c *Codel) observe(delay time.Duration, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !now.Before(c.intervalEnd) {
+		// a new interval, judges by the minimum delay of the last one,
+		// no call in the last interval means the queue is empty
+		c.overloaded = !c.resetMin && c.minDelay > c.cfg.Target &&
+			now.Before(c.intervalEnd.Add(c.cfg.Interval))
+		c.intervalEnd = now.Add(c.cfg.Interval)
+		c.resetMin = true
+	}
+	if c.resetMin || delay < c.minDelay {
+		c.minDelay = delay
+		c.resetMin = false
+	}
+	return c.overloaded && delay > 2*c.cfg.Target
+}
@@ -0,0 +1,74 @@
+package overloader
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/sqos/yrpc"
+	"github.com/sqos/yrpc/yrpctest"
+)
+
+func TestCodelObserve(t *testing.T) {
+	c := NewCodel(CodelConfig{Target: 10 * time.Millisecond, Interval: 100 * time.Millisecond})
+	now := time.Now()
+	// the first interval is never overloaded
+	assert.False(t, c.observe(time.Second, now))
+	assert.False(t, c.observe(50*time.Millisecond, now.Add(50*time.Millisecond)))
+	// the minimum delay of the last interval is 50ms > 10ms
+	assert.True(t, c.observe(30*time.Millisecond, now.Add(100*time.Millisecond)))
+	assert.True(t, c.Overloaded())
+	// not shed if the delay <= 2*Target
+	assert.False(t, c.observe(20*time.Millisecond, now.Add(150*time.Millisecond)))
+	// the minimum delay of the last interval is 20ms > 10ms
+	assert.False(t, c.observe(5*time.Millisecond, now.Add(200*time.Millisecond)))
+	assert.True(t, c.observe(time.Second, now.Add(250*time.Millisecond)))
+	// the minimum delay of the last interval is 5ms, recovered
+	assert.False(t, c.observe(time.Second, now.Add(300*time.Millisecond)))
+	assert.False(t, c.Overloaded())
+	// no call in the last interval
+	c.observe(time.Second, now.Add(350*time.Millisecond))
+	assert.False(t, c.observe(time.Second, now.Add(600*time.Millisecond)))
+}
+
+// delayPlugin simulates the queueing delay.
+type delayPlugin time.Duration
+
+func (d delayPlugin) Name() string { return "delay" }
+
+func (d delayPlugin) PostReadCallHeader(yrpc.ReadCtx) *yrpc.Status {
+	time.Sleep(time.Duration(d))
+	return nil
+}
+
+func TestCodel(t *testing.T) {
+	var shed []string
+	c := NewCodel(CodelConfig{
+		Target:          5 * time.Millisecond,
+		Interval:        time.Hour,
+		CriticalMethods: []string{"/keyed_home/critical"},
+		OnShed: func(serviceMethod string, _ time.Duration) {
+			shed = append(shed, serviceMethod)
+		},
+	})
+	p := yrpctest.NewPair(t, yrpctest.PairConfig{ServerPlugins: []yrpc.Plugin{c, delayPlugin(20 * time.Millisecond)}})
+	p.Server.RouteCall(new(KeyedHome))
+	sess := p.Dial()
+	var result string
+	// not overloaded yet
+	assert.True(t, sess.Call("/keyed_home/test", nil, &result).Status().OK())
+
+	c.mu.Lock()
+	c.overloaded = true
+	c.mu.Unlock()
+	stat := sess.Call("/keyed_home/test", nil, &result).Status()
+	assert.Equal(t, CodeShed, stat.Code())
+	assert.Equal(t, uint64(1), c.Shed())
+	assert.Equal(t, []string{"/keyed_home/test"}, shed)
+
+	// critical calls are never shed
+	stat = sess.Call("/keyed_home/test", nil, &result, yrpc.WithSetMeta(MetaPriority, PriorityCritical)).Status()
+	assert.True(t, stat.OK(), stat)
+	assert.Equal(t, uint64(1), c.Shed())
+}
@@ -0,0 +1,196 @@
+package overloader
+
+import (
+	"container/list"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/sqos/yrpc"
+	"github.com/sqos/yrpc/utils"
+)
+
+const defaultMaxKeys = 10000
+
+type (
+	// KeyedLimit token bucket limitation per key, e.g. per session, per IP or per tenant
+	KeyedLimit struct {
+		// Name identifies the limitation, the buckets are kept by Update if the name is unchanged.
+		Name string
+		// Key extracts the key of the message, the message is not limited if the key is empty.
+		Key KeyFunc
+		// ServiceMethod only limits the service method if not empty.
+		ServiceMethod string
+		// Rate is the tokens added per second.
+		Rate float64
+		// Burst is the bucket size, default is ceil(Rate).
+		Burst int32
+		// MaxKeys is the capacity of the keys, the least recently used key is evicted, default 10000.
+		MaxKeys int
+		// Overrides overrides the rate and burst of the specified keys.
+		Overrides map[string]KeyRate
+	}
+	// KeyRate the rate and burst of a key
+	KeyRate struct {
+		Rate  float64
+		Burst int32
+	}
+	// KeyFunc extracts the limitation key of the message.
+	KeyFunc func(ctx yrpc.ReadCtx) string
+)
+
+// KeyBySession uses the session ID as the key.
+func KeyBySession() KeyFunc {
+	return func(ctx yrpc.ReadCtx) string {
+		return ctx.Session().ID()
+	}
+}
+
+// KeyByRemoteIP uses the IP of the connection remote address as the key.
+func KeyByRemoteIP() KeyFunc {
+	return func(ctx yrpc.ReadCtx) string {
+		return utils.HostOf(ctx.Session().RemoteAddr().String())
+	}
+}
+
+// KeyByRealIP uses the RealIP as the key.
+// NOTE: The X-Real-IP metadata is set by the client, only use it behind a trusted proxy.
+func KeyByRealIP() KeyFunc {
+	return func(ctx yrpc.ReadCtx) string {
+		return utils.HostOf(ctx.RealIP())
+	}
+}
+
+// KeyByMeta uses the metadata value as the key, e.g. a tenant ID.
+func KeyByMeta(metaKey string) KeyFunc {
+	return func(ctx yrpc.ReadCtx) string {
+		return string(ctx.PeekMeta(metaKey))
+	}
+}
+
+type keyedLimiter struct {
+	mu      sync.Mutex
+	cfg     KeyedLimit
+	buckets map[string]*list.Element
+	lru     *list.List
+}
+
+type tokenBucket struct {
+	key    string
+	tokens float64
+	last   time.Time
+}
+
+func newKeyedLimiter(cfg KeyedLimit) *keyedLimiter {
+	k := &keyedLimiter{
+		buckets: make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+	k.update(cfg)
+	return k
+}
+
+func (k *keyedLimiter) update(cfg KeyedLimit) {
+	if cfg.MaxKeys <= 0 {
+		cfg.MaxKeys = defaultMaxKeys
+	}
+	k.mu.Lock()
+	k.cfg = cfg
+	for k.lru.Len() > cfg.MaxKeys {
+		k.evictOldest()
+	}
+	k.mu.Unlock()
+}
+
+func (k *keyedLimiter) rateOf(key string) (float64, float64) {
+	rate, burst := k.cfg.Rate, k.cfg.Burst
+	if o, ok := k.cfg.Overrides[key]; ok {
+		rate, burst = o.Rate, o.Burst
+	}
+	if burst <= 0 {
+		burst = int32(math.Ceil(rate))
+		if burst <= 0 {
+			burst = 1
+		}
+	}
+	return rate, float64(burst)
+}
+
+// take takes a token of the message key, if failed, returns the limitation name and
+// the delay until a token is available.
+// NOTE: The key is extracted out of the lock, since KeyFunc is user code.
+func (k *keyedLimiter) take(ctx yrpc.ReadCtx, now time.Time) (name, key string, ok bool, retryAfter time.Duration) {
+	k.mu.Lock()
+	name, keyFunc, serviceMethod := k.cfg.Name, k.cfg.Key, k.cfg.ServiceMethod
+	k.mu.Unlock()
+	if serviceMethod != "" && serviceMethod != ctx.ServiceMethod() {
+		return "", "", true, 0
+	}
+	key = keyFunc(ctx)
+	if key == "" {
+		return "", "", true, 0
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	ok, retryAfter = k.takeKey(key, now)
+	return name, key, ok, retryAfter
+}
+
+func (k *keyedLimiter) takeKey(key string, now time.Time) (bool, time.Duration) {
+	rate, burst := k.rateOf(key)
+	var b *tokenBucket
+	if e, ok := k.buckets[key]; ok {
+		k.lru.MoveToFront(e)
+		b = e.Value.(*tokenBucket)
+		if elapsed := now.Sub(b.last); elapsed > 0 {
+			b.tokens = math.Min(burst, b.tokens+elapsed.Seconds()*rate)
+		}
+		b.last = now
+	} else {
+		if k.lru.Len() >= k.cfg.MaxKeys {
+			k.evictOldest()
+		}
+		b = &tokenBucket{key: key, tokens: burst, last: now}
+		k.buckets[key] = k.lru.PushFront(b)
+	}
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	if rate <= 0 {
+		return false, time.Second
+	}
+	return false, time.Duration((1 - b.tokens) / rate * float64(time.Second))
+}
+
+// refund returns a token taken by take to the bucket of the key.
+func (k *keyedLimiter) refund(key string) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if e, ok := k.buckets[key]; ok {
+		_, burst := k.rateOf(key)
+		b := e.Value.(*tokenBucket)
+		b.tokens = math.Min(burst, b.tokens+1)
+	}
+}
+
+func (k *keyedLimiter) evictOldest() {
+	e := k.lru.Back()
+	if e == nil {
+		return
+	}
+	k.lru.Remove(e)
+	delete(k.buckets, e.Value.(*tokenBucket).key)
+}
+
+func (k *keyedLimiter) name() string {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.cfg.Name
+}
+
+func (k *keyedLimiter) len() int {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.lru.Len()
+}
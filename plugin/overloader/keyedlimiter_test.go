@@ -0,0 +1,189 @@
+package overloader
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/sqos/yrpc"
+	"github.com/sqos/yrpc/yrpctest"
+)
+
+func TestKeyedLimiterBucket(t *testing.T) {
+	l := newKeyedLimiter(KeyedLimit{
+		Rate:      2,
+		Burst:     3,
+		MaxKeys:   2,
+		Overrides: map[string]KeyRate{"vip": {Rate: 100, Burst: 10}},
+	})
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		ok, _ := l.takeKey("a", now)
+		assert.True(t, ok)
+	}
+	ok, retryAfter := l.takeKey("a", now)
+	assert.False(t, ok)
+	assert.Equal(t, 500*time.Millisecond, retryAfter)
+
+	// refilled after 500ms
+	ok, _ = l.takeKey("a", now.Add(500*time.Millisecond))
+	assert.True(t, ok)
+
+	// override
+	for i := 0; i < 10; i++ {
+		ok, _ = l.takeKey("vip", now)
+		assert.True(t, ok)
+	}
+	ok, retryAfter = l.takeKey("vip", now)
+	assert.False(t, ok)
+	assert.Equal(t, 10*time.Millisecond, retryAfter)
+
+	// LRU eviction: "a" is the least recently used
+	ok, _ = l.takeKey("b", now)
+	assert.True(t, ok)
+	assert.Equal(t, 2, l.len())
+	_, exist := l.buckets["a"]
+	assert.False(t, exist)
+	_, exist = l.buckets["vip"]
+	assert.True(t, exist)
+
+	l.update(KeyedLimit{Rate: 1, MaxKeys: 1})
+	assert.Equal(t, 1, l.len())
+}
+
+type KeyedHome struct {
+	yrpc.CallCtx
+}
+
+func (h *KeyedHome) Test(*struct{}) (string, *yrpc.Status) {
+	return "ok", nil
+}
+
+func TestKeyedLimit(t *testing.T) {
+	ol := New(LimitConfig{
+		KeyedLimits: []KeyedLimit{
+			{Name: "tenant", Key: KeyByMeta("tenant"), Rate: 0.5, Burst: 2},
+		},
+	})
+	p := yrpctest.NewPair(t, yrpctest.PairConfig{ServerPlugins: []yrpc.Plugin{ol}})
+	p.Server.RouteCall(new(KeyedHome))
+	sess := p.Dial()
+	call := func(tenant string) yrpc.CallCmd {
+		var result string
+		return sess.Call("/keyed_home/test", nil, &result, yrpc.WithSetMeta("tenant", tenant))
+	}
+	assert.True(t, call("t1").Status().OK())
+	assert.True(t, call("t1").Status().OK())
+	cmd := call("t1")
+	assert.Equal(t, yrpc.CodeTooManyRequests, cmd.Status().Code())
+	retryAfter, ok := yrpc.GetRetryAfter(cmd.InputMeta())
+	assert.True(t, ok)
+	assert.Equal(t, 2*time.Second, retryAfter)
+
+	// other tenants and the messages without the key are not affected
+	assert.True(t, call("t2").Status().OK())
+	assert.True(t, call("").Status().OK())
+
+	// the buckets are kept by Update if the name is unchanged
+	ol.Update(LimitConfig{
+		KeyedLimits: []KeyedLimit{
+			{Name: "tenant", Key: KeyByMeta("tenant"), Rate: 0.5, Burst: 2},
+		},
+	})
+	assert.Equal(t, yrpc.CodeTooManyRequests, call("t1").Status().Code())
+	ol.Update(LimitConfig{
+		KeyedLimits: []KeyedLimit{
+			{Name: "tenant-v2", Key: KeyByMeta("tenant"), Rate: 0.5, Burst: 2},
+		},
+	})
+	assert.True(t, call("t1").Status().OK())
+}
+
+func TestKeyedLimitRefund(t *testing.T) {
+	ol := New(LimitConfig{
+		KeyedLimits: []KeyedLimit{
+			{Name: "session", Key: KeyBySession(), Rate: 0.001, Burst: 3},
+			{Name: "tenant", Key: KeyByMeta("tenant"), Rate: 0.001, Burst: 1},
+		},
+	})
+	p := yrpctest.NewPair(t, yrpctest.PairConfig{ServerPlugins: []yrpc.Plugin{ol}})
+	p.Server.RouteCall(new(KeyedHome))
+	sess := p.Dial()
+	call := func(tenant string) *yrpc.Status {
+		var result string
+		return sess.Call("/keyed_home/test", nil, &result, yrpc.WithSetMeta("tenant", tenant)).Status()
+	}
+	assert.True(t, call("t1").OK())
+	// rejected by the tenant limit, the session tokens are refunded
+	for i := 0; i < 3; i++ {
+		stat := call("t1")
+		assert.Equal(t, yrpc.CodeTooManyRequests, stat.Code())
+		assert.Contains(t, stat.Msg(), "limit=tenant")
+	}
+	assert.True(t, call("t2").OK())
+	assert.True(t, call("t3").OK())
+	stat := call("t4")
+	assert.Equal(t, yrpc.CodeTooManyRequests, stat.Code())
+	assert.Contains(t, stat.Msg(), "limit=session")
+}
+
+func TestKeyedLimitBeforeGlobal(t *testing.T) {
+	ol := New(LimitConfig{
+		QPSInterval:   time.Second,
+		MaxHandlerQPS: []HandlerLimit{{ServiceMethod: "/keyed_home/test", MaxQPS: 2}},
+		KeyedLimits: []KeyedLimit{
+			{Name: "tenant", Key: KeyByMeta("tenant"), Rate: 0.001, Burst: 1},
+		},
+	})
+	p := yrpctest.NewPair(t, yrpctest.PairConfig{ServerPlugins: []yrpc.Plugin{ol}})
+	p.Server.RouteCall(new(KeyedHome))
+	sess := p.Dial()
+	call := func(tenant string) *yrpc.Status {
+		var result string
+		return sess.Call("/keyed_home/test", nil, &result, yrpc.WithSetMeta("tenant", tenant)).Status()
+	}
+	assert.True(t, call("t1").OK())
+	// rejected by the tenant limit, the handler tokens are not taken
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, yrpc.CodeTooManyRequests, call("t1").Code())
+	}
+	assert.True(t, call("t2").OK())
+	// rejected by the handler limit, the tenant token is refunded
+	stat := call("t3")
+	assert.Equal(t, yrpc.CodeInternalServerError, stat.Code())
+	assert.Contains(t, stat.Msg(), "handler_limit")
+	assert.Eventually(t, func() bool {
+		return call("t3").OK()
+	}, 3*time.Second, 100*time.Millisecond)
+}
+
+func TestKeyedLimiterKeyOutOfLock(t *testing.T) {
+	entered, release := make(chan struct{}), make(chan struct{})
+	l := newKeyedLimiter(KeyedLimit{
+		Rate: 1,
+		Key: func(yrpc.ReadCtx) string {
+			close(entered)
+			<-release
+			return "a"
+		},
+	})
+	taken := make(chan bool)
+	go func() {
+		_, _, ok, _ := l.take(nil, time.Now())
+		taken <- ok
+	}()
+	<-entered
+	// the slow KeyFunc does not block the limiter
+	done := make(chan int)
+	go func() { done <- l.len() }()
+	select {
+	case n := <-done:
+		assert.Equal(t, 0, n)
+	case <-time.After(time.Second):
+		t.Fatal("the limiter is locked by KeyFunc")
+	}
+	close(release)
+	assert.True(t, <-taken)
+	assert.Equal(t, 1, l.len())
+}
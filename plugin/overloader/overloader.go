@@ -20,6 +20,8 @@ type (
 		totalQPSLimiterLock   sync.RWMutex
 		handlerQPSLimiter     map[string]*qpsLimiter
 		handlerQPSLimiterLock sync.RWMutex
+		keyedLimiters         []*keyedLimiter
+		keyedLimitersLock     sync.RWMutex
 	}
 	// LimitConfig overload limitation condition
 	LimitConfig struct {
@@ -27,6 +29,7 @@ type (
 		QPSInterval   time.Duration
 		MaxTotalQPS   int32
 		MaxHandlerQPS []HandlerLimit
+		KeyedLimits   []KeyedLimit
 	}
 	// HandlerLimit handler QPS overload limitation condition
 	HandlerLimit struct {
@@ -86,25 +89,32 @@ func (o *Overloader) PostDisconnect(_ yrpc.BaseSession) *yrpc.Status {
 
 // PostReadCallHeader checks PULL QPS overload.
 // If overload, print error log and reply error.
+// NOTE: The keyed limitations are checked first, so the message rejected by them does not take the global tokens.
 func (o *Overloader) PostReadCallHeader(ctx yrpc.ReadCtx) *yrpc.Status {
+	refund, stat := o.takeKeyed(ctx)
+	if !stat.OK() {
+		return stat
+	}
 	if !o.takeTotalQPS() {
+		refund()
 		msg := fmt.Sprintf("qps overload, total_limit=%d",
 			o.totalQPSLimiter.getLimit(),
 		)
 		return yrpc.NewStatus(yrpc.CodeInternalServerError, msg, nil)
 	}
 	limit, ok := o.takeHandlerQPS(ctx.ServiceMethod())
-	if ok {
-		return nil
+	if !ok {
+		refund()
+		msg := fmt.Sprintf("qps overload, handler_limit=%d",
+			limit,
+		)
+		return yrpc.NewStatus(yrpc.CodeInternalServerError, msg, nil)
 	}
-	msg := fmt.Sprintf("qps overload, handler_limit=%d",
-		limit,
-	)
-	return yrpc.NewStatus(yrpc.CodeInternalServerError, msg, nil)
+	return nil
 }
 
-// PostReadPushHeader checks PUSH QPS overload.
-// If overload, print warning log.
+// PostReadPushHeader checks PUSH QPS overload, including the keyed limitations.
+// If overload, print warning log and drop the PUSH.
 func (o *Overloader) PostReadPushHeader(ctx yrpc.ReadCtx) *yrpc.Status {
 	return o.PostReadCallHeader(ctx)
 }
@@ -120,6 +130,7 @@ func (o *Overloader) Update(newLimitConfig LimitConfig) {
 	o.updateConnLimiter(limitConfig)
 	o.updateTotalQPSLimiter(limitConfig)
 	o.updateHandlerLimiter(limitConfig)
+	o.updateKeyedLimiters(limitConfig)
 	o.limitConfigLock.Lock()
 	o.limitConfig = limitConfig
 	o.limitConfigLock.Unlock()
@@ -185,6 +196,29 @@ func (o *Overloader) updateHandlerLimiter(limitConfig *LimitConfig) {
 	o.handlerQPSLimiterLock.Unlock()
 }
 
+func (o *Overloader) updateKeyedLimiters(limitConfig *LimitConfig) {
+	o.keyedLimitersLock.Lock()
+	old := make(map[string]*keyedLimiter, len(o.keyedLimiters))
+	for _, l := range o.keyedLimiters {
+		old[l.name()] = l
+	}
+	limiters := make([]*keyedLimiter, 0, len(limitConfig.KeyedLimits))
+	for _, v := range limitConfig.KeyedLimits {
+		if v.Key == nil {
+			continue
+		}
+		if l, ok := old[v.Name]; ok && v.Name != "" {
+			l.update(v)
+			delete(old, v.Name)
+			limiters = append(limiters, l)
+		} else {
+			limiters = append(limiters, newKeyedLimiter(v))
+		}
+	}
+	o.keyedLimiters = limiters
+	o.keyedLimitersLock.Unlock()
+}
+
 func (o *Overloader) takeConn() bool {
 	o.connLimiterLock.RLock()
 	bol := o.connLimiter == nil || o.connLimiter.take()
@@ -220,3 +254,42 @@ func (o *Overloader) takeHandlerQPS(serviceMethod string) (limit int32, ok bool)
 	o.handlerQPSLimiterLock.RUnlock()
 	return limit, ok
 }
+
+// takeKeyed takes a token from each matched keyed limiter, and returns the function to refund them,
+// if failed, refunds the tokens taken from the others, and sets the retry-after hint into the reply metadata.
+func (o *Overloader) takeKeyed(ctx yrpc.ReadCtx) (refund func(), stat *yrpc.Status) {
+	o.keyedLimitersLock.RLock()
+	limiters := o.keyedLimiters
+	o.keyedLimitersLock.RUnlock()
+	refund = func() {}
+	if len(limiters) == 0 {
+		return refund, nil
+	}
+	now := time.Now()
+	keys := make([]string, len(limiters))
+	for i, l := range limiters {
+		name, key, ok, retryAfter := l.take(ctx, now)
+		if ok {
+			keys[i] = key
+			continue
+		}
+		// the rejected message does not drain the other buckets
+		for j, key := range keys[:i] {
+			if key != "" {
+				limiters[j].refund(key)
+			}
+		}
+		if c, ok := ctx.(interface{ Output() yrpc.Message }); ok {
+			yrpc.WithRetryAfter(retryAfter)(c.Output())
+		}
+		msg := fmt.Sprintf("rate limited, limit=%s, key=%s", name, key)
+		return refund, yrpc.NewStatus(yrpc.CodeTooManyRequests, msg, nil)
+	}
+	return func() {
+		for i, key := range keys {
+			if key != "" {
+				limiters[i].refund(key)
+			}
+		}
+	}, nil
+}
@@ -0,0 +1,61 @@
+// Copyright 2024 sqos. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reliable
+
+import (
+	"container/list"
+	"sync"
+)
+
+// dedupe the LRU set of the recently handled message IDs
+type dedupe struct {
+	size  int
+	mu    sync.Mutex
+	ll    *list.List
+	items map[string]*list.Element
+}
+
+func newDedupe(size int) *dedupe {
+	return &dedupe{
+		size:  size,
+		ll:    list.New(),
+		items: make(map[string]*list.Element, size),
+	}
+}
+
+func (d *dedupe) contains(id string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	e, ok := d.items[id]
+	if ok {
+		d.ll.MoveToFront(e)
+	}
+	return ok
+}
+
+func (d *dedupe) add(id string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if e, ok := d.items[id]; ok {
+		d.ll.MoveToFront(e)
+		return
+	}
+	d.items[id] = d.ll.PushFront(id)
+	if d.ll.Len() > d.size {
+		e := d.ll.Back()
+		d.ll.Remove(e)
+		delete(d.items, e.Value.(string))
+	}
+}
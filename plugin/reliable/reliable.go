@@ -0,0 +1,482 @@
+// Package reliable is a plugin of the at-least-once PUSH with acknowledgements and retransmission.
+//
+// Copyright 2024 sqos. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package reliable
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sqos/yrpc"
+	"github.com/sqos/yrpc/codec"
+)
+
+const (
+	// MetaMessageID the metadata key of the reliable PUSH message ID
+	MetaMessageID = "X-Msg-ID"
+	// AckServiceMethod the PUSH service method of the acknowledgement, whose body is the acknowledged seq
+	AckServiceMethod = "/reliable/ack"
+)
+
+// CodeWindowFull the status code of the reliable PUSH when the window of the unacknowledged pushes is full
+const CodeWindowFull int32 = 1470
+
+// duplicateServiceMethod the no-op PUSH handler that the duplicate pushes are routed to
+const duplicateServiceMethod = "/reliable/duplicate"
+
+// Config the reliable PUSH config
+type Config struct {
+	// WindowSize is the max number of the unacknowledged pushes of a session, default is 1024.
+	WindowSize int
+	// RetransmitInterval is the time to wait for the acknowledgement before retransmitting, default is 3s.
+	RetransmitInterval time.Duration
+	// DedupeSize is the number of the recently handled message IDs kept for deduplication, default is 65536.
+	DedupeSize int
+	// Linger is the time to keep the unacknowledged pushes of the closed session
+	// for a session that reconnects with the same ID, default is 1m.
+	Linger time.Duration
+}
+
+// Reliable is the reliable PUSH plugin, which is registered on both the sender and the receiver.
+// NOTE:
+//
+//	The receiver acknowledges the PUSH by seq after its handler finishes with OK status,
+//	and routes the message ID that has been handled to a no-op handler, which is acknowledged again,
+//	so the handler may run again if the retransmission arrives before the first handling finishes;
+//	The sender keeps the unacknowledged pushes in the window of the session ID,
+//	retransmits them every RetransmitInterval, and after a session with the ID is added again, e.g. redialed or reconnected;
+//	The window of the closed session is kept for Linger, so the session ID should be stable, e.g. set by SetID in PostAccept;
+//	If PeerConfig.DuplicateID is allow_multiple, the window follows the latest session of the ID;
+//	The body is encoded once by Push, and only the service method, the body codec, the metadata
+//	and the transfer filter pipe of the message settings are kept for the retransmissions.
+type Reliable struct {
+	cfg       Config
+	prefix    string
+	nextID    atomic.Uint64
+	bodyCodec byte
+	mu        sync.Mutex
+	windows   map[string]*window
+	dedupe    *dedupe
+}
+
+var (
+	_ yrpc.PreNewPeerPlugin         = (*Reliable)(nil)
+	_ yrpc.PostNewPeerPlugin        = (*Reliable)(nil)
+	_ yrpc.PostAddPlugin            = (*Reliable)(nil)
+	_ yrpc.PreWritePushPlugin       = (*Reliable)(nil)
+	_ yrpc.PostReadPushHeaderPlugin = (*Reliable)(nil)
+	_ yrpc.PostHandlePushPlugin     = (*Reliable)(nil)
+)
+
+// New creates a reliable PUSH plugin.
+func New(cfg Config) *Reliable {
+	if cfg.WindowSize <= 0 {
+		cfg.WindowSize = 1024
+	}
+	if cfg.RetransmitInterval <= 0 {
+		cfg.RetransmitInterval = 3 * time.Second
+	}
+	if cfg.DedupeSize <= 0 {
+		cfg.DedupeSize = 65536
+	}
+	if cfg.Linger <= 0 {
+		cfg.Linger = time.Minute
+	}
+	prefix := make([]byte, 8)
+	rand.Read(prefix)
+	return &Reliable{
+		cfg:       cfg,
+		prefix:    hex.EncodeToString(prefix) + "-",
+		bodyCodec: yrpc.DefaultBodyCodec().ID(),
+		windows:   make(map[string]*window),
+		dedupe:    newDedupe(cfg.DedupeSize),
+	}
+}
+
+// Name returns the plugin name.
+func (r *Reliable) Name() string {
+	return "reliable"
+}
+
+// PreNewPeer gets the default body codec of the peer.
+func (r *Reliable) PreNewPeer(cfg *yrpc.PeerConfig, _ *yrpc.PluginContainer) error {
+	if c, err := codec.GetByName(cfg.DefaultBodyCodec); err == nil {
+		r.bodyCodec = c.ID()
+	}
+	return nil
+}
+
+// PostNewPeer registers the acknowledgement handler.
+func (r *Reliable) PostNewPeer(peer yrpc.EarlyPeer) error {
+	group := peer.SubRoute("/reliable")
+	group.RoutePushFunc(ack)
+	group.RoutePushFunc(duplicate)
+	return nil
+}
+
+// Push sends a PUSH message, which is retransmitted until it is acknowledged.
+// NOTE:
+//
+//	It returns CodeWindowFull if the window of the session ID is full;
+//	It returns yrpc.CodeBadMessage if the body fails to be encoded;
+//	If the write fails with yrpc.CodeConnClosed or yrpc.CodeWriteFailed, the message stays in the window
+//	and is retransmitted after a session with the ID is added again.
+func (r *Reliable) Push(sess yrpc.CtxSession, serviceMethod string, args interface{}, setting ...yrpc.MessageSetting) *yrpc.Status {
+	e, stat := r.newEntry(serviceMethod, args, setting)
+	if !stat.OK() {
+		return stat
+	}
+	w := r.window(sess)
+	if !w.add(e) {
+		return yrpc.NewStatus(CodeWindowFull, "push window is full", "unacknowledged pushes: "+strconv.Itoa(r.cfg.WindowSize))
+	}
+	stat = w.send(e)
+	switch stat.Code() {
+	case yrpc.CodeOK, yrpc.CodeConnClosed, yrpc.CodeWriteFailed:
+	default:
+		w.remove(e.id)
+	}
+	return stat
+}
+
+// newEntry encodes the message as the settings of the session.Push do.
+func (r *Reliable) newEntry(serviceMethod string, args interface{}, setting []yrpc.MessageSetting) (*entry, *yrpc.Status) {
+	m := yrpc.GetMessage()
+	defer yrpc.PutMessage(m)
+	m.SetServiceMethod(serviceMethod)
+	m.SetBody(args)
+	for _, fn := range setting {
+		if fn != nil {
+			fn(m)
+		}
+	}
+	if m.BodyCodec() == codec.NilCodecID {
+		m.SetBodyCodec(r.bodyCodec)
+	}
+	body, err := m.MarshalBody()
+	if err != nil {
+		return nil, yrpc.NewStatus(yrpc.CodeBadMessage, "reliable encode failed", err.Error())
+	}
+	return &entry{
+		id:            r.prefix + strconv.FormatUint(r.nextID.Add(1), 36),
+		serviceMethod: m.ServiceMethod(),
+		bodyCodec:     m.BodyCodec(),
+		meta:          append([]byte(nil), m.Meta().QueryString()...),
+		xferPipe:      m.XferPipe().IDs(),
+		body:          append([]byte(nil), body...),
+	}, nil
+}
+
+// Pending returns the number of the unacknowledged pushes of the session ID.
+func (r *Reliable) Pending(sess yrpc.CtxSession) int {
+	r.mu.Lock()
+	w, ok := r.windows[sess.ID()]
+	r.mu.Unlock()
+	if !ok {
+		return 0
+	}
+	return w.len()
+}
+
+// PostAdd hands the window of the session ID to the added session,
+// and makes the unacknowledged pushes retransmitted at once.
+func (r *Reliable) PostAdd(sess yrpc.Session) *yrpc.Status {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if w, ok := r.windows[sess.ID()]; ok {
+		w.bind(sess)
+		w.expireAll()
+		go w.retransmit(sess)
+	}
+	return nil
+}
+
+// PreWritePush maps the seq to the message ID.
+func (r *Reliable) PreWritePush(ctx yrpc.WriteCtx) *yrpc.Status {
+	id := ctx.Output().Meta().Peek(MetaMessageID)
+	if len(id) == 0 {
+		return nil
+	}
+	if v, ok := ctx.Session().Swap().Load(windowSwapKey); ok {
+		v.(*window).setSeq(string(id), ctx.Output().Seq())
+	}
+	return nil
+}
+
+// PostReadPushHeader routes the message ID that has been handled to the no-op handler,
+// so the original handler is skipped and PostHandlePush acknowledges it again.
+func (r *Reliable) PostReadPushHeader(ctx yrpc.ReadCtx) *yrpc.Status {
+	id := ctx.PeekMeta(MetaMessageID)
+	if len(id) == 0 || !r.dedupe.contains(string(id)) {
+		return nil
+	}
+	ctx.Session().Debugf("reliable: skip the duplicate push %s of %s", id, ctx.ServiceMethod())
+	ctx.Input().SetServiceMethod(duplicateServiceMethod)
+	return nil
+}
+
+// PostHandlePush acknowledges the PUSH after its handler finishes with OK status.
+func (r *Reliable) PostHandlePush(ctx yrpc.ReadCtx) *yrpc.Status {
+	id := ctx.PeekMeta(MetaMessageID)
+	if len(id) == 0 || !ctx.StatusOK() {
+		return nil
+	}
+	r.dedupe.add(string(id))
+	ctx.Session().Push(AckServiceMethod, ctx.Seq())
+	return nil
+}
+
+const windowSwapKey = "reliable-window"
+
+// window returns the window of the session ID, creates it if not exists.
+func (r *Reliable) window(sess yrpc.CtxSession) *window {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	id := sess.ID()
+	if w, ok := r.windows[id]; ok {
+		return w
+	}
+	w := newWindow(r, id, sess)
+	r.windows[id] = w
+	go w.retransmitLoop()
+	return w
+}
+
+// dropIdle drops the window of the closed session if it is empty or has lingered long enough,
+// returns false if the window is handed to another session.
+func (r *Reliable) dropIdle(w *window, closed yrpc.CtxSession) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !w.idle(closed, r.cfg.Linger) {
+		return false
+	}
+	if r.windows[w.id] == w {
+		delete(r.windows, w.id)
+	}
+	return true
+}
+
+type entry struct {
+	id            string
+	serviceMethod string
+	bodyCodec     byte
+	meta          []byte
+	xferPipe      []byte
+	body          []byte
+	seq           int32
+	sentAt        time.Time
+}
+
+// window the unacknowledged pushes of a session ID
+type window struct {
+	r        *Reliable
+	id       string
+	size     int
+	interval time.Duration
+	mu       sync.Mutex
+	sess     yrpc.CtxSession
+	closedAt time.Time
+	entries  map[string]*entry
+	order    []string
+	bySeq    map[int32]string
+}
+
+func newWindow(r *Reliable, id string, sess yrpc.CtxSession) *window {
+	w := &window{
+		r:        r,
+		id:       id,
+		size:     r.cfg.WindowSize,
+		interval: r.cfg.RetransmitInterval,
+		entries:  make(map[string]*entry),
+		bySeq:    make(map[int32]string),
+	}
+	w.bind(sess)
+	return w
+}
+
+// bind hands the window to the session, the seqs of the previous session are not acknowledged any more.
+func (w *window) bind(sess yrpc.CtxSession) {
+	sess.Swap().Store(windowSwapKey, w)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.sess != sess {
+		w.sess = sess
+		w.bySeq = make(map[int32]string)
+	}
+	w.closedAt = time.Time{}
+}
+
+func (w *window) session() yrpc.CtxSession {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.sess
+}
+
+// idle returns true if the window is still bound to the closed session, and is empty or has lingered long enough.
+func (w *window) idle(closed yrpc.CtxSession, linger time.Duration) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.sess != closed {
+		return false
+	}
+	if w.closedAt.IsZero() {
+		w.closedAt = time.Now()
+	}
+	return len(w.entries) == 0 || time.Since(w.closedAt) >= linger
+}
+
+func (w *window) add(e *entry) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.entries) >= w.size {
+		return false
+	}
+	e.sentAt = time.Now()
+	w.entries[e.id] = e
+	w.order = append(w.order, e.id)
+	return true
+}
+
+func (w *window) remove(id string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if e, ok := w.entries[id]; ok {
+		w.unmapSeq(e)
+		delete(w.entries, id)
+	}
+}
+
+func (w *window) len() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return len(w.entries)
+}
+
+func (w *window) setSeq(id string, seq int32) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if e, ok := w.entries[id]; ok {
+		w.unmapSeq(e)
+		e.seq = seq
+		w.bySeq[seq] = id
+	}
+}
+
+// unmapSeq removes the seq of the previous write, which may be reused by another push.
+func (w *window) unmapSeq(e *entry) {
+	if w.bySeq[e.seq] == e.id {
+		delete(w.bySeq, e.seq)
+	}
+}
+
+func (w *window) ack(seq int32) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if id, ok := w.bySeq[seq]; ok {
+		delete(w.bySeq, seq)
+		delete(w.entries, id)
+	}
+}
+
+func (w *window) expireAll() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, e := range w.entries {
+		e.sentAt = time.Time{}
+	}
+}
+
+func (w *window) send(e *entry) *yrpc.Status {
+	return w.session().Push(e.serviceMethod, e.body,
+		yrpc.WithBodyCodec(e.bodyCodec),
+		yrpc.WithXferPipe(e.xferPipe...),
+		func(m yrpc.Message) {
+			m.Meta().ParseBytes(e.meta)
+		},
+		yrpc.WithSetMeta(MetaMessageID, e.id),
+	)
+}
+
+// due returns the pushes that wait for the acknowledgement longer than the interval in order.
+func (w *window) due() []*entry {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var list []*entry
+	order := w.order[:0]
+	deadline := time.Now().Add(-w.interval)
+	for _, id := range w.order {
+		e, ok := w.entries[id]
+		if !ok {
+			continue
+		}
+		order = append(order, id)
+		if e.sentAt.Before(deadline) {
+			e.sentAt = time.Now()
+			list = append(list, e)
+		}
+	}
+	w.order = order
+	return list
+}
+
+func (w *window) retransmitLoop() {
+	tick := w.interval / 4
+	if tick < 10*time.Millisecond {
+		tick = 10 * time.Millisecond
+	}
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+	for range ticker.C {
+		sess := w.session()
+		select {
+		case <-sess.CloseNotify():
+			if w.r.dropIdle(w, sess) {
+				return
+			}
+			continue
+		default:
+		}
+		if sess.Health() {
+			w.retransmit(sess)
+		}
+	}
+}
+
+// retransmit sends the due pushes in order, and stops at the first failure.
+func (w *window) retransmit(sess yrpc.CtxSession) {
+	for _, e := range w.due() {
+		if stat := w.send(e); !stat.OK() {
+			sess.Debugf("reliable: retransmit %s failed: %v", e.id, stat)
+			return
+		}
+	}
+}
+
+// ack handles the acknowledgement, whose body is the acknowledged seq.
+func ack(ctx yrpc.PushCtx, seq *int32) *yrpc.Status {
+	if v, ok := ctx.Session().Swap().Load(windowSwapKey); ok {
+		v.(*window).ack(*seq)
+	}
+	return nil
+}
+
+// duplicate handles the duplicate push, whose body is discarded.
+func duplicate(ctx yrpc.PushCtx, _ *[]byte) *yrpc.Status {
+	return nil
+}
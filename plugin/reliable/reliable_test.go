@@ -0,0 +1,168 @@
+package reliable_test
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/sqos/yrpc"
+	"github.com/sqos/yrpc/plugin/reliable"
+	"github.com/sqos/yrpc/yrpctest"
+)
+
+type notice struct {
+	yrpc.PushCtx
+}
+
+var (
+	received = make(chan string, 10)
+	failures atomic.Int32
+)
+
+func (n *notice) Send(arg *string) *yrpc.Status {
+	if *arg == "fail-once" && failures.Add(1) == 1 {
+		return yrpc.NewStatus(500, "temporary failure")
+	}
+	received <- *arg
+	return nil
+}
+
+// pair returns the server-side session of a connection.
+func pair(t *testing.T, srvPlugin, cliPlugin []yrpc.Plugin) yrpc.Session {
+	p := yrpctest.NewPair(t, yrpctest.PairConfig{ServerPlugins: srvPlugin, ClientPlugins: cliPlugin})
+	p.Client.RoutePush(new(notice))
+	return p.ServerSession(p.Dial())
+}
+
+func TestAck(t *testing.T) {
+	sender := reliable.New(reliable.Config{RetransmitInterval: 50 * time.Millisecond})
+	sess := pair(t, []yrpc.Plugin{sender}, []yrpc.Plugin{reliable.New(reliable.Config{})})
+	defer sess.Close()
+
+	stat := sender.Push(sess, "/notice/send", "a")
+	assert.True(t, stat.OK(), stat)
+	assert.Equal(t, "a", yrpctest.Receive(t, received))
+	assert.Eventually(t, func() bool {
+		return sender.Pending(sess) == 0
+	}, time.Second, 10*time.Millisecond)
+	// acknowledged, not retransmitted
+	yrpctest.AssertNoReceive(t, received, 100*time.Millisecond)
+}
+
+func TestRetransmit(t *testing.T) {
+	failures.Store(0)
+	sender := reliable.New(reliable.Config{RetransmitInterval: 50 * time.Millisecond})
+	sess := pair(t, []yrpc.Plugin{sender}, []yrpc.Plugin{reliable.New(reliable.Config{})})
+	defer sess.Close()
+
+	arg := "fail-once"
+	stat := sender.Push(sess, "/notice/send", &arg)
+	assert.True(t, stat.OK(), stat)
+	// the retransmission sends the body encoded by Push
+	arg = "changed"
+	// the failed handling is not acknowledged
+	assert.Equal(t, "fail-once", yrpctest.Receive(t, received))
+	assert.Equal(t, int32(2), failures.Load())
+	assert.Eventually(t, func() bool {
+		return sender.Pending(sess) == 0
+	}, time.Second, 10*time.Millisecond)
+	yrpctest.AssertNoReceive(t, received, 100*time.Millisecond)
+}
+
+func TestReconnect(t *testing.T) {
+	sender := reliable.New(reliable.Config{RetransmitInterval: time.Hour})
+	srv, addr := yrpctest.NewServer(t, yrpc.PeerConfig{}, &yrpc.PluginImpl{
+		PluginName: "login",
+		OnPostAccept: func(sess yrpc.PreSession) *yrpc.Status {
+			sess.SetID("user-1")
+			return nil
+		},
+	}, sender)
+	connect := func(plugins ...yrpc.Plugin) yrpc.Session {
+		cli := yrpctest.NewClient(t, yrpc.PeerConfig{}, plugins...)
+		cli.RoutePush(new(notice))
+		return yrpctest.Dial(t, cli, addr)
+	}
+	// accepted asynchronously
+	session := func() (sess yrpc.Session) {
+		assert.Eventually(t, func() bool {
+			var ok bool
+			sess, ok = srv.GetSession("user-1")
+			return ok
+		}, time.Second, 10*time.Millisecond)
+		return sess
+	}
+
+	// the receiver without the plugin never acknowledges
+	old := connect()
+	sess := session()
+	stat := sender.Push(sess, "/notice/send", "a")
+	assert.True(t, stat.OK(), stat)
+	assert.Equal(t, "a", yrpctest.Receive(t, received))
+	old.Close()
+	assert.Eventually(t, func() bool {
+		_, ok := srv.GetSession("user-1")
+		return !ok
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, 1, sender.Pending(sess))
+
+	// the window is handed to the new session of the ID
+	connect(reliable.New(reliable.Config{}))
+	assert.Equal(t, "a", yrpctest.Receive(t, received))
+	sess = session()
+	assert.Eventually(t, func() bool {
+		return sender.Pending(sess) == 0
+	}, time.Second, 10*time.Millisecond)
+	yrpctest.AssertNoReceive(t, received, 100*time.Millisecond)
+}
+
+func TestDedupe(t *testing.T) {
+	rec := yrpctest.NewRecorder()
+	sess := pair(t, nil, []yrpc.Plugin{reliable.New(reliable.Config{}), rec})
+	defer sess.Close()
+
+	stat := sess.Push("/notice/send", "dup", yrpc.WithSetMeta(reliable.MetaMessageID, "id-1"))
+	assert.True(t, stat.OK(), stat)
+	assert.Equal(t, "dup", yrpctest.Receive(t, received))
+	// wait for the handled message to be recorded
+	time.Sleep(10 * time.Millisecond)
+	// the retransmissions after handling
+	for i := 0; i < 2; i++ {
+		stat = sess.Push("/notice/send", "dup", yrpc.WithSetMeta(reliable.MetaMessageID, "id-1"))
+		assert.True(t, stat.OK(), stat)
+	}
+	stat = sess.Push("/notice/send", "other", yrpc.WithSetMeta(reliable.MetaMessageID, "id-2"))
+	assert.True(t, stat.OK(), stat)
+	assert.Equal(t, "other", yrpctest.Receive(t, received))
+	yrpctest.AssertNoReceive(t, received, 100*time.Millisecond)
+
+	// the duplicates are skipped with OK status and acknowledged
+	assert.True(t, rec.Wait(yrpctest.HookPostHandlePush, 4, time.Second))
+	var skipped int
+	for _, e := range rec.Filter(yrpctest.HookPostHandlePush) {
+		assert.True(t, e.Status.OK(), e.Status)
+		if e.ServiceMethod == "/reliable/duplicate" {
+			skipped++
+		}
+	}
+	assert.Equal(t, 2, skipped)
+	assert.Equal(t, 4, rec.Count(yrpctest.HookPostWritePush))
+}
+
+func TestWindowFull(t *testing.T) {
+	sender := reliable.New(reliable.Config{WindowSize: 2, RetransmitInterval: time.Hour})
+	// the receiver without the plugin never acknowledges
+	sess := pair(t, []yrpc.Plugin{sender}, nil)
+	defer sess.Close()
+
+	for i := 0; i < 2; i++ {
+		stat := sender.Push(sess, "/notice/send", "x")
+		assert.True(t, stat.OK(), stat)
+		yrpctest.Receive(t, received)
+	}
+	stat := sender.Push(sess, "/notice/send", "x")
+	assert.Equal(t, reliable.CodeWindowFull, stat.Code())
+	assert.Equal(t, 2, sender.Pending(sess))
+}
@@ -0,0 +1,223 @@
+package secure_test
+
+import (
+	"bytes"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/sqos/yrpc"
+	"github.com/sqos/yrpc/plugin/secure"
+	"github.com/sqos/yrpc/socket"
+	"github.com/sqos/yrpc/yrpctest"
+)
+
+const aeadStatCode int32 = 100002
+
+// recordConn records the bytes written by the client.
+type recordConn struct {
+	net.Conn
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (c *recordConn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	c.buf.Write(b)
+	c.mu.Unlock()
+	return c.Conn.Write(b)
+}
+
+func (c *recordConn) record() []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]byte(nil), c.buf.Bytes()...)
+}
+
+// tamperPlugin modifies the metadata after it is received.
+type tamperPlugin struct{}
+
+func (tamperPlugin) Name() string { return "tamper" }
+
+func (tamperPlugin) PostReadCallHeader(ctx yrpc.ReadCtx) *yrpc.Status {
+	if len(ctx.PeekMeta("tamper")) > 0 {
+		ctx.Input().Meta().Set("tamper", "tampered")
+	}
+	return nil
+}
+
+// reseqPlugin moves the received reply to the next seq before it is decrypted.
+type reseqPlugin struct{}
+
+func (reseqPlugin) Name() string { return "reseq" }
+
+func (reseqPlugin) PostReadReplyHeader(ctx yrpc.ReadCtx) *yrpc.Status {
+	ctx.Input().SetSeq(ctx.Input().Seq() + 1)
+	return nil
+}
+
+func newAEADKeyring(t *testing.T, keys ...string) *secure.Keyring {
+	k := secure.NewKeyring()
+	for _, id := range keys {
+		alg := secure.ALG_AES_GCM
+		if id == "chacha" {
+			alg = secure.ALG_CHACHA20_POLY1305
+		}
+		if err := k.Add(id, alg, bytes.Repeat([]byte(id[:1]), 32), time.Time{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return k
+}
+
+// serveAEAD returns the server address, and the client session whose written bytes are recorded.
+func serveAEAD(t *testing.T, srvKeyring, cliKeyring *secure.Keyring, cliPlugins ...yrpc.Plugin) (string, yrpc.Session, *recordConn) {
+	srv, addr := yrpctest.NewServer(t, yrpc.PeerConfig{}, tamperPlugin{}, secure.NewAEADPlugin(aeadStatCode, srvKeyring, time.Minute))
+	srv.RouteCall(new(math))
+	cli := yrpctest.NewClient(t, yrpc.PeerConfig{}, append(cliPlugins, secure.NewAEADPlugin(aeadStatCode, cliKeyring, time.Minute))...)
+	conn, err := yrpctest.DialConn(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc := &recordConn{Conn: conn}
+	sess, stat := cli.ServeConn(rc)
+	if !stat.OK() {
+		t.Fatal(stat)
+	}
+	return addr, sess, rc
+}
+
+func TestAEADPlugin(t *testing.T) {
+	srvKeyring := newAEADKeyring(t, "k1", "chacha")
+	for _, current := range []string{"k1", "chacha"} {
+		cliKeyring := newAEADKeyring(t, "k1", "chacha")
+		assert.NoError(t, cliKeyring.Use(current))
+		_, sess, _ := serveAEAD(t, srvKeyring, cliKeyring)
+		var result Result
+		stat := sess.Call("/math/add", &Arg{A: 10, B: 2}, &result,
+			secure.WithSecureMeta(),
+			yrpc.WithAddMeta("x", "1"),
+		).Status()
+		assert.True(t, stat.OK(), stat)
+		assert.Equal(t, 12, result.C)
+
+		// accept secure reply
+		stat = sess.Call("/math/add", &Arg{A: 20, B: 4}, &result, secure.WithAcceptSecureMeta(true)).Status()
+		assert.True(t, stat.OK(), stat)
+		assert.Equal(t, 24, result.C)
+	}
+}
+
+func TestAEADKeyRotation(t *testing.T) {
+	srvKeyring := newAEADKeyring(t, "k1")
+	cliKeyring := newAEADKeyring(t, "k1", "k2")
+	assert.NoError(t, cliKeyring.Use("k2"))
+	_, sess, _ := serveAEAD(t, srvKeyring, cliKeyring)
+
+	var result Result
+	stat := sess.Call("/math/add", &Arg{A: 1, B: 2}, &result, secure.WithSecureMeta()).Status()
+	assert.Equal(t, aeadStatCode, stat.Code())
+
+	// the server learns the new key, and keeps accepting the old one until it expires
+	assert.NoError(t, srvKeyring.Add("k2", secure.ALG_AES_GCM, bytes.Repeat([]byte("k"), 32), time.Time{}))
+	stat = sess.Call("/math/add", &Arg{A: 1, B: 2}, &result, secure.WithSecureMeta()).Status()
+	assert.True(t, stat.OK(), stat)
+	assert.Equal(t, 3, result.C)
+
+	assert.NoError(t, cliKeyring.Use("k1"))
+	stat = sess.Call("/math/add", &Arg{A: 1, B: 3}, &result, secure.WithSecureMeta()).Status()
+	assert.True(t, stat.OK(), stat)
+
+	assert.NoError(t, srvKeyring.Add("k1", secure.ALG_AES_GCM, bytes.Repeat([]byte("k"), 32), time.Now().Add(-time.Second)))
+	stat = sess.Call("/math/add", &Arg{A: 1, B: 3}, &result, secure.WithSecureMeta()).Status()
+	assert.Equal(t, aeadStatCode, stat.Code())
+	assert.Contains(t, stat.Cause().Error(), "expired")
+
+	assert.Error(t, cliKeyring.Remove("k1"))
+	assert.Error(t, cliKeyring.Use("none"))
+	assert.Error(t, cliKeyring.Add("k3", "none", nil, time.Time{}))
+	assert.Error(t, cliKeyring.Add("k3", secure.ALG_CHACHA20_POLY1305, []byte("short"), time.Time{}))
+}
+
+func TestAEADTamper(t *testing.T) {
+	keyring := newAEADKeyring(t, "k1")
+	_, sess, _ := serveAEAD(t, keyring, keyring)
+	var result Result
+	stat := sess.Call("/math/add", &Arg{A: 1, B: 2}, &result,
+		secure.WithSecureMeta(),
+		yrpc.WithSetMeta("tamper", "origin"),
+	).Status()
+	assert.Equal(t, aeadStatCode, stat.Code())
+	assert.Equal(t, "decrypt ciphertext error", stat.Msg())
+}
+
+func TestAEADReseq(t *testing.T) {
+	keyring := newAEADKeyring(t, "k1")
+	_, sess, _ := serveAEAD(t, keyring, keyring, reseqPlugin{})
+	var result Result
+	stat := sess.Call("/math/add", &Arg{A: 1, B: 2}, &result).Status()
+	assert.Equal(t, aeadStatCode, stat.Code())
+	assert.Equal(t, "decrypt ciphertext error", stat.Msg())
+}
+
+func TestAEADReplay(t *testing.T) {
+	keyring := newAEADKeyring(t, "k1")
+	addr, sess, rc := serveAEAD(t, keyring, keyring)
+	var result Result
+	stat := sess.Call("/math/add", &Arg{A: 1, B: 2}, &result, secure.WithSecureMeta()).Status()
+	assert.True(t, stat.OK(), stat)
+
+	// replay the recorded call on a new connection
+	attackConn, err := yrpctest.DialConn(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer attackConn.Close()
+	go attackConn.Write(rc.record())
+	reply := socket.GetMessage()
+	defer socket.PutMessage(reply)
+	attackConn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	assert.NoError(t, socket.RawProtoFunc(attackConn).Unpack(reply))
+	assert.Equal(t, aeadStatCode, reply.Status().Code())
+	assert.Contains(t, reply.Status().Cause().Error(), "replay")
+}
+
+func TestAEADUnencrypted(t *testing.T) {
+	keyring := newAEADKeyring(t, "k1")
+	addr, sess, _ := serveAEAD(t, keyring, keyring)
+	// the body is encrypted without the secure metadata
+	var result Result
+	stat := sess.Call("/math/add", &Arg{A: 1, B: 2}, &result).Status()
+	assert.True(t, stat.OK(), stat)
+	assert.Equal(t, 3, result.C)
+
+	// the plaintext body is rejected
+	plainSess := yrpctest.Dial(t, yrpctest.NewClient(t, yrpc.PeerConfig{}), addr)
+	stat = plainSess.Call("/math/add", &Arg{A: 1, B: 2}, &result).Status()
+	assert.Equal(t, aeadStatCode, stat.Code())
+	assert.Equal(t, "unencrypted body", stat.Msg())
+}
+
+func TestAEADNoncesFull(t *testing.T) {
+	keyring := newAEADKeyring(t, "k1")
+	srv, addr := yrpctest.NewServer(t, yrpc.PeerConfig{}, secure.NewAEADPlugin(aeadStatCode, keyring, 200*time.Millisecond, 2))
+	srv.RouteCall(new(math))
+	sess := yrpctest.Dial(t, yrpctest.NewClient(t, yrpc.PeerConfig{}, secure.NewAEADPlugin(aeadStatCode, keyring, time.Minute)), addr)
+	var result Result
+	for i := 0; i < 2; i++ {
+		stat := sess.Call("/math/add", &Arg{A: 1, B: 2}, &result).Status()
+		assert.True(t, stat.OK(), stat)
+	}
+	// the nonces within the replay window are not evicted
+	stat := sess.Call("/math/add", &Arg{A: 1, B: 2}, &result).Status()
+	assert.Equal(t, aeadStatCode, stat.Code())
+	assert.Contains(t, stat.Cause().Error(), "too many")
+
+	// the expired ones make room
+	time.Sleep(250 * time.Millisecond)
+	stat = sess.Call("/math/add", &Arg{A: 1, B: 2}, &result).Status()
+	assert.True(t, stat.OK(), stat)
+}
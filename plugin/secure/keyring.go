@@ -0,0 +1,308 @@
+// Copyright 2024 sqos. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package secure
+
+import (
+	"container/heap"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/chacha20poly1305"
+
+	"github.com/sqos/yrpc"
+)
+
+const (
+	// ALG_AES_GCM AES-GCM, the key is 16, 24, or 32 bytes to select AES-128, AES-192, or AES-256.
+	ALG_AES_GCM = "aes-gcm"
+	// ALG_CHACHA20_POLY1305 ChaCha20-Poly1305, the key is 32 bytes.
+	ALG_CHACHA20_POLY1305 = "chacha20-poly1305"
+)
+
+// Keyring is a set of AEAD keys identified by key ID.
+// NOTE:
+//
+//	The sender encrypts with the current key;
+//	The receiver accepts any key in the keyring that has not expired.
+type Keyring struct {
+	mu      sync.RWMutex
+	current string
+	keys    map[string]*keyringEntry
+}
+
+type keyringEntry struct {
+	aead     cipher.AEAD
+	expireAt time.Time
+}
+
+// NewKeyring creates an empty keyring.
+func NewKeyring() *Keyring {
+	return &Keyring{keys: make(map[string]*keyringEntry)}
+}
+
+// Add adds or replaces the key of keyID.
+// NOTE:
+//
+//	algorithm is ALG_AES_GCM or ALG_CHACHA20_POLY1305;
+//	If expireAt is zero, the key never expires;
+//	The first added key becomes the current key.
+func (k *Keyring) Add(keyID string, algorithm string, key []byte, expireAt time.Time) error {
+	if keyID == "" {
+		return errors.New("secure: empty key ID")
+	}
+	var aead cipher.AEAD
+	switch algorithm {
+	case ALG_AES_GCM:
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return fmt.Errorf("secure: %v", err)
+		}
+		aead, err = cipher.NewGCM(block)
+		if err != nil {
+			return fmt.Errorf("secure: %v", err)
+		}
+	case ALG_CHACHA20_POLY1305:
+		var err error
+		aead, err = chacha20poly1305.New(key)
+		if err != nil {
+			return fmt.Errorf("secure: %v", err)
+		}
+	default:
+		return fmt.Errorf("secure: unsupported algorithm: %q", algorithm)
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.keys[keyID] = &keyringEntry{aead: aead, expireAt: expireAt}
+	if k.current == "" {
+		k.current = keyID
+	}
+	return nil
+}
+
+// Use sets the current key used for encryption.
+func (k *Keyring) Use(keyID string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if _, ok := k.keys[keyID]; !ok {
+		return fmt.Errorf("secure: key %q not found", keyID)
+	}
+	k.current = keyID
+	return nil
+}
+
+// Remove removes the key of keyID.
+// NOTE: The current key cannot be removed.
+func (k *Keyring) Remove(keyID string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if keyID == k.current {
+		return fmt.Errorf("secure: cannot remove the current key %q", keyID)
+	}
+	delete(k.keys, keyID)
+	return nil
+}
+
+// Current returns the current key ID.
+func (k *Keyring) Current() string {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.current
+}
+
+func (k *Keyring) currentKey() (string, cipher.AEAD, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	e, ok := k.keys[k.current]
+	if !ok {
+		return "", nil, errors.New("secure: no current key")
+	}
+	return k.current, e.aead, nil
+}
+
+func (k *Keyring) get(keyID string, now time.Time) (cipher.AEAD, error) {
+	k.mu.RLock()
+	e, ok := k.keys[keyID]
+	k.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown key ID: %q", keyID)
+	}
+	if !e.expireAt.IsZero() && now.After(e.expireAt) {
+		return nil, fmt.Errorf("expired key ID: %q", keyID)
+	}
+	return e.aead, nil
+}
+
+// aeadCipher encrypts the body with the keyring.
+// NOTE:
+//
+//	Cipherversion is the key ID;
+//	Ciphertext is the base64 of {8 bytes timestamp}{nonce}{sealed body};
+//	The message type, seq, service method, metadata, key ID and timestamp are the associated data,
+//	and so is the status code of the reply.
+type aeadCipher struct {
+	keyring *Keyring
+	window  time.Duration
+	nonces  nonceCache
+}
+
+var (
+	errReplay     = errors.New("replayed message")
+	errNoncesFull = errors.New("too many messages in the replay window")
+)
+
+func (a *aeadCipher) encrypt(m yrpc.Message, plaintext []byte) (*Encrypt, error) {
+	keyID, aead, err := a.keyring.currentKey()
+	if err != nil {
+		return nil, err
+	}
+	ts := time.Now().UnixNano()
+	nonceSize := aead.NonceSize()
+	b := make([]byte, 8+nonceSize, 8+nonceSize+len(plaintext)+aead.Overhead())
+	binary.BigEndian.PutUint64(b, uint64(ts))
+	nonce := b[8:]
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	b = aead.Seal(b, nonce, plaintext, associatedData(m, keyID, b[:8]))
+	return &Encrypt{
+		Cipherversion: keyID,
+		Ciphertext:    base64.RawStdEncoding.EncodeToString(b),
+	}, nil
+}
+
+func (a *aeadCipher) decrypt(m yrpc.Message, obj *Encrypt) ([]byte, error) {
+	now := time.Now()
+	keyID := obj.GetCipherversion()
+	aead, err := a.keyring.get(keyID, now)
+	if err != nil {
+		return nil, err
+	}
+	b, err := base64.RawStdEncoding.DecodeString(obj.GetCiphertext())
+	if err != nil {
+		return nil, err
+	}
+	nonceSize := aead.NonceSize()
+	if len(b) < 8+nonceSize+aead.Overhead() {
+		return nil, errors.New("ciphertext too short")
+	}
+	ts := time.Unix(0, int64(binary.BigEndian.Uint64(b)))
+	if d := now.Sub(ts); d > a.window || d < -a.window {
+		return nil, fmt.Errorf("timestamp out of window: %s", ts.Format(time.RFC3339Nano))
+	}
+	nonce := b[8 : 8+nonceSize]
+	plaintext, err := aead.Open(nil, nonce, b[8+nonceSize:], associatedData(m, keyID, b[:8]))
+	if err != nil {
+		return nil, err
+	}
+	// check replay after authentication, so that forged nonces are not cached
+	if err = a.nonces.add(keyID+string(nonce), ts, now, a.window); err != nil {
+		return nil, err
+	}
+	return plaintext, nil
+}
+
+// associatedData encodes the authenticated but not encrypted parts of the message.
+func associatedData(m yrpc.Message, keyID string, ts []byte) []byte {
+	var kvs [][2][]byte
+	m.Meta().VisitAll(func(k, v []byte) {
+		kvs = append(kvs, [2][]byte{k, v})
+	})
+	sort.SliceStable(kvs, func(i, j int) bool {
+		return string(kvs[i][0]) < string(kvs[j][0])
+	})
+	b := make([]byte, 0, 64)
+	b = append(b, m.Mtype())
+	b = binary.AppendVarint(b, int64(m.Seq()))
+	if m.Mtype() == yrpc.TypeReply {
+		b = binary.AppendVarint(b, int64(m.Status().Code()))
+	}
+	b = appendBytes(b, []byte(m.ServiceMethod()))
+	b = appendBytes(b, []byte(keyID))
+	b = append(b, ts...)
+	b = binary.AppendUvarint(b, uint64(len(kvs)))
+	for _, kv := range kvs {
+		b = appendBytes(b, kv[0])
+		b = appendBytes(b, kv[1])
+	}
+	return b
+}
+
+func appendBytes(b, s []byte) []byte {
+	b = binary.AppendUvarint(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+// nonceCache records the nonces seen within the replay window, at most max ones.
+type nonceCache struct {
+	max   int
+	mu    sync.Mutex
+	seen  map[string]struct{}
+	order nonceHeap
+}
+
+// add returns errReplay if the nonce has been seen,
+// or errNoncesFull if the cache is full of the nonces within the replay window.
+func (c *nonceCache) add(nonce string, ts, now time.Time, window time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.seen == nil {
+		c.seen = make(map[string]struct{})
+	}
+	// the expired nonces are rejected by the timestamp check
+	for len(c.order) > 0 && now.Sub(c.order[0].ts) > window {
+		delete(c.seen, heap.Pop(&c.order).(nonceEntry).nonce)
+	}
+	if _, ok := c.seen[nonce]; ok {
+		return errReplay
+	}
+	if len(c.seen) >= c.max {
+		return errNoncesFull
+	}
+	c.seen[nonce] = struct{}{}
+	heap.Push(&c.order, nonceEntry{nonce: nonce, ts: ts})
+	return nil
+}
+
+type nonceEntry struct {
+	nonce string
+	ts    time.Time
+}
+
+// nonceHeap the nonces ordered by timestamp, the oldest first
+type nonceHeap []nonceEntry
+
+func (h nonceHeap) Len() int           { return len(h) }
+func (h nonceHeap) Less(i, j int) bool { return h[i].ts.Before(h[j].ts) }
+func (h nonceHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *nonceHeap) Push(x interface{}) {
+	*h = append(*h, x.(nonceEntry))
+}
+
+func (h *nonceHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	e := old[n-1]
+	*h = old[:n-1]
+	return e
+}
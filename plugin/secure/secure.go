@@ -19,6 +19,7 @@ package secure
 import (
 	"crypto/aes"
 	"fmt"
+	"time"
 
 	"github.com/sqos/yrpc"
 	"github.com/sqos/yrpc/utils"
@@ -47,17 +48,58 @@ func NewPlugin(statCode int32, cipherkey string) yrpc.Plugin {
 	if _, err := aes.NewCipher(b); err != nil {
 		yrpc.Fatalf("secure: %v", err)
 	}
-	version := goutil.Md5([]byte(cipherkey))
+	return newSecurePlugin(statCode, &aesCipher{
+		version:   goutil.Md5([]byte(cipherkey)),
+		cipherkey: b,
+	}, false)
+}
+
+// DefaultMaxNonces the default max number of the nonces kept for the replay check of NewAEADPlugin
+const DefaultMaxNonces = 1 << 20
+
+// NewAEADPlugin creates an AEAD encryption/decryption plugin with the keyring.
+// NOTE:
+//
+//	The sender encrypts with the current key of the keyring,
+//	and the receiver accepts any key in the keyring that has not expired;
+//	The message type, seq, service method, metadata and the status code of the reply
+//	are bound as associated data, so that the sealed body cannot be moved to another message,
+//	and the plugin should be registered after the plugins that modify the output metadata;
+//	The message whose timestamp is out of replayWindow, or whose nonce has been seen, is rejected;
+//	At most maxNonces nonces are kept, and the message is rejected when they are all within replayWindow;
+//	All the call, push and reply bodies are encrypted, and the unencrypted one is rejected,
+//	except the reply with error status, which has no body;
+//	If replayWindow<=0, it is set to 5 minutes;
+//	If maxNonces is not set or <=0, it is set to DefaultMaxNonces.
+func NewAEADPlugin(statCode int32, keyring *Keyring, replayWindow time.Duration, maxNonces ...int) yrpc.Plugin {
+	if keyring == nil {
+		yrpc.Fatalf("secure: nil keyring")
+	}
+	if replayWindow <= 0 {
+		replayWindow = 5 * time.Minute
+	}
+	max := DefaultMaxNonces
+	if len(maxNonces) > 0 && maxNonces[0] > 0 {
+		max = maxNonces[0]
+	}
+	return newSecurePlugin(statCode, &aeadCipher{
+		keyring: keyring,
+		window:  replayWindow,
+		nonces:  nonceCache{max: max},
+	}, true)
+}
+
+func newSecurePlugin(statCode int32, c bodyCipher, enforce bool) *securePlugin {
 	return &securePlugin{
 		encryptPlugin: &encryptPlugin{
-			version:   version,
-			cipherkey: b,
-			statCode:  statCode,
+			cipher:   c,
+			statCode: statCode,
+			enforce:  enforce,
 		},
 		decryptPlugin: &decryptPlugin{
-			version:   version,
-			cipherkey: b,
-			statCode:  statCode,
+			cipher:   c,
+			statCode: statCode,
+			enforce:  enforce,
 		},
 	}
 }
@@ -98,11 +140,22 @@ type (
 		*decryptPlugin
 	}
 	encryptPlugin struct {
+		cipher   bodyCipher
+		statCode int32
+		// enforce is true if all the bodies are encrypted, regardless of the metadata.
+		enforce bool
+	}
+	decryptPlugin encryptPlugin
+	// bodyCipher encrypts/decrypts the message body.
+	bodyCipher interface {
+		encrypt(m yrpc.Message, plaintext []byte) (*Encrypt, error)
+		decrypt(m yrpc.Message, obj *Encrypt) ([]byte, error)
+	}
+	// aesCipher the AES-ECB cipher identified by the MD5 of the key.
+	aesCipher struct {
 		version   string
 		cipherkey []byte
-		statCode  int32
 	}
-	decryptPlugin encryptPlugin
 )
 
 var (
@@ -146,7 +199,9 @@ func (e *encryptPlugin) PreWriteCall(ctx yrpc.WriteCtx) *yrpc.Status {
 	if ctx.Status() != nil {
 		return nil
 	}
-	if !isSecure(ctx.Output().Meta()) {
+	if e.enforce {
+		EnforceSecure(ctx.Output())
+	} else if !isSecure(ctx.Output().Meta()) {
 		_, acceptSecure := ctx.Swap().Load(accept_encrypt)
 		if !acceptSecure {
 			return nil
@@ -159,11 +214,11 @@ func (e *encryptPlugin) PreWriteCall(ctx yrpc.WriteCtx) *yrpc.Status {
 	if err != nil {
 		return yrpc.NewStatus(e.statCode, "marshal raw body error", err.Error())
 	}
-	ciphertext := goutil.AESEncrypt(e.cipherkey, bodyBytes)
-	ctx.Output().SetBody(&Encrypt{
-		Cipherversion: e.version,
-		Ciphertext:    goutil.BytesToString(ciphertext),
-	})
+	obj, err := e.cipher.encrypt(ctx.Output(), bodyBytes)
+	if err != nil {
+		return yrpc.NewStatus(e.statCode, "encrypt raw body error", err.Error())
+	}
+	ctx.Output().SetBody(obj)
 	return nil
 }
 
@@ -179,6 +234,9 @@ func (e *decryptPlugin) PreReadCallBody(ctx yrpc.ReadCtx) *yrpc.Status {
 	b := ctx.PeekMeta(ACCEPT_SECURE_META_KEY)
 	accept := goutil.BytesToString(b)
 	useDecrypt := isSecure(ctx.Input().Meta())
+	if !useDecrypt && e.enforce {
+		return yrpc.NewStatus(e.statCode, "unencrypted body", "")
+	}
 	if !useDecrypt {
 		// if the metadata ACCEPT_SECURE_META_KEY is true,
 		// perform encryption operation to the body.
@@ -205,23 +263,9 @@ func (e *decryptPlugin) PostReadCallBody(ctx yrpc.ReadCtx) *yrpc.Status {
 	}
 
 	var obj = ctx.Input().Body().(*Encrypt)
-	var version = obj.GetCipherversion()
-	var bodyBytes []byte
-	var err error
-
-	if len(version) > 0 {
-		if version != e.version {
-			return yrpc.NewStatus(
-				e.statCode,
-				"decrypt ciphertext error",
-				fmt.Sprintf("inconsistent encryption version, get:%q, want:%q", obj.GetCipherversion(), e.version),
-			)
-		}
-		ciphertext := obj.GetCiphertext()
-		bodyBytes, err = goutil.AESDecrypt(e.cipherkey, goutil.StringToBytes(ciphertext))
-		if err != nil {
-			return yrpc.NewStatus(e.statCode, "decrypt ciphertext error", err.Error())
-		}
+	bodyBytes, err := e.cipher.decrypt(ctx.Input(), obj)
+	if err != nil {
+		return yrpc.NewStatus(e.statCode, "decrypt ciphertext error", err.Error())
 	}
 
 	ctx.Swap().Delete(encrypt_rawbody)
@@ -234,6 +278,10 @@ func (e *decryptPlugin) PostReadCallBody(ctx yrpc.ReadCtx) *yrpc.Status {
 }
 
 func (e *decryptPlugin) PreReadReplyBody(ctx yrpc.ReadCtx) *yrpc.Status {
+	// the reply with error status is not encrypted
+	if e.enforce && !ctx.Input().StatusOK() {
+		return nil
+	}
 	return e.PreReadCallBody(ctx)
 }
 
@@ -248,3 +296,22 @@ func (e *decryptPlugin) PreReadPushBody(ctx yrpc.ReadCtx) *yrpc.Status {
 func (e *decryptPlugin) PostReadPushBody(ctx yrpc.ReadCtx) *yrpc.Status {
 	return e.PostReadCallBody(ctx)
 }
+
+func (a *aesCipher) encrypt(_ yrpc.Message, plaintext []byte) (*Encrypt, error) {
+	ciphertext := goutil.AESEncrypt(a.cipherkey, plaintext)
+	return &Encrypt{
+		Cipherversion: a.version,
+		Ciphertext:    goutil.BytesToString(ciphertext),
+	}, nil
+}
+
+func (a *aesCipher) decrypt(_ yrpc.Message, obj *Encrypt) ([]byte, error) {
+	version := obj.GetCipherversion()
+	if len(version) == 0 {
+		return nil, nil
+	}
+	if version != a.version {
+		return nil, fmt.Errorf("inconsistent encryption version, get:%q, want:%q", version, a.version)
+	}
+	return goutil.AESDecrypt(a.cipherkey, goutil.StringToBytes(obj.GetCiphertext()))
+}
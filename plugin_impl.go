@@ -24,10 +24,13 @@ var (
 	_ PostReadPushHeaderPlugin  = (*PluginImpl)(nil)
 	_ PreReadPushBodyPlugin     = (*PluginImpl)(nil)
 	_ PostReadPushBodyPlugin    = (*PluginImpl)(nil)
+	_ PostHandlePushPlugin      = (*PluginImpl)(nil)
 	_ PostReadReplyHeaderPlugin = (*PluginImpl)(nil)
 	_ PreReadReplyBodyPlugin    = (*PluginImpl)(nil)
 	_ PostReadReplyBodyPlugin   = (*PluginImpl)(nil)
 	_ PostDisconnectPlugin      = (*PluginImpl)(nil)
+	_ PostAddPlugin             = (*PluginImpl)(nil)
+	_ PostEvictPlugin           = (*PluginImpl)(nil)
 )
 
 // PluginImpl implemented all plug-in interfaces.
@@ -74,6 +77,8 @@ type PluginImpl struct {
 	OnPreReadPushBody func(ReadCtx) *Status
 	// OnPostReadPushBody is called after a push body is read.
 	OnPostReadPushBody func(ReadCtx) *Status
+	// OnPostHandlePush is called after a push handler finishes.
+	OnPostHandlePush func(ReadCtx) *Status
 	// OnPostReadReplyHeader is called after a reply header is read.
 	OnPostReadReplyHeader func(ReadCtx) *Status
 	// OnPreReadReplyBody is called before a reply body is read.
@@ -82,6 +87,10 @@ type PluginImpl struct {
 	OnPostReadReplyBody func(ReadCtx) *Status
 	// OnPostDisconnect is called after a session is disconnected.
 	OnPostDisconnect func(BaseSession) *Status
+	// OnPostAdd is called after a session is added to the session hub.
+	OnPostAdd func(Session) *Status
+	// OnPostEvict is called after a session is evicted for the duplicate session ID.
+	OnPostEvict func(BaseSession, *Status) *Status
 }
 
 // Name returns the name of the plugin.
@@ -249,6 +258,14 @@ func (p *PluginImpl) PostReadPushBody(readCtx ReadCtx) *Status {
 	return p.OnPostReadPushBody(readCtx)
 }
 
+// PostHandlePush is called after a push handler finishes.
+func (p *PluginImpl) PostHandlePush(readCtx ReadCtx) *Status {
+	if p.OnPostHandlePush == nil {
+		return nil
+	}
+	return p.OnPostHandlePush(readCtx)
+}
+
 // PostReadReplyHeader is called after a reply header is read.
 func (p *PluginImpl) PostReadReplyHeader(readCtx ReadCtx) *Status {
 	if p.OnPostReadReplyHeader == nil {
@@ -280,3 +297,19 @@ func (p *PluginImpl) PostDisconnect(sess BaseSession) *Status {
 	}
 	return p.OnPostDisconnect(sess)
 }
+
+// PostAdd is called after a session is added to the session hub.
+func (p *PluginImpl) PostAdd(sess Session) *Status {
+	if p.OnPostAdd == nil {
+		return nil
+	}
+	return p.OnPostAdd(sess)
+}
+
+// PostEvict is called after a session is evicted for the duplicate session ID.
+func (p *PluginImpl) PostEvict(sess BaseSession, reason *Status) *Status {
+	if p.OnPostEvict == nil {
+		return nil
+	}
+	return p.OnPostEvict(sess, reason)
+}
@@ -0,0 +1,358 @@
+// Copyright 2024 sqos. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httproto
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sqos/goutil"
+
+	"github.com/sqos/yrpc"
+	"github.com/sqos/yrpc/codec"
+	"github.com/sqos/yrpc/socket"
+)
+
+// Gateway is a net/http handler that dispatches REST requests into the router of a peer.
+// NOTE:
+//
+//	The URL path (without the prefix) is used as the service method;
+//	Only the request headers of the metadata keys set by SetMetaKeys are used as the metadata,
+//	and only the reply metadata of them is written to the response headers;
+//	The Content-Type header selects the body codec, the Accept header selects the reply body codec;
+//	Set the X-Mtype header to 3 to send a PUSH message, otherwise a CALL message is sent;
+//	The request body is read no more than the read limit of the peer, the larger one is answered with 413;
+//	The handlers and plugins are the same as those of the socket sessions,
+//	but the PostAccept and PostDisconnect plugins are not executed.
+type Gateway struct {
+	peer             yrpc.Peer
+	pathPrefix       string
+	mu               sync.RWMutex
+	defaultBodyCodec byte
+	statusCodeMapper func(*yrpc.Status) int
+	// metaKeys maps the canonical header name to the metadata key.
+	metaKeys  map[string]string
+	queryMeta bool
+}
+
+var _ http.Handler = new(Gateway)
+
+// NewGateway creates a net/http gateway of the peer.
+// NOTE: If pathPrefix is not empty, it is stripped from the URL path.
+func NewGateway(peer yrpc.Peer, pathPrefix ...string) *Gateway {
+	g := &Gateway{
+		peer:             peer,
+		defaultBodyCodec: codec.ID_JSON,
+		statusCodeMapper: StatusCodeMapper,
+	}
+	g.SetMetaKeys(yrpc.MetaRetryAfter)
+	if len(pathPrefix) > 0 {
+		g.pathPrefix = strings.TrimSuffix(pathPrefix[0], "/")
+	}
+	return g
+}
+
+// SetDefaultBodyCodec sets the body codec used when the request has no known Content-Type.
+func (g *Gateway) SetDefaultBodyCodec(codecID byte) {
+	g.mu.Lock()
+	g.defaultBodyCodec = codecID
+	g.mu.Unlock()
+}
+
+// SetStatusCodeMapper sets the function that maps the reply status to HTTP status code.
+func (g *Gateway) SetStatusCodeMapper(fn func(*yrpc.Status) int) {
+	if fn == nil {
+		fn = StatusCodeMapper
+	}
+	g.mu.Lock()
+	g.statusCodeMapper = fn
+	g.mu.Unlock()
+}
+
+// SetMetaKeys sets the metadata keys exchanged with the HTTP headers, default is [yrpc.MetaRetryAfter].
+// NOTE:
+//
+//	The header name is case-insensitive, and the metadata is stored with the exact key, e.g. "X-Real-IP";
+//	The other headers are not used, e.g. the hop-by-hop and credential headers such as Connection and Authorization;
+//	Do not set the keys trusted by the handlers and plugins, e.g. yrpc.MetaRealIP and the overloader X-Priority,
+//	unless the gateway is behind a proxy that overwrites them.
+func (g *Gateway) SetMetaKeys(metaKeys ...string) {
+	m := make(map[string]string, len(metaKeys))
+	for _, k := range metaKeys {
+		m[http.CanonicalHeaderKey(k)] = k
+	}
+	g.mu.Lock()
+	g.metaKeys = m
+	g.mu.Unlock()
+}
+
+// SetQueryMeta sets whether the URL query parameters of the metadata keys are used as the metadata, default is false.
+// NOTE: The query parameter is matched by the exact metadata key, and is added after the header.
+func (g *Gateway) SetQueryMeta(enable bool) {
+	g.mu.Lock()
+	g.queryMeta = enable
+	g.mu.Unlock()
+}
+
+func (g *Gateway) getMetaKeys() (map[string]string, bool) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return g.metaKeys, g.queryMeta
+}
+
+func (g *Gateway) getDefaultBodyCodec() byte {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return g.defaultBodyCodec
+}
+
+func (g *Gateway) statusCode(stat *yrpc.Status) int {
+	g.mu.RLock()
+	fn := g.statusCodeMapper
+	g.mu.RUnlock()
+	return fn(stat)
+}
+
+// StatusCodeMapper is the default mapping of the reply status to HTTP status code.
+// NOTE:
+//
+//	Codes in the range [400,599] are used as is;
+//	Sender peer errors [100,199] are mapped to 502 Bad Gateway;
+//	Other codes are mapped to 500 Internal Server Error.
+func StatusCodeMapper(stat *yrpc.Status) int {
+	code := stat.Code()
+	switch {
+	case code == yrpc.CodeOK:
+		return http.StatusOK
+	case code >= 400 && code <= 599:
+		return int(code)
+	case code >= 100 && code <= 199:
+		return http.StatusBadGateway
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// ServeHTTP implements http.Handler.
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	serviceMethod := r.URL.Path
+	if g.pathPrefix != "" {
+		if !strings.HasPrefix(serviceMethod, g.pathPrefix) {
+			g.writeStatus(w, yrpc.NewStatus(yrpc.CodeNotFound, yrpc.CodeText(yrpc.CodeNotFound), serviceMethod))
+			return
+		}
+		serviceMethod = serviceMethod[len(g.pathPrefix):]
+	}
+	gp := &gatewayProto{
+		gateway:       g,
+		w:             w,
+		r:             r,
+		serviceMethod: serviceMethod,
+	}
+	stat := g.peer.ServeMessage(newGatewayConn(r), func(yrpc.IOWithReadBuffer) yrpc.Proto {
+		return gp
+	})
+	if gp.written {
+		return
+	}
+	if !stat.OK() {
+		g.writeStatus(w, stat)
+		return
+	}
+	// PUSH has no reply
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (g *Gateway) writeStatus(w http.ResponseWriter, stat *yrpc.Status) error {
+	statBytes, _ := stat.MarshalJSON()
+	header := w.Header()
+	header.Set("Content-Type", "application/json;charset=utf-8")
+	header.Set("Content-Length", strconv.Itoa(len(statBytes)))
+	w.WriteHeader(g.statusCode(stat))
+	_, err := w.Write(statBytes)
+	return err
+}
+
+// gatewayProto reads the message from the HTTP request and writes the reply to the HTTP response.
+type gatewayProto struct {
+	gateway       *Gateway
+	w             http.ResponseWriter
+	r             *http.Request
+	serviceMethod string
+	written       bool
+}
+
+var errGatewayWritten = errors.New("HTTP response has been written")
+
+// Version returns the protocol's id and name.
+func (gp *gatewayProto) Version() (byte, string) {
+	return 'h', "http-gateway"
+}
+
+// Pack writes the reply message into the HTTP response.
+func (gp *gatewayProto) Pack(m yrpc.Message) error {
+	if gp.written {
+		return errGatewayWritten
+	}
+	switch m.Mtype() {
+	case yrpc.TypeReply, yrpc.TypeAuthReply:
+	default:
+		return fmt.Errorf("unsupport message type: %d(%s)", m.Mtype(), yrpc.TypeText(m.Mtype()))
+	}
+	if m.XferPipe().Len() > 0 {
+		return fmt.Errorf("unsupport xfer filter: %v", m.XferPipe().Names())
+	}
+	header := gp.w.Header()
+	metaKeys, _ := gp.gateway.getMetaKeys()
+	m.Meta().VisitAll(func(k, v []byte) {
+		if _, ok := metaKeys[http.CanonicalHeaderKey(goutil.BytesToString(k))]; ok {
+			header.Add(goutil.BytesToString(k), goutil.BytesToString(v))
+		}
+	})
+	gp.written = true
+	if stat := m.Status(); !stat.OK() {
+		return gp.gateway.writeStatus(gp.w, stat)
+	}
+	bodyBytes, err := m.MarshalBody()
+	if err != nil {
+		gp.written = false
+		return err
+	}
+	header.Set("Content-Type", GetContentType(m.BodyCodec(), "text/plain;charset=utf-8"))
+	header.Set("Content-Length", strconv.Itoa(len(bodyBytes)))
+	gp.w.WriteHeader(http.StatusOK)
+	m.SetSize(uint32(len(bodyBytes)))
+	_, err = gp.w.Write(bodyBytes)
+	return err
+}
+
+// Unpack reads the HTTP request to the message.
+func (gp *gatewayProto) Unpack(m yrpc.Message) error {
+	r := gp.r
+	yrpc.WithContext(r.Context())(m)
+	if r.Header.Get("X-Mtype") == strconv.Itoa(int(yrpc.TypePush)) {
+		m.SetMtype(yrpc.TypePush)
+	} else {
+		m.SetMtype(yrpc.TypeCall)
+	}
+	if seq := r.Header.Get("X-Seq"); seq != "" {
+		if n, err := strconv.ParseInt(seq, 10, 32); err == nil {
+			m.SetSeq(int32(n))
+		}
+	}
+	m.SetServiceMethod(gp.serviceMethod)
+	metaKeys, queryMeta := gp.gateway.getMetaKeys()
+	var query url.Values
+	if queryMeta && r.URL.RawQuery != "" {
+		query = r.URL.Query()
+	}
+	for name, key := range metaKeys {
+		for _, v := range r.Header[name] {
+			m.Meta().Add(key, v)
+		}
+		for _, v := range query[key] {
+			m.Meta().Add(key, v)
+		}
+	}
+	if len(m.Meta().Peek(yrpc.MetaAcceptBodyCodec)) == 0 {
+		if accept := GetBodyCodec(r.Header.Get("Accept"), codec.NilCodecID); accept != codec.NilCodecID {
+			yrpc.WithAcceptBodyCodec(accept)(m)
+		}
+	}
+	m.SetBodyCodec(GetBodyCodec(r.Header.Get("Content-Type"), gp.gateway.getDefaultBodyCodec()))
+
+	// the body is read no more than the read limit
+	limit := int64(socket.ReadLimitOf(m))
+	if limit == 0 {
+		limit = int64(socket.MessageSizeLimit())
+	}
+	var (
+		bodyBytes []byte
+		err       error
+	)
+	if r.ContentLength > limit {
+		err = socket.ErrExceedMessageSizeLimit
+	} else if bodyBytes, err = io.ReadAll(io.LimitReader(r.Body, limit+1)); err == nil && int64(len(bodyBytes)) > limit {
+		err = socket.ErrExceedMessageSizeLimit
+	}
+	if err != nil {
+		oversized := errors.Is(err, socket.ErrExceedMessageSizeLimit)
+		if oversized {
+			// the handler binding answers the size exceeding the read limit
+			m.SetSize(uint32(limit + 1))
+		}
+		// binds the handler so that the error can be replied
+		m.UnmarshalBody(nil)
+		if oversized && m.Body() == nil {
+			return nil
+		}
+		return err
+	}
+	m.SetSize(uint32(len(bodyBytes)))
+	return m.UnmarshalBody(bodyBytes)
+}
+
+// gatewayConn is a placeholder connection carrying the addresses of the HTTP request.
+type gatewayConn struct {
+	localAddr, remoteAddr net.Addr
+}
+
+func newGatewayConn(r *http.Request) net.Conn {
+	c := &gatewayConn{
+		localAddr:  gatewayAddr(r.Host),
+		remoteAddr: gatewayAddr(r.RemoteAddr),
+	}
+	if addr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+		c.localAddr = addr
+	}
+	if r.TLS != nil {
+		return &gatewayTLSConn{gatewayConn: c, state: r.TLS}
+	}
+	return c
+}
+
+// gatewayTLSConn is a placeholder connection carrying the TLS connection state of the HTTP request.
+type gatewayTLSConn struct {
+	*gatewayConn
+	state *tls.ConnectionState
+}
+
+// ConnectionState returns basic TLS details about the connection.
+func (c *gatewayTLSConn) ConnectionState() tls.ConnectionState {
+	return *c.state
+}
+
+func (c *gatewayConn) Read([]byte) (int, error)         { return 0, io.EOF }
+func (c *gatewayConn) Write([]byte) (int, error)        { return 0, errGatewayWritten }
+func (c *gatewayConn) Close() error                     { return nil }
+func (c *gatewayConn) LocalAddr() net.Addr              { return c.localAddr }
+func (c *gatewayConn) RemoteAddr() net.Addr             { return c.remoteAddr }
+func (c *gatewayConn) SetDeadline(time.Time) error      { return nil }
+func (c *gatewayConn) SetReadDeadline(time.Time) error  { return nil }
+func (c *gatewayConn) SetWriteDeadline(time.Time) error { return nil }
+
+type gatewayAddr string
+
+func (a gatewayAddr) Network() string { return "tcp" }
+func (a gatewayAddr) String() string  { return string(a) }
@@ -0,0 +1,144 @@
+package httproto_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/sqos/yrpc"
+	"github.com/sqos/yrpc/codec"
+	"github.com/sqos/yrpc/proto/httproto"
+)
+
+type GwHome struct {
+	yrpc.CallCtx
+}
+
+func (h *GwHome) Echo(arg *map[string]string) (map[string]string, *yrpc.Status) {
+	h.SetMeta("X-Trace", string(h.PeekMeta("X-Trace")))
+	h.SetMeta("X-Internal", "secret")
+	(*arg)["peer_id"] = string(h.PeekMeta("peer_id"))
+	if ip := h.PeekMeta(yrpc.MetaRealIP); len(ip) > 0 {
+		(*arg)["real_ip"] = string(ip)
+	}
+	return *arg, nil
+}
+
+func (h *GwHome) Deny(*map[string]string) (interface{}, *yrpc.Status) {
+	return nil, yrpc.NewStatus(yrpc.CodeUnauthorized, "denied", nil)
+}
+
+type GwNotice struct {
+	yrpc.PushCtx
+}
+
+var gwNotice = make(chan string, 1)
+
+func (n *GwNotice) Notify(arg *string) *yrpc.Status {
+	gwNotice <- *arg
+	return nil
+}
+
+func TestGateway(t *testing.T) {
+	srv := yrpc.NewPeer(yrpc.PeerConfig{})
+	srv.RouteCall(new(GwHome))
+	srv.RoutePush(new(GwNotice))
+	gw := httproto.NewGateway(srv, "/api")
+	gw.SetMetaKeys("X-Trace", "peer_id")
+	gw.SetQueryMeta(true)
+
+	do := func(method, target, contentType, body string, header ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		w := httptest.NewRecorder()
+		gw.ServeHTTP(w, req)
+		return w
+	}
+
+	w := do(http.MethodPost, "/api/gw_home/echo?peer_id=110", "application/json", `{"author":"andeya"}`, "X-Trace", "t1")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"author":"andeya","peer_id":"110"}`, w.Body.String())
+	assert.Equal(t, "t1", w.Header().Get("X-Trace"))
+	assert.Empty(t, w.Header().Get("X-Internal"))
+	assert.True(t, strings.HasPrefix(w.Header().Get("Content-Type"), "application/json"))
+
+	msgpackBody, _ := codec.Marshal(codec.ID_MSGPACK, map[string]string{"author": "andeya"})
+	w = do(http.MethodPost, "/api/gw_home/echo", "application/msgpack", string(msgpackBody))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/msgpack", w.Header().Get("Content-Type"))
+	var reply map[string]string
+	assert.NoError(t, codec.Unmarshal(codec.ID_MSGPACK, w.Body.Bytes(), &reply))
+	assert.Equal(t, map[string]string{"author": "andeya", "peer_id": ""}, reply)
+
+	w = do(http.MethodPost, "/api/gw_home/deny", "application/json", `{}`)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Contains(t, w.Body.String(), "denied")
+
+	w = do(http.MethodPost, "/api/gw_home/none", "application/json", `{}`)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = do(http.MethodPost, "/other/gw_home/echo", "application/json", `{}`)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = do(http.MethodPost, "/api/gw_home/echo", "application/json", `{bad json`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = do(http.MethodPost, "/api/gw_notice/notify", "application/json", `"hello"`, "X-Mtype", "3")
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, "hello", <-gwNotice)
+
+	w = do(http.MethodPost, "/api/gw_notice/none", "application/json", `"hello"`, "X-Mtype", "3")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	// the keys not set are not used as the metadata
+	w = do(http.MethodPost, "/api/gw_home/echo?X-Real-IP=10.0.0.1", "application/json", `{}`, "X-Real-Ip", "10.0.0.2")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"peer_id":""}`, w.Body.String())
+
+	// the exact key is restored from the header
+	gw.SetMetaKeys(yrpc.MetaRealIP)
+	gw.SetQueryMeta(false)
+	w = do(http.MethodPost, "/api/gw_home/echo?peer_id=110", "application/json", `{}`, "X-Real-Ip", "10.0.0.2")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"peer_id":"","real_ip":"10.0.0.2"}`, w.Body.String())
+	assert.Empty(t, w.Header().Get("X-Trace"))
+}
+
+func TestStatusCodeMapper(t *testing.T) {
+	assert.Equal(t, http.StatusOK, httproto.StatusCodeMapper(nil))
+	assert.Equal(t, http.StatusNotFound, httproto.StatusCodeMapper(yrpc.NewStatus(yrpc.CodeNotFound, "", nil)))
+	assert.Equal(t, http.StatusBadGateway, httproto.StatusCodeMapper(yrpc.NewStatus(yrpc.CodeConnClosed, "", nil)))
+	assert.Equal(t, http.StatusInternalServerError, httproto.StatusCodeMapper(yrpc.NewStatus(1001, "", nil)))
+}
+
+func TestGatewayReadLimit(t *testing.T) {
+	srv := yrpc.NewPeer(yrpc.PeerConfig{ReadLimit: 32})
+	srv.RouteCall(new(GwHome))
+	gw := httproto.NewGateway(srv)
+	data := `{"author":"` + strings.Repeat("a", 64) + `"}`
+
+	req := httptest.NewRequest(http.MethodPost, "/gw_home/echo", strings.NewReader(data))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	gw.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+
+	// the body without Content-Length is read no more than the read limit
+	body := strings.NewReader(data)
+	req = httptest.NewRequest(http.MethodPost, "/gw_home/echo", io.NopCloser(body))
+	req.ContentLength = -1
+	req.Header.Set("Content-Type", "application/json")
+	w = httptest.NewRecorder()
+	gw.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	assert.Equal(t, len(data)-33, body.Len())
+}
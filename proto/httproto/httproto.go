@@ -43,6 +43,9 @@ var (
 		"application/x-www-form-urlencoded": codec.ID_FORM,
 		"text/plain":                        codec.ID_PLAIN,
 		"text/xml":                          codec.ID_XML,
+		"application/msgpack":               codec.ID_MSGPACK,
+		"application/x-msgpack":             codec.ID_MSGPACK,
+		"application/cbor":                  codec.ID_CBOR,
 	}
 	contentTypeMapping = map[byte]string{
 		codec.ID_PROTOBUF: "application/x-protobuf;charset=utf-8",
@@ -50,6 +53,8 @@ var (
 		codec.ID_FORM:     "application/x-www-form-urlencoded;charset=utf-8",
 		codec.ID_PLAIN:    "text/plain;charset=utf-8",
 		codec.ID_XML:      "text/xml;charset=utf-8",
+		codec.ID_MSGPACK:  "application/msgpack",
+		codec.ID_CBOR:     "application/cbor",
 	}
 )
 
@@ -136,6 +136,9 @@ func (j *jsonproto) Unpack(m yrpc.Message) error {
 	}
 	bb := utils.AcquireByteBuffer()
 	defer utils.ReleaseByteBuffer(bb)
+	if err = yrpc.CheckReadLimit(m); err != nil {
+		return j.readOversized(bb, m, err)
+	}
 	bb.ChangeLen(int(m.Size()))
 	_, err = io.ReadFull(j.rw, bb.B)
 	if err != nil {
@@ -161,6 +164,15 @@ func (j *jsonproto) Unpack(m yrpc.Message) error {
 	s := string(bb.B)
 
 	// read other
+	readHeader(s, m)
+
+	// read body
+	body := gjson.Get(s, "body").String()
+	err = m.UnmarshalBody(goutil.StringToBytes(body))
+	return err
+}
+
+func readHeader(s string, m yrpc.Message) {
 	m.SetSeq(int32(gjson.Get(s, "seq").Int()))
 	m.SetMtype(byte(gjson.Get(s, "mtype").Int()))
 	m.SetServiceMethod(gjson.Get(s, "serviceMethod").String())
@@ -168,10 +180,41 @@ func (j *jsonproto) Unpack(m yrpc.Message) error {
 	m.Status(true).DecodeQuery(goutil.StringToBytes(stat))
 	meta := gjson.Get(s, "meta").String()
 	m.Meta().ParseBytes(goutil.StringToBytes(meta))
-
-	// read body
 	m.SetBodyCodec(byte(gjson.Get(s, "bodyCodec").Int()))
-	body := gjson.Get(s, "body").String()
-	err = m.UnmarshalBody(goutil.StringToBytes(body))
+}
+
+// readOversized reads the message exceeding the read limit.
+// NOTE:
+//
+//	The header in the first bytes within the read limit is decoded and bound,
+//	if no body is bound, e.g. the message is answered with CodePayloadTooLarge, the rest is discarded without buffering;
+//	Otherwise, or if the message has transfer pipe, or if the header exceeds the read limit, readLimitErr is returned.
+func (j *jsonproto) readOversized(bb *utils.ByteBuffer, m yrpc.Message, readLimitErr error) error {
+	bb.ChangeLen(1)
+	if _, err := io.ReadFull(j.rw, bb.B); err != nil {
+		return err
+	}
+	if bb.B[0] > 0 {
+		return readLimitErr
+	}
+	lastSize := int64(m.Size()) - 1
+	bb.ChangeLen(int(min(lastSize, int64(yrpc.ReadLimitOf(m)))))
+	if _, err := io.ReadFull(j.rw, bb.B); err != nil {
+		return err
+	}
+	// the body is the last field, and the quotes in the header strings are escaped
+	headerLen := bytes.Index(bb.B, msg7)
+	if headerLen < 0 {
+		return readLimitErr
+	}
+	readHeader(string(bb.B[:headerLen])+"}", m)
+	// bind the body
+	if err := m.UnmarshalBody(nil); err != nil {
+		return err
+	}
+	if m.Body() != nil {
+		return readLimitErr
+	}
+	_, err := io.CopyN(io.Discard, j.rw, lastSize-int64(len(bb.B)))
 	return err
 }
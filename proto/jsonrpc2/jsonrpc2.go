@@ -0,0 +1,446 @@
+// Package jsonrpc2 is implemented JSON-RPC 2.0 socket communication protocol.
+//
+// Copyright 2024 sqos. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package jsonrpc2
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+
+	"github.com/sqos/goutil"
+
+	"github.com/sqos/yrpc"
+	"github.com/sqos/yrpc/codec"
+	"github.com/sqos/yrpc/socket"
+)
+
+// JSON-RPC 2.0 error codes.
+const (
+	CodeParseError     int32 = -32700
+	CodeInvalidRequest int32 = -32600
+	CodeMethodNotFound int32 = -32601
+	CodeInvalidParams  int32 = -32602
+	CodeInternalError  int32 = -32603
+)
+
+// Version is the JSON-RPC protocol version.
+const Version = "2.0"
+
+// NewJSONRPC2ProtoFunc is creation function of JSON-RPC 2.0 socket protocol,
+// the messages are delimited by newline.
+//
+//	Message data demo: {"jsonrpc":"2.0","method":"/home/test","params":{"author":"andeya"},"id":1}\n
+func NewJSONRPC2ProtoFunc() yrpc.ProtoFunc {
+	return func(rw yrpc.IOWithReadBuffer) yrpc.Proto {
+		return newJSONRPC2(rw, true)
+	}
+}
+
+// NewJSONRPC2SubProtoFunc is creation function of JSON-RPC 2.0 protocol
+// used as websocket sub-protocol, one websocket message is one JSON-RPC message.
+func NewJSONRPC2SubProtoFunc() yrpc.ProtoFunc {
+	return func(rw yrpc.IOWithReadBuffer) yrpc.Proto {
+		return newJSONRPC2(rw, false)
+	}
+}
+
+func newJSONRPC2(rw yrpc.IOWithReadBuffer, lineDelimited bool) *jsonrpc2 {
+	j := &jsonrpc2{
+		id:            'r',
+		name:          "jsonrpc2",
+		rw:            rw,
+		lineDelimited: lineDelimited,
+		pending:       make(map[int32]*pendingCall),
+	}
+	if lineDelimited {
+		j.r = bufio.NewReader(rw)
+	}
+	return j
+}
+
+type jsonrpc2 struct {
+	rw            yrpc.IOWithReadBuffer
+	r             *bufio.Reader
+	rMu           sync.Mutex
+	wMu           sync.Mutex
+	queue         []*item
+	pending       map[int32]*pendingCall
+	seq           int32
+	name          string
+	id            byte
+	lineDelimited bool
+}
+
+type (
+	// item is one JSON-RPC request or response object.
+	item struct {
+		Version string          `json:"jsonrpc"`
+		Method  string          `json:"method,omitempty"`
+		Params  json.RawMessage `json:"params,omitempty"`
+		Result  json.RawMessage `json:"result,omitempty"`
+		Error   *itemError      `json:"error,omitempty"`
+		Meta    string          `json:"meta,omitempty"`
+		ID      json.RawMessage `json:"id,omitempty"`
+		// invalid is the JSON-RPC error code of an invalid request.
+		invalid int32
+		batch   *batch
+		index   int
+	}
+	itemError struct {
+		Code    int32       `json:"code"`
+		Message string      `json:"message"`
+		Data    interface{} `json:"data,omitempty"`
+	}
+	// pendingCall is a received request waiting for reply.
+	pendingCall struct {
+		id      json.RawMessage
+		invalid int32
+		batch   *batch
+		index   int
+	}
+	// batch collects the replies of a batch request.
+	batch struct {
+		replies [][]byte
+		remain  int
+	}
+)
+
+var (
+	nullID        = json.RawMessage("null")
+	nullResult    = json.RawMessage("null")
+	errNotJSONRPC = errors.New("jsonrpc2: not a JSON-RPC 2.0 message")
+)
+
+// Version returns the protocol's id and name.
+func (j *jsonrpc2) Version() (byte, string) {
+	return j.id, j.name
+}
+
+// Pack writes the Message into the connection.
+// NOTE: Make sure to write only once or there will be package contamination!
+func (j *jsonrpc2) Pack(m yrpc.Message) error {
+	if m.XferPipe().Len() > 0 {
+		return fmt.Errorf("jsonrpc2: unsupport xfer filter: %v", m.XferPipe().Names())
+	}
+	it := &item{Version: Version}
+	if m.Meta().Len() > 0 {
+		it.Meta = goutil.BytesToString(m.Meta().QueryString())
+	}
+	var (
+		p   *pendingCall
+		err error
+	)
+	switch m.Mtype() {
+	case yrpc.TypeCall, yrpc.TypePush:
+		if m.Mtype() == yrpc.TypeCall {
+			it.ID = json.RawMessage(strconv.FormatInt(int64(m.Seq()), 10))
+		}
+		it.Method = m.ServiceMethod()
+		if it.Params, err = marshalBody(m); err != nil {
+			return err
+		}
+	case yrpc.TypeReply:
+		j.wMu.Lock()
+		p = j.pending[m.Seq()]
+		delete(j.pending, m.Seq())
+		j.wMu.Unlock()
+		if p != nil {
+			it.ID = p.id
+		} else {
+			it.ID = json.RawMessage(strconv.FormatInt(int64(m.Seq()), 10))
+		}
+		if stat := m.Status(); !stat.OK() {
+			it.Error = &itemError{
+				Code:    toJSONRPCCode(stat.Code()),
+				Message: stat.Msg(),
+			}
+			if p != nil && p.invalid != 0 {
+				it.Error.Code = p.invalid
+			}
+			if cause := stat.Cause(); cause != nil {
+				it.Error.Data = cause.Error()
+			}
+		} else {
+			it.Result, err = marshalBody(m)
+		}
+	default:
+		return fmt.Errorf("jsonrpc2: unsupport message type: %d(%s)", m.Mtype(), yrpc.TypeText(m.Mtype()))
+	}
+	var b []byte
+	if err == nil {
+		b, err = json.Marshal(it)
+	}
+	if err != nil {
+		if p == nil || p.batch == nil {
+			return err
+		}
+		// the error object takes the place of the reply, so the batch is still written
+		b, _ = json.Marshal(&item{
+			Version: Version,
+			Error:   &itemError{Code: CodeInternalError, Message: "marshal reply error", Data: err.Error()},
+			ID:      it.ID,
+		})
+	}
+	m.SetSize(uint32(len(b)))
+	j.wMu.Lock()
+	defer j.wMu.Unlock()
+	if p != nil && p.batch != nil {
+		p.batch.replies[p.index] = b
+		p.batch.remain--
+		if p.batch.remain > 0 {
+			return nil
+		}
+		b = joinBatch(p.batch.replies)
+	}
+	return j.write(b)
+}
+
+func (j *jsonrpc2) write(b []byte) error {
+	if j.lineDelimited {
+		b = append(b, '\n')
+	}
+	_, err := j.rw.Write(b)
+	return err
+}
+
+func marshalBody(m yrpc.Message) (json.RawMessage, error) {
+	if m.BodyCodec() == codec.NilCodecID {
+		m.SetBodyCodec(codec.ID_JSON)
+	}
+	b, err := m.MarshalBody()
+	if err != nil {
+		return nil, err
+	}
+	if len(b) == 0 {
+		return nil, nil
+	}
+	return b, nil
+}
+
+func joinBatch(replies [][]byte) []byte {
+	var bb bytes.Buffer
+	bb.WriteByte('[')
+	var n int
+	for _, b := range replies {
+		if b == nil {
+			continue
+		}
+		if n > 0 {
+			bb.WriteByte(',')
+		}
+		bb.Write(b)
+		n++
+	}
+	bb.WriteByte(']')
+	return bb.Bytes()
+}
+
+// Unpack reads bytes from the connection to the Message.
+// NOTE: A batch is read as multiple messages.
+func (j *jsonrpc2) Unpack(m yrpc.Message) error {
+	j.rMu.Lock()
+	defer j.rMu.Unlock()
+	for len(j.queue) == 0 {
+		b, err := j.readFrame(m)
+		if err != nil {
+			return err
+		}
+		if err = m.SetSize(uint32(len(b))); err != nil {
+			return err
+		}
+		j.queue = j.decode(b)
+	}
+	it := j.queue[0]
+	j.queue[0] = nil
+	j.queue = j.queue[1:]
+	return j.fill(m, it)
+}
+
+// Pending returns whether there are batch items that are not unpacked yet.
+func (j *jsonrpc2) Pending() bool {
+	j.rMu.Lock()
+	defer j.rMu.Unlock()
+	return len(j.queue) > 0
+}
+
+// readFrame reads a frame no larger than the read limit of m,
+// or socket.MessageSizeLimit() if m has no read limit.
+func (j *jsonrpc2) readFrame(m socket.Message) ([]byte, error) {
+	limit := int(socket.ReadLimitOf(m))
+	if limit == 0 {
+		limit = int(socket.MessageSizeLimit())
+	}
+	if !j.lineDelimited {
+		b, err := io.ReadAll(io.LimitReader(j.rw, int64(limit)+1))
+		if err != nil {
+			return nil, err
+		}
+		if len(b) > limit {
+			return nil, socket.ErrExceedMessageSizeLimit
+		}
+		return b, nil
+	}
+	var b []byte
+	for {
+		line, err := j.r.ReadSlice('\n')
+		b = append(b, line...)
+		if len(b) > limit {
+			return nil, socket.ErrExceedMessageSizeLimit
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil {
+			if err == io.EOF && len(bytes.TrimSpace(b)) > 0 {
+				return b, nil
+			}
+			return nil, err
+		}
+		if len(bytes.TrimSpace(b)) > 0 {
+			return b, nil
+		}
+		b = b[:0]
+	}
+}
+
+// decode decodes a JSON-RPC request, response or batch.
+func (j *jsonrpc2) decode(b []byte) []*item {
+	b = bytes.TrimSpace(b)
+	if len(b) == 0 || b[0] != '[' {
+		return []*item{decodeItem(b)}
+	}
+	var raws []json.RawMessage
+	if err := json.Unmarshal(b, &raws); err != nil {
+		return []*item{{invalid: CodeParseError, ID: nullID}}
+	}
+	if len(raws) == 0 {
+		return []*item{{invalid: CodeInvalidRequest, ID: nullID}}
+	}
+	bat := new(batch)
+	items := make([]*item, len(raws))
+	for i, raw := range raws {
+		it := decodeItem(raw)
+		if it.isCall() {
+			it.batch = bat
+			it.index = bat.remain
+			bat.remain++
+		}
+		items[i] = it
+	}
+	bat.replies = make([][]byte, bat.remain)
+	return items
+}
+
+func decodeItem(b []byte) *item {
+	it := new(item)
+	if err := json.Unmarshal(b, it); err != nil {
+		if _, ok := err.(*json.SyntaxError); ok {
+			return &item{invalid: CodeParseError, ID: nullID}
+		}
+		return &item{invalid: CodeInvalidRequest, ID: nullID}
+	}
+	if it.Version != Version || (it.Method == "" && it.Result == nil && it.Error == nil) {
+		id := it.ID
+		if id == nil {
+			id = nullID
+		}
+		return &item{invalid: CodeInvalidRequest, ID: id}
+	}
+	return it
+}
+
+// isCall returns whether a reply should be sent.
+func (it *item) isCall() bool {
+	return it.invalid != 0 || (it.Method != "" && it.ID != nil)
+}
+
+func (it *item) isReply() bool {
+	return it.invalid == 0 && it.Method == ""
+}
+
+func (j *jsonrpc2) fill(m yrpc.Message, it *item) error {
+	m.SetBodyCodec(codec.ID_JSON)
+	if it.Meta != "" {
+		m.Meta().ParseBytes(goutil.StringToBytes(it.Meta))
+	}
+	if it.isReply() {
+		m.SetMtype(yrpc.TypeReply)
+		seq, err := strconv.ParseInt(string(it.ID), 10, 32)
+		if err != nil {
+			return fmt.Errorf("jsonrpc2: invalid reply id: %s", it.ID)
+		}
+		m.SetSeq(int32(seq))
+		if it.Error != nil {
+			m.SetStatus(yrpc.NewStatus(fromJSONRPCCode(it.Error.Code), it.Error.Message, it.Error.Data))
+			return m.UnmarshalBody(nil)
+		}
+		if bytes.Equal(it.Result, nullResult) {
+			return m.UnmarshalBody(nil)
+		}
+		return m.UnmarshalBody(it.Result)
+	}
+	m.SetServiceMethod(it.Method)
+	if !it.isCall() {
+		m.SetMtype(yrpc.TypePush)
+		return m.UnmarshalBody(it.Params)
+	}
+	m.SetMtype(yrpc.TypeCall)
+	j.wMu.Lock()
+	j.seq++
+	seq := j.seq
+	p := &pendingCall{id: it.ID, invalid: it.invalid, batch: it.batch, index: it.index}
+	j.pending[seq] = p
+	j.wMu.Unlock()
+	m.SetSeq(seq)
+	if it.invalid != 0 {
+		m.UnmarshalBody(nil)
+		return errNotJSONRPC
+	}
+	return m.UnmarshalBody(it.Params)
+}
+
+func toJSONRPCCode(code int32) int32 {
+	switch code {
+	case yrpc.CodeNotFound:
+		return CodeMethodNotFound
+	case yrpc.CodeBadMessage:
+		return CodeInvalidParams
+	case yrpc.CodeMtypeNotAllowed:
+		return CodeInvalidRequest
+	case yrpc.CodeInternalServerError:
+		return CodeInternalError
+	default:
+		return code
+	}
+}
+
+func fromJSONRPCCode(code int32) int32 {
+	switch code {
+	case CodeMethodNotFound:
+		return yrpc.CodeNotFound
+	case CodeInvalidParams, CodeInvalidRequest, CodeParseError:
+		return yrpc.CodeBadMessage
+	case CodeInternalError:
+		return yrpc.CodeInternalServerError
+	default:
+		return code
+	}
+}
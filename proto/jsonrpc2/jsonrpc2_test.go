@@ -0,0 +1,189 @@
+package jsonrpc2_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"math"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/sqos/yrpc"
+	"github.com/sqos/yrpc/proto/jsonrpc2"
+	"github.com/sqos/yrpc/socket"
+	"github.com/sqos/yrpc/yrpctest"
+)
+
+type Home struct {
+	yrpc.CallCtx
+}
+
+func (h *Home) Test(arg *map[string]string) (map[string]interface{}, *yrpc.Status) {
+	return map[string]interface{}{
+		"arg": *arg,
+	}, nil
+}
+
+func (h *Home) TestError(*map[string]string) (interface{}, *yrpc.Status) {
+	return nil, yrpc.NewStatus(1, "test error", "this is test")
+}
+
+func (h *Home) TestInf(*map[string]string) (float64, *yrpc.Status) {
+	return math.Inf(1), nil
+}
+
+type Push struct {
+	yrpc.PushCtx
+}
+
+var pushCh = make(chan string, 10)
+
+func (p *Push) Test(arg *string) *yrpc.Status {
+	pushCh <- *arg
+	return nil
+}
+
+func newServer(t *testing.T) (net.Conn, *bufio.Reader) {
+	p := yrpctest.NewPair(t, yrpctest.PairConfig{ProtoFunc: jsonrpc2.NewJSONRPC2ProtoFunc()})
+	p.Server.RouteCall(new(Home))
+	p.Server.RoutePush(new(Push))
+	conn, err := yrpctest.DialConn(p.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn, bufio.NewReader(conn)
+}
+
+func roundTrip(t *testing.T, conn net.Conn, r *bufio.Reader, req string) interface{} {
+	_, err := conn.Write([]byte(req + "\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	line, err := r.ReadBytes('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	var v interface{}
+	if err = json.Unmarshal(line, &v); err != nil {
+		t.Fatalf("%v: %s", err, line)
+	}
+	return v
+}
+
+func TestJSONRPC2(t *testing.T) {
+	conn, r := newServer(t)
+	defer conn.Close()
+
+	v := roundTrip(t, conn, r, `{"jsonrpc":"2.0","method":"/home/test","params":{"author":"andeya"},"id":"a1"}`)
+	assert.Equal(t, map[string]interface{}{
+		"jsonrpc": "2.0",
+		"result":  map[string]interface{}{"arg": map[string]interface{}{"author": "andeya"}},
+		"id":      "a1",
+	}, v)
+
+	v = roundTrip(t, conn, r, `{"jsonrpc":"2.0","method":"/home/test_error","params":{},"id":7}`)
+	rpcErr := v.(map[string]interface{})["error"].(map[string]interface{})
+	assert.Equal(t, float64(1), rpcErr["code"])
+	assert.Equal(t, "test error", rpcErr["message"])
+	assert.Equal(t, float64(7), v.(map[string]interface{})["id"])
+
+	v = roundTrip(t, conn, r, `{"jsonrpc":"2.0","method":"/home/none","id":8}`)
+	rpcErr = v.(map[string]interface{})["error"].(map[string]interface{})
+	assert.Equal(t, float64(jsonrpc2.CodeMethodNotFound), rpcErr["code"])
+
+	v = roundTrip(t, conn, r, `{"jsonrpc":"2.0","method"`)
+	rpcErr = v.(map[string]interface{})["error"].(map[string]interface{})
+	assert.Equal(t, float64(jsonrpc2.CodeParseError), rpcErr["code"])
+	assert.Nil(t, v.(map[string]interface{})["id"])
+
+	// notification
+	_, err := conn.Write([]byte(`{"jsonrpc":"2.0","method":"/push/test","params":"hello"}` + "\n"))
+	assert.NoError(t, err)
+	select {
+	case s := <-pushCh:
+		assert.Equal(t, "hello", s)
+	case <-time.After(3 * time.Second):
+		t.Fatal("push timeout")
+	}
+
+	// batch
+	v = roundTrip(t, conn, r, `[`+
+		`{"jsonrpc":"2.0","method":"/home/test","params":{"n":"1"},"id":1},`+
+		`{"jsonrpc":"2.0","method":"/push/test","params":"batch"},`+
+		`{"jsonrpc":"2.0","method":"/home/test","params":{"n":"2"},"id":2},`+
+		`1]`)
+	replies := v.([]interface{})
+	assert.Len(t, replies, 3)
+	ids := map[interface{}]bool{}
+	for _, reply := range replies {
+		ids[reply.(map[string]interface{})["id"]] = true
+	}
+	assert.Equal(t, map[interface{}]bool{float64(1): true, float64(2): true, nil: true}, ids)
+	assert.Equal(t, "batch", <-pushCh)
+
+	// the reply failing to marshal is replaced by an error object
+	v = roundTrip(t, conn, r, `[`+
+		`{"jsonrpc":"2.0","method":"/home/test","params":{"n":"1"},"id":1},`+
+		`{"jsonrpc":"2.0","method":"/home/test_inf","params":{},"id":2}]`)
+	replies = v.([]interface{})
+	assert.Len(t, replies, 2)
+	for _, reply := range replies {
+		reply := reply.(map[string]interface{})
+		if reply["id"] == float64(2) {
+			rpcErr := reply["error"].(map[string]interface{})
+			assert.Equal(t, float64(jsonrpc2.CodeInternalError), rpcErr["code"])
+		} else {
+			assert.NotNil(t, reply["result"])
+		}
+	}
+}
+
+func TestJSONRPC2Client(t *testing.T) {
+	p := yrpctest.NewPair(t, yrpctest.PairConfig{ProtoFunc: jsonrpc2.NewJSONRPC2ProtoFunc()})
+	p.Server.RouteCall(new(Home))
+	sess := p.Dial()
+	var result map[string]interface{}
+	stat := sess.Call("/home/test", map[string]string{"author": "andeya"}, &result).Status()
+	assert.True(t, stat.OK(), stat)
+	assert.Equal(t, map[string]interface{}{"arg": map[string]interface{}{"author": "andeya"}}, result)
+
+	stat = sess.Call("/home/test_error", map[string]string{}, &result).Status()
+	assert.Equal(t, int32(1), stat.Code())
+	assert.Equal(t, "test error", stat.Msg())
+
+	stat = sess.Call("/home/none", nil, &result).Status()
+	assert.Equal(t, yrpc.CodeNotFound, stat.Code())
+}
+
+func TestJSONRPC2SubProtoReadLimit(t *testing.T) {
+	limit := socket.MessageSizeLimit()
+	socket.SetMessageSizeLimit(64)
+	defer socket.SetMessageSizeLimit(limit)
+	frame := `{"jsonrpc":"2.0","method":"/home/test","params":{"author":"andeya"},"id":1}`
+	rw := bytes.NewBufferString(frame)
+	proto := jsonrpc2.NewJSONRPC2SubProtoFunc()(rw)
+	m := yrpc.GetMessage()
+	defer yrpc.PutMessage(m)
+	err := proto.Unpack(m)
+	assert.True(t, errors.Is(err, socket.ErrExceedMessageSizeLimit), err)
+	// the frame is read no more than the limit
+	assert.Equal(t, len(frame)-65, rw.Len())
+}
+
+func TestJSONRPC2SubProtoMessageReadLimit(t *testing.T) {
+	frame := `{"jsonrpc":"2.0","method":"/home/test","params":{"author":"andeya"},"id":1}`
+	rw := bytes.NewBufferString(frame)
+	proto := jsonrpc2.NewJSONRPC2SubProtoFunc()(rw)
+	m := yrpc.GetMessage(socket.WithReadLimit(32))
+	defer yrpc.PutMessage(m)
+	err := proto.Unpack(m)
+	assert.True(t, errors.Is(err, socket.ErrExceedMessageSizeLimit), err)
+	// the frame is read no more than the read limit of the message
+	assert.Equal(t, len(frame)-33, rw.Len())
+}
@@ -27,6 +27,7 @@ import (
 	"github.com/sqos/yrpc/codec"
 	"github.com/sqos/yrpc/proto/pbproto/pb"
 	"github.com/sqos/yrpc/utils"
+	"google.golang.org/protobuf/encoding/protowire"
 )
 
 // NewPbProtoFunc is creation function of PROTOBUF socket protocol.
@@ -112,6 +113,9 @@ func (pp *pbproto) Unpack(m yrpc.Message) error {
 
 	bb := utils.AcquireByteBuffer()
 	defer utils.ReleaseByteBuffer(bb)
+	if err = yrpc.CheckReadLimit(m); err != nil {
+		return pp.readOversized(bb, m, err)
+	}
 	bb.ChangeLen(int(m.Size()))
 	_, err = io.ReadFull(pp.rw, bb.B)
 	if err != nil {
@@ -141,14 +145,69 @@ func (pp *pbproto) Unpack(m yrpc.Message) error {
 	}
 
 	// read other
+	readHeader(s, m)
+
+	// read body
+	err = m.UnmarshalBody(s.Body)
+	return err
+}
+
+func readHeader(s *pb.Payload, m yrpc.Message) {
 	m.SetSeq(s.Seq)
 	m.SetMtype(byte(s.Mtype))
 	m.SetServiceMethod(s.ServiceMethod)
 	m.Status(true).DecodeQuery(s.Status)
 	m.Meta().ParseBytes(s.Meta)
-
-	// read body
 	m.SetBodyCodec(byte(s.BodyCodec))
-	err = m.UnmarshalBody(s.Body)
+}
+
+// readOversized reads the message exceeding the read limit.
+// NOTE:
+//
+//	The header in the first bytes within the read limit is decoded and bound,
+//	if no body is bound, e.g. the message is answered with CodePayloadTooLarge, the rest is discarded without buffering;
+//	Otherwise, or if the message has transfer pipe, or if the header exceeds the read limit, readLimitErr is returned.
+func (pp *pbproto) readOversized(bb *utils.ByteBuffer, m yrpc.Message, readLimitErr error) error {
+	bb.ChangeLen(1)
+	if _, err := io.ReadFull(pp.rw, bb.B); err != nil {
+		return err
+	}
+	if bb.B[0] > 0 {
+		return readLimitErr
+	}
+	lastSize := int64(m.Size()) - 1
+	bb.ChangeLen(int(min(lastSize, int64(yrpc.ReadLimitOf(m)))))
+	if _, err := io.ReadFull(pp.rw, bb.B); err != nil {
+		return err
+	}
+	// the fields are marshaled in order, so the header is the fields before the body
+	var headerLen int
+	for {
+		num, typ, n := protowire.ConsumeTag(bb.B[headerLen:])
+		if n < 0 {
+			return readLimitErr
+		}
+		if num == 7 {
+			break
+		}
+		l := protowire.ConsumeFieldValue(num, typ, bb.B[headerLen+n:])
+		if l < 0 {
+			return readLimitErr
+		}
+		headerLen += n + l
+	}
+	s := &pb.Payload{}
+	if err := codec.ProtoUnmarshal(bb.B[:headerLen], s); err != nil {
+		return err
+	}
+	readHeader(s, m)
+	// bind the body
+	if err := m.UnmarshalBody(nil); err != nil {
+		return err
+	}
+	if m.Body() != nil {
+		return readLimitErr
+	}
+	_, err := io.CopyN(io.Discard, pp.rw, lastSize-int64(len(bb.B)))
 	return err
 }
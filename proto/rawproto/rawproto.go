@@ -34,3 +34,41 @@ import (
 func NewRawProtoFunc() yrpc.ProtoFunc {
 	return socket.RawProtoFunc
 }
+
+/*
+# raw protocol v7 format(Big Endian):
+
+{4 bytes message length}
+{1 byte protocol version} # 7
+{1 byte flags} # bit0: header checksum
+{1 byte transfer pipe length}
+{transfer pipe IDs}
+# The following is handled data by transfer pipe
+{varint sequence}
+{1 byte message type} # e.g. CALL:1; REPLY:2; PUSH:3
+{uvarint service method length}
+{service method}
+{varint status code}
+{uvarint status message length}
+{status message}
+{uvarint status cause length}
+{status cause}
+{uvarint metadata count}
+{uvarint key length}{key}{uvarint value length}{value}...
+{1 byte body codec id}
+{4 bytes CRC-32C of the header} # only if the header checksum flag is set
+{body}
+*/
+
+// NewRawProtoV7Func is creation function of compact binary socket protocol.
+// NOTE:
+//
+//	id:7, name:"raw7"
+//	If headerChecksum is true, a CRC-32C of the header is written and checked by the receiver;
+//	Use yrpc.SetDefaultProtoFunc(rawproto.NewRawProtoV7Func()) to make it the default protocol.
+func NewRawProtoV7Func(headerChecksum ...bool) yrpc.ProtoFunc {
+	if len(headerChecksum) > 0 && headerChecksum[0] {
+		return socket.NewRawProtoV7Func(true)
+	}
+	return socket.RawProtoV7Func
+}
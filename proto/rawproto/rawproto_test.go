@@ -1,10 +1,12 @@
 package rawproto_test
 
 import (
+	"net"
 	"testing"
 	"time"
 
 	"github.com/sqos/yrpc"
+	"github.com/sqos/yrpc/proto/rawproto"
 	"github.com/sqos/yrpc/xfer/gzip"
 	"github.com/sqos/goutil"
 )
@@ -69,3 +71,41 @@ func (p *Push) Test(arg *map[string]string) *yrpc.Status {
 	yrpc.Infof("receive push(%s):\narg: %#v\n", p.IP(), arg)
 	return nil
 }
+
+func TestRawProtoV7(t *testing.T) {
+	for _, headerChecksum := range []bool{false, true} {
+		srv := yrpc.NewPeer(yrpc.PeerConfig{})
+		srv.RouteCall(new(Home))
+		cli := yrpc.NewPeer(yrpc.PeerConfig{})
+		cli.RoutePush(new(Push))
+		srvConn, cliConn := net.Pipe()
+		_, stat := srv.ServeConn(srvConn, rawproto.NewRawProtoV7Func(headerChecksum))
+		if !stat.OK() {
+			t.Fatal(stat)
+		}
+		sess, stat := cli.ServeConn(cliConn, rawproto.NewRawProtoV7Func(headerChecksum))
+		if !stat.OK() {
+			t.Fatal(stat)
+		}
+		var result map[string]interface{}
+		stat = sess.Call("/home/test",
+			map[string]string{
+				"author": "andeya",
+			},
+			&result,
+			yrpc.WithAddMeta("peer_id", "110"),
+		).Status()
+		if !stat.OK() {
+			t.Fatal(stat)
+		}
+		if author := result["arg"].(map[string]interface{})["author"]; author != "andeya" {
+			t.Fatalf("want andeya, have %v", author)
+		}
+		stat = sess.Call("/home/none", nil, &result).Status()
+		if stat.Code() != yrpc.CodeNotFound {
+			t.Fatalf("want code %d, have %v", yrpc.CodeNotFound, stat)
+		}
+		srv.Close()
+		cli.Close()
+	}
+}
@@ -32,7 +32,9 @@ func init() {
 // NOTE:
 //
 //	Marshal the body into binary;
-//	Support the Meta, BodyCodec and XferPipe.
+//	Support the Meta, BodyCodec and XferPipe;
+//	The frame exceeding the read limit breaks the connection,
+//	since the thrift header transport reads the whole frame before the header is decoded.
 func NewBinaryProtoFunc() yrpc.ProtoFunc {
 	return func(rw yrpc.IOWithReadBuffer) yrpc.Proto {
 		p := &tBinaryProto{
@@ -54,6 +56,7 @@ type tBinaryProto struct {
 	unpackLock sync.Mutex
 	name       string
 	id         byte
+	readLimit  uint32
 }
 
 // Version returns the protocol's id and name.
@@ -123,6 +126,7 @@ func (t *tBinaryProto) binaryUnpack(m yrpc.Message) error {
 	t.unpackLock.Lock()
 	defer t.unpackLock.Unlock()
 	t.rwCounter.WriteCounter.Zero()
+	t.setReadLimit(m)
 
 	err := readMessageBegin(t.tProtocol, m)
 	if err != nil {
@@ -174,6 +178,20 @@ func writeMessageBegin(tProtocol thrift.TProtocol, m yrpc.Message) error {
 	return tProtocol.WriteMessageBegin(context.TODO(), m.ServiceMethod(), typeID, m.Seq())
 }
 
+// setReadLimit lowers the max frame size to the read limit of the message,
+// so the oversized frame is rejected before it is read.
+func (t *tBinaryProto) setReadLimit(m yrpc.Message) {
+	limit := yrpc.ReadLimitOf(m)
+	if limit >= thrift.DEFAULT_MAX_FRAME_SIZE {
+		limit = 0
+	}
+	if limit == t.readLimit {
+		return
+	}
+	t.readLimit = limit
+	t.tProtocol.SetTConfiguration(&thrift.TConfiguration{MaxFrameSize: int32(limit)})
+}
+
 // readMessageBegin read a message header.
 func readMessageBegin(tProtocol thrift.TProtocol, m yrpc.Message) error {
 	rMethod, rTypeID, rSeqID, err := tProtocol.ReadMessageBegin(context.TODO())
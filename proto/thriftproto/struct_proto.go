@@ -101,6 +101,7 @@ func (t *tStructProto) structUnpack(m yrpc.Message) error {
 	t.unpackLock.Lock()
 	defer t.unpackLock.Unlock()
 	t.rwCounter.WriteCounter.Zero()
+	(*tBinaryProto)(t).setReadLimit(m)
 	err := readMessageBegin(t.tProtocol, m)
 	if err != nil {
 		return err
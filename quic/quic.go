@@ -164,6 +164,11 @@ func (c *Conn) Close() error {
 	return c.sess.CloseWithError(0, "")
 }
 
+// ConnectionState returns basic TLS details about the connection.
+func (c *Conn) ConnectionState() tls.ConnectionState {
+	return c.sess.ConnectionState().TLS
+}
+
 // LocalAddr returns the local network address.
 func (c *Conn) LocalAddr() net.Addr {
 	return c.sess.LocalAddr()
@@ -0,0 +1,93 @@
+// Copyright 2024 sqos. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package yrpc
+
+import "fmt"
+
+// NewReadLimitPlugin creates a router plugin that sets the maximum size of the received messages
+// of the routes, which overrides PeerConfig.ReadLimit, e.g.
+//
+//	peer.SubRoute("/upload", yrpc.NewReadLimitPlugin(512<<20)).RouteCall(new(Upload))
+//
+// NOTE: The global GetReadLimit() is still the upper limit of all messages.
+func NewReadLimitPlugin(limit uint32) Plugin {
+	return &readLimitPlugin{limit: limit}
+}
+
+type readLimitPlugin struct {
+	limit uint32
+}
+
+func (p *readLimitPlugin) Name() string {
+	return "read-limit"
+}
+
+// readLimitOf returns the read limit of the innermost read limit plugin, 0 means not set.
+func readLimitOf(pluginContainer *PluginContainer) uint32 {
+	plugins := pluginContainer.middle.GetAll()
+	for i := len(plugins) - 1; i >= 0; i-- {
+		if p, ok := plugins[i].(*readLimitPlugin); ok {
+			return p.limit
+		}
+	}
+	return 0
+}
+
+// raiseReadLimit records the largest read limit of the routes.
+func (r *Router) raiseReadLimit(limit uint32) {
+	for {
+		old := r.maxReadLimit.Load()
+		if limit <= old || r.maxReadLimit.CompareAndSwap(old, limit) {
+			return
+		}
+	}
+}
+
+// frameReadLimit returns the read limit checked by the protocols that read the whole message before decoding it,
+// which is the largest one of the peer and the routes, since the route is unknown until the header is decoded.
+// NOTE:
+//
+//	The message exceeding it breaks the connection,
+//	unless the protocol decodes the header within it and discards the rest, e.g. pbproto and jsonproto without transfer pipe;
+//	The message exceeding only the limit of its route is buffered, then answered with CodePayloadTooLarge.
+func (p *peer) frameReadLimit() uint32 {
+	if p.readLimit == 0 {
+		return 0
+	}
+	if limit := p.router.maxReadLimit.Load(); limit > p.readLimit {
+		return limit
+	}
+	return p.readLimit
+}
+
+// ReadLimit returns the maximum size of the received message of the handler,
+// 0 means PeerConfig.ReadLimit is used.
+func (h *Handler) ReadLimit() uint32 {
+	return h.readLimit
+}
+
+// checkReadLimit checks the size of the received message, and sets the CodePayloadTooLarge status if exceeded.
+// NOTE: handlerLimit overrides the peer limit if it is not 0.
+func (c *handlerCtx) checkReadLimit(handlerLimit uint32) bool {
+	limit := handlerLimit
+	if limit == 0 {
+		limit = c.sess.peer.readLimit
+	}
+	if limit == 0 || c.input.Size() <= limit {
+		return true
+	}
+	c.stat = statPayloadTooLarge.Copy(fmt.Sprintf("message size %d exceeds the limit %d", c.input.Size(), limit))
+	return false
+}
@@ -0,0 +1,141 @@
+package yrpc_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/sqos/yrpc"
+	"github.com/sqos/yrpc/proto/jsonproto"
+	"github.com/sqos/yrpc/proto/pbproto"
+	"github.com/sqos/yrpc/proto/rawproto"
+	"github.com/sqos/yrpc/xfer/md5"
+	"github.com/sqos/yrpc/yrpctest"
+)
+
+type LimitHome struct {
+	yrpc.CallCtx
+}
+
+func (h *LimitHome) Echo(arg *string) (string, *yrpc.Status) {
+	return *arg, nil
+}
+
+type LimitPush struct {
+	yrpc.PushCtx
+}
+
+var limitPushCh = make(chan string, 1)
+
+func (p *LimitPush) Notice(arg *string) *yrpc.Status {
+	limitPushCh <- *arg
+	return nil
+}
+
+func init() {
+	md5.Reg('m', "md5")
+}
+
+func TestReadLimit(t *testing.T) {
+	p := yrpctest.NewPair(t, yrpctest.PairConfig{
+		Server: yrpc.PeerConfig{ReadLimit: 200},
+		Client: yrpc.PeerConfig{ReadLimit: 1000},
+	})
+	p.Server.RouteCall(new(LimitHome))
+	p.Server.RoutePush(new(LimitPush))
+	p.Server.SubRoute("/bulk", yrpc.NewReadLimitPlugin(2000)).RouteCall(new(LimitHome))
+	sess := p.Dial()
+	small, medium, large := "hello", strings.Repeat("a", 500), strings.Repeat("a", 1500)
+	var result string
+
+	// the peer limit
+	stat := sess.Call("/limit_home/echo", small, &result).Status()
+	assert.True(t, stat.OK(), stat)
+	assert.Equal(t, small, result)
+	stat = sess.Call("/limit_home/echo", medium, &result).Status()
+	assert.Equal(t, yrpc.CodePayloadTooLarge, stat.Code())
+
+	// the session is still usable
+	stat = sess.Call("/limit_home/echo", small, &result).Status()
+	assert.True(t, stat.OK(), stat)
+
+	// the route limit overrides the peer limit
+	stat = sess.Call("/bulk/limit_home/echo", medium, &result).Status()
+	assert.True(t, stat.OK(), stat)
+	assert.Equal(t, medium, result)
+
+	// the reply exceeds the client limit
+	stat = sess.Call("/bulk/limit_home/echo", large, &result).Status()
+	assert.Equal(t, yrpc.CodePayloadTooLarge, stat.Code())
+
+	// with transfer pipe, the message is read completely but the body is not decoded
+	stat = sess.Call("/limit_home/echo", medium, &result, yrpc.WithXferPipe('m')).Status()
+	assert.Equal(t, yrpc.CodePayloadTooLarge, stat.Code())
+	stat = sess.Call("/bulk/limit_home/echo", medium, &result, yrpc.WithXferPipe('m')).Status()
+	assert.True(t, stat.OK(), stat)
+
+	// the oversized push is dropped
+	sess.Push("/limit_push/notice", medium)
+	sess.Push("/limit_push/notice", small)
+	select {
+	case s := <-limitPushCh:
+		assert.Equal(t, small, s)
+	case <-time.After(time.Second):
+		t.Fatal("push timeout")
+	}
+
+	// with transfer pipe, the message exceeding the limits of all routes is rejected before it is read
+	stat = sess.Call("/bulk/limit_home/echo", strings.Repeat("a", 2500), &result, yrpc.WithXferPipe('m')).Status()
+	assert.False(t, stat.OK())
+	assert.NotEqual(t, yrpc.CodePayloadTooLarge, stat.Code())
+	assert.False(t, sess.Health())
+}
+
+func TestReadLimitProto(t *testing.T) {
+	cases := []struct {
+		name      string
+		protoFunc yrpc.ProtoFunc
+		// xfer is true if the transfer pipe is set, so the whole message is read before decoding it
+		xfer bool
+	}{
+		{"raw7", rawproto.NewRawProtoV7Func(), false},
+		{"json", jsonproto.NewJSONProtoFunc(), false},
+		{"pb", pbproto.NewPbProtoFunc(), false},
+		{"json-xfer", jsonproto.NewJSONProtoFunc(), true},
+		{"pb-xfer", pbproto.NewPbProtoFunc(), true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			p := yrpctest.NewPair(t, yrpctest.PairConfig{
+				Server:    yrpc.PeerConfig{ReadLimit: 200},
+				ProtoFunc: c.protoFunc,
+			})
+			p.Server.RouteCall(new(LimitHome))
+			p.Server.SubRoute("/bulk", yrpc.NewReadLimitPlugin(2000)).RouteCall(new(LimitHome))
+			sess := p.Dial()
+			var result string
+			var setting []yrpc.MessageSetting
+			if c.xfer {
+				setting = append(setting, yrpc.WithXferPipe('m'))
+			}
+
+			// the route limit is checked after the header is decoded
+			stat := sess.Call("/limit_home/echo", strings.Repeat("a", 500), &result, setting...).Status()
+			assert.Equal(t, yrpc.CodePayloadTooLarge, stat.Code())
+			yrpctest.AssertCall(t, sess, "/bulk/limit_home/echo", "hello", "hello")
+
+			// the message exceeding the limits of all routes
+			stat = sess.Call("/bulk/limit_home/echo", strings.Repeat("a", 2500), &result, setting...).Status()
+			if c.xfer {
+				assert.False(t, stat.OK())
+				assert.NotEqual(t, yrpc.CodePayloadTooLarge, stat.Code())
+				assert.False(t, sess.Health())
+				return
+			}
+			assert.Equal(t, yrpc.CodePayloadTooLarge, stat.Code())
+			yrpctest.AssertCall(t, sess, "/bulk/limit_home/echo", "hello", "hello")
+		})
+	}
+}
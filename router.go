@@ -22,6 +22,7 @@ import (
 	"runtime"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"unsafe"
 
 	"github.com/sqos/goutil"
@@ -216,6 +217,8 @@ type (
 	// Router the router of call or push handlers.
 	Router struct {
 		subRouter *SubRouter
+		// the largest read limit of the routes
+		maxReadLimit atomic.Uint32
 	}
 	// SubRouter without the SetUnknownCall and SetUnknownPush methods
 	SubRouter struct {
@@ -237,6 +240,7 @@ type (
 		unknownHandleFunc func(*handlerCtx)
 		pluginContainer   *PluginContainer
 		routerTypeName    string
+		readLimit         uint32
 		isUnknown         bool
 	}
 	// HandlersMaker makes []*Handler
@@ -367,6 +371,8 @@ func (r *SubRouter) reg(
 			Fatalf("there is a handler conflict: %s", h.name)
 		}
 		h.routerTypeName = routerTypeName
+		h.readLimit = readLimitOf(pluginContainer)
+		r.root.raiseReadLimit(h.readLimit)
 		hadHandlers[h.name] = h
 		pluginContainer.postReg(h)
 		Printf("register %s handler: %s", routerTypeName, h.name)
@@ -386,6 +392,7 @@ func (r *Router) SetUnknownCall(fn func(UnknownCallCtx) (interface{}, *Status),
 		isUnknown:       true,
 		argElem:         reflect.TypeOf([]byte{}),
 		pluginContainer: pluginContainer,
+		readLimit:       readLimitOf(pluginContainer),
 		unknownHandleFunc: func(ctx *handlerCtx) {
 			body, stat := fn(ctx)
 			if !stat.OK() {
@@ -397,6 +404,7 @@ func (r *Router) SetUnknownCall(fn func(UnknownCallCtx) (interface{}, *Status),
 		},
 	}
 
+	r.raiseReadLimit(h.readLimit)
 	if *r.subRouter.unknownCall == nil {
 		Printf("set %s handler", h.name)
 	} else {
@@ -416,11 +424,13 @@ func (r *Router) SetUnknownPush(fn func(UnknownPushCtx) *Status, plugin ...Plugi
 		isUnknown:       true,
 		argElem:         reflect.TypeOf([]byte{}),
 		pluginContainer: pluginContainer,
+		readLimit:       readLimitOf(pluginContainer),
 		unknownHandleFunc: func(ctx *handlerCtx) {
 			ctx.stat = fn(ctx)
 		},
 	}
 
+	r.raiseReadLimit(h.readLimit)
 	if *r.subRouter.unknownPush == nil {
 		Printf("set %s handler", h.name)
 	} else {
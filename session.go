@@ -16,7 +16,9 @@
 package yrpc
 
 import (
+	"bytes"
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -42,9 +44,14 @@ type (
 		LocalAddr() net.Addr
 		// RemoteAddr returns the remote network address.
 		RemoteAddr() net.Addr
+		// TLS returns the TLS connection state, or nil if the connection is not TLS.
+		TLS() *tls.ConnectionState
+		// PeerIdentity returns the identity of the remote peer certificate, or nil if there is no peer certificate.
+		PeerIdentity() *PeerIdentity
 		// Swap returns custom data swap of the session(socket).
 		Swap() goutil.Map
 		// SetID sets the session id.
+		// NOTE: If the id is already used, PeerConfig.DuplicateID decides which session is evicted.
 		SetID(newID string)
 		// ControlFD invokes f on the underlying connection's file
 		// descriptor or handle.
@@ -56,7 +63,8 @@ type (
 		// The connection fd is not allowed to change!
 		// Inherit the previous session id and custom data swap;
 		// If modifiedConn!=nil, reset the net.Conn of the socket;
-		// If newProtoFunc!=nil, reset the ProtoFunc of the socket.
+		// If newProtoFunc!=nil, reset the ProtoFunc of the socket;
+		// The data buffered by the socket is read first from the conn passed to fn.
 		ModifySocket(fn func(conn net.Conn) (modifiedConn net.Conn, newProtoFunc ProtoFunc))
 		// GetProtoFunc returns the ProtoFunc
 		GetProtoFunc() ProtoFunc
@@ -128,6 +136,10 @@ type (
 		LocalAddr() net.Addr
 		// RemoteAddr returns the remote network address.
 		RemoteAddr() net.Addr
+		// TLS returns the TLS connection state, or nil if the connection is not TLS.
+		TLS() *tls.ConnectionState
+		// PeerIdentity returns the identity of the remote peer certificate, or nil if there is no peer certificate.
+		PeerIdentity() *PeerIdentity
 		// Swap returns custom data swap of the session(socket).
 		Swap() goutil.Map
 		// CloseNotify returns a channel that closes when the connection has gone away.
@@ -165,6 +177,7 @@ type (
 		// Peer returns the peer.
 		Peer() Peer
 		// SetID sets the session id.
+		// NOTE: If the id is already used, PeerConfig.DuplicateID decides which session is evicted.
 		SetID(newID string)
 		// Close closes the session.
 		Close() error
@@ -196,8 +209,9 @@ type session struct {
 	sessionAgeLock                 sync.RWMutex
 	contextAgeLock                 sync.RWMutex
 	lock                           sync.RWMutex
+	peerIdentity                   atomic.Pointer[PeerIdentity]
 	redialForClientLocked          func() bool // only for client role
-	seq                            int32
+	seq                            atomic.Int64
 	status                         int32
 	didCloseNotify                 int32
 }
@@ -315,16 +329,25 @@ func (s *session) ID() string {
 }
 
 // SetID sets the session id.
+// NOTE:
+//
+//	If the id is already used, PeerConfig.DuplicateID decides which session is evicted;
+//	If the session itself is rejected, it keeps the old id and is closed.
 func (s *session) SetID(newID string) {
 	oldID := s.ID()
 	if oldID == newID {
 		return
 	}
-	s.socket.SetID(newID)
-	hub := s.peer.sessHub
-	hub.set(s)
-	hub.delete(oldID)
+	evicted, reason := s.peer.sessHub.rename(s, newID)
+	if evicted == s {
+		Tracef("session can not change id: %s -> %s: %s", oldID, newID, reason.String())
+		s.peer.evictSession(s, reason)
+		return
+	}
 	Tracef("session changes id: %s -> %s", oldID, newID)
+	if evicted != nil {
+		s.peer.evictSession(evicted, reason)
+	}
 }
 
 // ControlFD invokes f on the underlying connection's file
@@ -346,9 +369,18 @@ func (s *session) getConn() net.Conn {
 // The connection fd is not allowed to change!
 // Inherit the previous session id and custom data swap;
 // If modifiedConn!=nil, reset the net.Conn of the socket;
-// If newProtoFunc!=nil, reset the ProtoFunc of the socket.
+// If newProtoFunc!=nil, reset the ProtoFunc of the socket;
+// The data buffered by the socket is read first from the conn passed to fn.
 func (s *session) ModifySocket(fn func(conn net.Conn) (modifiedConn net.Conn, newProtoFunc ProtoFunc)) {
 	conn := s.getConn()
+	if us, ok := s.socket.(socket.UnsafeSocket); ok {
+		if buffered := us.Buffered(); len(buffered) > 0 {
+			conn = &bufferedConn{
+				Conn:   conn,
+				reader: io.MultiReader(bytes.NewReader(buffered), conn),
+			}
+		}
+	}
 	modifiedConn, newProtoFunc := fn(conn)
 	isModifiedConn := modifiedConn != nil
 	isNewProtoFunc := newProtoFunc != nil
@@ -369,6 +401,21 @@ func (s *session) ModifySocket(fn func(conn net.Conn) (modifiedConn net.Conn, ne
 	s.socket.SetID(id)
 }
 
+// bufferedConn reads the data buffered by the socket before reading the connection.
+type bufferedConn struct {
+	net.Conn
+	reader io.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
+
+// NetConn returns the underlying connection.
+func (c *bufferedConn) NetConn() net.Conn {
+	return c.Conn
+}
+
 // GetProtoFunc returns the ProtoFunc
 func (s *session) GetProtoFunc() ProtoFunc {
 	if len(s.protoFuncs) > 0 && s.protoFuncs[0] != nil {
@@ -377,6 +424,15 @@ func (s *session) GetProtoFunc() ProtoFunc {
 	return socket.DefaultProtoFunc()
 }
 
+// ProtoVersion returns the id and name of the socket protocol in use,
+// implements socket.ProtoVersioner.
+func (s *session) ProtoVersion() (byte, string) {
+	if v, ok := s.socket.(socket.ProtoVersioner); ok {
+		return v.ProtoVersion()
+	}
+	return 0, ""
+}
+
 // LocalAddr returns the local network address.
 func (s *session) LocalAddr() net.Addr {
 	return s.socket.LocalAddr()
@@ -387,6 +443,28 @@ func (s *session) RemoteAddr() net.Addr {
 	return s.socket.RemoteAddr()
 }
 
+// TLS returns the TLS connection state, or nil if the connection is not TLS.
+func (s *session) TLS() *tls.ConnectionState {
+	return tlsConnectionState(s.getConn())
+}
+
+// PeerIdentity returns the identity of the remote peer certificate, or nil if there is no peer certificate.
+// NOTE: The identity is parsed once after the TLS handshake is complete.
+func (s *session) PeerIdentity() *PeerIdentity {
+	if identity := s.peerIdentity.Load(); identity != nil {
+		return identity
+	}
+	state := s.TLS()
+	if state == nil || !state.HandshakeComplete {
+		return nil
+	}
+	identity := newPeerIdentity(state)
+	if identity != nil {
+		s.peerIdentity.Store(identity)
+	}
+	return identity
+}
+
 // SessionAge returns the session max age.
 func (s *session) SessionAge() time.Duration {
 	s.sessionAgeLock.RLock()
@@ -446,13 +524,13 @@ func (s *session) PreSend(mtype byte, serviceMethod string, body interface{}, st
 	return opStat
 }
 
-func (s *session) send(mtype byte, seq int32, serviceMethod string, body interface{}, stat *Status, setting []MessageSetting) (Message, *Status) {
+func (s *session) send(mtype byte, seq int64, serviceMethod string, body interface{}, stat *Status, setting []MessageSetting) (Message, *Status) {
 	output := socket.GetMessage(setting...)
 	output.SetMtype(mtype)
 	if seq == 0 {
-		seq = atomic.AddInt32(&s.seq, 1)
+		seq = s.seq.Add(1)
 	}
-	output.SetSeq(seq)
+	socket.SetWideSeq(output, seq)
 	if output.BodyCodec() == codec.NilCodecID {
 		output.SetBodyCodec(s.peer.defaultBodyCodec)
 	}
@@ -582,7 +660,7 @@ func (s *session) PreReply(req Message, body interface{}, stat *Status, setting
 			opStat = statBadMessage.Copy(p, 3)
 		}
 	}()
-	output, opStat = s.send(TypeReply, req.Seq(), req.ServiceMethod(), body, stat, setting)
+	output, opStat = s.send(TypeReply, socket.WideSeqOf(req), req.ServiceMethod(), body, stat, setting)
 	return opStat
 }
 
@@ -610,6 +688,11 @@ func (s *session) RawPush(serviceMethod string, args interface{}, setting ...Mes
 // If the args is []byte or *[]byte type, it can automatically fill in the body codec name;
 // If the session is a client role and PeerConfig.RedialTimes>0, it is automatically re-called once after a failure.
 func (s *session) Push(serviceMethod string, args interface{}, setting ...MessageSetting) *Status {
+	return s.push(serviceMethod, args, nil, setting)
+}
+
+// push sends a message of TypePush type, the body is encoded by the cache if it is not nil.
+func (s *session) push(serviceMethod string, args interface{}, cache *bodyCache, setting []MessageSetting) *Status {
 	ctx := s.peer.getContext(s, true)
 	defer func() {
 		s.peer.putContext(ctx, true)
@@ -629,11 +712,18 @@ func (s *session) Push(serviceMethod string, args interface{}, setting ...Messag
 			fn(output)
 		}
 	}
-	output.SetSeq(atomic.AddInt32(&s.seq, 1))
+	socket.SetWideSeq(output, s.seq.Add(1))
 
 	if output.BodyCodec() == codec.NilCodecID {
 		output.SetBodyCodec(s.peer.defaultBodyCodec)
 	}
+	if cache != nil {
+		body, err := cache.encode(output)
+		if err != nil {
+			return statWriteFailed.Copy(err)
+		}
+		output.SetBody(body)
+	}
 	if age := s.ContextAge(); age > 0 {
 		ctxTimout, _ := context.WithTimeout(output.Context(), age)
 		socket.WithContext(ctxTimout)(output)
@@ -691,8 +781,7 @@ func (s *session) AsyncCall(
 		}
 	}
 
-	seq := atomic.AddInt32(&s.seq, 1)
-	output.SetSeq(seq)
+	socket.SetWideSeq(output, s.seq.Add(1))
 
 	if output.BodyCodec() == codec.NilCodecID {
 		output.SetBodyCodec(s.peer.defaultBodyCodec)
@@ -725,7 +814,7 @@ func (s *session) AsyncCall(
 	cmd.mu.Lock()
 	defer cmd.mu.Unlock()
 
-	s.callCmdMap.Store(seq, cmd)
+	s.callCmdMap.Store(output.Seq(), cmd)
 
 	defer func() {
 		if p := recover(); p != nil {
@@ -778,12 +867,13 @@ func (s *session) closeLocked() error {
 	if !s.tryChangeStatus(statusActiveClosing, statusOk, statusPreparing) {
 		return nil
 	} // readDisconnected is being called
-	s.peer.sessHub.delete(s.ID())
+	s.peer.sessHub.delete(s)
 	s.notifyClosed()
 	s.graceCtxWait()
 	s.graceCallCmdWaitGroup.Wait()
 	s.changeStatus(statusActiveClosed)
 	err := s.socket.Close()
+	s.peer.groups.leaveAll(s)
 	s.peer.pluginContainer.postDisconnect(s)
 	return err
 }
@@ -798,7 +888,7 @@ func (s *session) readDisconnected(oldConn net.Conn, err error) {
 		s.changeStatus(statusPassiveClosing)
 	}
 
-	s.peer.sessHub.delete(s.ID())
+	s.peer.sessHub.delete(s)
 
 	var reason string
 	if err != nil && err != socket.ErrProactivelyCloseSocket {
@@ -826,8 +916,11 @@ func (s *session) readDisconnected(oldConn net.Conn, err error) {
 
 	s.socket.Close()
 	if !s.redialForClient(oldConn) {
-		s.changeStatus(statusPassiveClosed)
+		if !s.tryChangeStatus(statusPassiveClosed, statusPassiveClosing, statusRedialing, statusRedialFailed) {
+			return
+		} // closed actively when the redial is rejected
 		s.notifyClosed()
+		s.peer.groups.leaveAll(s)
 		s.peer.pluginContainer.postDisconnect(s)
 	}
 }
@@ -873,6 +966,7 @@ func (s *session) startReadAndHandle() {
 	for s.goonRead() {
 		var ctx = s.peer.getContext(s, false)
 		withContext(ctx.input)
+		socket.WithReadLimit(s.peer.frameReadLimit())(ctx.input)
 		if s.peer.pluginContainer.preReadHeader(ctx) != nil {
 			s.peer.putContext(ctx, false)
 			return
@@ -940,30 +1034,94 @@ ERR:
 	return usedConn, statWriteFailed.Copy(err)
 }
 
+// CloseReasonServiceMethod is the PUSH service method of the close reason,
+// which is sent to the session evicted for the duplicate session ID, and whose body is the *Status.
+const CloseReasonServiceMethod = "/close_reason"
+
+var (
+	statKickedOld   = NewStatus(CodeConflict, CodeText(CodeConflict), "replaced by a new session with the same ID")
+	statRejectedNew = NewStatus(CodeConflict, CodeText(CodeConflict), "a session with the same ID already exists")
+)
+
 // SessionHub sessions hub
 type SessionHub struct {
 	// key: session id (ip, name and so on)
-	// value: *session
+	// value: *session, the latest one of the id
 	sessions goutil.Map
+	// the earlier sessions of the same id, only for DuplicateAllowMultiple
+	others      map[string][]*session
+	othersCount int
+	policy      string
+	mu          sync.Mutex
 }
 
 // newSessionHub creates a new sessions hub.
-func newSessionHub() *SessionHub {
+func newSessionHub(policy string) *SessionHub {
 	chub := &SessionHub{
 		sessions: goutil.AtomicMap(),
+		others:   make(map[string][]*session),
+		policy:   policy,
 	}
 	return chub
 }
 
-// set sets a *session.
-func (sh *SessionHub) set(sess *session) {
-	_sess, loaded := sh.sessions.LoadOrStore(sess.ID(), sess)
+// set sets a *session by the duplicate session ID policy,
+// returns the evicted *session and the close reason if the session id is already used.
+// NOTE: The evicted *session is the older one for DuplicateKickOld, or sess itself for DuplicateRejectNew.
+func (sh *SessionHub) set(sess *session) (*session, *Status) {
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	return sh.setLocked(sess.ID(), sess)
+}
+
+// rename changes the id of the *session by the duplicate session ID policy,
+// and returns the evicted *session and the close reason as set does.
+// NOTE:
+//
+//	The *session not in the hub, e.g. in PostAccept, just changes its id;
+//	If the *session itself is rejected, it keeps the old id.
+func (sh *SessionHub) rename(sess *session, newID string) (*session, *Status) {
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	oldID := sess.ID()
+	if !sh.containsLocked(oldID, sess) {
+		sess.socket.SetID(newID)
+		return nil, nil
+	}
+	evicted, reason := sh.setLocked(newID, sess)
+	if evicted == sess {
+		return evicted, reason
+	}
+	sess.socket.SetID(newID)
+	sh.deleteLocked(oldID, sess)
+	return evicted, reason
+}
+
+func (sh *SessionHub) setLocked(id string, sess *session) (*session, *Status) {
+	_sess, loaded := sh.sessions.LoadOrStore(id, sess)
 	if !loaded {
-		return
+		return nil, nil
+	}
+	oldSess := _sess.(*session)
+	if oldSess == sess {
+		return nil, nil
 	}
-	sh.sessions.Store(sess.ID(), sess)
-	if oldSess := _sess.(*session); sess != oldSess {
-		oldSess.Close()
+	for _, s := range sh.others[id] {
+		if s == sess {
+			return nil, nil
+		}
+	}
+	switch sh.policy {
+	case DuplicateRejectNew:
+		return sess, statRejectedNew
+	case DuplicateAllowMultiple:
+		sh.others[id] = append(sh.others[id], oldSess)
+		sh.othersCount++
+		sh.sessions.Store(id, sess)
+		return nil, nil
+	default:
+		sh.sessions.Store(id, sess)
+		return oldSess, statKickedOld
 	}
 }
 
@@ -977,12 +1135,59 @@ func (sh *SessionHub) get(id string) (*session, bool) {
 	return _sess.(*session), true
 }
 
+// getAll gets all *session of the id, the latest first.
+func (sh *SessionHub) getAll(id string) []*session {
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	return sh.getAllLocked(id)
+}
+
+func (sh *SessionHub) getAllLocked(id string) []*session {
+	sess, ok := sh.get(id)
+	if !ok {
+		return nil
+	}
+	others := sh.others[id]
+	list := make([]*session, 0, len(others)+1)
+	list = append(list, sess)
+	for i := len(others) - 1; i >= 0; i-- {
+		list = append(list, others[i])
+	}
+	return list
+}
+
+// containsLocked returns whether the *session is set with the id.
+func (sh *SessionHub) containsLocked(id string, sess *session) bool {
+	for _, s := range sh.getAllLocked(id) {
+		if s == sess {
+			return true
+		}
+	}
+	return false
+}
+
 // rangeCallback calls f sequentially for each id and *session present in the session hub.
 // If fn returns false, stop traversing.
 func (sh *SessionHub) rangeCallback(fn func(*session) bool) {
+	next := true
 	sh.sessions.Range(func(key, value interface{}) bool {
-		return fn(value.(*session))
+		next = fn(value.(*session))
+		return next
 	})
+	if !next {
+		return
+	}
+	sh.mu.Lock()
+	var others []*session
+	for _, list := range sh.others {
+		others = append(others, list...)
+	}
+	sh.mu.Unlock()
+	for _, s := range others {
+		if !fn(s) {
+			return
+		}
+	}
 }
 
 // random gets a *session randomly.
@@ -998,12 +1203,44 @@ func (sh *SessionHub) random() (*session, bool) {
 // len returns the length of the session hub.
 // NOTE: the count implemented using sync.Map may be inaccurate.
 func (sh *SessionHub) len() int {
-	return sh.sessions.Len()
+	sh.mu.Lock()
+	othersCount := sh.othersCount
+	sh.mu.Unlock()
+	return sh.sessions.Len() + othersCount
+}
+
+// delete deletes the *session by its id, the latest one of the other sessions of the id takes its place.
+// NOTE: The id is read under the lock, so it is not changed by rename concurrently.
+func (sh *SessionHub) delete(sess *session) {
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	sh.deleteLocked(sess.ID(), sess)
 }
 
-// delete deletes the *session for a id.
-func (sh *SessionHub) delete(id string) {
-	sh.sessions.Delete(id)
+func (sh *SessionHub) deleteLocked(id string, sess *session) {
+	others := sh.others[id]
+	if cur, ok := sh.get(id); ok && cur == sess {
+		if len(others) == 0 {
+			sh.sessions.Delete(id)
+			return
+		}
+		sh.sessions.Store(id, others[len(others)-1])
+		others = others[:len(others)-1]
+	} else {
+		i := len(others) - 1
+		for ; i >= 0 && others[i] != sess; i-- {
+		}
+		if i < 0 {
+			return
+		}
+		others = append(others[:i], others[i+1:]...)
+	}
+	sh.othersCount--
+	if len(others) == 0 {
+		delete(sh.others, id)
+	} else {
+		sh.others[id] = others
+	}
 }
 
 const (
@@ -146,7 +146,8 @@ type message struct {
 	xferPipe      *xfer.XferPipe
 	ctx           context.Context
 	size          uint32
-	seq           int32
+	readLimit     uint32
+	seq           int64
 	mtype         byte
 	bodyCodec     byte
 }
@@ -227,6 +228,7 @@ func (m *message) Reset(settings ...MessageSetting) Message {
 	m.mtype = 0
 	m.serviceMethod = ""
 	m.size = 0
+	m.readLimit = 0
 	m.ctx = nil
 	m.bodyCodec = codec.NilCodecID
 	m.doSetting(settings...)
@@ -249,14 +251,14 @@ func (m *message) Context() context.Context {
 	return m.ctx
 }
 
-// Seq returns the message sequence.
+// Seq returns the message sequence, which is the low 32 bits of WideSeqOf(m).
 func (m *message) Seq() int32 {
-	return m.seq
+	return int32(m.seq)
 }
 
-// SetSeq sets the message sequence.
+// SetSeq sets the message sequence, and WideSeqOf(m) is its sign extension.
 func (m *message) SetSeq(seq int32) {
-	m.seq = seq
+	m.seq = int64(seq)
 }
 
 // Mtype returns the message type, such as CALL, REPLY, PUSH.
@@ -533,6 +535,57 @@ func WithNewBody(newBodyFunc NewBodyFunc) MessageSetting {
 	}
 }
 
+// WithReadLimit sets the maximum size of the message to read, which is checked by CheckReadLimit,
+// 0 means only MessageSizeLimit() is checked.
+//
+//	NOTE: readLimit is only for reading form connection.
+func WithReadLimit(readLimit uint32) MessageSetting {
+	return func(m Message) {
+		if _m, ok := m.(*message); ok {
+			_m.readLimit = readLimit
+		}
+	}
+}
+
+// ReadLimitOf returns the maximum size of the message to read set by WithReadLimit, 0 means not set.
+func ReadLimitOf(m Message) uint32 {
+	if _m, ok := m.(*message); ok {
+		return _m.readLimit
+	}
+	return 0
+}
+
+// WideSeqOf returns the 64-bit message sequence, whose low 32 bits are m.Seq().
+// NOTE: The protocols that carry only Seq() set it to the sign extension of Seq().
+func WideSeqOf(m Message) int64 {
+	if _m, ok := m.(*message); ok {
+		return _m.seq
+	}
+	return int64(m.Seq())
+}
+
+// SetWideSeq sets the 64-bit message sequence, and m.Seq() returns its low 32 bits.
+// SUGGEST: The Proto that carries the 64-bit sequence calls it instead of SetSeq.
+func SetWideSeq(m Message, seq int64) {
+	if _m, ok := m.(*message); ok {
+		_m.seq = seq
+		return
+	}
+	m.SetSeq(int32(seq))
+}
+
+// CheckReadLimit returns ErrExceedMessageSizeLimit if the size of the message exceeds the limit set by WithReadLimit.
+// SUGGEST: The Proto that reads the whole message into memory calls it after SetSize,
+// so the oversized message is rejected before it is buffered.
+// NOTE: The error breaks the connection, since the message is not decoded to be answered,
+// unless the Proto decodes the header within the limit and discards the rest.
+func CheckReadLimit(m Message) error {
+	if limit := ReadLimitOf(m); limit > 0 && m.Size() > limit {
+		return fmt.Errorf("%w: %d > %d", ErrExceedMessageSizeLimit, m.Size(), limit)
+	}
+	return nil
+}
+
 // WithXferPipe sets transfer filter pipe.
 // NOTE: Panic if the filterID is not registered.
 // SUGGEST: The length can not be bigger than 255!
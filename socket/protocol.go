@@ -20,6 +20,7 @@ import (
 	"errors"
 	"io"
 	"math"
+	"slices"
 	"strconv"
 	"sync"
 
@@ -204,8 +205,8 @@ func (r *rawProto) Unpack(m Message) error {
 	defer utils.ReleaseByteBuffer(bb)
 
 	// read message
-	err := r.readMessage(bb, m)
-	if err != nil {
+	done, err := r.readMessage(bb, m)
+	if err != nil || done {
 		return err
 	}
 	// do transfer pipe
@@ -222,51 +223,120 @@ func (r *rawProto) Unpack(m Message) error {
 	return r.readBody(data, m)
 }
 
-func (r *rawProto) readMessage(bb *utils.ByteBuffer, m Message) error {
+// readMessage reads the message bytes,
+// done is true if the message without transfer pipe has been read completely.
+func (r *rawProto) readMessage(bb *utils.ByteBuffer, m Message) (done bool, err error) {
 	r.rMu.Lock()
 	defer r.rMu.Unlock()
 
 	// size
 	bb.ChangeLen(4)
-	_, err := io.ReadFull(r.r, bb.B)
+	_, err = io.ReadFull(r.r, bb.B)
 	if err != nil {
-		return err
+		return false, err
 	}
 	_lastSize := binary.BigEndian.Uint32(bb.B)
 	if err = m.SetSize(_lastSize); err != nil {
-		return err
+		return false, err
 	}
 	lastSize := int(_lastSize)
 	lastSize, err = minus(lastSize, 4)
 	if err != nil {
-		return err
+		return false, err
 	}
-	bb.ChangeLen(lastSize)
 
 	// transfer pipe
-	_, err = io.ReadFull(r.r, bb.B[:1])
+	bb.ChangeLen(1)
+	_, err = io.ReadFull(r.r, bb.B)
 	if err != nil {
-		return err
+		return false, err
 	}
 	var xferLen = bb.B[0]
 	if xferLen > 0 {
-		_, err = io.ReadFull(r.r, bb.B[:xferLen])
+		bb.ChangeLen(int(xferLen))
+		_, err = io.ReadFull(r.r, bb.B)
 		if err != nil {
-			return err
+			return false, err
 		}
-		err = m.XferPipe().Append(bb.B[:xferLen]...)
+		err = m.XferPipe().Append(bb.B...)
 		if err != nil {
-			return err
+			return false, err
 		}
 	}
 	lastSize, err = minus(lastSize, 1+int(xferLen))
 	if err != nil {
-		return err
+		return false, err
+	}
+	if xferLen == 0 {
+		return true, r.readPlain(bb, m, lastSize)
+	}
+	// the whole message is read for the transfer pipe
+	if err = CheckReadLimit(m); err != nil {
+		return false, err
 	}
 	// read last all
 	bb.ChangeLen(lastSize)
 	_, err = io.ReadFull(r.r, bb.B)
-	return err
+	return false, err
+}
+
+// readPlain reads the message without transfer pipe.
+// NOTE:
+//
+//	The header is read and bound before the body,
+//	if no body is bound, e.g. the message exceeds the read limit, the body is discarded without buffering;
+//	The message with transfer pipe is read as a whole, so it is rejected by CheckReadLimit instead.
+func (r *rawProto) readPlain(bb *utils.ByteBuffer, m Message, lastSize int) error {
+	bb.B = bb.B[:0]
+	readN := func(n int) ([]byte, error) {
+		if n > lastSize-len(bb.B) {
+			return nil, errors.New("raw proto: bad package")
+		}
+		start := len(bb.B)
+		bb.B = slices.Grow(bb.B, n)[:start+n]
+		_, err := io.ReadFull(r.r, bb.B[start:])
+		return bb.B[start:], err
+	}
+	// sequence length
+	b, err := readN(1)
+	if err != nil {
+		return err
+	}
+	// sequence, message type and service method length
+	if b, err = readN(int(b[0]) + 2); err != nil {
+		return err
+	}
+	// service method and status length
+	if b, err = readN(int(b[len(b)-1]) + 2); err != nil {
+		return err
+	}
+	// status and metadata length
+	if b, err = readN(int(binary.BigEndian.Uint16(b[len(b)-2:])) + 2); err != nil {
+		return err
+	}
+	// metadata and body codec
+	if _, err = readN(int(binary.BigEndian.Uint16(b[len(b)-2:])) + 1); err != nil {
+		return err
+	}
+	data, err := r.readHeader(bb.B, m)
+	if err != nil {
+		return err
+	}
+	m.SetBodyCodec(data[0])
+	// bind the body
+	if err = m.UnmarshalBody(nil); err != nil {
+		return err
+	}
+	bodySize := lastSize - len(bb.B)
+	if m.Body() == nil {
+		_, err = io.CopyN(io.Discard, r.r, int64(bodySize))
+		return err
+	}
+	bb.ChangeLen(bodySize)
+	if _, err = io.ReadFull(r.r, bb.B); err != nil {
+		return err
+	}
+	return m.UnmarshalBody(bb.B)
 }
 
 func minus(a int, b int) (int, error) {
@@ -0,0 +1,510 @@
+// Copyright 2024 sqos. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package socket
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"slices"
+	"sync"
+
+	"github.com/sqos/yrpc/utils"
+)
+
+/*
+# raw protocol v7 format(Big Endian):
+
+{4 bytes message length}
+{1 byte protocol version} # 7
+{1 byte flags} # bit0: header checksum
+{1 byte transfer pipe length}
+{transfer pipe IDs}
+# The following is handled data by transfer pipe
+{varint sequence} # 64-bit, the low 32 bits are Header.Seq()
+{1 byte message type} # e.g. CALL:1; REPLY:2; PUSH:3
+{uvarint service method length}
+{service method}
+{varint status code} # int32 range
+{uvarint status message length}
+{status message}
+{uvarint status cause length}
+{status cause}
+{uvarint metadata count}
+{uvarint key length}{key}{uvarint value length}{value}...
+{1 byte body codec id}
+{4 bytes CRC-32C of the header} # only if the header checksum flag is set
+{body}
+*/
+
+const (
+	rawProtoV7ID              = 7
+	rawV7FlagHeaderChecksum   = 1 << 0
+	rawV7KnownFlags           = rawV7FlagHeaderChecksum
+	rawV7HeaderChecksumLength = 4
+)
+
+var (
+	errRawV7BadPackage = errors.New("raw proto v7: bad package")
+	errRawV7Checksum   = errors.New("raw proto v7: header checksum mismatch")
+	rawV7ChecksumTable = crc32.MakeTable(crc32.Castagnoli)
+)
+
+var _ Proto = new(rawProtoV7)
+
+// rawProtoV7 compact binary socket communication protocol.
+type rawProtoV7 struct {
+	r              io.Reader
+	w              io.Writer
+	rMu            sync.Mutex
+	headerChecksum bool
+}
+
+// RawProtoV7Func is creation function of compact binary socket protocol, without header checksum.
+var RawProtoV7Func = NewRawProtoV7Func(false)
+
+// NewRawProtoV7Func returns the creation function of compact binary socket protocol.
+// NOTE:
+//
+//	id:7, name:"raw7"
+//	If headerChecksum is true, a CRC-32C of the header is written and checked by the receiver;
+//	The receiver always accepts messages with or without the header checksum;
+//	The sequence is the 64-bit WideSeqOf(m) on the wire, so it does not wrap at int32,
+//	and Header.Seq() of the received message is its low 32 bits;
+//	The status code is a varint on the wire but int32 in the message,
+//	the receiver rejects the message whose code overflows int32.
+func NewRawProtoV7Func(headerChecksum bool) ProtoFunc {
+	return func(rw IOWithReadBuffer) Proto {
+		return &rawProtoV7{
+			r:              rw,
+			w:              rw,
+			headerChecksum: headerChecksum,
+		}
+	}
+}
+
+// Version returns the protocol's id and name.
+func (r *rawProtoV7) Version() (byte, string) {
+	return rawProtoV7ID, "raw7"
+}
+
+// Pack writes the Message into the connection.
+// NOTE: Make sure to write only once or there will be package contamination!
+func (r *rawProtoV7) Pack(m Message) error {
+	bb := utils.AcquireByteBuffer()
+	defer utils.ReleaseByteBuffer(bb)
+
+	var flags byte
+	if r.headerChecksum {
+		flags |= rawV7FlagHeaderChecksum
+	}
+	// fake size, version, flags and transfer pipe
+	bb.B = append(bb.B[:0], 0, 0, 0, 0, rawProtoV7ID, flags, byte(m.XferPipe().Len()))
+	bb.Write(m.XferPipe().IDs())
+
+	prefixLen := bb.Len()
+
+	// header
+	err := r.writeHeader(bb, m)
+	if err != nil {
+		return err
+	}
+	if r.headerChecksum {
+		sum := crc32.Checksum(bb.B[prefixLen:], rawV7ChecksumTable)
+		bb.B = binary.BigEndian.AppendUint32(bb.B, sum)
+	}
+
+	// body
+	bodyBytes, err := m.MarshalBody()
+	if err != nil {
+		return err
+	}
+	bb.Write(bodyBytes)
+
+	// do transfer pipe
+	payload, err := m.XferPipe().OnPack(bb.B[prefixLen:])
+	if err != nil {
+		return err
+	}
+	bb.B = append(bb.B[:prefixLen], payload...)
+
+	// set and check message size
+	if uint64(bb.Len()) > math.MaxUint32 {
+		return ErrExceedMessageSizeLimit
+	}
+	err = m.SetSize(uint32(bb.Len()))
+	if err != nil {
+		return err
+	}
+
+	// reset real size
+	binary.BigEndian.PutUint32(bb.B, m.Size())
+
+	// real write
+	_, err = r.w.Write(bb.B)
+	return err
+}
+
+func (r *rawProtoV7) writeHeader(bb *utils.ByteBuffer, m Message) error {
+	bb.B = binary.AppendVarint(bb.B, WideSeqOf(m))
+	bb.B = append(bb.B, m.Mtype())
+	bb.B = appendRawV7String(bb.B, m.ServiceMethod())
+
+	stat := m.Status(true)
+	bb.B = binary.AppendVarint(bb.B, int64(stat.Code()))
+	msg := stat.Msg()
+	bb.B = appendRawV7String(bb.B, msg)
+	var cause string
+	if c := stat.Cause(); c != nil && c.Error() != msg {
+		cause = c.Error()
+	}
+	bb.B = appendRawV7String(bb.B, cause)
+
+	meta := m.Meta()
+	bb.B = binary.AppendUvarint(bb.B, uint64(meta.Len()))
+	meta.VisitAll(func(k, v []byte) {
+		bb.B = binary.AppendUvarint(bb.B, uint64(len(k)))
+		bb.B = append(bb.B, k...)
+		bb.B = binary.AppendUvarint(bb.B, uint64(len(v)))
+		bb.B = append(bb.B, v...)
+	})
+
+	bb.B = append(bb.B, m.BodyCodec())
+	return nil
+}
+
+func appendRawV7String(b []byte, s string) []byte {
+	b = binary.AppendUvarint(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+// Unpack reads bytes from the connection to the Message.
+// NOTE: Concurrent unsafe!
+func (r *rawProtoV7) Unpack(m Message) error {
+	bb := utils.AcquireByteBuffer()
+	defer utils.ReleaseByteBuffer(bb)
+
+	// read message
+	flags, done, err := r.readMessage(bb, m)
+	if err != nil || done {
+		return err
+	}
+	// do transfer pipe
+	data, err := m.XferPipe().OnUnpack(bb.B)
+	if err != nil {
+		return err
+	}
+	// header
+	data, err = r.readHeader(data, flags, m)
+	if err != nil {
+		return err
+	}
+	// body
+	return m.UnmarshalBody(data)
+}
+
+// readMessage reads the message bytes,
+// done is true if the message without transfer pipe has been read completely.
+func (r *rawProtoV7) readMessage(bb *utils.ByteBuffer, m Message) (flags byte, done bool, err error) {
+	r.rMu.Lock()
+	defer r.rMu.Unlock()
+
+	// size
+	bb.ChangeLen(4)
+	_, err = io.ReadFull(r.r, bb.B)
+	if err != nil {
+		return 0, false, err
+	}
+	_lastSize := binary.BigEndian.Uint32(bb.B)
+	if err = m.SetSize(_lastSize); err != nil {
+		return 0, false, err
+	}
+	lastSize, err := minus(int(_lastSize), 7)
+	if err != nil {
+		return 0, false, err
+	}
+
+	// version, flags and transfer pipe length
+	bb.ChangeLen(3)
+	_, err = io.ReadFull(r.r, bb.B)
+	if err != nil {
+		return 0, false, err
+	}
+	if bb.B[0] != rawProtoV7ID {
+		return 0, false, fmt.Errorf("raw proto v7: unsupported protocol version: %d", bb.B[0])
+	}
+	flags = bb.B[1]
+	if flags&^rawV7KnownFlags != 0 {
+		return 0, false, fmt.Errorf("raw proto v7: unsupported flags: %08b", flags)
+	}
+	xferLen := int(bb.B[2])
+	if xferLen > 0 {
+		if xferLen > lastSize {
+			return 0, false, errRawV7BadPackage
+		}
+		bb.ChangeLen(xferLen)
+		_, err = io.ReadFull(r.r, bb.B)
+		if err != nil {
+			return 0, false, err
+		}
+		err = m.XferPipe().Append(bb.B...)
+		if err != nil {
+			return 0, false, err
+		}
+	}
+	lastSize, err = minus(lastSize, xferLen)
+	if err != nil {
+		return 0, false, err
+	}
+	if xferLen == 0 {
+		return flags, true, r.readPlain(bb, flags, m, lastSize)
+	}
+	// the whole message is read for the transfer pipe
+	if err = CheckReadLimit(m); err != nil {
+		return 0, false, err
+	}
+	// read last all
+	bb.ChangeLen(lastSize)
+	_, err = io.ReadFull(r.r, bb.B)
+	return flags, false, err
+}
+
+// readPlain reads the message without transfer pipe.
+// NOTE:
+//
+//	The header is read and bound before the body,
+//	if no body is bound, e.g. the message exceeds the read limit, the body is discarded without buffering;
+//	The header exceeding the read limit is rejected;
+//	The message with transfer pipe is read as a whole, so it is rejected by CheckReadLimit instead.
+func (r *rawProtoV7) readPlain(bb *utils.ByteBuffer, flags byte, m Message, lastSize int) error {
+	bb.B = bb.B[:0]
+	readN := func(n uint64) error {
+		if n > uint64(lastSize-len(bb.B)) {
+			return errRawV7BadPackage
+		}
+		// the header is buffered, so it is bounded by the read limit
+		if limit := ReadLimitOf(m); limit > 0 && uint64(len(bb.B))+n > uint64(limit) {
+			return fmt.Errorf("raw proto v7: header: %w", ErrExceedMessageSizeLimit)
+		}
+		start := len(bb.B)
+		bb.B = slices.Grow(bb.B, int(n))[:start+int(n)]
+		_, err := io.ReadFull(r.r, bb.B[start:])
+		return err
+	}
+	readVarint := func() (uint64, error) {
+		start := len(bb.B)
+		for {
+			if err := readN(1); err != nil {
+				return 0, err
+			}
+			if bb.B[len(bb.B)-1] < 0x80 {
+				break
+			}
+		}
+		x, n := binary.Uvarint(bb.B[start:])
+		if n <= 0 {
+			return 0, errRawV7BadPackage
+		}
+		return x, nil
+	}
+	readBytes := func() error {
+		n, err := readVarint()
+		if err != nil {
+			return err
+		}
+		return readN(n)
+	}
+	// sequence and message type
+	if _, err := readVarint(); err != nil {
+		return err
+	}
+	if err := readN(1); err != nil {
+		return err
+	}
+	// service method
+	if err := readBytes(); err != nil {
+		return err
+	}
+	// status code, message and cause
+	if _, err := readVarint(); err != nil {
+		return err
+	}
+	for i := 0; i < 2; i++ {
+		if err := readBytes(); err != nil {
+			return err
+		}
+	}
+	// metadata
+	metaCount, err := readVarint()
+	if err != nil {
+		return err
+	}
+	for i := uint64(0); i < metaCount*2; i++ {
+		if err = readBytes(); err != nil {
+			return err
+		}
+	}
+	// body codec
+	if err = readN(1); err != nil {
+		return err
+	}
+	// header checksum
+	if flags&rawV7FlagHeaderChecksum != 0 {
+		if err = readN(rawV7HeaderChecksumLength); err != nil {
+			return err
+		}
+	}
+	if _, err = r.readHeader(bb.B, flags, m); err != nil {
+		return err
+	}
+	// bind the body
+	if err = m.UnmarshalBody(nil); err != nil {
+		return err
+	}
+	bodySize := lastSize - len(bb.B)
+	if m.Body() == nil {
+		_, err = io.CopyN(io.Discard, r.r, int64(bodySize))
+		return err
+	}
+	bb.ChangeLen(bodySize)
+	if _, err = io.ReadFull(r.r, bb.B); err != nil {
+		return err
+	}
+	return m.UnmarshalBody(bb.B)
+}
+
+func (r *rawProtoV7) readHeader(data []byte, flags byte, m Message) ([]byte, error) {
+	d := rawV7Decoder{data: data}
+
+	// seq
+	SetWideSeq(m, d.varint())
+
+	// type
+	m.SetMtype(d.byte())
+
+	// service method
+	m.SetServiceMethod(string(d.bytes()))
+
+	// status
+	code := d.varint()
+	msg := d.bytes()
+	cause := d.bytes()
+	if d.err != nil {
+		return nil, d.err
+	}
+	if code < math.MinInt32 || code > math.MaxInt32 {
+		return nil, errRawV7BadPackage
+	}
+	if code != 0 || len(msg) > 0 || len(cause) > 0 {
+		stat := m.Status(true)
+		stat.SetCode(int32(code))
+		stat.SetMsg(string(msg))
+		if len(cause) > 0 {
+			stat.SetCause(string(cause))
+		}
+	}
+
+	// meta
+	metaCount := d.uvarint()
+	meta := m.Meta()
+	for i := uint64(0); i < metaCount && d.err == nil; i++ {
+		k := d.bytes()
+		v := d.bytes()
+		if d.err == nil {
+			meta.AddBytesKV(k, v)
+		}
+	}
+
+	// body codec
+	m.SetBodyCodec(d.byte())
+	if d.err != nil {
+		return nil, d.err
+	}
+
+	// header checksum
+	if flags&rawV7FlagHeaderChecksum != 0 {
+		headerLen := len(data) - len(d.data)
+		if len(d.data) < rawV7HeaderChecksumLength {
+			return nil, errRawV7BadPackage
+		}
+		if crc32.Checksum(data[:headerLen], rawV7ChecksumTable) != binary.BigEndian.Uint32(d.data) {
+			return nil, errRawV7Checksum
+		}
+		d.data = d.data[rawV7HeaderChecksumLength:]
+	}
+	return d.data, nil
+}
+
+// rawV7Decoder reads the header fields and records the first error.
+type rawV7Decoder struct {
+	data []byte
+	err  error
+}
+
+func (d *rawV7Decoder) byte() byte {
+	if d.err != nil {
+		return 0
+	}
+	if len(d.data) == 0 {
+		d.err = errRawV7BadPackage
+		return 0
+	}
+	b := d.data[0]
+	d.data = d.data[1:]
+	return b
+}
+
+func (d *rawV7Decoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	x, n := binary.Uvarint(d.data)
+	if n <= 0 {
+		d.err = errRawV7BadPackage
+		return 0
+	}
+	d.data = d.data[n:]
+	return x
+}
+
+func (d *rawV7Decoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	x, n := binary.Varint(d.data)
+	if n <= 0 {
+		d.err = errRawV7BadPackage
+		return 0
+	}
+	d.data = d.data[n:]
+	return x
+}
+
+func (d *rawV7Decoder) bytes() []byte {
+	n := d.uvarint()
+	if d.err != nil {
+		return nil
+	}
+	if n > uint64(len(d.data)) {
+		d.err = errRawV7BadPackage
+		return nil
+	}
+	b := d.data[:n]
+	d.data = d.data[n:]
+	return b
+}
@@ -0,0 +1,218 @@
+package socket
+
+import (
+	"bytes"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/sqos/yrpc/codec"
+	"github.com/sqos/yrpc/xfer/gzip"
+)
+
+type testRW struct {
+	bytes.Buffer
+}
+
+func init() {
+	gzip.Reg('G', "gzip-raw7", 5)
+}
+
+func newRawV7TestMessage() Message {
+	m := GetMessage()
+	m.SetSeq(math.MinInt32)
+	m.SetMtype(2)
+	m.SetServiceMethod("/home/test")
+	m.SetStatus(NewStatus(400, "this is msg", "this is cause"))
+	m.Meta().Set("key", "value")
+	m.Meta().Add("key", "value2")
+	m.Meta().Set("big", strings.Repeat("m", 70000))
+	m.SetBodyCodec(codec.ID_JSON)
+	m.SetBody(map[string]string{"a": "b"})
+	return m
+}
+
+func TestRawProtoV7(t *testing.T) {
+	for _, headerChecksum := range []bool{false, true} {
+		rw := new(testRW)
+		proto := NewRawProtoV7Func(headerChecksum)(rw)
+		id, name := proto.Version()
+		assert.Equal(t, byte(7), id)
+		assert.Equal(t, "raw7", name)
+
+		for _, xferIDs := range [][]byte{nil, {'G'}} {
+			m := newRawV7TestMessage()
+			m.XferPipe().Append(xferIDs...)
+			assert.NoError(t, proto.Pack(m))
+			PutMessage(m)
+
+			var body map[string]string
+			m2 := GetMessage(WithBody(&body))
+			assert.NoError(t, proto.Unpack(m2))
+			assert.Equal(t, int32(math.MinInt32), m2.Seq())
+			assert.Equal(t, byte(2), m2.Mtype())
+			assert.Equal(t, "/home/test", m2.ServiceMethod())
+			assert.Equal(t, int32(400), m2.Status().Code())
+			assert.Equal(t, "this is msg", m2.Status().Msg())
+			assert.Equal(t, "this is cause", m2.Status().Cause().Error())
+			assert.Equal(t, [][]byte{[]byte("value"), []byte("value2")}, m2.Meta().PeekMulti("key"))
+			assert.Equal(t, 70000, len(m2.Meta().Peek("big")))
+			assert.Equal(t, byte(codec.ID_JSON), m2.BodyCodec())
+			assert.Equal(t, map[string]string{"a": "b"}, body)
+			assert.Equal(t, len(xferIDs), m2.XferPipe().Len())
+			PutMessage(m2)
+		}
+	}
+}
+
+func TestRawProtoV7OKStatus(t *testing.T) {
+	rw := new(testRW)
+	proto := RawProtoV7Func(rw)
+	m := GetMessage(WithBody([]byte("raw")))
+	m.SetSeq(1)
+	assert.NoError(t, proto.Pack(m))
+	PutMessage(m)
+
+	var body []byte
+	m = GetMessage(WithBody(&body))
+	defer PutMessage(m)
+	assert.NoError(t, proto.Unpack(m))
+	assert.True(t, m.StatusOK())
+	assert.Equal(t, int32(1), m.Seq())
+	assert.Equal(t, "raw", string(body))
+}
+
+func TestRawProtoV7WideSeq(t *testing.T) {
+	rw := new(testRW)
+	proto := RawProtoV7Func(rw)
+	for _, seq := range []int64{math.MaxInt32 + 1, 1<<32 + 5, math.MinInt64} {
+		m := GetMessage()
+		SetWideSeq(m, seq)
+		assert.Equal(t, int32(seq), m.Seq())
+		assert.NoError(t, proto.Pack(m))
+		PutMessage(m)
+
+		m = GetMessage()
+		assert.NoError(t, proto.Unpack(m))
+		assert.Equal(t, seq, WideSeqOf(m))
+		assert.Equal(t, int32(seq), m.Seq())
+		PutMessage(m)
+	}
+
+	// the protocol carrying only Seq() gets the sign extension
+	m := GetMessage()
+	defer PutMessage(m)
+	SetWideSeq(m, 1<<32+5)
+	assert.NoError(t, RawProtoFunc(rw).Pack(m))
+	m.Reset()
+	assert.NoError(t, RawProtoFunc(rw).Unpack(m))
+	assert.Equal(t, int64(5), WideSeqOf(m))
+}
+
+func TestRawProtoV7HeaderChecksum(t *testing.T) {
+	rw := new(testRW)
+	proto := NewRawProtoV7Func(true)(rw)
+	m := newRawV7TestMessage()
+	assert.NoError(t, proto.Pack(m))
+	PutMessage(m)
+
+	// tamper with the service method
+	b := rw.Bytes()
+	i := bytes.Index(b, []byte("/home/test"))
+	b[i+1] = 'H'
+
+	m = GetMessage()
+	defer PutMessage(m)
+	assert.Equal(t, errRawV7Checksum, proto.Unpack(m))
+}
+
+func TestRawProtoV7BadPackage(t *testing.T) {
+	rw := new(testRW)
+	// size:8, version:6
+	rw.Write([]byte{0, 0, 0, 8, 6, 0, 0, 0})
+	m := GetMessage()
+	defer PutMessage(m)
+	assert.Error(t, RawProtoV7Func(rw).Unpack(m))
+
+	rw.Reset()
+	// size:9, version:7, truncated header
+	rw.Write([]byte{0, 0, 0, 9, 7, 0, 0, 2, 1})
+	m.Reset()
+	assert.Equal(t, errRawV7BadPackage, RawProtoV7Func(rw).Unpack(m))
+}
+
+func benchmarkProto(b *testing.B, protoFunc ProtoFunc) {
+	rw := new(testRW)
+	proto := protoFunc(rw)
+	m := GetMessage()
+	m.SetSeq(math.MaxInt32)
+	m.SetMtype(1)
+	m.SetServiceMethod("/home/test")
+	for i := 0; i < 8; i++ {
+		m.Meta().Set("key"+string(rune('a'+i)), "value with some length")
+	}
+	m.SetBodyCodec(codec.ID_JSON)
+	m.SetBody(map[string]string{"a": "b"})
+	var body map[string]string
+	m2 := GetMessage(WithBody(&body))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := proto.Pack(m); err != nil {
+			b.Fatal(err)
+		}
+		m2.Meta().Reset()
+		if err := proto.Unpack(m2); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkRawProtoV6(b *testing.B) {
+	benchmarkProto(b, RawProtoFunc)
+}
+
+func BenchmarkRawProtoV7(b *testing.B) {
+	benchmarkProto(b, RawProtoV7Func)
+}
+
+func BenchmarkRawProtoV7HeaderChecksum(b *testing.B) {
+	benchmarkProto(b, NewRawProtoV7Func(true))
+}
+
+func TestRawProtoV7ReadLimit(t *testing.T) {
+	rw := new(testRW)
+	proto := RawProtoV7Func(rw)
+	pack := func(xferIDs ...byte) uint32 {
+		m := newRawV7TestMessage()
+		defer PutMessage(m)
+		m.XferPipe().Append(xferIDs...)
+		assert.NoError(t, proto.Pack(m))
+		return m.Size()
+	}
+
+	// the body of the plain message is discarded since no body is bound
+	size := pack()
+	m := GetMessage(WithReadLimit(size - 1))
+	assert.NoError(t, proto.Unpack(m))
+	assert.Equal(t, "/home/test", m.ServiceMethod())
+	assert.Nil(t, m.Body())
+	assert.Equal(t, 0, rw.Len())
+	PutMessage(m)
+
+	// the header exceeding the limit is rejected
+	pack()
+	m = GetMessage(WithReadLimit(1000))
+	assert.ErrorIs(t, proto.Unpack(m), ErrExceedMessageSizeLimit)
+	PutMessage(m)
+	rw.Reset()
+
+	// the message with transfer pipe is rejected after the prefix and the transfer pipe IDs
+	size = pack('G')
+	m = GetMessage(WithReadLimit(size - 1))
+	defer PutMessage(m)
+	assert.ErrorIs(t, proto.Unpack(m), ErrExceedMessageSizeLimit)
+	assert.Equal(t, int(size)-8, rw.Len())
+}
@@ -0,0 +1,36 @@
+package socket
+
+import (
+	"encoding/binary"
+	"io"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/sqos/yrpc/codec"
+)
+
+func TestRawProtoDiscardAlloc(t *testing.T) {
+	rw := new(testRW)
+	proto := RawProtoFunc(rw)
+	m := GetMessage(WithBody("body"))
+	m.SetSeq(1)
+	m.SetMtype(1)
+	m.SetServiceMethod("/home/test")
+	m.SetBodyCodec(codec.ID_JSON)
+	assert.NoError(t, proto.Pack(m))
+	PutMessage(m)
+
+	// declare a huge body, which is discarded since no body is bound
+	const hugeSize = 1 << 29
+	binary.BigEndian.PutUint32(rw.Bytes(), hugeSize)
+	m = GetMessage()
+	defer PutMessage(m)
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	assert.ErrorIs(t, proto.Unpack(m), io.EOF)
+	runtime.ReadMemStats(&after)
+	assert.Equal(t, "/home/test", m.ServiceMethod())
+	assert.Less(t, after.TotalAlloc-before.TotalAlloc, uint64(1<<20))
+}
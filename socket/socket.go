@@ -101,6 +101,11 @@ type (
 		// Raw returns the raw net.Conn
 		Raw() net.Conn
 	}
+	// ProtoVersioner is the optional interface of the Socket that reports the protocol in use.
+	ProtoVersioner interface {
+		// ProtoVersion returns the id and name of the protocol in use.
+		ProtoVersion() (byte, string)
+	}
 	// UnsafeSocket has more unsafe methods than Socket interface.
 	UnsafeSocket interface {
 		Socket
@@ -109,6 +114,11 @@ type (
 		// NOTE:
 		//  Make sure the external is locked before calling
 		RawLocked() net.Conn
+		// Buffered returns a copy of the data that has been read from
+		// the connection but not yet consumed by the socket.
+		// NOTE:
+		//  Make sure no message is being read concurrently
+		Buffered() []byte
 	}
 	socket struct {
 		net.Conn
@@ -130,8 +140,9 @@ const (
 )
 
 var (
-	_ net.Conn     = Socket(nil)
-	_ UnsafeSocket = new(socket)
+	_ net.Conn       = Socket(nil)
+	_ UnsafeSocket   = new(socket)
+	_ ProtoVersioner = new(socket)
 )
 
 // ErrProactivelyCloseSocket proactively close the socket error.
@@ -177,6 +188,14 @@ func (s *socket) Raw() net.Conn {
 	return conn
 }
 
+// ProtoVersion returns the id and name of the protocol in use.
+func (s *socket) ProtoVersion() (byte, string) {
+	s.mu.RLock()
+	protocol := s.protocol
+	s.mu.RUnlock()
+	return protocol.Version()
+}
+
 // RawLocked returns the raw net.Conn,
 // can be called in ProtoFunc.
 // NOTE:
@@ -186,6 +205,22 @@ func (s *socket) RawLocked() net.Conn {
 	return s.Conn
 }
 
+// Buffered returns a copy of the data that has been read from
+// the connection but not yet consumed by the socket.
+// NOTE:
+//
+//	Make sure no message is being read concurrently
+func (s *socket) Buffered() []byte {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	n := s.readerWithBuffer.Buffered()
+	if n == 0 {
+		return nil
+	}
+	b, _ := s.readerWithBuffer.Peek(n)
+	return append([]byte(nil), b...)
+}
+
 // Read reads data from the connection.
 // Read can be made to time out and return an Error with Timeout() == true
 // after a fixed time limit; see SetDeadline and SetReadDeadline.
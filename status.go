@@ -71,26 +71,29 @@ func NewStatusByCodeText(code int32, cause interface{}, tagStack bool) *Status {
 //	message handling error code range: [400,499].
 //	receiver peer error code range: [500,599].
 const (
-	CodeUnknownError        int32 = -1
-	CodeOK                  int32 = 0      // nil error (ok)
-	CodeNoError             int32 = CodeOK // nil error (ok)
-	CodeInvalidOp           int32 = 1
-	CodeWrongConn           int32 = 100
-	CodeConnClosed          int32 = 102
-	CodeWriteFailed         int32 = 104
-	CodeDialFailed          int32 = 105
-	CodeBadMessage          int32 = 400
-	CodeUnauthorized        int32 = 401
-	CodeNotFound            int32 = 404
-	CodeMtypeNotAllowed     int32 = 405
-	CodeHandleTimeout       int32 = 408
-	CodeInternalServerError int32 = 500
-	CodeBadGateway          int32 = 502
+	CodeUnknownError         int32 = -1
+	CodeOK                   int32 = 0      // nil error (ok)
+	CodeNoError              int32 = CodeOK // nil error (ok)
+	CodeInvalidOp            int32 = 1
+	CodeWrongConn            int32 = 100
+	CodeConnClosed           int32 = 102
+	CodeWriteFailed          int32 = 104
+	CodeDialFailed           int32 = 105
+	CodeBadMessage           int32 = 400
+	CodeUnauthorized         int32 = 401
+	CodeForbidden            int32 = 403
+	CodeNotFound             int32 = 404
+	CodeMtypeNotAllowed      int32 = 405
+	CodeHandleTimeout        int32 = 408
+	CodeConflict             int32 = 409
+	CodeUnsupportedTx        int32 = 410
+	CodePayloadTooLarge      int32 = 413
+	CodeUnsupportedCodecType int32 = 415
+	CodeTooManyRequests      int32 = 429
+	CodeInternalServerError  int32 = 500
+	CodeBadGateway           int32 = 502
+	CodeServiceUnavailable   int32 = 503
 
-	// CodeConflict                      int32 = 409
-	// CodeUnsupportedTx                 int32 = 410
-	// CodeUnsupportedCodecType          int32 = 415
-	// CodeServiceUnavailable            int32 = 503
 	// CodeGatewayTimeout                int32 = 504
 	// CodeVariantAlsoNegotiates         int32 = 506
 	// CodeInsufficientStorage           int32 = 507
@@ -111,6 +114,8 @@ func CodeText(statCode int32) string {
 		return "Bad Message"
 	case CodeUnauthorized:
 		return "Unauthorized"
+	case CodeForbidden:
+		return "Forbidden"
 	case CodeDialFailed:
 		return "Dial Failed"
 	case CodeWrongConn:
@@ -123,12 +128,24 @@ func CodeText(statCode int32) string {
 		return "Not Found"
 	case CodeHandleTimeout:
 		return "Handle Timeout"
+	case CodeConflict:
+		return "Conflict"
 	case CodeMtypeNotAllowed:
 		return "Message Type Not Allowed"
+	case CodeUnsupportedTx:
+		return "Unsupported Transfer Filter"
+	case CodePayloadTooLarge:
+		return "Payload Too Large"
+	case CodeUnsupportedCodecType:
+		return "Unsupported Codec Type"
+	case CodeTooManyRequests:
+		return "Too Many Requests"
 	case CodeInternalServerError:
 		return "Internal Server Error"
 	case CodeBadGateway:
 		return "Bad Gateway"
+	case CodeServiceUnavailable:
+		return "Service Unavailable"
 	case CodeUnknownError:
 		fallthrough
 	default:
@@ -147,6 +164,7 @@ var (
 	statNotFound            = NewStatus(CodeNotFound, CodeText(CodeNotFound), "")
 	statCodeMtypeNotAllowed = NewStatus(CodeMtypeNotAllowed, CodeText(CodeMtypeNotAllowed), "")
 	statHandleTimeout       = NewStatus(CodeHandleTimeout, CodeText(CodeHandleTimeout), "")
+	statPayloadTooLarge     = NewStatus(CodePayloadTooLarge, CodeText(CodePayloadTooLarge), "")
 	statInternalServerError = NewStatus(CodeInternalServerError, CodeText(CodeInternalServerError), "")
 )
 
@@ -0,0 +1,273 @@
+// Copyright 2024 sqos. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package yrpc
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// TLSReloaderConfig is the config of the hot-reloadable TLS certificate source.
+type TLSReloaderConfig struct {
+	// CertFile is the PEM encoded certificate (chain) file.
+	CertFile string
+	// KeyFile is the PEM encoded private key file.
+	KeyFile string
+	// CAFile is the optional PEM encoded CA bundle file,
+	// which is used to verify the remote certificates.
+	CAFile string
+	// ClientAuth is the policy of the server side for TLS client authentication,
+	// the CA bundle is used as ClientCAs.
+	ClientAuth tls.ClientAuthType
+	// InsecureSkipVerifyForClient skips verifying the server certificate on the client side.
+	InsecureSkipVerifyForClient bool
+	// WatchInterval is the interval to check the files for changes,
+	// the files are not watched if it is <= 0, call Reload to trigger explicitly.
+	WatchInterval time.Duration
+	// OnReload is called after each reload triggered by the watcher, err is nil if succeeded.
+	OnReload func(err error)
+}
+
+// TLSReloader is a TLS certificate source that can be reloaded without restarting the peer.
+// NOTE:
+//
+//	The existing sessions are not affected, the new certificate and CA bundle are used by the next handshakes;
+//	If reloading fails, the previous certificate and CA bundle are kept.
+type TLSReloader struct {
+	cfg       TLSReloaderConfig
+	state     atomic.Pointer[tlsReloaderState]
+	mu        sync.Mutex
+	modTimes  [3]time.Time
+	closeCh   chan struct{}
+	closeOnce sync.Once
+}
+
+type tlsReloaderState struct {
+	cert       *tls.Certificate
+	certExpiry time.Time
+	caPool     *x509.CertPool
+	caExpiry   time.Time
+}
+
+// NewTLSReloader loads the files and creates a hot-reloadable TLS certificate source.
+func NewTLSReloader(cfg TLSReloaderConfig) (*TLSReloader, error) {
+	r := &TLSReloader{
+		cfg:     cfg,
+		closeCh: make(chan struct{}),
+	}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	if cfg.WatchInterval > 0 {
+		go r.watch()
+	}
+	return r, nil
+}
+
+// Reload reloads the certificate, private key and CA bundle files.
+func (r *TLSReloader) Reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	// record the files even if failed, so that the watcher retries only after the next change
+	r.modTimes = r.fileModTimes()
+	cert, err := tls.LoadX509KeyPair(r.cfg.CertFile, r.cfg.KeyFile)
+	if err != nil {
+		return err
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return err
+	}
+	cert.Leaf = leaf
+	state := &tlsReloaderState{
+		cert:       &cert,
+		certExpiry: leaf.NotAfter,
+	}
+	if r.cfg.CAFile != "" {
+		state.caPool, state.caExpiry, err = loadCAFile(r.cfg.CAFile)
+		if err != nil {
+			return err
+		}
+	}
+	r.state.Store(state)
+	return nil
+}
+
+func loadCAFile(caFile string) (*x509.CertPool, time.Time, error) {
+	pemBytes, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	var (
+		pool   = x509.NewCertPool()
+		expiry time.Time
+		block  *pem.Block
+	)
+	for {
+		block, pemBytes = pem.Decode(pemBytes)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, time.Time{}, fmt.Errorf("%s: %w", caFile, err)
+		}
+		pool.AddCert(cert)
+		if expiry.IsZero() || cert.NotAfter.Before(expiry) {
+			expiry = cert.NotAfter
+		}
+	}
+	if expiry.IsZero() {
+		return nil, time.Time{}, fmt.Errorf("%s: no CA certificate found", caFile)
+	}
+	return pool, expiry, nil
+}
+
+// Certificate returns the current certificate.
+func (r *TLSReloader) Certificate() *tls.Certificate {
+	return r.state.Load().cert
+}
+
+// CertExpiry returns the expiry time of the current leaf certificate.
+func (r *TLSReloader) CertExpiry() time.Time {
+	return r.state.Load().certExpiry
+}
+
+// CAExpiry returns the earliest expiry time of the current CA bundle,
+// it is zero if no CA file is configured.
+func (r *TLSReloader) CAExpiry() time.Time {
+	return r.state.Load().caExpiry
+}
+
+// CAPool returns the current CA bundle, it is nil if no CA file is configured.
+func (r *TLSReloader) CAPool() *x509.CertPool {
+	return r.state.Load().caPool
+}
+
+// Close stops watching the files.
+func (r *TLSReloader) Close() {
+	r.closeOnce.Do(func() {
+		close(r.closeCh)
+	})
+}
+
+// TLSConfig creates a TLS config that always uses the current certificate and CA bundle.
+// NOTE:
+//
+//	It can be used on both the server and client side, e.g. peer.SetTLSConfig(reloader.TLSConfig());
+//	On the server side, GetConfigForClient serves the current certificate and uses the CA bundle as ClientCAs;
+//	On the client side, GetClientCertificate serves the current certificate,
+//	and the server certificate is verified against the CA bundle by VerifyConnection,
+//	which fails if ServerName is neither set nor taken from the dial address.
+func (r *TLSReloader) TLSConfig() *tls.Config {
+	base := newTLSConfig(tls.Certificate{})
+	base.Certificates = nil
+	base.GetCertificate = func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+		return r.Certificate(), nil
+	}
+	base.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+		return r.Certificate(), nil
+	}
+	serverConfig := base.Clone()
+	base.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		c := serverConfig.Clone()
+		c.ClientAuth = r.cfg.ClientAuth
+		c.ClientCAs = r.CAPool()
+		return c, nil
+	}
+	if r.cfg.InsecureSkipVerifyForClient {
+		base.InsecureSkipVerify = true
+	} else if r.cfg.CAFile != "" {
+		// the built-in verification uses a static RootCAs, so verify by the current CA bundle instead
+		base.InsecureSkipVerify = true
+		base.VerifyConnection = r.verifyServer
+	}
+	return base
+}
+
+func (r *TLSReloader) verifyServer(cs tls.ConnectionState) error {
+	if len(cs.PeerCertificates) == 0 {
+		return errors.New("tls: server did not provide a certificate")
+	}
+	// any host name would be accepted without it
+	if cs.ServerName == "" {
+		return errors.New("tls: ServerName is required to verify the server certificate")
+	}
+	opts := x509.VerifyOptions{
+		Roots:         r.CAPool(),
+		DNSName:       cs.ServerName,
+		Intermediates: x509.NewCertPool(),
+	}
+	for _, cert := range cs.PeerCertificates[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	chains, err := cs.PeerCertificates[0].Verify(opts)
+	if err != nil {
+		return err
+	}
+	// the PeerIdentity reads them as the VerifiedChains
+	verifiedChains.add(cs.PeerCertificates[0], chains)
+	return nil
+}
+
+func (r *TLSReloader) fileModTimes() [3]time.Time {
+	var modTimes [3]time.Time
+	for i, name := range []string{r.cfg.CertFile, r.cfg.KeyFile, r.cfg.CAFile} {
+		if name == "" {
+			continue
+		}
+		if info, err := os.Stat(name); err == nil {
+			modTimes[i] = info.ModTime()
+		}
+	}
+	return modTimes
+}
+
+func (r *TLSReloader) watch() {
+	ticker := time.NewTicker(r.cfg.WatchInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.closeCh:
+			return
+		case <-ticker.C:
+		}
+		r.mu.Lock()
+		changed := r.fileModTimes() != r.modTimes
+		r.mu.Unlock()
+		if !changed {
+			continue
+		}
+		err := r.Reload()
+		if err != nil {
+			Errorf("reload TLS certificate: %s", err.Error())
+		} else {
+			Infof("reload TLS certificate: expiry=%s", r.CertExpiry().Format(time.RFC3339))
+		}
+		if r.cfg.OnReload != nil {
+			r.cfg.OnReload(err)
+		}
+	}
+}
@@ -0,0 +1,222 @@
+package yrpc_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/sqos/yrpc"
+	"github.com/sqos/yrpc/yrpctest"
+)
+
+type testPKI struct {
+	caCert *x509.Certificate
+	caKey  *ecdsa.PrivateKey
+	caPEM  []byte
+}
+
+func newTestPKI(t *testing.T, cn string, notAfter time.Time) *testPKI {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              notAfter,
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testPKI{
+		caCert: cert,
+		caKey:  key,
+		caPEM:  pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+	}
+}
+
+func (p *testPKI) issue(t *testing.T, cn string, notAfter time.Time) (certPEM, keyPEM []byte) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, p.caCert, &key.PublicKey, p.caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func writeFile(t *testing.T, name string, data []byte) {
+	if err := os.WriteFile(name, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+type TLSHome struct {
+	yrpc.CallCtx
+}
+
+func (h *TLSHome) Whoami(*struct{}) (string, *yrpc.Status) {
+	return h.Session().PeerIdentity().CommonName, nil
+}
+
+func TestTLSReloader(t *testing.T) {
+	dir := t.TempDir()
+	var (
+		srvCertFile = filepath.Join(dir, "server.crt")
+		srvKeyFile  = filepath.Join(dir, "server.key")
+		cliCertFile = filepath.Join(dir, "client.crt")
+		cliKeyFile  = filepath.Join(dir, "client.key")
+		caFile      = filepath.Join(dir, "ca.crt")
+		expiry1     = time.Now().Add(24 * time.Hour).Truncate(time.Second).UTC()
+		expiry2     = time.Now().Add(48 * time.Hour).Truncate(time.Second).UTC()
+	)
+	pki1 := newTestPKI(t, "ca1", expiry2)
+	pki2 := newTestPKI(t, "ca2", expiry2.Add(time.Hour))
+	writeCerts := func(pki *testPKI, srvCN, cliCN string, notAfter time.Time) {
+		certPEM, keyPEM := pki.issue(t, srvCN, notAfter)
+		writeFile(t, srvCertFile, certPEM)
+		writeFile(t, srvKeyFile, keyPEM)
+		certPEM, keyPEM = pki.issue(t, cliCN, notAfter)
+		writeFile(t, cliCertFile, certPEM)
+		writeFile(t, cliKeyFile, keyPEM)
+		writeFile(t, caFile, pki.caPEM)
+	}
+	writeCerts(pki1, "server1", "client1", expiry1)
+
+	srvReloader, err := yrpc.NewTLSReloader(yrpc.TLSReloaderConfig{
+		CertFile:   srvCertFile,
+		KeyFile:    srvKeyFile,
+		CAFile:     caFile,
+		ClientAuth: tls.RequireAndVerifyClientCert,
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	reloaded := make(chan error, 1)
+	cliReloader, err := yrpc.NewTLSReloader(yrpc.TLSReloaderConfig{
+		CertFile:      cliCertFile,
+		KeyFile:       cliKeyFile,
+		CAFile:        caFile,
+		WatchInterval: 10 * time.Millisecond,
+		OnReload:      func(err error) { reloaded <- err },
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer cliReloader.Close()
+	assert.Equal(t, expiry1, srvReloader.CertExpiry())
+	assert.Equal(t, expiry2, srvReloader.CAExpiry())
+
+	tlsPlugin := func(c *tls.Config) yrpc.Plugin {
+		return &yrpc.PluginImpl{
+			PluginName: "tls",
+			OnPostNewPeer: func(peer yrpc.EarlyPeer) error {
+				peer.SetTLSConfig(c)
+				return nil
+			},
+		}
+	}
+	srv, addr := yrpctest.NewServer(t, yrpc.PeerConfig{}, tlsPlugin(srvReloader.TLSConfig()))
+	srv.RouteCall(new(TLSHome))
+	cliTLSConfig := cliReloader.TLSConfig()
+	cliTLSConfig.ServerName = "localhost"
+
+	// dial wraps the raw connection with cliTLSConfig as is
+	dial := func() (yrpc.Session, *yrpc.Status) {
+		conn, err := yrpctest.DialConn(addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return yrpctest.NewClient(t, yrpc.PeerConfig{}).ServeConn(tls.Client(conn, cliTLSConfig))
+	}
+	whoami := func(sess yrpc.Session) string {
+		var cn string
+		stat := sess.Call("/tlshome/whoami", nil, &cn).Status()
+		assert.True(t, stat.OK(), stat)
+		return cn
+	}
+
+	sess1, stat := dial()
+	if !assert.True(t, stat.OK(), stat) {
+		return
+	}
+	assert.Equal(t, "client1", whoami(sess1))
+	assert.Equal(t, "server1", sess1.PeerIdentity().CommonName)
+	// verified by VerifyConnection with InsecureSkipVerify
+	assert.True(t, sess1.PeerIdentity().Verified)
+	assert.NotEmpty(t, sess1.PeerIdentity().VerifiedChains)
+
+	// the ServerName of the custom network connection is the host of the dial address
+	_, port, _ := net.SplitHostPort(addr)
+	memCli := yrpctest.NewClient(t, yrpc.PeerConfig{}, tlsPlugin(cliReloader.TLSConfig()))
+	memSess := yrpctest.Dial(t, memCli, net.JoinHostPort("localhost", port))
+	assert.Equal(t, "client1", whoami(memSess))
+	assert.True(t, memSess.PeerIdentity().Verified)
+
+	// rotate to the new CA and certificates
+	writeCerts(pki2, "server2", "client2", expiry2)
+	future := time.Now().Add(time.Second)
+	for _, name := range []string{srvCertFile, srvKeyFile, cliCertFile, cliKeyFile, caFile} {
+		os.Chtimes(name, future, future)
+	}
+	assert.NoError(t, srvReloader.Reload())
+	select {
+	case err = <-reloaded:
+		assert.NoError(t, err)
+	case <-time.After(3 * time.Second):
+		t.Fatal("watcher did not reload")
+	}
+	assert.Equal(t, expiry2, srvReloader.CertExpiry())
+	assert.Equal(t, expiry2.Add(time.Hour), cliReloader.CAExpiry())
+
+	// the existing session is kept
+	assert.Equal(t, "client1", whoami(sess1))
+
+	sess2, stat := dial()
+	if !assert.True(t, stat.OK(), stat) {
+		return
+	}
+	assert.Equal(t, "client2", whoami(sess2))
+	assert.Equal(t, "server2", sess2.PeerIdentity().CommonName)
+
+	// a broken file keeps the previous certificate
+	writeFile(t, srvKeyFile, []byte("broken"))
+	assert.Error(t, srvReloader.Reload())
+	assert.Equal(t, "server2", srvReloader.Certificate().Leaf.Subject.CommonName)
+
+	// the server certificate is verified against the current CA bundle
+	for _, serverName := range []string{"example.com", ""} {
+		cliTLSConfig.ServerName = serverName
+		sess3, stat := dial()
+		if assert.True(t, stat.OK(), stat) {
+			var cn string
+			stat = sess3.Call("/tlshome/whoami", nil, &cn).Status()
+			assert.False(t, stat.OK(), serverName)
+		}
+	}
+}
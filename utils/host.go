@@ -0,0 +1,11 @@
+package utils
+
+import "net"
+
+// HostOf returns the host of the address, or the address itself if it has no port.
+func HostOf(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
@@ -0,0 +1,38 @@
+package utils
+
+// MatchWildcard reports whether s matches the pattern, '*' matches any sequence of characters.
+func MatchWildcard(pattern, s string) bool {
+	var px, sx int
+	// the position to restart when a mismatch happens after '*'
+	nextPx, nextSx := -1, 0
+	for px < len(pattern) || sx < len(s) {
+		if px < len(pattern) {
+			if pattern[px] == '*' {
+				nextPx, nextSx = px, sx+1
+				px++
+				continue
+			}
+			if sx < len(s) && pattern[px] == s[sx] {
+				px++
+				sx++
+				continue
+			}
+		}
+		if nextPx >= 0 && nextSx <= len(s) {
+			px, sx = nextPx, nextSx
+			continue
+		}
+		return false
+	}
+	return true
+}
+
+// MatchAnyWildcard reports whether s matches any of the patterns.
+func MatchAnyWildcard(patterns []string, s string) bool {
+	for _, p := range patterns {
+		if MatchWildcard(p, s) {
+			return true
+		}
+	}
+	return false
+}
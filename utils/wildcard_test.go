@@ -0,0 +1,38 @@
+package utils
+
+import "testing"
+
+func TestMatchWildcard(t *testing.T) {
+	cases := []struct {
+		pattern, s string
+		want       bool
+	}{
+		{"*", "", true},
+		{"/admin/*", "/admin/user/delete", true},
+		{"/*/get", "/user/info/get", true},
+		{"spiffe://*/sa/web", "spiffe://example.org/ns/prod/sa/web", true},
+		{"a*b*c", "aXXbYYbc", true},
+		{"/admin/*", "/public/ping", false},
+		{"/user/get", "/user/get_all", false},
+		{"a*b", "acbd", false},
+		{"abc", "ab", false},
+	}
+	for _, c := range cases {
+		if got := MatchWildcard(c.pattern, c.s); got != c.want {
+			t.Errorf("MatchWildcard(%q, %q) = %v, want %v", c.pattern, c.s, got, c.want)
+		}
+	}
+}
+
+func TestMatchAnyWildcard(t *testing.T) {
+	patterns := []string{"/admin/*", "/user/get"}
+	if !MatchAnyWildcard(patterns, "/admin/user/delete") {
+		t.Error("MatchAnyWildcard should match /admin/user/delete")
+	}
+	if MatchAnyWildcard(patterns, "/user/get_all") {
+		t.Error("MatchAnyWildcard should not match /user/get_all")
+	}
+	if MatchAnyWildcard(nil, "") {
+		t.Error("MatchAnyWildcard should not match with no patterns")
+	}
+}
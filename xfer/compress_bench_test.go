@@ -0,0 +1,79 @@
+package xfer_test
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/sqos/yrpc/xfer"
+	"github.com/sqos/yrpc/xfer/gzip"
+	"github.com/sqos/yrpc/xfer/lz4"
+	"github.com/sqos/yrpc/xfer/snappy"
+	"github.com/sqos/yrpc/xfer/threshold"
+	"github.com/sqos/yrpc/xfer/zstd"
+)
+
+var benchFilters = []string{"gzip", "zstd", "snappy", "lz4", "threshold-zstd"}
+
+func init() {
+	gzip.Reg('g', "gzip", 5)
+	zstd.Reg('z', "zstd", 3)
+	snappy.Reg('s', "snappy")
+	lz4.Reg('l', "lz4", 0)
+	threshold.Reg('t', "threshold-zstd", 'z', 256)
+}
+
+var benchSizes = []struct {
+	name string
+	size int
+}{{"64B", 64}, {"1KB", 1024}, {"64KB", 64 * 1024}}
+
+func benchPayload(size int) []byte {
+	var buf bytes.Buffer
+	for i := 0; buf.Len() < size; i++ {
+		fmt.Fprintf(&buf, `{"id":%d,"name":"user-%d","email":"user-%d@example.com","active":%t},`, i, i, i, i%2 == 0)
+	}
+	return buf.Bytes()[:size]
+}
+
+func BenchmarkOnPack(b *testing.B) {
+	for _, size := range benchSizes {
+		payload := benchPayload(size.size)
+		for _, name := range benchFilters {
+			f, _ := xfer.GetByName(name)
+			b.Run(name+"/"+size.name, func(b *testing.B) {
+				src := make([]byte, len(payload), len(payload)+1)
+				b.SetBytes(int64(len(payload)))
+				b.ReportAllocs()
+				var packed []byte
+				for i := 0; i < b.N; i++ {
+					copy(src, payload)
+					packed, _ = f.OnPack(src[:len(payload)])
+				}
+				b.ReportMetric(float64(len(packed))/float64(len(payload)), "ratio")
+			})
+		}
+	}
+}
+
+func BenchmarkOnUnpack(b *testing.B) {
+	for _, size := range benchSizes {
+		payload := benchPayload(size.size)
+		for _, name := range benchFilters {
+			f, _ := xfer.GetByName(name)
+			packed, err := f.OnPack(append([]byte{}, payload...))
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.Run(name+"/"+size.name, func(b *testing.B) {
+				b.SetBytes(int64(len(payload)))
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					if _, err := f.OnUnpack(append([]byte{}, packed...)); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
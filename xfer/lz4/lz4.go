@@ -0,0 +1,143 @@
+// Copyright 2024 sqos. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package lz4 provides a lz4 block compression transfer filter.
+package lz4
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/pierrec/lz4/v4"
+
+	"github.com/sqos/yrpc/socket"
+	"github.com/sqos/yrpc/xfer"
+)
+
+// Reg registers a lz4 filter for transfer.
+// NOTE: level 0 is the fast mode, levels in the range [1,9] use the high compression mode.
+func Reg(id byte, name string, level int) {
+	xfer.Reg(newLz4(id, name, level))
+}
+
+// newLz4 creates a new lz4 filter.
+func newLz4(id byte, name string, level int) *Lz4 {
+	if level < 0 || level > 9 {
+		panic(fmt.Sprintf("lz4: invalid compression level: %d", level))
+	}
+	l := &Lz4{
+		id:    id,
+		name:  name,
+		level: level,
+	}
+	if level == 0 {
+		l.cPool.New = func() interface{} {
+			return new(lz4.Compressor)
+		}
+	} else {
+		l.cPool.New = func() interface{} {
+			return &lz4.CompressorHC{Level: lz4.CompressionLevel(1 << (8 + level))}
+		}
+	}
+	return l
+}
+
+type compressor interface {
+	CompressBlock(src, dst []byte) (int, error)
+}
+
+// Lz4 compression filter
+// NOTE: The frame is a flag byte, followed by the uvarint length of the source and the lz4 block,
+// or followed by the source if it is incompressible.
+type Lz4 struct {
+	id    byte
+	name  string
+	level int
+	cPool sync.Pool
+}
+
+const (
+	flagRaw byte = iota
+	flagBlock
+)
+
+var errCorrupt = errors.New("lz4: corrupt input")
+
+// ID returns transfer filter id.
+func (l *Lz4) ID() byte {
+	return l.id
+}
+
+// Name returns transfer filter name.
+func (l *Lz4) Name() string {
+	return l.name
+}
+
+// OnPack performs filtering on packing.
+func (l *Lz4) OnPack(src []byte) ([]byte, error) {
+	dst := make([]byte, 1+binary.MaxVarintLen64+lz4.CompressBlockBound(len(src)))
+	dst[0] = flagBlock
+	n := 1 + binary.PutUvarint(dst[1:], uint64(len(src)))
+	c := l.cPool.Get().(compressor)
+	size, err := c.CompressBlock(src, dst[n:])
+	l.cPool.Put(c)
+	if err != nil {
+		return nil, err
+	}
+	if size == 0 || n+size >= 1+len(src) {
+		dst = dst[:1+len(src)]
+		dst[0] = flagRaw
+		copy(dst[1:], src)
+		return dst, nil
+	}
+	return dst[:n+size], nil
+}
+
+// OnUnpack performs filtering on unpacking.
+// NOTE: The declared length is checked against socket.MessageSizeLimit() before it is allocated.
+func (l *Lz4) OnUnpack(src []byte) ([]byte, error) {
+	if len(src) == 0 {
+		return src, nil
+	}
+	switch src[0] {
+	case flagRaw:
+		return src[1:], nil
+	case flagBlock:
+	default:
+		return nil, errCorrupt
+	}
+	size, n := binary.Uvarint(src[1:])
+	if n <= 0 {
+		return nil, errCorrupt
+	}
+	block := src[1+n:]
+	// the lz4 compression ratio is at most 255
+	if size > uint64(len(block))*255+16 {
+		return nil, errCorrupt
+	}
+	if size > uint64(socket.MessageSizeLimit()) {
+		return nil, fmt.Errorf("lz4: declared length %d: %w", size, socket.ErrExceedMessageSizeLimit)
+	}
+	dst := make([]byte, size)
+	m, err := lz4.UncompressBlock(block, dst)
+	if err != nil {
+		return nil, err
+	}
+	if uint64(m) != size {
+		return nil, errCorrupt
+	}
+	return dst, nil
+}
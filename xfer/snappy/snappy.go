@@ -0,0 +1,70 @@
+// Copyright 2024 sqos. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package snappy provides a snappy compression transfer filter.
+package snappy
+
+import (
+	"fmt"
+
+	"github.com/klauspost/compress/snappy"
+
+	"github.com/sqos/yrpc/socket"
+	"github.com/sqos/yrpc/xfer"
+)
+
+// Reg registers a snappy filter for transfer.
+func Reg(id byte, name string) {
+	xfer.Reg(&Snappy{
+		id:   id,
+		name: name,
+	})
+}
+
+// Snappy compression filter
+type Snappy struct {
+	id   byte
+	name string
+}
+
+// ID returns transfer filter id.
+func (s *Snappy) ID() byte {
+	return s.id
+}
+
+// Name returns transfer filter name.
+func (s *Snappy) Name() string {
+	return s.name
+}
+
+// OnPack performs filtering on packing.
+func (s *Snappy) OnPack(src []byte) ([]byte, error) {
+	return snappy.Encode(nil, src), nil
+}
+
+// OnUnpack performs filtering on unpacking.
+// NOTE: The decoded length is checked against socket.MessageSizeLimit() before it is allocated.
+func (s *Snappy) OnUnpack(src []byte) ([]byte, error) {
+	if len(src) == 0 {
+		return src, nil
+	}
+	n, err := snappy.DecodedLen(src)
+	if err != nil {
+		return nil, err
+	}
+	if uint64(n) > uint64(socket.MessageSizeLimit()) {
+		return nil, fmt.Errorf("snappy: decoded length %d: %w", n, socket.ErrExceedMessageSizeLimit)
+	}
+	return snappy.Decode(nil, src)
+}
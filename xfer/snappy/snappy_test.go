@@ -0,0 +1,51 @@
+package snappy_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+
+	"github.com/sqos/yrpc/socket"
+	"github.com/sqos/yrpc/xfer"
+	"github.com/sqos/yrpc/xfer/snappy"
+)
+
+func TestSnappy(t *testing.T) {
+	// test register
+	snappy.Reg('s', "snappy")
+
+	if _, err := xfer.Get('s'); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := xfer.GetByName("snappy"); err != nil {
+		t.Fatal(err)
+	}
+	xferPipe := xfer.NewXferPipe()
+	xferPipe.Append('s')
+	t.Logf("transfer filter: ids:%v, names:%v", xferPipe.IDs(), xferPipe.Names())
+
+	// test logic
+	large := bytes.Repeat([]byte("src"), 10000)
+	for _, want := range [][]byte{{}, []byte("src"), large} {
+		b, err := xferPipe.OnPack(want)
+		if err != nil {
+			t.Fatalf("nopack: %v", err)
+		}
+		src, err := xferPipe.OnUnpack(b)
+		if err != nil {
+			t.Fatalf("nounpack: %v", err)
+		}
+		if !bytes.Equal(src, want) {
+			t.Fatalf("snappy has error: want len %d, have len %d", len(want), len(src))
+		}
+	}
+	if _, err := xferPipe.OnUnpack([]byte("bad frame")); err == nil {
+		t.Fatal("expect error for corrupt input")
+	}
+	// the decoded length exceeding the message size limit is rejected before it is allocated
+	huge := binary.AppendUvarint(nil, uint64(socket.MessageSizeLimit())+1)
+	if _, err := xferPipe.OnUnpack(append(huge, 0)); !errors.Is(err, socket.ErrExceedMessageSizeLimit) {
+		t.Fatalf("expect the size limit error, have %v", err)
+	}
+}
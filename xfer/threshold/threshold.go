@@ -0,0 +1,109 @@
+// Copyright 2024 sqos. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package threshold provides a transfer filter wrapper that compresses only large payloads.
+package threshold
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/sqos/yrpc/xfer"
+)
+
+// Reg registers a threshold filter for transfer, which wraps the registered inner filter.
+// NOTE:
+//
+//	Only the payload whose length is not less than minSize is filtered by the inner filter;
+//	A trailing flag byte marks whether the frame is filtered or not;
+//	The inner filter must be registered before.
+func Reg(id byte, name string, innerID byte, minSize int) {
+	inner, err := xfer.Get(innerID)
+	if err != nil {
+		panic(fmt.Sprintf("threshold: %v", err))
+	}
+	if minSize < 0 {
+		panic(fmt.Sprintf("threshold: invalid min size: %d", minSize))
+	}
+	xfer.Reg(&Threshold{
+		id:      id,
+		name:    name,
+		inner:   inner,
+		minSize: minSize,
+	})
+}
+
+// Threshold is a wrapper filter that skips the inner filter for small payloads.
+type Threshold struct {
+	id      byte
+	name    string
+	inner   xfer.XferFilter
+	minSize int
+}
+
+const (
+	flagRaw byte = iota
+	flagFiltered
+)
+
+var errCorrupt = errors.New("threshold: corrupt input")
+
+// ID returns transfer filter id.
+func (t *Threshold) ID() byte {
+	return t.id
+}
+
+// Name returns transfer filter name.
+func (t *Threshold) Name() string {
+	return t.name
+}
+
+// Inner returns the wrapped filter.
+func (t *Threshold) Inner() xfer.XferFilter {
+	return t.inner
+}
+
+// MinSize returns the minimum payload length to be filtered by the inner filter.
+func (t *Threshold) MinSize() int {
+	return t.minSize
+}
+
+// OnPack performs filtering on packing.
+// NOTE: The flag is appended to a copy, the spare capacity of src may be used by the caller.
+func (t *Threshold) OnPack(src []byte) ([]byte, error) {
+	if len(src) < t.minSize {
+		return append(src[:len(src):len(src)], flagRaw), nil
+	}
+	dst, err := t.inner.OnPack(src)
+	if err != nil {
+		return nil, err
+	}
+	return append(dst[:len(dst):len(dst)], flagFiltered), nil
+}
+
+// OnUnpack performs filtering on unpacking.
+func (t *Threshold) OnUnpack(src []byte) ([]byte, error) {
+	if len(src) == 0 {
+		return src, nil
+	}
+	n := len(src) - 1
+	switch src[n] {
+	case flagRaw:
+		return src[:n], nil
+	case flagFiltered:
+		return t.inner.OnUnpack(src[:n])
+	default:
+		return nil, errCorrupt
+	}
+}
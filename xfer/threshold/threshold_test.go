@@ -0,0 +1,76 @@
+package threshold_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/sqos/yrpc/xfer"
+	"github.com/sqos/yrpc/xfer/gzip"
+	"github.com/sqos/yrpc/xfer/threshold"
+)
+
+func TestThreshold(t *testing.T) {
+	// test register
+	gzip.Reg('g', "gzip-5", 5)
+	threshold.Reg('t', "gzip-5-over-64", 'g', 64)
+
+	f, err := xfer.GetByName("gzip-5-over-64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.ID() != 't' {
+		t.Fatalf("want id 't', have %q", f.ID())
+	}
+
+	// small payload is not compressed
+	small := []byte("src")
+	b, err := f.OnPack(append([]byte{}, small...))
+	if err != nil {
+		t.Fatalf("nopack: %v", err)
+	}
+	if !bytes.Equal(b[:len(b)-1], small) {
+		t.Fatalf("small payload should not be compressed: %q", b)
+	}
+	src, err := f.OnUnpack(b)
+	if err != nil {
+		t.Fatalf("nounpack: %v", err)
+	}
+	if !bytes.Equal(src, small) {
+		t.Fatalf("want %q, have %q", small, src)
+	}
+
+	// large payload is compressed
+	large := bytes.Repeat([]byte("src"), 1000)
+	b, err = f.OnPack(append([]byte{}, large...))
+	if err != nil {
+		t.Fatalf("nopack: %v", err)
+	}
+	if len(b) >= len(large) {
+		t.Fatalf("large payload should be compressed: %d >= %d", len(b), len(large))
+	}
+	src, err = f.OnUnpack(b)
+	if err != nil {
+		t.Fatalf("nounpack: %v", err)
+	}
+	if !bytes.Equal(src, large) {
+		t.Fatalf("want len %d, have len %d", len(large), len(src))
+	}
+
+	// the spare capacity of the source is not written
+	buf := []byte("srcXYZ")
+	b, err = f.OnPack(buf[:3])
+	if err != nil {
+		t.Fatalf("nopack: %v", err)
+	}
+	if string(buf) != "srcXYZ" {
+		t.Fatalf("the source buffer is modified: %q", buf)
+	}
+	if src, err = f.OnUnpack(b); err != nil || !bytes.Equal(src, small) {
+		t.Fatalf("want %q, have %q, %v", small, src, err)
+	}
+
+	// unknown flag
+	if _, err = f.OnUnpack([]byte{'x', 9}); err == nil {
+		t.Fatal("expect error for corrupt input")
+	}
+}
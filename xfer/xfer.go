@@ -20,6 +20,7 @@ import (
 	"errors"
 	"fmt"
 	"math"
+	"sort"
 )
 
 // XferFilter handles byte stream of message when transfer.
@@ -77,6 +78,16 @@ func GetByName(name string) (XferFilter, error) {
 	return xferFilter, nil
 }
 
+// IDs returns the sorted ids of all registered transfer filters.
+func IDs() []byte {
+	ids := make([]byte, 0, len(xferFilterMap.idMap))
+	for id := range xferFilterMap.idMap {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
 // XferPipe transfer filter pipe, handlers from outer-most to inner-most.
 // NOTE: the length can not be bigger than 255!
 type XferPipe struct {
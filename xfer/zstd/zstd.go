@@ -0,0 +1,100 @@
+// Copyright 2024 sqos. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package zstd provides a zstandard compression transfer filter.
+package zstd
+
+import (
+	"fmt"
+
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/sqos/yrpc/socket"
+	"github.com/sqos/yrpc/xfer"
+)
+
+// Reg registers a zstd filter for transfer.
+// NOTE: level is the zstd compression level, in the range [1,22].
+func Reg(id byte, name string, level int) {
+	xfer.Reg(newZstd(id, name, level))
+}
+
+// newZstd creates a new zstd filter.
+func newZstd(id byte, name string, level int) *Zstd {
+	if level < 1 || level > 22 {
+		panic(fmt.Sprintf("zstd: invalid compression level: %d", level))
+	}
+	encoder, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+	if err != nil {
+		panic(fmt.Sprintf("zstd: %v", err))
+	}
+	decoder, err := zstd.NewReader(nil, zstd.WithDecoderMaxMemory(uint64(socket.MessageSizeLimit())))
+	if err != nil {
+		panic(fmt.Sprintf("zstd: %v", err))
+	}
+	return &Zstd{
+		id:      id,
+		name:    name,
+		encoder: encoder,
+		decoder: decoder,
+	}
+}
+
+// Zstd compression filter
+type Zstd struct {
+	id      byte
+	name    string
+	encoder *zstd.Encoder
+	decoder *zstd.Decoder
+}
+
+// ID returns transfer filter id.
+func (z *Zstd) ID() byte {
+	return z.id
+}
+
+// Name returns transfer filter name.
+func (z *Zstd) Name() string {
+	return z.name
+}
+
+// OnPack performs filtering on packing.
+func (z *Zstd) OnPack(src []byte) ([]byte, error) {
+	return z.encoder.EncodeAll(src, make([]byte, 0, len(src)/2+16)), nil
+}
+
+// OnUnpack performs filtering on unpacking.
+// NOTE: The frame content size is checked against socket.MessageSizeLimit() before it is decoded,
+// and the decoded size of the frame without it is bounded by the limit when the filter is registered.
+func (z *Zstd) OnUnpack(src []byte) ([]byte, error) {
+	if len(src) == 0 {
+		return src, nil
+	}
+	limit := uint64(socket.MessageSizeLimit())
+	var h zstd.Header
+	if err := h.Decode(src); err != nil {
+		return nil, err
+	}
+	if h.HasFCS && h.FrameContentSize > limit {
+		return nil, fmt.Errorf("zstd: frame content size %d: %w", h.FrameContentSize, socket.ErrExceedMessageSizeLimit)
+	}
+	dst, err := z.decoder.DecodeAll(src, nil)
+	if err != nil {
+		return nil, err
+	}
+	if uint64(len(dst)) > limit {
+		return nil, fmt.Errorf("zstd: decoded length %d: %w", len(dst), socket.ErrExceedMessageSizeLimit)
+	}
+	return dst, nil
+}
@@ -0,0 +1,56 @@
+package zstd_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/sqos/yrpc/socket"
+	"github.com/sqos/yrpc/xfer"
+	"github.com/sqos/yrpc/xfer/zstd"
+)
+
+func TestZstd(t *testing.T) {
+	// test register
+	zstd.Reg('z', "zstd-3", 3)
+
+	if _, err := xfer.Get('z'); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := xfer.GetByName("zstd-3"); err != nil {
+		t.Fatal(err)
+	}
+	xferPipe := xfer.NewXferPipe()
+	xferPipe.Append('z')
+	t.Logf("transfer filter: ids:%v, names:%v", xferPipe.IDs(), xferPipe.Names())
+
+	// test logic
+	large := bytes.Repeat([]byte("src"), 10000)
+	for _, want := range [][]byte{{}, []byte("src"), large} {
+		b, err := xferPipe.OnPack(want)
+		if err != nil {
+			t.Fatalf("nopack: %v", err)
+		}
+		src, err := xferPipe.OnUnpack(b)
+		if err != nil {
+			t.Fatalf("nounpack: %v", err)
+		}
+		if !bytes.Equal(src, want) {
+			t.Fatalf("zstd has error: want len %d, have len %d", len(want), len(src))
+		}
+	}
+	if _, err := xferPipe.OnUnpack([]byte("bad frame")); err == nil {
+		t.Fatal("expect error for corrupt input")
+	}
+	// the decoded length exceeding the message size limit is rejected
+	b, err := xferPipe.OnPack(large)
+	if err != nil {
+		t.Fatalf("nopack: %v", err)
+	}
+	limit := socket.MessageSizeLimit()
+	socket.SetMessageSizeLimit(uint32(len(large) - 1))
+	defer socket.SetMessageSizeLimit(limit)
+	if _, err := xferPipe.OnUnpack(b); !errors.Is(err, socket.ErrExceedMessageSizeLimit) {
+		t.Fatalf("expect the size limit error, have %v", err)
+	}
+}
@@ -0,0 +1,299 @@
+// Copyright 2024 sqos. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package yrpctest
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sqos/yrpc"
+)
+
+// Network is the name of the in-memory network, which is used as yrpc.PeerConfig.Network.
+const Network = "mem"
+
+// ErrRefused the error of dialing the address that no one listens on
+var ErrRefused = errors.New("yrpctest: connection refused")
+
+// ErrDropped the error of writing the connection dropped by the faults
+var ErrDropped = errors.New("yrpctest: connection dropped")
+
+func init() {
+	yrpc.RegNetwork(Network, mem.listen, mem.dial)
+}
+
+// Faults the faults injected into the in-memory connections dialed to an address.
+type Faults struct {
+	// Latency delays every write of both sides.
+	Latency time.Duration
+	// DropAfter closes the connection after the number of the writes of the dialing side, 0 means never.
+	DropAfter int
+	// PartialWrite makes the write that drops the connection write the first half of the data.
+	PartialWrite bool
+}
+
+// InjectFaults injects the faults into the connections dialed to the address afterwards,
+// the zero Faults removes them.
+// NOTE: The address is identified by the port only.
+func InjectFaults(addr string, f Faults) {
+	port := addrPort(addr)
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+	if f == (Faults{}) {
+		delete(mem.faults, port)
+	} else {
+		mem.faults[port] = f
+	}
+}
+
+// DropConns closes the established connections to the address, and returns the number of them.
+func DropConns(addr string) int {
+	mem.mu.Lock()
+	lis := mem.listeners[addrPort(addr)]
+	mem.mu.Unlock()
+	if lis == nil {
+		return 0
+	}
+	return lis.dropConns()
+}
+
+// DialConn dials the address of the in-memory network, and returns the raw connection,
+// e.g. to serve it with a wrapper by yrpc.Peer.ServeConn, or to write the crafted bytes.
+func DialConn(addr string) (net.Conn, error) {
+	return mem.dial("", addr, waitTimeout)
+}
+
+var mem = &memNetwork{
+	listeners: make(map[string]*listener),
+	faults:    make(map[string]Faults),
+	nextPort:  20000,
+}
+
+// memNetwork the in-memory network, whose addresses are identified by the port
+type memNetwork struct {
+	mu        sync.Mutex
+	listeners map[string]*listener
+	faults    map[string]Faults
+	nextPort  int
+}
+
+func addrPort(addr string) string {
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return port
+}
+
+// allocPort returns an unused port, the caller holds the lock.
+func (m *memNetwork) allocPort() string {
+	for {
+		m.nextPort++
+		if m.nextPort > 65535 {
+			m.nextPort = 20001
+		}
+		port := strconv.Itoa(m.nextPort)
+		if _, ok := m.listeners[port]; !ok {
+			return port
+		}
+	}
+}
+
+func (m *memNetwork) listen(laddr string) (net.Listener, error) {
+	host, port, err := net.SplitHostPort(laddr)
+	if err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if port == "0" {
+		port = m.allocPort()
+	} else if _, ok := m.listeners[port]; ok {
+		return nil, errors.New("yrpctest: address already in use: " + laddr)
+	}
+	lis := &listener{
+		port:    port,
+		addr:    addr(net.JoinHostPort(host, port)),
+		connCh:  make(chan net.Conn),
+		closeCh: make(chan struct{}),
+		conns:   make(map[*conn]struct{}),
+	}
+	m.listeners[port] = lis
+	return lis, nil
+}
+
+func (m *memNetwork) listening(port string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.listeners[port]
+	return ok
+}
+
+// localAddr returns the local address of a dialed connection, the caller holds the lock.
+// NOTE: The port of laddr is not reserved if set, so the connections may share it,
+// e.g. to test the sessions with the same ID.
+func (m *memNetwork) localAddr(laddr string) addr {
+	host, port, err := net.SplitHostPort(laddr)
+	if err != nil || port == "" || port == "0" {
+		port = m.allocPort()
+	}
+	if ip := net.ParseIP(host); ip == nil || ip.IsUnspecified() {
+		host = "127.0.0.1"
+	}
+	return addr(net.JoinHostPort(host, port))
+}
+
+func (m *memNetwork) dial(laddr, raddr string, timeout time.Duration) (net.Conn, error) {
+	port := addrPort(raddr)
+	m.mu.Lock()
+	lis, ok := m.listeners[port]
+	faults := m.faults[port]
+	localAddr := m.localAddr(laddr)
+	m.mu.Unlock()
+	if !ok {
+		return nil, ErrRefused
+	}
+	c1, c2 := net.Pipe()
+	cli := &conn{Conn: c1, local: localAddr, remote: lis.addr, faults: faults, dropping: true}
+	srv := &conn{Conn: c2, local: lis.addr, remote: localAddr, faults: Faults{Latency: faults.Latency}, lis: lis}
+	var timer <-chan time.Time
+	if timeout > 0 {
+		timer = time.After(timeout)
+	}
+	select {
+	case lis.connCh <- srv:
+		lis.addConn(srv)
+		return cli, nil
+	case <-lis.closeCh:
+	case <-timer:
+	}
+	c1.Close()
+	c2.Close()
+	return nil, ErrRefused
+}
+
+// addr the address of the in-memory network
+type addr string
+
+func (a addr) Network() string { return Network }
+func (a addr) String() string  { return string(a) }
+
+// listener the listener of the in-memory network
+type listener struct {
+	port      string
+	addr      addr
+	connCh    chan net.Conn
+	closeCh   chan struct{}
+	closeOnce sync.Once
+	mu        sync.Mutex
+	conns     map[*conn]struct{}
+}
+
+func (l *listener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.connCh:
+		return c, nil
+	case <-l.closeCh:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *listener) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.closeCh)
+		mem.mu.Lock()
+		if mem.listeners[l.port] == l {
+			delete(mem.listeners, l.port)
+		}
+		mem.mu.Unlock()
+	})
+	return nil
+}
+
+func (l *listener) Addr() net.Addr {
+	return l.addr
+}
+
+func (l *listener) addConn(c *conn) {
+	l.mu.Lock()
+	l.conns[c] = struct{}{}
+	l.mu.Unlock()
+}
+
+func (l *listener) removeConn(c *conn) {
+	l.mu.Lock()
+	delete(l.conns, c)
+	l.mu.Unlock()
+}
+
+func (l *listener) dropConns() int {
+	l.mu.Lock()
+	conns := make([]*conn, 0, len(l.conns))
+	for c := range l.conns {
+		conns = append(conns, c)
+	}
+	l.mu.Unlock()
+	for _, c := range conns {
+		c.Close()
+	}
+	return len(conns)
+}
+
+// conn the connection of the in-memory network
+type conn struct {
+	net.Conn
+	local, remote addr
+	faults        Faults
+	// dropping is true for the dialing side, whose writes are counted for Faults.DropAfter.
+	dropping bool
+	writes   int
+	writeMu  sync.Mutex
+	lis      *listener
+}
+
+func (c *conn) LocalAddr() net.Addr  { return c.local }
+func (c *conn) RemoteAddr() net.Addr { return c.remote }
+
+func (c *conn) Write(b []byte) (int, error) {
+	if c.faults.Latency > 0 {
+		time.Sleep(c.faults.Latency)
+	}
+	if !c.dropping || c.faults.DropAfter <= 0 {
+		return c.Conn.Write(b)
+	}
+	c.writeMu.Lock()
+	c.writes++
+	drop := c.writes > c.faults.DropAfter
+	c.writeMu.Unlock()
+	if !drop {
+		return c.Conn.Write(b)
+	}
+	var n int
+	if c.faults.PartialWrite {
+		n, _ = c.Conn.Write(b[:len(b)/2])
+	}
+	c.Close()
+	return n, ErrDropped
+}
+
+func (c *conn) Close() error {
+	if c.lis != nil {
+		c.lis.removeConn(c)
+	}
+	return c.Conn.Close()
+}
@@ -0,0 +1,231 @@
+// Copyright 2024 sqos. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package yrpctest
+
+import (
+	"sync"
+	"time"
+
+	"github.com/sqos/yrpc"
+)
+
+// The hooks recorded by Recorder
+const (
+	HookPostDial            = "PostDial"
+	HookPostAccept          = "PostAccept"
+	HookPostWriteCall       = "PostWriteCall"
+	HookPostWriteReply      = "PostWriteReply"
+	HookPostWritePush       = "PostWritePush"
+	HookPostReadCallHeader  = "PostReadCallHeader"
+	HookPostReadPushHeader  = "PostReadPushHeader"
+	HookPostReadReplyHeader = "PostReadReplyHeader"
+	HookPostHandlePush      = "PostHandlePush"
+	HookPostDisconnect      = "PostDisconnect"
+	HookPostEvict           = "PostEvict"
+)
+
+// Event the recorded plugin event
+type Event struct {
+	Hook      string
+	SessionID string
+	// ServiceMethod and Seq are empty for the session hooks.
+	ServiceMethod string
+	Seq           int32
+	// Status is the handle status of the message hooks, or the reason of HookPostEvict.
+	Status *yrpc.Status
+}
+
+// Recorder is a plugin that records the events of the hooks in order.
+type Recorder struct {
+	mu       sync.Mutex
+	events   []Event
+	notifyCh chan struct{}
+}
+
+var (
+	_ yrpc.PostDialPlugin            = (*Recorder)(nil)
+	_ yrpc.PostAcceptPlugin          = (*Recorder)(nil)
+	_ yrpc.PostWriteCallPlugin       = (*Recorder)(nil)
+	_ yrpc.PostWriteReplyPlugin      = (*Recorder)(nil)
+	_ yrpc.PostWritePushPlugin       = (*Recorder)(nil)
+	_ yrpc.PostReadCallHeaderPlugin  = (*Recorder)(nil)
+	_ yrpc.PostReadPushHeaderPlugin  = (*Recorder)(nil)
+	_ yrpc.PostReadReplyHeaderPlugin = (*Recorder)(nil)
+	_ yrpc.PostHandlePushPlugin      = (*Recorder)(nil)
+	_ yrpc.PostDisconnectPlugin      = (*Recorder)(nil)
+	_ yrpc.PostEvictPlugin           = (*Recorder)(nil)
+)
+
+// NewRecorder creates a plugin that records the events of the hooks.
+func NewRecorder() *Recorder {
+	return &Recorder{notifyCh: make(chan struct{})}
+}
+
+// Name returns the plugin name.
+func (r *Recorder) Name() string {
+	return "yrpctest-recorder"
+}
+
+// Events returns the copy of the recorded events.
+func (r *Recorder) Events() []Event {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Event(nil), r.events...)
+}
+
+// Filter returns the recorded events of the hook.
+func (r *Recorder) Filter(hook string) []Event {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var list []Event
+	for _, e := range r.events {
+		if e.Hook == hook {
+			list = append(list, e)
+		}
+	}
+	return list
+}
+
+// Count returns the number of the recorded events of the hook.
+func (r *Recorder) Count(hook string) int {
+	return len(r.Filter(hook))
+}
+
+// Wait waits until at least n events of the hook are recorded, returns false if timeout.
+func (r *Recorder) Wait(hook string, n int, timeout time.Duration) bool {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		r.mu.Lock()
+		notifyCh := r.notifyCh
+		r.mu.Unlock()
+		if r.Count(hook) >= n {
+			return true
+		}
+		select {
+		case <-notifyCh:
+		case <-timer.C:
+			return false
+		}
+	}
+}
+
+// Reset clears the recorded events.
+func (r *Recorder) Reset() {
+	r.mu.Lock()
+	r.events = nil
+	r.mu.Unlock()
+}
+
+func (r *Recorder) record(e Event) {
+	r.mu.Lock()
+	r.events = append(r.events, e)
+	close(r.notifyCh)
+	r.notifyCh = make(chan struct{})
+	r.mu.Unlock()
+}
+
+func (r *Recorder) recordWrite(hook string, ctx yrpc.WriteCtx) {
+	r.record(Event{
+		Hook:          hook,
+		SessionID:     ctx.Session().ID(),
+		ServiceMethod: ctx.Output().ServiceMethod(),
+		Seq:           ctx.Output().Seq(),
+		Status:        ctx.Status(),
+	})
+}
+
+func (r *Recorder) recordRead(hook string, ctx yrpc.ReadCtx) {
+	r.record(Event{
+		Hook:          hook,
+		SessionID:     ctx.Session().ID(),
+		ServiceMethod: ctx.ServiceMethod(),
+		Seq:           ctx.Seq(),
+		Status:        ctx.Status(),
+	})
+}
+
+func sessionID(sess interface{}) string {
+	if s, ok := sess.(interface{ ID() string }); ok {
+		return s.ID()
+	}
+	return ""
+}
+
+// PostDial records the event.
+func (r *Recorder) PostDial(sess yrpc.PreSession, isRedial bool) *yrpc.Status {
+	r.record(Event{Hook: HookPostDial, SessionID: sessionID(sess)})
+	return nil
+}
+
+// PostAccept records the event.
+func (r *Recorder) PostAccept(sess yrpc.PreSession) *yrpc.Status {
+	r.record(Event{Hook: HookPostAccept, SessionID: sessionID(sess)})
+	return nil
+}
+
+// PostWriteCall records the event.
+func (r *Recorder) PostWriteCall(ctx yrpc.WriteCtx) *yrpc.Status {
+	r.recordWrite(HookPostWriteCall, ctx)
+	return nil
+}
+
+// PostWriteReply records the event.
+func (r *Recorder) PostWriteReply(ctx yrpc.WriteCtx) *yrpc.Status {
+	r.recordWrite(HookPostWriteReply, ctx)
+	return nil
+}
+
+// PostWritePush records the event.
+func (r *Recorder) PostWritePush(ctx yrpc.WriteCtx) *yrpc.Status {
+	r.recordWrite(HookPostWritePush, ctx)
+	return nil
+}
+
+// PostReadCallHeader records the event.
+func (r *Recorder) PostReadCallHeader(ctx yrpc.ReadCtx) *yrpc.Status {
+	r.recordRead(HookPostReadCallHeader, ctx)
+	return nil
+}
+
+// PostReadPushHeader records the event.
+func (r *Recorder) PostReadPushHeader(ctx yrpc.ReadCtx) *yrpc.Status {
+	r.recordRead(HookPostReadPushHeader, ctx)
+	return nil
+}
+
+// PostReadReplyHeader records the event.
+func (r *Recorder) PostReadReplyHeader(ctx yrpc.ReadCtx) *yrpc.Status {
+	r.recordRead(HookPostReadReplyHeader, ctx)
+	return nil
+}
+
+// PostHandlePush records the event.
+func (r *Recorder) PostHandlePush(ctx yrpc.ReadCtx) *yrpc.Status {
+	r.recordRead(HookPostHandlePush, ctx)
+	return nil
+}
+
+// PostDisconnect records the event.
+func (r *Recorder) PostDisconnect(sess yrpc.BaseSession) *yrpc.Status {
+	r.record(Event{Hook: HookPostDisconnect, SessionID: sess.ID()})
+	return nil
+}
+
+// PostEvict records the event.
+func (r *Recorder) PostEvict(sess yrpc.BaseSession, reason *yrpc.Status) *yrpc.Status {
+	r.record(Event{Hook: HookPostEvict, SessionID: sess.ID(), Status: reason})
+	return nil
+}
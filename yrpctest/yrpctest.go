@@ -0,0 +1,207 @@
+// Package yrpctest provides an in-memory network and the helpers for testing yrpc peers.
+//
+// Copyright 2024 sqos. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package yrpctest
+
+import (
+	"net"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/sqos/yrpc"
+)
+
+// waitTimeout is the max time to wait for the server to listen or the session to be accepted.
+const waitTimeout = 3 * time.Second
+
+// NewServer starts a server peer listening on a new address of the in-memory network,
+// and returns the peer and the address to dial. The peer is closed when the test finishes.
+// NOTE: cfg.Network and cfg.ListenPort are overwritten.
+func NewServer(tb testing.TB, cfg yrpc.PeerConfig, plugin ...yrpc.Plugin) (yrpc.Peer, string) {
+	tb.Helper()
+	return newServer(tb, cfg, nil, plugin...)
+}
+
+func newServer(tb testing.TB, cfg yrpc.PeerConfig, protoFunc []yrpc.ProtoFunc, plugin ...yrpc.Plugin) (yrpc.Peer, string) {
+	tb.Helper()
+	mem.mu.Lock()
+	port := mem.allocPort()
+	mem.mu.Unlock()
+	p, _ := strconv.ParseUint(port, 10, 16)
+	cfg.Network = Network
+	cfg.ListenPort = uint16(p)
+	srv := yrpc.NewPeer(cfg, plugin...)
+	tb.Cleanup(func() { srv.Close() })
+	errCh := make(chan error, 1)
+	go func() { errCh <- srv.ListenAndServe(protoFunc...) }()
+	deadline := time.Now().Add(waitTimeout)
+	for !mem.listening(port) {
+		select {
+		case err := <-errCh:
+			tb.Fatalf("yrpctest: listen failed: %v", err)
+		default:
+		}
+		if time.Now().After(deadline) {
+			tb.Fatalf("yrpctest: listen timeout")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return srv, net.JoinHostPort("127.0.0.1", port)
+}
+
+// NewClient creates a client peer of the in-memory network, which is closed when the test finishes.
+// NOTE: cfg.Network is overwritten.
+func NewClient(tb testing.TB, cfg yrpc.PeerConfig, plugin ...yrpc.Plugin) yrpc.Peer {
+	cfg.Network = Network
+	cli := yrpc.NewPeer(cfg, plugin...)
+	tb.Cleanup(func() { cli.Close() })
+	return cli
+}
+
+// Dial dials the address by the client peer, and fails the test if it fails.
+func Dial(tb testing.TB, cli yrpc.Peer, addr string, protoFunc ...yrpc.ProtoFunc) yrpc.Session {
+	tb.Helper()
+	sess, stat := cli.Dial(addr, protoFunc...)
+	if !stat.OK() {
+		tb.Fatalf("yrpctest: dial %s failed: %v", addr, stat)
+	}
+	return sess
+}
+
+// PairConfig the config of a server and client peer pair
+type PairConfig struct {
+	Server        yrpc.PeerConfig
+	Client        yrpc.PeerConfig
+	ServerPlugins []yrpc.Plugin
+	ClientPlugins []yrpc.Plugin
+	// ProtoFunc is the protocol of both peers, nil means the default one.
+	ProtoFunc yrpc.ProtoFunc
+}
+
+// Pair is a server and client peer pair of the in-memory network.
+// NOTE: Register the handlers to Server and Client, then call Dial.
+type Pair struct {
+	Server yrpc.Peer
+	Client yrpc.Peer
+	// Addr is the address of the server.
+	Addr      string
+	tb        testing.TB
+	protoFunc []yrpc.ProtoFunc
+}
+
+// NewPair starts the server peer and creates the client peer, which are closed when the test finishes.
+func NewPair(tb testing.TB, cfg PairConfig) *Pair {
+	tb.Helper()
+	var protoFunc []yrpc.ProtoFunc
+	if cfg.ProtoFunc != nil {
+		protoFunc = append(protoFunc, cfg.ProtoFunc)
+	}
+	srv, addr := newServer(tb, cfg.Server, protoFunc, cfg.ServerPlugins...)
+	return &Pair{
+		Server:    srv,
+		Client:    NewClient(tb, cfg.Client, cfg.ClientPlugins...),
+		Addr:      addr,
+		tb:        tb,
+		protoFunc: protoFunc,
+	}
+}
+
+// Dial dials the server by the client peer, and fails the test if it fails.
+func (p *Pair) Dial() yrpc.Session {
+	p.tb.Helper()
+	return Dial(p.tb, p.Client, p.Addr, p.protoFunc...)
+}
+
+// ServerSession returns the server-side session of the client-side session, and waits until it is accepted.
+func (p *Pair) ServerSession(cliSess yrpc.Session) yrpc.Session {
+	p.tb.Helper()
+	// the server-side session id is the remote address, which is the local address of the client-side one
+	id := cliSess.LocalAddr().String()
+	deadline := time.Now().Add(waitTimeout)
+	for {
+		if sess, ok := p.Server.GetSession(id); ok {
+			return sess
+		}
+		if time.Now().After(deadline) {
+			p.tb.Fatalf("yrpctest: session %s is not accepted", id)
+			return nil
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// AssertCall calls the service method, and reports the test failure if the status is not OK
+// or the result is not equal to want, returns whether the assertion succeeds.
+// NOTE: The result is not checked if want is nil.
+func AssertCall(tb testing.TB, sess yrpc.CtxSession, serviceMethod string, args, want interface{}, setting ...yrpc.MessageSetting) bool {
+	tb.Helper()
+	var result interface{}
+	if want != nil {
+		result = reflect.New(reflect.TypeOf(want)).Interface()
+	}
+	stat := sess.Call(serviceMethod, args, result, setting...).Status()
+	if !stat.OK() {
+		tb.Errorf("yrpctest: call %s: unexpected status: %v", serviceMethod, stat)
+		return false
+	}
+	if want == nil {
+		return true
+	}
+	if got := reflect.ValueOf(result).Elem().Interface(); !reflect.DeepEqual(got, want) {
+		tb.Errorf("yrpctest: call %s: result is not equal:\n\twant: %#v\n\tgot:  %#v", serviceMethod, want, got)
+		return false
+	}
+	return true
+}
+
+// AssertCallStatus calls the service method, and reports the test failure if the status code is not equal to code,
+// returns whether the assertion succeeds.
+func AssertCallStatus(tb testing.TB, sess yrpc.CtxSession, serviceMethod string, args interface{}, code int32, setting ...yrpc.MessageSetting) bool {
+	tb.Helper()
+	stat := sess.Call(serviceMethod, args, nil, setting...).Status()
+	if stat.Code() != code {
+		tb.Errorf("yrpctest: call %s: status code is not equal: want %d, got %v", serviceMethod, code, stat)
+		return false
+	}
+	return true
+}
+
+// Receive receives a value from the channel, and fails the test if nothing is received in time.
+func Receive[T any](tb testing.TB, ch <-chan T) T {
+	tb.Helper()
+	select {
+	case v := <-ch:
+		return v
+	case <-time.After(waitTimeout):
+		tb.Fatalf("yrpctest: receive timeout")
+		var zero T
+		return zero
+	}
+}
+
+// AssertNoReceive waits for the duration, and reports the test failure if a value is received from the channel,
+// returns whether the assertion succeeds.
+func AssertNoReceive[T any](tb testing.TB, ch <-chan T, wait time.Duration) bool {
+	tb.Helper()
+	select {
+	case v := <-ch:
+		tb.Errorf("yrpctest: unexpected value received: %v", v)
+		return false
+	case <-time.After(wait):
+		return true
+	}
+}
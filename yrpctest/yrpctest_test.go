@@ -0,0 +1,96 @@
+package yrpctest_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/sqos/yrpc"
+	"github.com/sqos/yrpc/yrpctest"
+)
+
+type Math struct {
+	yrpc.CallCtx
+}
+
+func (m *Math) Add(arg *[]int) (int, *yrpc.Status) {
+	var r int
+	for _, a := range *arg {
+		r += a
+	}
+	return r, nil
+}
+
+func TestPair(t *testing.T) {
+	srvRec, cliRec := yrpctest.NewRecorder(), yrpctest.NewRecorder()
+	p := yrpctest.NewPair(t, yrpctest.PairConfig{
+		ServerPlugins: []yrpc.Plugin{srvRec},
+		ClientPlugins: []yrpc.Plugin{cliRec},
+	})
+	p.Server.RouteCall(new(Math))
+	received := make(chan string, 1)
+	p.Client.RoutePushFunc(func(ctx yrpc.PushCtx, arg *string) *yrpc.Status {
+		received <- *arg
+		return nil
+	})
+	sess := p.Dial()
+	assert.Equal(t, yrpctest.Network, sess.RemoteAddr().Network())
+
+	yrpctest.AssertCall(t, sess, "/math/add", []int{1, 2, 3}, 6)
+	yrpctest.AssertCallStatus(t, sess, "/math/none", nil, yrpc.CodeNotFound)
+
+	srvSess := p.ServerSession(sess)
+	stat := srvSess.Push("/func1", "hi")
+	assert.True(t, stat.OK(), stat)
+	assert.Equal(t, "hi", yrpctest.Receive(t, received))
+	yrpctest.AssertNoReceive(t, received, 50*time.Millisecond)
+
+	assert.Equal(t, 1, cliRec.Count(yrpctest.HookPostDial))
+	assert.Equal(t, 2, cliRec.Count(yrpctest.HookPostWriteCall))
+	assert.True(t, srvRec.Wait(yrpctest.HookPostWriteReply, 2, time.Second))
+	assert.True(t, cliRec.Wait(yrpctest.HookPostHandlePush, 1, time.Second))
+	calls := srvRec.Filter(yrpctest.HookPostReadCallHeader)
+	assert.Equal(t, "/math/add", calls[0].ServiceMethod)
+	assert.Equal(t, sess.LocalAddr().String(), calls[0].SessionID)
+}
+
+func TestRedial(t *testing.T) {
+	rec := yrpctest.NewRecorder()
+	p := yrpctest.NewPair(t, yrpctest.PairConfig{
+		Client:        yrpc.PeerConfig{RedialTimes: 3, RedialInterval: 10 * time.Millisecond},
+		ClientPlugins: []yrpc.Plugin{rec},
+	})
+	p.Server.RouteCall(new(Math))
+	sess := p.Dial()
+	yrpctest.AssertCall(t, sess, "/math/add", []int{1}, 1)
+
+	assert.Equal(t, 1, yrpctest.DropConns(p.Addr))
+	assert.True(t, rec.Wait(yrpctest.HookPostDial, 2, time.Second))
+	yrpctest.AssertCall(t, sess, "/math/add", []int{1, 1}, 2)
+}
+
+func TestFaults(t *testing.T) {
+	rec := yrpctest.NewRecorder()
+	p := yrpctest.NewPair(t, yrpctest.PairConfig{
+		ServerPlugins: []yrpc.Plugin{rec},
+	})
+	p.Server.RouteCall(new(Math))
+
+	yrpctest.InjectFaults(p.Addr, yrpctest.Faults{Latency: 20 * time.Millisecond})
+	sess := p.Dial()
+	start := time.Now()
+	yrpctest.AssertCall(t, sess, "/math/add", []int{1}, 1)
+	// the call and the reply are both delayed
+	assert.GreaterOrEqual(t, time.Since(start), 40*time.Millisecond)
+	sess.Close()
+
+	yrpctest.InjectFaults(p.Addr, yrpctest.Faults{DropAfter: 1, PartialWrite: true})
+	defer yrpctest.InjectFaults(p.Addr, yrpctest.Faults{})
+	sess = p.Dial()
+	yrpctest.AssertCall(t, sess, "/math/add", []int{1}, 1)
+	stat := sess.Call("/math/add", []int{2}, nil).Status()
+	assert.False(t, stat.OK())
+	// the server gets the partial message and disconnects
+	assert.True(t, rec.Wait(yrpctest.HookPostDisconnect, 2, time.Second))
+}